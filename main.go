@@ -20,6 +20,8 @@ func setupCommands() {
 	CMD_FUNCS["inspect"] = cmd.RunInspectCmdLine
 	CMD_FUNCS["aggregate"] = cmd.RunAggregateCmdLine
 	CMD_FUNCS["version"] = cmd.RunVersionCmdLine
+	CMD_FUNCS["serve"] = cmd.RunServeCmdLine
+	CMD_FUNCS["sql"] = cmd.RunSQLCmdLine
 
 	for k, _ := range CMD_FUNCS {
 		CMD_KEYS = append(CMD_KEYS, k)
@@ -28,7 +30,7 @@ func setupCommands() {
 
 var USAGE = `sybil: a fast and simple NoSQL column store
 
-Commands: ingest, digest, trim, query, index, rebuild, inspect, aggregate, version, serve
+Commands: ingest, digest, trim, query, sql, index, rebuild, inspect, aggregate, version, serve
 
 Storage Commands:
 
@@ -54,6 +56,61 @@ Query Commands:
     example: sybil query -table TABLE -print -group col1 -int col2 -op hist
     # reads the row store log (off by default)
     example: sybil query -table TABLE -read-log -print -group col1 -int col2 -op hist
+    # prints the given percentiles of each hist instead of p0, p25, p50, p75 and p99
+    example: sybil query -table TABLE -group col1 -int col2 -percentiles 50,90,99,99.9
+    # filters records with a boolean expression
+    example: sybil query -table TABLE -group col1 -where "(col2 = 500 or col2 = 503) and not col1 ~ '^canary'"
+    # only prints the groups whose aggregates pass the -having expression
+    example: sybil query -table TABLE -group col1 -int col2 -having "count > 1000 and p99(col2) > 500"
+    # sorts by any aggregate, add asc to sort ascending ($KEY sorts by the group)
+    example: sybil query -table TABLE -group col1 -int col2 -sort "p99(col2) asc"
+    # approximate top groups (with error bounds) for group bys with too many groups to hold
+    example: sybil query -table TABLE -group col1 -heavy-hitters 10000 -json
+    # exact count distincts while they are small, then a bigger (more accurate) sketch
+    example: sybil query -table TABLE -group col1 -distinct col2 -distinct-exact -distinct-precision 16
+    # percentiles accurate to 3 significant digits, whatever the column's range
+    example: sybil query -table TABLE -group col1 -int col2 -op hist -hdr -hdr-digits 3
+    # only queries the last 6 hours (also takes RFC3339 times, epoch seconds or now-7d)
+    example: sybil query -table TABLE -group col1 -start -6h -end now
+    # how each group's last hour changed against the same hour a week ago
+    example: sybil query -table TABLE -group col1 -int col2 -start -1h -compare-to -7d
+    # queries several tables (or globs) at once, grouped by the table each record is from
+    example: sybil query -table "logs_*,errors" -group '$table,col1' -int col2
+    # groups by a column of a dimension table, looked up by each record's user_id
+    example: sybil query -table TABLE -join-table users -join-key user_id -group users.country
+    # groups by columns computed from each record (+ - * / %, regex(), int() and float())
+    example: sybil query -table TABLE -compute "status_class=status/100" -compute "route=regex(path,'^/api/([^/]+)')" -group status_class,route
+    # splits each user's records into sessions and counts how many sessions reach each funnel step
+    example: sybil query -table TABLE -session user_id -session-gap 30m -funnel-col event -funnel view,add_to_cart,checkout
+    # runs a lua script's filter, map and reduce over the matched records (needs a build with -tags lua)
+    example: sybil query -table TABLE -lua count_paths.lua
+    # daily rollups that start at midnight in a time zone (also takes week and month)
+    example: sybil query -table TABLE -int col2 -time -time-bucket day -tz America/Los_Angeles
+    # prints every hourly bucket of the last day, even the ones without records
+    example: sybil query -table TABLE -group col1 -time -start -1d -fill zero
+    # per second rate of a counter and a 5 bucket moving average of latency
+    example: sybil query -table TABLE -time -agg "max(requests)" -int latency -transform "rate(max(requests)),mavg(latency)" -window 5
+    # the top 5 col1 values of each hour, plus an (other) row for the rest
+    example: sybil query -table TABLE -group col1 -time -limit 5 -limit-per-bucket -other
+    # aggregates each column with its own op (sum, min, max, count, avg, hist, pNN)
+    example: sybil query -table TABLE -group col1 -agg "sum(col2),p95(col3),max(col3)"
+    # counts latency under fixed bounds, plus the fraction under 300 and the apdex score
+    example: sybil query -table TABLE -group col1 -agg "buckets(latency),slo(latency<300),apdex(latency<300)" -bucket-bounds 100,300,1000
+
+  sql: run a query written in a subset of SQL
+
+    example: sybil sql "SELECT col1, count(*), p99(col2) FROM TABLE WHERE time > now() - 1h GROUP BY col1 ORDER BY count DESC LIMIT 20"
+    # supports avg, min, max, median, pNN, count(DISTINCT col), GROUP BY time(1h),
+    # LIKE, IN and BETWEEN. Query flags (like -read-log or -json) go before the query
+    example: sybil sql -read-log -json "SELECT * FROM TABLE WHERE col1 LIKE 'www%' LIMIT 10"
+
+  serve: run a long lived HTTP daemon that answers queries and ingests records
+
+    example: sybil serve -addr localhost:8888
+    example: curl 'localhost:8888/query?table=TABLE&group=col1&int=col2&op=hist'
+    example: curl 'localhost:8888/info?table=TABLE'
+    example: curl 'localhost:8888/tables'
+    example: curl -XPOST 'localhost:8888/ingest?table=TABLE' --data-binary @my_records.json
 
 Emergency Maintenance Commands:
 
@@ -24,6 +24,7 @@ type SybilQuery struct {
 	Strs []string
 	Ints []string
 	Sets []string
+	Aggs []string
 
 	IntFilters []SybilFilter
 	StrFilters []SybilFilter
@@ -44,6 +45,7 @@ func (t *SybilTable) Query() *SybilQuery {
 	sq.Strs = make([]string, 0)
 	sq.Sets = make([]string, 0)
 	sq.Ints = make([]string, 0)
+	sq.Aggs = make([]string, 0)
 
 	sq.ReadLog = true
 
@@ -58,6 +60,133 @@ func (sq *SybilQuery) TimeSeries(timeCol string, bucket int) *SybilQuery {
 
 }
 
+// TimeRange bounds the query's time column, start and end can be RFC3339,
+// epoch seconds or relative times like "-6h". Either can be left empty.
+func (sq *SybilQuery) TimeRange(start string, end string) *SybilQuery {
+	if start != "" {
+		sq.Flags = append(sq.Flags, "-start", start)
+	}
+	if end != "" {
+		sq.Flags = append(sq.Flags, "-end", end)
+	}
+	return sq
+}
+
+// Having filters the grouped results, ex: "count > 1000 and p99(latency) > 500"
+func (sq *SybilQuery) Having(expr string) *SybilQuery {
+	sq.Flags = append(sq.Flags, "-having", expr)
+	return sq
+}
+
+// Sort orders the results by $COUNT, $KEY, a column (its average) or an
+// aggregate like "p99(latency)", with " asc" appended to sort ascending
+func (sq *SybilQuery) Sort(by string) *SybilQuery {
+	sq.Flags = append(sq.Flags, "-sort", by)
+	return sq
+}
+
+// HeavyHitters keeps the n biggest groups of each block with approximate
+// counts, each result's Error is how much its Count can be over by
+func (sq *SybilQuery) HeavyHitters(n int) *SybilQuery {
+	sq.Flags = append(sq.Flags, "-heavy-hitters", strconv.Itoa(n))
+	return sq
+}
+
+// DistinctPrecision sets the size of the count distinct sketch to
+// 2^precision, exact counts small distincts exactly
+func (sq *SybilQuery) DistinctPrecision(precision int, exact bool) *SybilQuery {
+	sq.Flags = append(sq.Flags, "-distinct-precision", strconv.Itoa(precision))
+	if exact {
+		sq.Flags = append(sq.Flags, "-distinct-exact")
+	}
+	return sq
+}
+
+// Percentiles picks the percentiles hist aggregations return, like
+// "50,90,99,99.9"
+func (sq *SybilQuery) Percentiles(percentiles string) *SybilQuery {
+	sq.Flags = append(sq.Flags, "-percentiles", percentiles)
+	return sq
+}
+
+// BucketBounds sets the bounds that buckets(col) aggregations count under
+func (sq *SybilQuery) BucketBounds(bounds string) *SybilQuery {
+	sq.Flags = append(sq.Flags, "-bucket-bounds", bounds)
+	return sq
+}
+
+// HDRHist uses HDR histograms, which keep every value to digits
+// significant digits
+func (sq *SybilQuery) HDRHist(digits int) *SybilQuery {
+	sq.Flags = append(sq.Flags, "-hdr", "-hdr-digits", strconv.Itoa(digits))
+	return sq
+}
+
+// CompareTo compares the results to the same query over the time range
+// shifted by offset, ex: "-7d"
+func (sq *SybilQuery) CompareTo(offset string) *SybilQuery {
+	sq.Flags = append(sq.Flags, "-compare-to", offset)
+	return sq
+}
+
+// Join looks up each record's key column in a dimension table, whose
+// columns are then queried as table.col. A key of "user_id:id" joins columns
+// with different names
+func (sq *SybilQuery) Join(table string, key string) *SybilQuery {
+	sq.Flags = append(sq.Flags, "-join-table", table, "-join-key", key)
+	return sq
+}
+
+// Compute adds a column computed from each record, like
+// "status_class=status/100", that can be grouped by, filtered on and
+// aggregated. It can be called once per column
+func (sq *SybilQuery) Compute(spec string) *SybilQuery {
+	sq.Flags = append(sq.Flags, "-compute", spec)
+	return sq
+}
+
+// Sessions splits each actor's records into sessions that end after gap
+// (like 30m) without records, funnel (like "view,add_to_cart,checkout") can
+// be empty. Its steps are values of funnel_col
+func (sq *SybilQuery) Sessions(actor string, gap string, funnel_col string, funnel string) *SybilQuery {
+	sq.Flags = append(sq.Flags, "-session", actor, "-session-gap", gap)
+	if funnel != "" {
+		sq.Flags = append(sq.Flags, "-funnel-col", funnel_col, "-funnel", funnel)
+	}
+	return sq
+}
+
+// Lua runs the filter, map and reduce functions of a lua script over the
+// matched records. sybil has to be built with -tags lua
+func (sq *SybilQuery) Lua(path string) *SybilQuery {
+	sq.Flags = append(sq.Flags, "-lua", path)
+	return sq
+}
+
+// Fill prints every bucket of a time series for each group, mode is one of
+// "zero", "null" or "previous"
+func (sq *SybilQuery) Fill(mode string) *SybilQuery {
+	sq.Flags = append(sq.Flags, "-fill", mode)
+	return sq
+}
+
+// Transform derives series from a time rollup, ex: "rate(max(requests))",
+// window is how many buckets mavg and mpNN transforms cover
+func (sq *SybilQuery) Transform(transforms string, window int) *SybilQuery {
+	sq.Flags = append(sq.Flags, "-transform", transforms, "-window", strconv.Itoa(window))
+	return sq
+}
+
+// LimitPerBucket picks the top groups of a time series separately for each
+// bucket, other rolls up the rest of each bucket into an (other) group
+func (sq *SybilQuery) LimitPerBucket(other bool) *SybilQuery {
+	sq.Flags = append(sq.Flags, "-limit-per-bucket")
+	if other {
+		sq.Flags = append(sq.Flags, "-other")
+	}
+	return sq
+}
+
 func (sq *SybilQuery) ReadRowLog(v bool) *SybilQuery {
 	sq.ReadLog = v
 	return sq
@@ -79,6 +208,13 @@ func (sq *SybilQuery) Aggregate(field string) *SybilQuery {
 	return sq
 }
 
+// AggregateOp aggregates a column with its own op (sum, min, max, count, avg
+// or pNN), ex: AggregateOp("sum", "bytes")
+func (sq *SybilQuery) AggregateOp(op string, field string) *SybilQuery {
+	sq.Aggs = append(sq.Aggs, op+"("+field+")")
+	return sq
+}
+
 func (sq *SybilQuery) Hist() *SybilQuery {
 	sq.Flags = append(sq.Flags, "-op", "hist")
 	return sq
@@ -137,6 +273,13 @@ func (sq *SybilQuery) StrFilterRegex(field string, value string) *SybilQuery {
 	return sq
 }
 
+// Where filters the query by a boolean expression, like:
+// "(status = 500 or status = 503) and not host ~ '^canary'"
+func (sq *SybilQuery) Where(expr string) *SybilQuery {
+	sq.Flags = append(sq.Flags, "-where", expr)
+	return sq
+}
+
 func buildFilters(filterFlag string, filters []SybilFilter) []string {
 	if len(filters) == 0 {
 		return []string{}
@@ -176,6 +319,9 @@ func (sq *SybilQuery) Execute() ([]SybilResult, error) {
 	if len(sq.Sets) > 0 {
 		flags = append(flags, "-set", strings.Join(sq.Sets, FIELD_SEPARATOR))
 	}
+	if len(sq.Aggs) > 0 {
+		flags = append(flags, "-agg", strings.Join(sq.Aggs, FIELD_SEPARATOR))
+	}
 
 	cmd := exec.Command(SYBIL_BIN, flags...)
 	stderr, err := cmd.StderrPipe()
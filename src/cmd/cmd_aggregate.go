@@ -8,7 +8,7 @@ import "flag"
 // verify all their query specs match the same md5 result and then combine them
 
 func RunAggregateCmdLine() {
-	addPrintFlags()
+	addPrintFlags(flag.CommandLine)
 	flag.Parse()
 	dirs := flag.Args()
 
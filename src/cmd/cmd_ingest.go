@@ -9,9 +9,12 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -23,7 +26,65 @@ var JSON_PATH string
 // how many times we try to grab table info when ingesting
 var TABLE_INFO_GRABS = 10
 
-func ingest_dictionary(r *sybil.Record, recordmap *Dictionary, prefix string, timestampFormat string) {
+// -truncate-floats truncates fractional values into int columns instead of
+// skipping them, see add_number_field
+var TRUNCATE_FLOATS = false
+
+// how many fractional values were given to each int column, see
+// add_number_field
+var INT_FRACTIONS = make(map[string]int)
+var int_fractions_m = &sync.Mutex{}
+
+// numbers are stored as ints unless the column is already a float column, the
+// column was passed in -floats or the number has a fractional part. A
+// column's type can't change, so once a column is an int column (because the
+// first value it saw was whole), later fractions are skipped unless
+// -truncate-floats is passed. They are counted, see warn_int_fractions.
+func add_number_field(t *sybil.Table, r *sybil.Record, name string, val float64) {
+	col_type := t.GetColumnType(name)
+	if col_type != sybil.INT_VAL && (FLOAT_CAST[name] || col_type == sybil.FLOAT_VAL || val != math.Trunc(val)) {
+		r.AddFloatField(name, val)
+		return
+	}
+
+	if val != math.Trunc(val) {
+		int_fractions_m.Lock()
+		INT_FRACTIONS[name]++
+		int_fractions_m.Unlock()
+
+		if !TRUNCATE_FLOATS {
+			return
+		}
+	}
+
+	r.AddIntField(name, int64(val))
+}
+
+// warns about the int columns that were given fractional values since the
+// last call
+func warn_int_fractions() {
+	int_fractions_m.Lock()
+	defer int_fractions_m.Unlock()
+
+	names := make([]string, 0, len(INT_FRACTIONS))
+	for name := range INT_FRACTIONS {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if TRUNCATE_FLOATS {
+			sybil.Warn("TRUNCATED", INT_FRACTIONS[name], "FRACTIONAL VALUES INTO INT COLUMN", name)
+		} else {
+			sybil.Warn("SKIPPED", INT_FRACTIONS[name], "FRACTIONAL VALUES FOR INT COLUMN", name+",",
+				"PASS -floats FOR NEW COLUMNS OR -truncate-floats TO KEEP THEM AS INTS")
+		}
+	}
+
+	INT_FRACTIONS = make(map[string]int)
+}
+
+func ingest_dictionary(t *sybil.Table, r *sybil.Record, recordmap *Dictionary, prefix string, timestampFormat string) {
 	for k, v := range *recordmap {
 		key_name := fmt.Sprint(prefix, k)
 		_, ok := EXCLUDES[key_name]
@@ -50,6 +111,13 @@ func ingest_dictionary(r *sybil.Record, recordmap *Dictionary, prefix string, ti
 					continue
 				}
 				r.AddIntField(key_name, int64(val))
+			} else if FLOAT_CAST[key_name] {
+				val, err := strconv.ParseFloat(iv, 64)
+				if err != nil {
+					sybil.Debug(fmt.Sprintf("PROBLEM PARSING '%v' as float", iv), key_name)
+					continue
+				}
+				add_number_field(t, r, key_name, val)
 			} else {
 				r.AddStrField(key_name, iv)
 
@@ -57,7 +125,7 @@ func ingest_dictionary(r *sybil.Record, recordmap *Dictionary, prefix string, ti
 		case int64:
 			r.AddIntField(key_name, int64(iv))
 		case float64:
-			r.AddIntField(key_name, int64(iv))
+			add_number_field(t, r, key_name, iv)
 		case bool:
 			if iv {
 				r.AddIntField(key_name, 1)
@@ -67,7 +135,7 @@ func ingest_dictionary(r *sybil.Record, recordmap *Dictionary, prefix string, ti
 		// nested fields
 		case map[string]interface{}:
 			d := Dictionary(iv)
-			ingest_dictionary(r, &d, prefix_name, timestampFormat)
+			ingest_dictionary(t, r, &d, prefix_name, timestampFormat)
 		// This is a set field
 		case []interface{}:
 			key_strs := make([]string, 0)
@@ -130,7 +198,7 @@ func import_csv_records() {
 
 			val, err := strconv.ParseFloat(v, 64)
 			if err == nil {
-				r.AddIntField(field_name, int64(val))
+				add_number_field(t, r, field_name, val)
 			} else {
 				r.AddStrField(field_name, v)
 			}
@@ -185,13 +253,14 @@ func json_query(obj *interface{}, path []string) []interface{} {
 	return nil
 }
 
-func import_json_records(timestampFormat string) {
+func import_json_records(reader io.Reader, timestampFormat string) int {
 	t := sybil.GetTable(sybil.FLAGS.TABLE)
+	count := 0
 
 	path := strings.Split(JSON_PATH, ".")
 	sybil.Debug("PATH IS", path)
 
-	scanner := bufio.NewScanner(os.Stdin)
+	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
 		var decoded interface{}
 
@@ -210,28 +279,47 @@ func import_json_records(timestampFormat string) {
 			switch dict := ing.(type) {
 			case map[string]interface{}:
 				ndict := Dictionary(dict)
-				ingest_dictionary(r, &ndict, "", timestampFormat)
+				ingest_dictionary(t, r, &ndict, "", timestampFormat)
 			case Dictionary:
-				ingest_dictionary(r, &dict, "", timestampFormat)
+				ingest_dictionary(t, r, &dict, "", timestampFormat)
 
 			}
 			t.ChunkAndSave()
+			count++
 		}
 
 	}
 
+	return count
+}
+
+// We have a few tries to load table info, just in case the lock is held by
+// someone else
+func load_table_info_for_ingest(t *sybil.Table) bool {
+	for i := 0; i < TABLE_INFO_GRABS; i++ {
+		loaded := t.LoadTableInfo()
+		if loaded == true || t.HasFlagFile() == false {
+			return true
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	return !t.HasFlagFile()
 }
 
 var INT_CAST = make(map[string]bool)
+var FLOAT_CAST = make(map[string]bool)
 var TIMESTAMPS = make(map[string]bool)
 var EXCLUDES = make(map[string]bool)
 
 func RunIngestCmdLine() {
 	ingestfile := flag.String("file", sybil.INGEST_DIR, "name of dir to ingest into")
 	f_INTS := flag.String("ints", "", "columns to treat as ints (comma delimited)")
+	f_FLOATS := flag.String("floats", "", "columns to treat as floats (comma delimited)")
 	f_CSV := flag.Bool("csv", false, "expect incoming data in CSV format")
 	f_EXCLUDES := flag.String("exclude", "", "Columns to exclude (comma delimited)")
 	f_JSON_PATH := flag.String("path", "$", "Path to JSON record, ex: $.foo.bar")
+	flag.BoolVar(&TRUNCATE_FLOATS, "truncate-floats", false, "truncate fractional values into int columns instead of skipping them")
 	flag.BoolVar(&sybil.FLAGS.SKIP_COMPACT, "skip-compact", false, "skip auto compaction during ingest")
 
 	flag.BoolVar(&sybil.FLAGS.SAVE_AS_SRB, "save-srb", false, "Save ingestion records as SaveRecordBlocks, including Key info")
@@ -272,6 +360,9 @@ func RunIngestCmdLine() {
 	for _, v := range strings.Split(*f_INTS, ",") {
 		INT_CAST[v] = true
 	}
+	for _, v := range strings.Split(*f_FLOATS, ",") {
+		FLOAT_CAST[v] = true
+	}
 	for _, v := range strings.Split(*f_EXCLUDES, ",") {
 		EXCLUDES[v] = true
 	}
@@ -281,31 +372,17 @@ func RunIngestCmdLine() {
 	}
 
 	t := sybil.GetTable(sybil.FLAGS.TABLE)
-
-	// We have 5 tries to load table info, just in case the lock is held by
-	// someone else
-	var loaded_table = false
-	for i := 0; i < TABLE_INFO_GRABS; i++ {
-		loaded := t.LoadTableInfo()
-		if loaded == true || t.HasFlagFile() == false {
-			loaded_table = true
-			break
-		}
-		time.Sleep(time.Millisecond * 10)
-	}
-
-	if loaded_table == false {
-		if t.HasFlagFile() {
-			sybil.Warn("INGESTOR COULDNT READ TABLE INFO, LOSING SAMPLES")
-			return
-		}
+	if !load_table_info_for_ingest(t) {
+		sybil.Warn("INGESTOR COULDNT READ TABLE INFO, LOSING SAMPLES")
+		return
 	}
 
 	if *f_CSV == false {
-		import_json_records(*f_TIMESTAMP_FORMAT)
+		import_json_records(os.Stdin, *f_TIMESTAMP_FORMAT)
 	} else {
 		import_csv_records()
 	}
+	warn_int_fractions()
 
 	t.IngestRecords(digestfile)
 }
@@ -0,0 +1,69 @@
+package sybil_cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	sybil "github.com/logv/sybil/src/lib"
+)
+
+func TestAddNumberField(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sybil_ingest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old_dir := sybil.FLAGS.DIR
+	sybil.FLAGS.DIR = dir
+	defer func() { sybil.FLAGS.DIR = old_dir }()
+
+	FLOAT_CAST["price"] = true
+	defer delete(FLOAT_CAST, "price")
+
+	tbl := sybil.GetTable("ingest_test")
+	add_records := func() []*sybil.Record {
+		records := []*sybil.Record{}
+		for _, val := range []float64{1, 1.5, 2.5} {
+			r := tbl.NewRecord()
+			add_number_field(tbl, r, "count", val)
+			add_number_field(tbl, r, "price", val)
+			records = append(records, r)
+		}
+		return records
+	}
+
+	// count's first value was whole, so it's an int column that the
+	// fractions are skipped for
+	records := add_records()
+	if tbl.GetColumnType("count") != sybil.INT_VAL || INT_FRACTIONS["count"] != 2 {
+		t.Error("EXPECTED count TO BE AN INT COLUMN GIVEN 2 FRACTIONS", tbl.GetColumnType("count"), INT_FRACTIONS)
+	}
+
+	for i, r := range records {
+		if val, ok := r.GetIntVal("count"); ok != (i == 0) || (ok && val != 1) {
+			t.Error("RECORD", i, "HAS count", val, ok, "FRACTIONS SHOULD BE SKIPPED")
+		}
+	}
+
+	if tbl.GetColumnType("price") != sybil.FLOAT_VAL || INT_FRACTIONS["price"] != 0 {
+		t.Error("EXPECTED -floats TO MAKE price A FLOAT COLUMN", tbl.GetColumnType("price"), INT_FRACTIONS)
+	}
+
+	warn_int_fractions()
+	if len(INT_FRACTIONS) != 0 {
+		t.Error("WARNING ABOUT THE FRACTIONS SHOULD RESET THEIR COUNTS", INT_FRACTIONS)
+	}
+
+	TRUNCATE_FLOATS = true
+	defer func() { TRUNCATE_FLOATS = false }()
+
+	records = add_records()
+	for i, r := range records {
+		if val, ok := r.GetIntVal("count"); !ok || val != []int{1, 1, 2}[i] {
+			t.Error("RECORD", i, "HAS count", val, ok, "EXPECTED -truncate-floats TO TRUNCATE IT")
+		}
+	}
+	warn_int_fractions()
+}
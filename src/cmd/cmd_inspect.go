@@ -55,6 +55,23 @@ func decodeIntCol(digest_file *string) bool {
 
 }
 
+func decodeFloatCol(digest_file *string) bool {
+	dec := sybil.GetFileDecoder(*digest_file)
+
+	info := sybil.SavedFloatColumn{}
+	err := dec.Decode(&info)
+
+	if err != nil {
+		sybil.Print("ERROR", err)
+		return false
+	}
+
+	sybil.Print("FLOAT COL", info)
+
+	return true
+
+}
+
 func decodeStrCol(digest_file *string) bool {
 	dec := sybil.GetFileDecoder(*digest_file)
 
@@ -125,5 +142,8 @@ func RunInspectCmdLine() {
 	if decodeIntCol(digest_file) {
 		return
 	}
+	if decodeFloatCol(digest_file) {
+		return
+	}
 
 }
@@ -2,8 +2,10 @@ package sybil_cmd
 
 import (
 	"flag"
+	"io/ioutil"
 	"path"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,65 +18,139 @@ const (
 	SORT_COUNT = sybil.SORT_COUNT
 )
 
-func addPrintFlags() {
-	flag.StringVar(&sybil.FLAGS.OP, "op", "avg", "metric to calculate, either 'avg' or 'hist'")
-	flag.BoolVar(&sybil.FLAGS.LIST_TABLES, "tables", false, "List tables")
-	flag.BoolVar(&sybil.FLAGS.PRINT_INFO, "info", false, "Print table info")
-	flag.IntVar(&sybil.FLAGS.LIMIT, "limit", 100, "Number of results to return")
-	flag.BoolVar(&sybil.FLAGS.PRINT, "print", true, "Print some records")
-	flag.BoolVar(&sybil.FLAGS.SAMPLES, "samples", false, "Grab samples")
-	flag.BoolVar(&sybil.FLAGS.JSON, "json", false, "Print results in JSON format")
-}
+// -time-bucket takes seconds, a duration (6h) or a calendar unit (day, week or
+// month), see sybil.ParseTimeBucket
+type timeBucketFlag struct{}
 
-func addQueryFlags() {
-	if sybil.ENABLE_TDIGEST {
-		flag.BoolVar(&sybil.FLAGS.T_DIGEST, "tdigest", false, "Use TDIGEST Histograms")
+func (f timeBucketFlag) String() string {
+	if sybil.FLAGS.TIME_CALENDAR != "" {
+		return sybil.FLAGS.TIME_CALENDAR
 	}
 
-	flag.StringVar(&sybil.FLAGS.SORT, "sort", SORT_COUNT, "Int Column to sort by")
-	flag.StringVar(&sybil.FLAGS.PRUNE_BY, "prune-sort", SORT_COUNT, "Int Column to prune intermediate results by")
-
-	flag.BoolVar(&sybil.FLAGS.TIME, "time", false, "make a time rollup")
-	flag.StringVar(&sybil.FLAGS.TIME_COL, "time-col", "time", "which column to treat as a timestamp (use with -time flag)")
-	flag.IntVar(&sybil.FLAGS.TIME_BUCKET, "time-bucket", 60*60, "time bucket (in seconds)")
-	flag.StringVar(&sybil.FLAGS.WEIGHT_COL, "weight-col", "", "Which column to treat as an optional weighting column")
+	return strconv.Itoa(sybil.FLAGS.TIME_BUCKET)
+}
 
-	flag.BoolVar(&sybil.FLAGS.LOG_HIST, "loghist", false, "Use nested logarithmic histograms")
+func (f timeBucketFlag) Set(val string) error {
+	width, calendar, err := sybil.ParseTimeBucket(val)
+	if err != nil {
+		return err
+	}
 
-	flag.BoolVar(&sybil.FLAGS.ENCODE_RESULTS, "encode-results", false, "Print the results in binary format")
-	flag.BoolVar(&sybil.FLAGS.ENCODE_FLAGS, "encode-flags", false, "Print the query flags in binary format")
-	flag.BoolVar(&sybil.FLAGS.DECODE_FLAGS, "decode-flags", false, "Use the query flags supplied on stdin")
-	flag.StringVar(&sybil.FLAGS.INT_FILTERS, "int-filter", "", "Int filters, format: col:op:val")
-	flag.IntVar(&sybil.FLAGS.HIST_BUCKET, "int-bucket", 0, "Int hist bucket size")
+	sybil.FLAGS.TIME_BUCKET = width
+	sybil.FLAGS.TIME_CALENDAR = calendar
+	return nil
+}
 
-	flag.StringVar(&sybil.FLAGS.STR_REPLACE, "str-replace", "", "Str replacement, format: col:find:replace")
-	flag.StringVar(&sybil.FLAGS.STR_FILTERS, "str-filter", "", "Str filters, format: col:op:val")
-	flag.StringVar(&sybil.FLAGS.SET_FILTERS, "set-filter", "", "Set filters, format: col:op:val")
-	flag.BoolVar(&sybil.FLAGS.UPDATE_TABLE_INFO, "update-info", false, "Re-compute cached column data")
+// -compute can be given once per computed column, see sybil.ParseComputedColumn
+type computeFlag struct{}
 
-	flag.StringVar(&sybil.FLAGS.INTS, "int", "", "Integer values to aggregate")
-	flag.StringVar(&sybil.FLAGS.STRS, "str", "", "String values to load")
-	flag.StringVar(&sybil.FLAGS.SETS, "set", "", "Set values to load")
-	flag.StringVar(&sybil.FLAGS.SAMPLE_COLS, "sample-cols", "", "Columns to load for samples query")
-	flag.StringVar(&sybil.FLAGS.GROUPS, "group", "", "values group by")
-	flag.StringVar(&sybil.FLAGS.DISTINCT, sybil.DISTINCT_STR, "", "distinct group by")
-	flag.IntVar(&sybil.FLAGS.NUM_DISTINCT, sybil.NUM_DISTINCT, -1, "short the group by when this number of elements is hit")
+func (f computeFlag) String() string {
+	return strings.Join(sybil.FLAGS.COMPUTE, " ")
+}
 
-	flag.BoolVar(&sybil.FLAGS.EXPORT, "export", false, "export data to TSV")
+func (f computeFlag) Set(val string) error {
+	if _, err := sybil.ParseComputedColumn(val); err != nil {
+		return err
+	}
 
-	flag.BoolVar(&sybil.FLAGS.READ_ROWSTORE, "read-log", false, "read the ingestion log (can take longer!)")
+	sybil.FLAGS.COMPUTE = append(sybil.FLAGS.COMPUTE, val)
+	return nil
+}
 
-	flag.BoolVar(&sybil.FLAGS.RECYCLE_MEM, "recycle-mem", true, "recycle memory slabs (versus using Go's GC)")
-	flag.BoolVar(&sybil.FLAGS.FAST_RECYCLE, "fast-recycle", true, "faster memory recycling")
-	flag.BoolVar(&sybil.FLAGS.SHORTEN_KEY_TABLE, "shorten-key-table", true, "faster queries on wide tabes by shortening the key lookup")
+func addPrintFlags(fs *flag.FlagSet) {
+	fs.StringVar(&sybil.FLAGS.OP, "op", "avg", "metric to calculate, either 'avg', 'hist', or 'slo:col<T' and 'apdex:col<T' (see -agg)")
+	fs.StringVar(&sybil.FLAGS.PERCENTILES, "percentiles", "", "Comma separated percentiles to print instead of the default ones, ex: 50,90,99,99.9 (implies -op hist)")
+	fs.BoolVar(&sybil.FLAGS.LIST_TABLES, "tables", false, "List tables")
+	fs.BoolVar(&sybil.FLAGS.PRINT_INFO, "info", false, "Print table info")
+	fs.IntVar(&sybil.FLAGS.LIMIT, "limit", 100, "Number of results to return")
+	fs.IntVar(&sybil.FLAGS.HEAVY_HITTERS, "heavy-hitters", 0, "Keep the N biggest groups of each block with approximate counts instead of dropping groups over the result limit")
+	fs.BoolVar(&sybil.FLAGS.PRINT, "print", true, "Print some records")
+	fs.BoolVar(&sybil.FLAGS.SAMPLES, "samples", false, "Grab samples")
+	fs.BoolVar(&sybil.FLAGS.JSON, "json", false, "Print results in JSON format")
+}
 
-	flag.BoolVar(&sybil.FLAGS.CACHED_QUERIES, "cache-queries", false, "Cache query results per block")
+func addQueryFlags(fs *flag.FlagSet) {
+	if sybil.ENABLE_TDIGEST {
+		fs.BoolVar(&sybil.FLAGS.T_DIGEST, "tdigest", false, "Use TDIGEST Histograms")
+	}
+
+	if sybil.ENABLE_LUA {
+		fs.StringVar(&sybil.FLAGS.LUA_FILE, "lua", "", "a lua script with filter(record), map(record), reduce(acc, value) and combine(a, b) functions to run over the matched records")
+	}
+
+	fs.StringVar(&sybil.FLAGS.SORT, "sort", SORT_COUNT, "Result to sort by: $COUNT, $KEY, distinct, a column (its avg) or op(col) like p99(latency), add \" asc\" to sort ascending")
+	fs.StringVar(&sybil.FLAGS.PRUNE_BY, "prune-sort", "", "Result to prune intermediate results by (defaults to -sort)")
+
+	fs.BoolVar(&sybil.FLAGS.TIME, "time", false, "make a time rollup")
+	fs.StringVar(&sybil.FLAGS.TIME_COL, "time-col", "time", "which column to treat as a timestamp (use with -time flag)")
+	fs.StringVar(&sybil.FLAGS.START, "start", "", "only query records with time-col >= start, ex: 2017-06-01T00:00:00Z, 1496275200, -6h or now-7d (dates without an offset are in -tz)")
+	fs.StringVar(&sybil.FLAGS.END, "end", "", "only query records with time-col < end, same formats as -start")
+	fs.StringVar(&sybil.FLAGS.COMPARE_TO, "compare-to", "", "compare the results to the same query over -start and -end shifted by an offset, ex: -7d")
+	fs.StringVar(&sybil.FLAGS.JOIN_TABLE, "join-table", "", "a small table to look up each record's -join-key in, its columns are queried as table.col")
+	fs.StringVar(&sybil.FLAGS.JOIN_KEY, "join-key", "", "the column to join -join-table on, ex: user_id or user_id:id if it's named differently in -join-table")
+	fs.StringVar(&sybil.FLAGS.SESSION_COL, "session", "", "split each actor's records into sessions by this column (ex: user_id) and print how many there are and how long they last")
+	fs.StringVar(&sybil.FLAGS.SESSION_GAP, "session-gap", "30m", "how long an actor can go without records before their session ends, ex: 1800 or 30m")
+	fs.StringVar(&sybil.FLAGS.FUNNEL_COL, "funnel-col", "", "the column whose values are the -funnel steps, ex: event")
+	fs.StringVar(&sybil.FLAGS.FUNNEL, "funnel", "", "steps each -session walks in order, prints how many sessions reach each one, ex: view,add_to_cart,checkout")
+	fs.Var(computeFlag{}, "compute", "add a column computed from each record, ex: status_class=status/100 or route=regex(path,'^/api/([^/]+)') (can be repeated)")
+	sybil.FLAGS.TIME_BUCKET = 60 * 60
+	sybil.FLAGS.TIME_CALENDAR = ""
+	fs.Var(timeBucketFlag{}, "time-bucket", "time bucket, in seconds (3600), as a duration (6h) or one of day, week or month")
+	fs.StringVar(&sybil.FLAGS.TIME_ZONE, "tz", "", "time zone for day, week and month buckets and for printing times, ex: America/Los_Angeles (defaults to local time)")
+	fs.BoolVar(&sybil.FLAGS.LIMIT_PER_BUCKET, "limit-per-bucket", false, "pick the top -limit groups separately for each time bucket")
+	fs.BoolVar(&sybil.FLAGS.OTHER, "other", false, "with -limit-per-bucket, roll the rest of each bucket up into an (other) group")
+	fs.StringVar(&sybil.FLAGS.FILL, "fill", "", "print every time bucket between -start and -end for each group, filling gaps with one of zero, null or previous")
+	fs.StringVar(&sybil.FLAGS.TRANSFORM, "transform", "", "series to derive from a time rollup (rate, delta, cumsum, mavg or mpNN), ex: rate(max(requests)),mavg(latency)")
+	fs.IntVar(&sybil.FLAGS.WINDOW, "window", sybil.DEFAULT_TRANSFORM_WINDOW, "how many time buckets -transform mavg and mpNN cover")
+	fs.StringVar(&sybil.FLAGS.WEIGHT_COL, "weight-col", "", "Which column to treat as an optional weighting column")
+
+	fs.BoolVar(&sybil.FLAGS.LOG_HIST, "loghist", false, "Use nested logarithmic histograms")
+	fs.BoolVar(&sybil.FLAGS.HDR_HIST, "hdr", false, "Use HDR histograms for int columns, which keep every value to -hdr-digits significant digits")
+	fs.IntVar(&sybil.FLAGS.HDR_DIGITS, "hdr-digits", sybil.DEFAULT_HDR_DIGITS, "Significant digits (1 to 5) that -hdr histograms keep")
+
+	fs.BoolVar(&sybil.FLAGS.ENCODE_RESULTS, "encode-results", false, "Print the results in binary format")
+	fs.BoolVar(&sybil.FLAGS.MERGEABLE_HISTS, "mergeable-hists", true, "With -encode-results or several -table, use HDR histograms for int columns so their percentiles merge without losing precision")
+	fs.BoolVar(&sybil.FLAGS.ENCODE_FLAGS, "encode-flags", false, "Print the query flags in binary format")
+	fs.BoolVar(&sybil.FLAGS.DECODE_FLAGS, "decode-flags", false, "Use the query flags supplied on stdin")
+	fs.StringVar(&sybil.FLAGS.INT_FILTERS, "int-filter", "", "Int filters, format: col:op:val")
+	fs.IntVar(&sybil.FLAGS.HIST_BUCKET, "int-bucket", 0, "Int hist bucket size")
+	fs.StringVar(&sybil.FLAGS.BUCKET_BOUNDS, "bucket-bounds", "", "Increasing bucket bounds for -agg buckets(col), ex: 100,300,1000")
+
+	fs.StringVar(&sybil.FLAGS.STR_REPLACE, "str-replace", "", "Str replacement, format: col:find:replace")
+	fs.StringVar(&sybil.FLAGS.STR_FILTERS, "str-filter", "", "Str filters, format: col:op:val")
+	fs.StringVar(&sybil.FLAGS.SET_FILTERS, "set-filter", "", "Set filters, format: col:op:val")
+	fs.StringVar(&sybil.FLAGS.FLOAT_FILTERS, "float-filter", "", "Float filters, format: col:op:val")
+	fs.StringVar(&sybil.FLAGS.WHERE, "where", "", "Filter expression, ex: \"(status = 500 or status = 503) and not host ~ '^canary'\"")
+	fs.StringVar(&sybil.FLAGS.HAVING, "having", "", "Filter the grouped results, ex: \"count > 1000 and p99(latency) > 500\"")
+	fs.BoolVar(&sybil.FLAGS.UPDATE_TABLE_INFO, "update-info", false, "Re-compute cached column data")
+
+	fs.StringVar(&sybil.FLAGS.INTS, "int", "", "Integer (or float) values to aggregate")
+	fs.StringVar(&sybil.FLAGS.AGGS, "agg", "", "Per column aggregations (sum, min, max, count, avg, hist, pNN, buckets, slo or apdex), ex: sum(bytes),p95(latency),slo(latency<300)")
+	fs.StringVar(&sybil.FLAGS.STRS, "str", "", "String values to load")
+	fs.StringVar(&sybil.FLAGS.SETS, "set", "", "Set values to load")
+	fs.StringVar(&sybil.FLAGS.SAMPLE_COLS, "sample-cols", "", "Columns to load for samples query")
+	fs.StringVar(&sybil.FLAGS.GROUPS, "group", "", "values group by")
+	fs.StringVar(&sybil.FLAGS.DISTINCT, sybil.DISTINCT_STR, "", "distinct group by")
+	fs.IntVar(&sybil.FLAGS.NUM_DISTINCT, sybil.NUM_DISTINCT, -1, "short the group by when this number of elements is hit")
+	fs.IntVar(&sybil.FLAGS.DISTINCT_PRECISION, "distinct-precision", sybil.DEFAULT_DISTINCT_PRECISION, "log2 of the count distinct sketch size (4 to 18), higher is more accurate but uses more memory")
+	fs.BoolVar(&sybil.FLAGS.DISTINCT_EXACT, "distinct-exact", false, "count small distincts exactly, switching to a sketch once they get big")
+
+	fs.BoolVar(&sybil.FLAGS.EXPORT, "export", false, "export data to TSV")
+
+	fs.BoolVar(&sybil.FLAGS.READ_ROWSTORE, "read-log", false, "read the ingestion log (can take longer!)")
+	fs.BoolVar(&sybil.FLAGS.SKIP_OLD_LOGS, "skip-old-logs", false, "with -read-log and -start, skip ingestion log files last written before -start. Only safe when -time-col is the time records were ingested at")
+
+	fs.BoolVar(&sybil.FLAGS.RECYCLE_MEM, "recycle-mem", true, "recycle memory slabs (versus using Go's GC)")
+	fs.BoolVar(&sybil.FLAGS.FAST_RECYCLE, "fast-recycle", true, "faster memory recycling")
+	fs.BoolVar(&sybil.FLAGS.SHORTEN_KEY_TABLE, "shorten-key-table", true, "faster queries on wide tabes by shortening the key lookup")
+
+	fs.BoolVar(&sybil.FLAGS.CACHED_QUERIES, "cache-queries", false, "Cache query results per block")
 
 }
 
 func RunQueryCmdLine() {
-	addQueryFlags()
-	addPrintFlags()
+	addQueryFlags(flag.CommandLine)
+	addPrintFlags(flag.CommandLine)
 	flag.Parse()
 
 	runQueryCmdLine()
@@ -97,15 +173,133 @@ func runQueryCmdLine() {
 		return
 	}
 
-	table := sybil.FLAGS.TABLE
-	if table == "" {
+	if sybil.FLAGS.TABLE == "" {
 		flag.PrintDefaults()
 		return
 	}
 
-	t := sybil.GetTable(table)
-	if t.IsNotExist() {
-		sybil.Error(t.Name, "table can not be loaded or does not exist in", sybil.FLAGS.DIR)
+	table_names, err := sybil.ExpandTables(sybil.FLAGS.TABLE)
+	if err != nil {
+		sybil.Error("NO TABLES TO QUERY", err)
+	}
+
+	tables := make([]*sybil.Table, 0, len(table_names))
+	for _, name := range table_names {
+		t := sybil.GetTable(name)
+		if t.IsNotExist() {
+			sybil.Error(t.Name, "table can not be loaded or does not exist in", sybil.FLAGS.DIR)
+		}
+		tables = append(tables, t)
+	}
+
+	if _, err := sybil.LoadTimeZone(sybil.FLAGS.TIME_ZONE); err != nil {
+		sybil.Error("UNKNOWN TIME ZONE", sybil.FLAGS.TIME_ZONE, err)
+	}
+
+	fill, err := sybil.ParseFill(sybil.FLAGS.FILL)
+	if err != nil {
+		sybil.Error("UNKNOWN FILL", err)
+	}
+
+	transforms, err := sybil.ParseTransforms(sybil.FLAGS.TRANSFORM, sybil.FLAGS.FIELD_SEPARATOR)
+	if err != nil {
+		sybil.Error("COULDNT PARSE TRANSFORM", err)
+	}
+	if len(transforms) > 0 && !sybil.FLAGS.TIME {
+		sybil.Error("-transform NEEDS -time")
+	}
+	if sybil.FLAGS.WINDOW < 1 {
+		sybil.Error("-window MUST BE AT LEAST 1")
+	}
+
+	compare_offset := int64(0)
+	if sybil.FLAGS.COMPARE_TO != "" {
+		compare_offset, err = sybil.ParseCompareOffset(sybil.FLAGS.COMPARE_TO)
+		if err != nil {
+			sybil.Error("BAD -compare-to", err)
+		}
+		if sybil.FLAGS.ENCODE_RESULTS || sybil.FLAGS.SAMPLES {
+			sybil.Error("CANT USE -compare-to WITH -encode-results OR -samples")
+		}
+	}
+
+	if sybil.FLAGS.HEAVY_HITTERS > 0 && sybil.FLAGS.TIME {
+		sybil.Error("CANT USE -heavy-hitters WITH -time")
+	}
+
+	percentiles, err := sybil.ParsePercentiles(sybil.FLAGS.PERCENTILES)
+	if err != nil {
+		sybil.Error("BAD PERCENTILES", err)
+	}
+	if len(percentiles) > 0 {
+		sybil.FLAGS.OP = sybil.HIST_STR
+	}
+
+	if _, err := sybil.ParseDistinctPrecision(sybil.FLAGS.DISTINCT_PRECISION); err != nil {
+		sybil.Error("BAD DISTINCT PRECISION", err)
+	}
+
+	if sybil.FLAGS.HDR_DIGITS < 1 || sybil.FLAGS.HDR_DIGITS > sybil.MAX_HDR_DIGITS {
+		sybil.Error("-hdr-digits MUST BE BETWEEN 1 AND", sybil.MAX_HDR_DIGITS)
+	}
+
+	// computed columns are added to each table when it's queried, these are
+	// the columns they read
+	computed := make(map[string]bool)
+	computed_cols := make([]string, 0)
+	for _, spec := range sybil.FLAGS.COMPUTE {
+		c, err := sybil.ParseComputedColumn(spec)
+		if err != nil {
+			sybil.Error("BAD -compute", err)
+		}
+
+		computed[c.Name] = true
+		computed_cols = append(computed_cols, c.Columns()...)
+	}
+
+	// sessions are built from the actor, time and funnel columns of every
+	// matched record
+	var sessions *sybil.SessionSpec
+	session_cols := make([]string, 0)
+	if sybil.FLAGS.SESSION_COL != "" {
+		gap, err := sybil.ParseSessionGap(sybil.FLAGS.SESSION_GAP)
+		if err != nil {
+			sybil.Error("BAD -session-gap", err)
+		}
+
+		funnel, err := sybil.ParseFunnel(sybil.FLAGS.FUNNEL, sybil.FLAGS.FIELD_SEPARATOR)
+		if err != nil {
+			sybil.Error("BAD -funnel", err)
+		}
+		if len(funnel) > 0 && sybil.FLAGS.FUNNEL_COL == "" {
+			sybil.Error("-funnel NEEDS A -funnel-col")
+		}
+
+		if sybil.FLAGS.TIME || sybil.FLAGS.GROUPS != "" || compare_offset != 0 {
+			sybil.Error("CANT USE -session WITH -time, -group OR -compare-to")
+		}
+
+		sessions = &sybil.SessionSpec{Actor: sybil.FLAGS.SESSION_COL, TimeCol: sybil.FLAGS.TIME_COL, Gap: gap,
+			FunnelCol: sybil.FLAGS.FUNNEL_COL, Funnel: funnel}
+		session_cols = append(session_cols, sessions.Actor, sessions.TimeCol)
+		if sessions.FunnelCol != "" {
+			session_cols = append(session_cols, sessions.FunnelCol)
+		}
+	} else if sybil.FLAGS.FUNNEL != "" || sybil.FLAGS.FUNNEL_COL != "" {
+		sybil.Error("-funnel NEEDS A -session")
+	}
+
+	lua_script := ""
+	if sybil.FLAGS.LUA_FILE != "" {
+		source, err := ioutil.ReadFile(sybil.FLAGS.LUA_FILE)
+		if err != nil {
+			sybil.Error("COULDNT READ LUA SCRIPT", err)
+		}
+
+		if err := sybil.LoadLuaScript(string(source)); err != nil {
+			sybil.Error("BAD LUA SCRIPT", err)
+		}
+		lua_script = string(source)
 	}
 
 	ints := make([]string, 0)
@@ -120,6 +314,17 @@ func runQueryCmdLine() {
 		groups = strings.Split(sybil.FLAGS.GROUPS, sybil.FLAGS.FIELD_SEPARATOR)
 	}
 
+	// $table isn't a column, each table's results are grouped by it after
+	// the table is queried
+	table_group := -1
+	for i, g := range groups {
+		if g == sybil.TABLE_GROUP {
+			table_group = i
+			groups = append(groups[:i:i], groups[i+1:]...)
+			break
+		}
+	}
+
 	if sybil.FLAGS.DISTINCT != "" {
 		distinct = strings.Split(sybil.FLAGS.DISTINCT, sybil.FLAGS.FIELD_SEPARATOR)
 	}
@@ -138,6 +343,94 @@ func runQueryCmdLine() {
 		has_sample_cols = true
 	}
 
+	// -op slo:latency<300 is short for -agg slo(latency<300)
+	if colon := strings.Index(sybil.FLAGS.OP, ":"); colon > 0 {
+		op_agg := sybil.FLAGS.OP[:colon] + "(" + sybil.FLAGS.OP[colon+1:] + ")"
+		if sybil.FLAGS.AGGS != "" {
+			op_agg = sybil.FLAGS.FIELD_SEPARATOR + op_agg
+		}
+		sybil.FLAGS.AGGS += op_agg
+		sybil.FLAGS.OP = sybil.OP_AVG
+	}
+
+	agg_ops, err := sybil.ParseAggs(sybil.FLAGS.AGGS, sybil.FLAGS.FIELD_SEPARATOR)
+	if err != nil {
+		sybil.Error(err)
+	}
+
+	bucket_bounds, err := sybil.ParseBucketBounds(sybil.FLAGS.BUCKET_BOUNDS, sybil.FLAGS.FIELD_SEPARATOR)
+	if err != nil {
+		sybil.Error("BAD BUCKET BOUNDS", err)
+	}
+
+	for i, agg := range agg_ops {
+		if agg.Op == sybil.OP_BUCKETS {
+			if len(bucket_bounds) == 0 {
+				sybil.Error(agg.Label(), "NEEDS -bucket-bounds")
+			}
+			agg_ops[i].Bounds = bucket_bounds
+		}
+	}
+
+	agg_cols := make([]string, 0)
+	for _, agg := range agg_ops {
+		agg_cols = append(agg_cols, agg.Name)
+	}
+
+	// -having and -sort can only read columns the query aggregates
+	aggregated := make(map[string]bool)
+	for _, col := range append(ints, agg_cols...) {
+		aggregated[col] = true
+	}
+
+	having_aggs := make([]sybil.Aggregation, 0)
+	if sybil.FLAGS.HAVING != "" {
+		having, err := sybil.ParseHaving(sybil.FLAGS.HAVING)
+		if err != nil {
+			sybil.Error("COULDNT PARSE HAVING", err)
+		}
+
+		having_aggs = having.HavingAggregations()
+		for _, agg := range having_aggs {
+			if !aggregated[agg.Name] {
+				sybil.Error("HAVING USES", agg.Name, "BUT IT ISN'T AGGREGATED, ADD IT TO -int OR -agg")
+			}
+		}
+	}
+
+	sort_aggs := make([]sybil.Aggregation, 0)
+	for _, sort_by := range []string{sybil.FLAGS.SORT, sybil.FLAGS.PRUNE_BY} {
+		if sort_by == "" {
+			continue
+		}
+
+		if _, _, err := sybil.ParseSortBy(sort_by); err != nil {
+			sybil.Error("COULDNT PARSE SORT", err)
+		}
+
+		for _, agg := range sybil.SortAggregations(sort_by) {
+			if !aggregated[agg.Name] {
+				sybil.Error("SORTING BY", agg.Name, "BUT IT ISN'T AGGREGATED, ADD IT TO -int OR -agg")
+			}
+			sort_aggs = append(sort_aggs, agg)
+		}
+	}
+
+	transform_aggs := sybil.TransformAggregations(transforms)
+	for _, agg := range transform_aggs {
+		if !aggregated[agg.Name] {
+			sybil.Error("TRANSFORMING", agg.Name, "BUT IT ISN'T AGGREGATED, ADD IT TO -int OR -agg")
+		}
+	}
+
+	// histograms only keep buckets around in hist mode, which percentiles
+	// need. The op is settled before any aggregation is built, -int columns
+	// still print the -op they asked for
+	int_op := sybil.FLAGS.OP
+	if sybil.NeedsPercentiles(agg_ops) || sybil.NeedsPercentiles(having_aggs) || sybil.NeedsPercentiles(sort_aggs) || sybil.NeedsPercentiles(transform_aggs) {
+		sybil.FLAGS.OP = sybil.HIST_STR
+	}
+
 	sample_cols := make([]string, 0)
 	if sybil.FLAGS.SAMPLE_COLS != "" {
 		sample_cols = strings.Split(sybil.FLAGS.SAMPLE_COLS, sybil.FLAGS.FIELD_SEPARATOR)
@@ -155,13 +448,191 @@ func runQueryCmdLine() {
 		sybil.FLAGS.READ_INGESTION_LOG = true
 	}
 
+	q := &tableQuery{ints: ints, int_op: int_op, strs: strs, sets: sets, groups: groups, distinct: distinct,
+		agg_ops: agg_ops, agg_cols: agg_cols, sample_cols: sample_cols, has_sample_cols: has_sample_cols,
+		fill: fill, transforms: transforms, percentiles: percentiles, compare_offset: compare_offset,
+		computed: computed, computed_cols: computed_cols, lua_script: lua_script,
+		sessions: sessions, session_cols: session_cols, multi: len(tables) > 1}
+
+	if q.multi && !sybil.FLAGS.PRINT_INFO {
+		checkTableColumns(tables, q)
+		sybil.OPTS.MULTI_TABLE = true
+	}
+
+	specs := make([]*sybil.QuerySpec, 0, len(tables))
+	baselines := make([]*sybil.QuerySpec, 0, len(tables))
+	for _, t := range tables {
+		querySpec, baseline := runTableQuery(t, q)
+		if querySpec == nil {
+			continue
+		}
+
+		if table_group >= 0 {
+			querySpec.AddTableGroup(t.Name, table_group)
+			if baseline != nil {
+				baseline.AddTableGroup(t.Name, table_group)
+			}
+		}
+
+		specs = append(specs, querySpec)
+		baselines = append(baselines, baseline)
+	}
+
+	if sybil.FLAGS.SAMPLES {
+		if q.multi {
+			sybil.PrintTablesSamples(tables)
+		} else {
+			tables[0].PrintSamples()
+		}
+
+		return
+	}
+
+	if sybil.FLAGS.PRINT_INFO {
+		sybil.FLAGS.LOAD_AND_QUERY = false
+		if q.multi {
+			sybil.MergeTableInfo(tables).PrintTableInfo()
+		} else {
+			tables[0].PrintTableInfo()
+		}
+
+		return
+	}
+
+	if len(specs) == 0 {
+		return
+	}
+
+	querySpec, baseline := specs[0], baselines[0]
+	if q.multi {
+		querySpec = sybil.CombineTableResults(specs)
+		querySpec.Having = sybil.FLAGS.HAVING
+		querySpec.FinishResults()
+		if compare_offset != 0 {
+			baseline = sybil.CombineTableResults(baselines)
+		}
+	}
+
+	if compare_offset != 0 {
+		querySpec.CompareTo(baseline, compare_offset)
+	}
+	querySpec.PrintResults()
+}
+
+// the parts of a query that don't depend on the table it runs over
+type tableQuery struct {
+	ints, strs, sets, groups, distinct []string
+	int_op                             string // the -op of the -int columns
+
+	agg_ops               []sybil.Aggregation
+	agg_cols, sample_cols []string
+	has_sample_cols       bool
+
+	fill           string
+	transforms     []sybil.Transform
+	percentiles    []float64
+	compare_offset int64
+
+	computed      map[string]bool // the names of the -compute columns
+	computed_cols []string        // the columns they read
+
+	lua_script string // the source of the -lua script
+
+	sessions     *sybil.SessionSpec // nil unless the query has a -session
+	session_cols []string           // the columns sessions are built from
+
+	multi bool // whether the query runs over several tables
+}
+
+// makes sure each table has the columns the query reads, with the same types
+func checkTableColumns(tables []*sybil.Table, q *tableQuery) {
+	cols := make([]string, 0)
+	for _, group := range [][]string{q.groups, q.distinct, q.ints, q.strs, q.sets, q.agg_cols, q.sample_cols, q.computed_cols, q.session_cols} {
+		for _, col := range group {
+			// joined and computed columns are added to each table when it's
+			// queried
+			if sybil.FLAGS.JOIN_TABLE != "" && strings.HasPrefix(col, sybil.FLAGS.JOIN_TABLE+".") {
+				continue
+			}
+			if q.computed[col] {
+				continue
+			}
+			cols = append(cols, col)
+		}
+	}
+	if sybil.FLAGS.TIME {
+		cols = append(cols, sybil.FLAGS.TIME_COL)
+	}
+
+	for _, t := range tables {
+		t.LoadTableInfo()
+	}
+
+	if err := sybil.CheckTableColumns(tables, cols); err != nil {
+		sybil.Error("CANT QUERY THE TABLES TOGETHER", err)
+	}
+}
+
+// runs the query over one table. It returns the query's results and the
+// results of its -compare-to baseline, which are nil if it only loaded
+// samples or info
+func runTableQuery(t *sybil.Table, q *tableQuery) (*sybil.QuerySpec, *sybil.QuerySpec) {
+	// sample columns are added to these, so each table gets its own copy
+	ints := append([]string{}, q.ints...)
+	strs := append([]string{}, q.strs...)
+	sets := append([]string{}, q.sets...)
+
+	groups, distinct, sample_cols, has_sample_cols := q.groups, q.distinct, q.sample_cols, q.has_sample_cols
+	agg_ops, agg_cols := q.agg_ops, q.agg_cols
+	fill, transforms, percentiles, compare_offset := q.fill, q.transforms, q.percentiles, q.compare_offset
+
+	var result, baseline *sybil.QuerySpec
+
 	// LOAD TABLE INFOS BEFORE WE CREATE OUR FILTERS, SO WE CAN CREATE FILTERS ON
 	// THE RIGHT COLUMN ID
 	t.LoadTableInfo()
 	t.LoadRecords(nil)
 
+	// joined columns go into the key table, so they have to be added before
+	// the filters are built and the key table is shortened
+	join_key := ""
+	if sybil.FLAGS.JOIN_TABLE != "" {
+		if sybil.FLAGS.JOIN_KEY == "" {
+			sybil.Error("-join-table NEEDS A -join-key")
+		}
+
+		dim := sybil.GetTable(sybil.FLAGS.JOIN_TABLE)
+		if dim.IsNotExist() {
+			sybil.Error(dim.Name, "table can not be loaded or does not exist in", sybil.FLAGS.DIR)
+		}
+
+		var dim_key string
+		join_key, dim_key = sybil.ParseJoinKey(sybil.FLAGS.JOIN_KEY)
+		if err := t.JoinTable(dim, join_key, dim_key); err != nil {
+			sybil.Error("COULDNT JOIN", err)
+		}
+	}
+
+	// computed columns can read joined ones, so they are added after them
+	for _, spec := range sybil.FLAGS.COMPUTE {
+		c, _ := sybil.ParseComputedColumn(spec)
+		if err := t.AddComputedColumn(c); err != nil {
+			sybil.Error("COULDNT COMPUTE", c.Name, err)
+		}
+	}
+
 	// Make filterSpec before shortening key table
-	filterSpec := sybil.FilterSpec{Int: sybil.FLAGS.INT_FILTERS, Str: sybil.FLAGS.STR_FILTERS, Set: sybil.FLAGS.SET_FILTERS}
+	filterSpec := sybil.FilterSpec{Int: sybil.FLAGS.INT_FILTERS, Str: sybil.FLAGS.STR_FILTERS, Set: sybil.FLAGS.SET_FILTERS, Float: sybil.FLAGS.FLOAT_FILTERS, Where: sybil.FLAGS.WHERE,
+		Start: sybil.FLAGS.START, End: sybil.FLAGS.END}
+
+	var baselineFilterSpec sybil.FilterSpec
+	if compare_offset != 0 {
+		var err error
+		baselineFilterSpec, err = filterSpec.ShiftTimeRange(compare_offset, time.Now())
+		if err != nil {
+			sybil.Error("CANT COMPARE", err)
+		}
+	}
 
 	count := 0
 	for _, block := range t.BlockList {
@@ -178,6 +649,10 @@ func runQueryCmdLine() {
 	if sybil.FLAGS.SAMPLES && !has_sample_cols {
 		shorten_key_table = false
 	}
+	// lua scripts can read any column
+	if q.lua_script != "" {
+		shorten_key_table = false
+	}
 
 	if sybil.FLAGS.READ_ROWSTORE && shorten_key_table {
 		sybil.Debug("DISABLING SHORT KEY TABLE BECAUSE OF ROWSTORE")
@@ -190,10 +665,19 @@ func runQueryCmdLine() {
 		t.UseKeys(strs)
 		t.UseKeys(sets)
 		t.UseKeys(ints)
+		t.UseKeys(agg_cols)
 		t.UseKeys(groups)
 		t.UseKeys(distinct)
 		t.UseKeys(sample_cols)
 		t.UseKeys(filterSpec.GetFilterCols())
+		if sybil.FLAGS.TIME {
+			t.UseKeys([]string{sybil.FLAGS.TIME_COL})
+		}
+		if join_key != "" {
+			t.UseKeys([]string{join_key})
+		}
+		t.UseKeys(q.computed_cols)
+		t.UseKeys(q.session_cols)
 
 		t.ShortenKeyTable()
 
@@ -208,7 +692,13 @@ func runQueryCmdLine() {
 	aggs := []sybil.Aggregation{}
 	if !sybil.FLAGS.SAMPLES {
 		for _, agg := range ints {
-			aggs = append(aggs, t.Aggregation(agg, sybil.FLAGS.OP))
+			aggs = append(aggs, t.Aggregation(agg, q.int_op))
+		}
+
+		for _, agg := range agg_ops {
+			a := t.Aggregation(agg.Name, agg.Op)
+			a.Bounds = agg.Bounds
+			aggs = append(aggs, a)
 		}
 	}
 
@@ -231,7 +721,7 @@ func runQueryCmdLine() {
 		used[v]++
 		if used[v] > 1 {
 			sybil.Error("THERE IS A SERIOUS KEY TABLE INCONSISTENCY")
-			return
+			return nil, nil
 		}
 	}
 
@@ -250,8 +740,10 @@ func runQueryCmdLine() {
 			loadSpec.Str(v)
 		case sybil.INT_VAL:
 			loadSpec.Int(v)
+		case sybil.FLOAT_VAL:
+			loadSpec.Float(v)
 		case sybil.SET_VAL:
-			sybil.Error("Grouping by Set columns is currently not supported")
+			loadSpec.Set(v)
 		default:
 			t.PrintTableInfo()
 			loadSpec.Missing(v)
@@ -262,7 +754,7 @@ func runQueryCmdLine() {
 	for _, v := range sample_cols {
 		key_id := t.KeyTable[v]
 		switch t.KeyTypes[key_id] {
-		case sybil.INT_VAL:
+		case sybil.INT_VAL, sybil.FLOAT_VAL:
 			ints = append(ints, v)
 		case sybil.STR_VAL:
 			strs = append(strs, v)
@@ -279,31 +771,71 @@ func runQueryCmdLine() {
 		loadSpec.Set(v)
 	}
 	for _, v := range ints {
-		loadSpec.Int(v)
+		load_numeric_col(t, &loadSpec, v)
 	}
-
-	if sybil.FLAGS.SORT != "" {
-		if sybil.FLAGS.SORT != SORT_COUNT {
-			loadSpec.Int(sybil.FLAGS.SORT)
+	for _, v := range agg_cols {
+		load_numeric_col(t, &loadSpec, v)
+	}
+	if join_key != "" {
+		switch t.GetColumnType(join_key) {
+		case sybil.STR_VAL:
+			loadSpec.Str(join_key)
+		case sybil.INT_VAL:
+			loadSpec.Int(join_key)
 		}
-		querySpec.OrderBy = sybil.FLAGS.SORT
-	} else {
-		querySpec.OrderBy = ""
 	}
-
-	if sybil.FLAGS.PRUNE_BY != "" {
-		if sybil.FLAGS.PRUNE_BY != SORT_COUNT {
-			loadSpec.Int(sybil.FLAGS.PRUNE_BY)
+	for _, v := range append(q.computed_cols, q.session_cols...) {
+		switch t.GetColumnType(v) {
+		case sybil.STR_VAL:
+			loadSpec.Str(v)
+		case sybil.INT_VAL:
+			loadSpec.Int(v)
+		case sybil.FLOAT_VAL:
+			loadSpec.Float(v)
 		}
-		querySpec.PruneBy = sybil.FLAGS.PRUNE_BY
+	}
+
+	// the columns -sort and -prune-sort read are aggregated, so they are
+	// already loaded. An empty PruneBy prunes by OrderBy
+	querySpec.OrderBy = sybil.FLAGS.SORT
+	querySpec.PruneBy = sybil.FLAGS.PRUNE_BY
+	// with several tables, -having waits until their results are combined
+	if !q.multi {
+		querySpec.Having = sybil.FLAGS.HAVING
 	} else {
-		querySpec.PruneBy = SORT_COUNT
+		querySpec.HavingPending = sybil.FLAGS.HAVING != ""
+	}
+	querySpec.EncodeResults = sybil.FLAGS.ENCODE_RESULTS
+	querySpec.HeavyHitters = sybil.FLAGS.HEAVY_HITTERS
+	querySpec.Percentiles = percentiles
+	if q.lua_script != "" {
+		querySpec.LuaScript = q.lua_script
+		loadSpec.LoadAllColumns = true
+	}
+	if q.sessions != nil {
+		querySpec.Sessions = t.Sessions(*q.sessions)
 	}
 
 	if sybil.FLAGS.TIME {
 		// TODO: infer the TimeBucket size
 		querySpec.TimeBucket = sybil.FLAGS.TIME_BUCKET
-		sybil.Debug("USING TIME BUCKET", querySpec.TimeBucket, "SECONDS")
+		querySpec.TimeCalendar = sybil.FLAGS.TIME_CALENDAR
+		querySpec.TimeZone = sybil.FLAGS.TIME_ZONE
+		querySpec.LimitPerBucket = sybil.FLAGS.LIMIT_PER_BUCKET
+		querySpec.Other = sybil.FLAGS.OTHER
+		querySpec.Fill = fill
+		if fill != "" {
+			fill_start, fill_end, err := sybil.ParseTimeRange(sybil.FLAGS.START, sybil.FLAGS.END, time.Now())
+			if err != nil {
+				sybil.Error("COULDNT PARSE TIME RANGE", err)
+			}
+			querySpec.FillStart, querySpec.FillEnd = fill_start, fill_end
+		}
+		querySpec.Transforms = transforms
+		if len(transforms) > 0 {
+			querySpec.TransformWindow = sybil.FLAGS.WINDOW
+		}
+		sybil.Debug("USING TIME BUCKET", querySpec.TimeBucket, "SECONDS", querySpec.TimeCalendar, querySpec.TimeZone)
 		loadSpec.Int(sybil.FLAGS.TIME_COL)
 		time_col_id, ok := t.KeyTable[sybil.FLAGS.TIME_COL]
 		if ok {
@@ -319,6 +851,8 @@ func runQueryCmdLine() {
 
 	querySpec.Limit = int(sybil.FLAGS.LIMIT)
 	querySpec.NumDistinct = int(sybil.FLAGS.NUM_DISTINCT)
+	querySpec.DistinctPrecision = sybil.FLAGS.DISTINCT_PRECISION
+	querySpec.DistinctExact = sybil.FLAGS.DISTINCT_EXACT
 	if querySpec.NumDistinct > 0 {
 		sybil.Debug("Setting Limit to same as NumDistinct:", querySpec.NumDistinct)
 		querySpec.Limit = querySpec.NumDistinct
@@ -335,9 +869,7 @@ func runQueryCmdLine() {
 
 		t.LoadAndQueryRecords(&loadSpec, &querySpec)
 
-		t.PrintSamples()
-
-		return
+		return nil, nil
 	}
 
 	if sybil.FLAGS.EXPORT {
@@ -347,7 +879,8 @@ func runQueryCmdLine() {
 	if !sybil.FLAGS.PRINT_INFO {
 		// DISABLE GC FOR QUERY PATH
 		sybil.Debug("ADDING BULLET HOLES FOR SPEED (DISABLING GC)")
-		debug.SetGCPercent(-1)
+		old_percent := debug.SetGCPercent(-1)
+		defer debug.SetGCPercent(old_percent)
 
 		sybil.Debug("USING LOAD SPEC", loadSpec)
 
@@ -360,7 +893,10 @@ func runQueryCmdLine() {
 
 			end := time.Now()
 			sybil.Debug("LOAD AND QUERY RECORDS TOOK", end.Sub(start))
-			querySpec.PrintResults()
+			if compare_offset != 0 {
+				baseline = queryBaseline(t, &loadSpec, &querySpec, baselineFilterSpec)
+			}
+			result = &querySpec
 		}
 
 	}
@@ -369,13 +905,37 @@ func runQueryCmdLine() {
 		sybil.Print("EXPORTED RECORDS TO", path.Join(t.Name, "export"))
 	}
 
-	if sybil.FLAGS.PRINT_INFO {
-		t := sybil.GetTable(table)
-		sybil.FLAGS.LOAD_AND_QUERY = false
+	return result, baseline
+}
 
-		t.PrintTableInfo()
-	}
+// runs the query again over the -compare-to baseline's time range. With
+// -cache-queries, the baseline uses the per block query cache, see compare.go
+func queryBaseline(t *sybil.Table, loadSpec *sybil.LoadSpec, querySpec *sybil.QuerySpec, filterSpec sybil.FilterSpec) *sybil.QuerySpec {
+	old_start, old_end := sybil.FLAGS.START, sybil.FLAGS.END
+	sybil.FLAGS.START, sybil.FLAGS.END = filterSpec.Start, filterSpec.End
+	defer func() {
+		sybil.FLAGS.START, sybil.FLAGS.END = old_start, old_end
+	}()
+
+	baseline := sybil.QuerySpec{QueryParams: querySpec.QueryParams}
+	baseline.EncodeResults = querySpec.EncodeResults
+	baseline.Filters = sybil.BuildFilters(t, loadSpec, filterSpec)
 
+	start := time.Now()
+	t.LoadAndQueryRecords(loadSpec, &baseline)
+	sybil.Debug("QUERYING THE BASELINE TOOK", time.Now().Sub(start))
+
+	return &baseline
+}
+
+// -int columns can also be float columns, so we check the column's type
+// before adding it to the load spec
+func load_numeric_col(t *sybil.Table, loadSpec *sybil.LoadSpec, name string) {
+	if t.GetColumnType(name) == sybil.FLOAT_VAL {
+		loadSpec.Float(name)
+	} else {
+		loadSpec.Int(name)
+	}
 }
 
 func split(s, sep string) []string {
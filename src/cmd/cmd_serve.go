@@ -0,0 +1,378 @@
+package sybil_cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	sybil "github.com/logv/sybil/src/lib"
+)
+
+// sybil serve keeps a sybil process around between requests, so that table
+// info and block info caches stay warm instead of being re-read from disk on
+// every query. The query path is built on top of the global FLAGS and OPTS, so
+// requests are served one at a time and each one starts from a fresh copy of
+// the flags that the server was started with.
+
+type sybilServer struct {
+	m sync.Mutex
+
+	flags sybil.FlagDefs
+	opts  sybil.OptionDefs
+}
+
+type serveError struct {
+	Error string `json:"error"`
+}
+
+func newSybilServer() *sybilServer {
+	return &sybilServer{flags: sybil.FLAGS, opts: sybil.OPTS}
+}
+
+func (s *sybilServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/query", s.handleQuery)
+	mux.HandleFunc("/sql", s.handleSQL)
+	mux.HandleFunc("/info", s.handleInfo)
+	mux.HandleFunc("/tables", s.handleTables)
+	mux.HandleFunc("/ingest", s.handleIngest)
+	mux.HandleFunc("/digest", s.handleDigest)
+
+	return mux
+}
+
+// resets the process wide query state before every request. What the flags
+// and options share (like the STR_REPLACEMENTS map) is copied too, so that a
+// request can't change it for the requests after it
+func (s *sybilServer) reset() {
+	sybil.FLAGS = s.flags
+	sybil.FLAGS.COMPUTE = append([]string(nil), s.flags.COMPUTE...)
+
+	sybil.OPTS = s.opts
+	sybil.OPTS.STR_REPLACEMENTS = make(map[string]sybil.StrReplace)
+	for col, replace := range s.opts.STR_REPLACEMENTS {
+		sybil.OPTS.STR_REPLACEMENTS[col] = replace
+	}
+
+	sybil.HOLD_MATCHES = false
+	sybil.DELETE_BLOCKS_AFTER_QUERY = true
+	sybil.READ_ROWS_ONLY = false
+	sybil.PANIC_ON_ERROR = true
+}
+
+// run calls cb while holding the server lock and writes whatever cb printed
+// to sybil.OUTPUT as the response. Calls to sybil.Error() inside cb become
+// a 400 response instead of exiting the server.
+func (s *sybilServer) run(w http.ResponseWriter, cb func()) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.reset()
+
+	var buf bytes.Buffer
+	sybil.OUTPUT = &buf
+	defer func() { sybil.OUTPUT = os.Stdout }()
+
+	status, err := s.call(cb)
+	if err != nil {
+		writeServeError(w, status, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	buf.WriteTo(w)
+}
+
+func (s *sybilServer) call(cb func()) (status int, err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+
+		switch e := r.(type) {
+		case sybil.SybilError:
+			status, err = http.StatusBadRequest, e
+		default:
+			sybil.Warn("SERVE RECOVERED FROM PANIC", r)
+			status, err = http.StatusInternalServerError, fmt.Errorf("%v", r)
+		}
+	}()
+
+	cb()
+	return http.StatusOK, nil
+}
+
+func writeServeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(serveError{err.Error()})
+}
+
+// turns ?group=col1&int=col2 into -group=col1 -int=col2, so that requests
+// are parsed exactly the same way as sybil query's command line
+func formToArgs(r *http.Request) []string {
+	r.ParseForm()
+
+	keys := make([]string, 0, len(r.Form))
+	for k := range r.Form {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	args := make([]string, 0)
+	for _, k := range keys {
+		for _, v := range r.Form[k] {
+			args = append(args, fmt.Sprintf("-%s=%s", k, v))
+		}
+	}
+
+	return args
+}
+
+// the query flags that requests can pass. Flags that write to disk (like
+// -update-info, -cache-queries and -export), read other files (like -lua) or
+// change the output format aren't allowed
+var SERVE_QUERY_FLAGS = map[string]bool{
+	"agg":                true,
+	"bucket-bounds":      true,
+	"compare-to":         true,
+	"compute":            true,
+	"distinct":           true,
+	"distinct-exact":     true,
+	"distinct-precision": true,
+	"end":                true,
+	"fill":               true,
+	"float-filter":       true,
+	"funnel":             true,
+	"funnel-col":         true,
+	"group":              true,
+	"having":             true,
+	"hdr":                true,
+	"hdr-digits":         true,
+	"heavy-hitters":      true,
+	"info":               true,
+	"int":                true,
+	"int-bucket":         true,
+	"int-filter":         true,
+	"join-key":           true,
+	"join-table":         true,
+	"limit":              true,
+	"limit-per-bucket":   true,
+	"loghist":            true,
+	"op":                 true,
+	"other":              true,
+	"percentiles":        true,
+	"prune-sort":         true,
+	"read-log":           true,
+	"sample-cols":        true,
+	"samples":            true,
+	"session":            true,
+	"session-gap":        true,
+	"set":                true,
+	"set-filter":         true,
+	"skip-old-logs":      true,
+	"sort":               true,
+	"start":              true,
+	"str":                true,
+	"str-filter":         true,
+	"str-replace":        true,
+	"table":              true,
+	"tables":             true,
+	"tdigest":            true,
+	"time":               true,
+	"time-bucket":        true,
+	"time-col":           true,
+	"transform":          true,
+	"tz":                 true,
+	"weight-col":         true,
+	"where":              true,
+	"window":             true,
+}
+
+func parseServeQueryFlags(args []string) {
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+
+	fs.StringVar(&sybil.FLAGS.TABLE, "table", "", "Table to operate on")
+	addQueryFlags(fs)
+	addPrintFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		sybil.Error(err)
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		if !SERVE_QUERY_FLAGS[f.Name] {
+			sybil.Error("flag not allowed:", "-"+f.Name)
+		}
+	})
+
+	// results always come back as JSON
+	sybil.FLAGS.JSON = true
+	sybil.FLAGS.PRINT = true
+
+	// shortening the key table modifies the cached table in place
+	sybil.FLAGS.SHORTEN_KEY_TABLE = false
+}
+
+func (s *sybilServer) runQuery(args []string) {
+	parseServeQueryFlags(args)
+	s.runParsedQuery()
+}
+
+// runs a query parsed by sybil.ParseSQL, the other parameters are parsed as
+// query flags (like read-log)
+func (s *sybilServer) runSQL(query string, args []string) {
+	parseServeQueryFlags(args)
+
+	sql, err := sybil.ParseSQL(query)
+	if err != nil {
+		sybil.Error(err)
+	}
+
+	sql.SetFlags()
+	s.runParsedQuery()
+}
+
+func (s *sybilServer) runParsedQuery() {
+	if sybil.FLAGS.LIST_TABLES {
+		runQueryCmdLine()
+		return
+	}
+
+	if sybil.FLAGS.TABLE == "" {
+		sybil.Error("missing table parameter")
+	}
+
+	// the block list is rebuilt on every query, but the table info and
+	// block info cache are kept around
+	t := sybil.GetTable(sybil.FLAGS.TABLE)
+	t.BlockList = make(map[string]*sybil.TableBlock)
+	defer func() { t.BlockList = make(map[string]*sybil.TableBlock) }()
+
+	runQueryCmdLine()
+}
+
+func (s *sybilServer) handleQuery(w http.ResponseWriter, r *http.Request) {
+	args := formToArgs(r)
+	s.run(w, func() { s.runQuery(args) })
+}
+
+func (s *sybilServer) handleSQL(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	query := r.Form.Get("q")
+	r.Form.Del("q")
+
+	args := formToArgs(r)
+	s.run(w, func() {
+		if query == "" {
+			sybil.Error("missing q parameter")
+		}
+
+		s.runSQL(query, args)
+	})
+}
+
+func (s *sybilServer) handleInfo(w http.ResponseWriter, r *http.Request) {
+	args := append(formToArgs(r), "-info")
+	s.run(w, func() { s.runQuery(args) })
+}
+
+func (s *sybilServer) handleTables(w http.ResponseWriter, r *http.Request) {
+	args := append(formToArgs(r), "-tables")
+	s.run(w, func() { s.runQuery(args) })
+}
+
+// ingests newline delimited JSON records from the request body. Writes to the
+// ingestion log go through the table's info and digest locks, the same as
+// sybil ingest does.
+func (s *sybilServer) handleIngest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeServeError(w, http.StatusMethodNotAllowed, fmt.Errorf("ingest requires a POST"))
+		return
+	}
+
+	table := r.URL.Query().Get("table")
+	json_path := r.URL.Query().Get("path")
+	if json_path == "" {
+		json_path = "$"
+	}
+
+	s.run(w, func() {
+		if table == "" {
+			sybil.Error("missing table parameter")
+		}
+
+		sybil.FLAGS.TABLE = table
+		JSON_PATH = json_path
+
+		t := sybil.GetTable(table)
+		if !load_table_info_for_ingest(t) {
+			sybil.Error("couldn't read table info for", table)
+		}
+
+		// if anything goes wrong, throw away the partially ingested records
+		defer func() {
+			if e := recover(); e != nil {
+				sybil.UnloadTable(table)
+				panic(e)
+			}
+		}()
+
+		count := import_json_records(r.Body, time.RFC3339)
+		warn_int_fractions()
+		t.IngestRecords(sybil.INGEST_DIR)
+
+		json.NewEncoder(sybil.OUTPUT).Encode(map[string]interface{}{"table": table, "ingested": count})
+	})
+}
+
+// collates the row store into column blocks, like sybil digest
+func (s *sybilServer) handleDigest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeServeError(w, http.StatusMethodNotAllowed, fmt.Errorf("digest requires a POST"))
+		return
+	}
+
+	table := r.URL.Query().Get("table")
+
+	s.run(w, func() {
+		if table == "" {
+			sybil.Error("missing table parameter")
+		}
+
+		sybil.FLAGS.TABLE = table
+		sybil.DELETE_BLOCKS_AFTER_QUERY = false
+
+		t := sybil.GetTable(table)
+		if t.LoadTableInfo() == false {
+			sybil.Error("couldn't read table info for", table)
+		}
+
+		t.DigestRecords()
+		t.BlockList = make(map[string]*sybil.TableBlock)
+
+		json.NewEncoder(sybil.OUTPUT).Encode(map[string]interface{}{"table": table})
+	})
+}
+
+func RunServeCmdLine() {
+	addr := flag.String("addr", "localhost:8888", "address to listen on")
+	flag.Parse()
+
+	s := newSybilServer()
+
+	sybil.Print("SYBIL IS SERVING", sybil.FLAGS.DIR, "ON", *addr)
+	err := http.ListenAndServe(*addr, s.handler())
+	if err != nil {
+		sybil.Error("SERVER EXITED", err)
+	}
+}
@@ -0,0 +1,175 @@
+package sybil_cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	sybil "github.com/logv/sybil/src/lib"
+)
+
+func getServeJSON(t *testing.T, url string, into interface{}) int {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if into != nil && resp.StatusCode == http.StatusOK {
+		if err := json.Unmarshal(body, into); err != nil {
+			t.Error("COULDNT DECODE RESPONSE", url, err, string(body))
+		}
+	}
+
+	return resp.StatusCode
+}
+
+func postServe(t *testing.T, url string, body string) int {
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestServe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sybil_serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old_dir := sybil.FLAGS.DIR
+	sybil.FLAGS.DIR = dir
+	defer func() { sybil.FLAGS.DIR = old_dir }()
+
+	srv := httptest.NewServer(newSybilServer().handler())
+	defer srv.Close()
+
+	tableName := "serve_test"
+
+	lines := make([]string, 0)
+	for i := 0; i < 100; i++ {
+		lines = append(lines, fmt.Sprintf(`{"id": %d, "age": %d, "name": "name_%d"}`, i, i%10, i%5))
+	}
+
+	status := postServe(t, srv.URL+"/ingest?table="+tableName, strings.Join(lines, "\n"))
+	if status != http.StatusOK {
+		t.Fatal("INGEST FAILED WITH STATUS", status)
+	}
+
+	var tables []string
+	getServeJSON(t, srv.URL+"/tables", &tables)
+	if len(tables) != 1 || tables[0] != tableName {
+		t.Error("EXPECTED TABLE LIST TO BE", tableName, "BUT GOT", tables)
+	}
+
+	var results []map[string]interface{}
+	status = getServeJSON(t, srv.URL+"/query?table="+tableName+"&read-log=true&group=name&int=age", &results)
+	if status != http.StatusOK {
+		t.Fatal("QUERY FAILED WITH STATUS", status)
+	}
+
+	if len(results) != 5 {
+		t.Error("EXPECTED 5 GROUPS, GOT", len(results), results)
+	}
+
+	total := 0
+	for _, r := range results {
+		total += int(r["Count"].(float64))
+	}
+
+	if total != 100 {
+		t.Error("EXPECTED 100 RECORDS, GOT", total)
+	}
+
+	status = postServe(t, srv.URL+"/digest?table="+tableName, "")
+	if status != http.StatusOK {
+		t.Fatal("DIGEST FAILED WITH STATUS", status)
+	}
+
+	// after digesting, the records should be in the column store and the
+	// server should pick up the new blocks
+	results = nil
+	getServeJSON(t, srv.URL+"/query?table="+tableName+"&int=age", &results)
+	if len(results) != 1 || int(results[0]["Count"].(float64)) != 100 {
+		t.Error("EXPECTED 100 DIGESTED RECORDS, GOT", results)
+	}
+
+	results = nil
+	sql := url.QueryEscape("SELECT name, count(*), avg(age) FROM " + tableName + " WHERE age < 5 GROUP BY name")
+	getServeJSON(t, srv.URL+"/sql?q="+sql, &results)
+	if len(results) != 5 {
+		t.Error("EXPECTED 5 GROUPS FROM SQL QUERY, GOT", results)
+	}
+
+	for _, r := range results {
+		if int(r["Count"].(float64)) != 10 {
+			t.Error("EXPECTED 10 RECORDS PER GROUP FROM SQL QUERY, GOT", r)
+		}
+	}
+
+	status = getServeJSON(t, srv.URL+"/sql?q="+url.QueryEscape("SELECT name FROM "+tableName+" GROUP name"), nil)
+	if status != http.StatusBadRequest {
+		t.Error("EXPECTED SQL SYNTAX ERROR TO BE A BAD REQUEST, GOT", status)
+	}
+
+	var info map[string]interface{}
+	getServeJSON(t, srv.URL+"/info?table="+tableName, &info)
+	if int(info["count"].(float64)) != 100 {
+		t.Error("EXPECTED TABLE INFO TO HAVE 100 RECORDS, GOT", info["count"])
+	}
+
+	// bad queries are reported back instead of exiting the server
+	status = getServeJSON(t, srv.URL+"/query?table=missing_table", nil)
+	if status != http.StatusBadRequest {
+		t.Error("EXPECTED MISSING TABLE TO BE A BAD REQUEST, GOT", status)
+	}
+
+	status = getServeJSON(t, srv.URL+"/query?table="+tableName+"&not-a-flag=1", nil)
+	if status != http.StatusBadRequest {
+		t.Error("EXPECTED UNKNOWN FLAG TO BE A BAD REQUEST, GOT", status)
+	}
+
+	// -lua runs a script from the server's disk
+	status = getServeJSON(t, srv.URL+"/query?table="+tableName+"&lua=/tmp/script.lua", nil)
+	if status != http.StatusBadRequest {
+		t.Error("EXPECTED -lua TO BE A BAD REQUEST, GOT", status)
+	}
+
+	status = getServeJSON(t, srv.URL+"/query?table="+tableName+"&int=age", nil)
+	if status != http.StatusOK {
+		t.Error("SERVER DIDNT RECOVER AFTER BAD REQUEST", status)
+	}
+
+	// flags that write to disk aren't allowed
+	for _, flag := range []string{"update-info", "cache-queries", "export"} {
+		status = getServeJSON(t, srv.URL+"/query?table="+tableName+"&"+flag+"=true", nil)
+		if status != http.StatusBadRequest {
+			t.Error("EXPECTED -"+flag+" TO BE A BAD REQUEST, GOT", status)
+		}
+	}
+}
+
+func TestServeResetCopiesMaps(t *testing.T) {
+	old_flags, old_opts := sybil.FLAGS, sybil.OPTS
+	defer func() { sybil.FLAGS, sybil.OPTS = old_flags, old_opts }()
+
+	s := newSybilServer()
+	s.opts.STR_REPLACEMENTS = map[string]sybil.StrReplace{"col": {Pattern: "a", Replace: "b"}}
+
+	s.reset()
+	sybil.OPTS.STR_REPLACEMENTS["other"] = sybil.StrReplace{Pattern: "c", Replace: "d"}
+
+	if len(s.opts.STR_REPLACEMENTS) != 1 {
+		t.Error("A REQUEST CHANGED THE SERVER'S STR REPLACEMENTS", s.opts.STR_REPLACEMENTS)
+	}
+}
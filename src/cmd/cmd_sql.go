@@ -0,0 +1,48 @@
+package sybil_cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	sybil "github.com/logv/sybil/src/lib"
+)
+
+// prints a parse error with a marker under the offending position, ex:
+//
+//	SELECT host FROM requests GROUP host
+//	                                ^
+//	syntax error at position 32: expected BY, found "host"
+func printSQLError(query string, err error) {
+	if sql_err, ok := err.(*sybil.SQLError); ok {
+		fmt.Fprintln(os.Stderr, query)
+		fmt.Fprintln(os.Stderr, strings.Repeat(" ", sql_err.Pos)+"^")
+	}
+
+	fmt.Fprintln(os.Stderr, err)
+}
+
+func RunSQLCmdLine() {
+	addQueryFlags(flag.CommandLine)
+	addPrintFlags(flag.CommandLine)
+	flag.Parse()
+
+	query := strings.Join(flag.Args(), " ")
+	if query == "" {
+		fmt.Fprintln(os.Stderr, "Usage: sybil sql [query flags] \"SELECT col, count(*) FROM table GROUP BY col\"")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	sql, err := sybil.ParseSQL(query)
+	if err != nil {
+		printSQLError(query, err)
+		os.Exit(1)
+	}
+
+	sql.SetFlags()
+	sybil.Debug("SQL QUERY", query, "WHERE", sybil.FLAGS.WHERE)
+
+	runQueryCmdLine()
+}
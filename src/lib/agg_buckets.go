@@ -0,0 +1,180 @@
+package sybil
+
+import "fmt"
+import "sort"
+import "strconv"
+import "strings"
+
+// {{{ BUCKET COUNTS AND SLOS
+// buckets(col) counts a column's values between explicit -bucket-bounds,
+// ex: -agg buckets(latency) -bucket-bounds 100,300,1000 counts the values
+// under 100, under 300, under 1000 and the rest.
+//
+// slo(col<T) is the fraction of a column's values under T and apdex(col<T)
+// is its apdex score: values under T are satisfied, values under 4T are
+// tolerating and count as half. -op slo:latency<300 is short for
+// -agg slo(latency<300).
+//
+// each of these aggregations keeps exact counts in a BucketCounts, which
+// merge across blocks and nodes by adding them up
+
+const (
+	OP_BUCKETS = "buckets"
+	OP_SLO     = "slo"
+	OP_APDEX   = "apdex"
+)
+
+// BucketCounts counts values below each of its Bounds. Counts[i] holds the
+// values under Bounds[i] (and not under the bound before it), the last count
+// holds the rest
+type BucketCounts struct {
+	Bounds []float64
+	Counts []int64
+}
+
+func isBucketOp(op string) bool {
+	return op == OP_BUCKETS || op == OP_SLO || op == OP_APDEX
+}
+
+// ParseBucketBounds parses a list of increasing bucket bounds
+func ParseBucketBounds(spec string, sep string) ([]float64, error) {
+	bounds := make([]float64, 0)
+	if strings.TrimSpace(spec) == "" {
+		return bounds, nil
+	}
+
+	for _, token := range strings.Split(spec, sep) {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(token), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket bound %q", token)
+		}
+
+		if len(bounds) > 0 && bound <= bounds[len(bounds)-1] {
+			return nil, fmt.Errorf("bucket bounds must increase, %v isn't over %v", bound, bounds[len(bounds)-1])
+		}
+
+		bounds = append(bounds, bound)
+	}
+
+	return bounds, nil
+}
+
+// splits the col<T argument of slo and apdex ops into the column and the
+// bounds they count under. buckets(col) gets its bounds from -bucket-bounds
+func parseBucketAgg(op string, arg string) (Aggregation, error) {
+	agg := Aggregation{Op: op, Name: arg}
+	if op == OP_BUCKETS {
+		return agg, nil
+	}
+
+	lt := strings.Index(arg, "<")
+	if lt <= 0 {
+		return agg, fmt.Errorf("%s(%s) needs a threshold, ex: %s(latency<300)", op, arg, op)
+	}
+
+	threshold, err := strconv.ParseFloat(strings.TrimSpace(arg[lt+1:]), 64)
+	if err != nil {
+		return agg, fmt.Errorf("invalid threshold in %s(%s)", op, arg)
+	}
+
+	agg.Name = strings.TrimSpace(arg[:lt])
+	agg.Bounds = []float64{threshold}
+	if op == OP_APDEX {
+		if threshold <= 0 {
+			return agg, fmt.Errorf("apdex(%s) needs a positive threshold", arg)
+		}
+		agg.Bounds = append(agg.Bounds, 4*threshold)
+	}
+
+	return agg, nil
+}
+
+func formatBound(bound float64) string {
+	return strconv.FormatFloat(bound, 'f', -1, 64)
+}
+
+func newBucketCounts(bounds []float64) *BucketCounts {
+	return &BucketCounts{Bounds: bounds, Counts: make([]int64, len(bounds)+1)}
+}
+
+func (b *BucketCounts) Add(val float64, weight int64) {
+	i := sort.Search(len(b.Bounds), func(i int) bool { return val < b.Bounds[i] })
+	b.Counts[i] += weight
+}
+
+func (b *BucketCounts) Combine(o *BucketCounts) {
+	if len(o.Counts) != len(b.Counts) {
+		Warn("CANT COMBINE BUCKETS WITH BOUNDS", o.Bounds, "INTO", b.Bounds)
+		return
+	}
+
+	for i, count := range o.Counts {
+		b.Counts[i] += count
+	}
+}
+
+func (b *BucketCounts) Total() int64 {
+	total := int64(0)
+	for _, count := range b.Counts {
+		total += count
+	}
+
+	return total
+}
+
+// how many values are under the ith bound
+func (b *BucketCounts) under(i int) int64 {
+	total := int64(0)
+	for _, count := range b.Counts[:i+1] {
+		total += count
+	}
+
+	return total
+}
+
+// the label of each bucket, like <100 or >=1000 for the last one
+func (b *BucketCounts) labels() []string {
+	labels := make([]string, len(b.Counts))
+	for i, bound := range b.Bounds {
+		labels[i] = "<" + formatBound(bound)
+	}
+
+	labels[len(b.Bounds)] = "rest"
+	if len(b.Bounds) > 0 {
+		labels[len(b.Bounds)] = ">=" + formatBound(b.Bounds[len(b.Bounds)-1])
+	}
+
+	return labels
+}
+
+// the counts keyed by their labels, for printing
+func (b *BucketCounts) labeledCounts() map[string]int64 {
+	ret := make(map[string]int64)
+	for i, label := range b.labels() {
+		ret[label] = b.Counts[i]
+	}
+
+	return ret
+}
+
+// the value of a bucket aggregation: the total count for buckets, the
+// fraction under the threshold for slo and the apdex score for apdex
+func (b *BucketCounts) value(op string) (float64, bool) {
+	total := b.Total()
+	if total == 0 {
+		return 0, false
+	}
+
+	switch op {
+	case OP_SLO:
+		return float64(b.under(0)) / float64(total), true
+	case OP_APDEX:
+		satisfied := float64(b.under(0))
+		tolerating := float64(b.under(1)) - satisfied
+		return (satisfied + tolerating/2) / float64(total), true
+	}
+
+	return float64(total), true
+}
+
+// }}} BUCKET COUNTS AND SLOS
@@ -0,0 +1,112 @@
+package sybil
+
+import "math"
+import "reflect"
+import "strconv"
+import "testing"
+
+func TestParseBucketAggs(t *testing.T) {
+	bounds, err := ParseBucketBounds("100, 300,1000.5", ",")
+	if err != nil || !reflect.DeepEqual(bounds, []float64{100, 300, 1000.5}) {
+		t.Error("PARSED BUCKET BOUNDS WRONG", bounds, err)
+	}
+
+	for _, bad := range []string{"100,abc", "300,100", "100,100"} {
+		if _, err := ParseBucketBounds(bad, ","); err == nil {
+			t.Error("EXPECTED AN ERROR FOR BUCKET BOUNDS", bad)
+		}
+	}
+
+	aggs, err := ParseAggs("slo(latency < 300),apdex(latency<0.5),buckets(latency)", ",")
+	if err != nil || len(aggs) != 3 {
+		t.Fatal("COULDNT PARSE BUCKET AGGS", aggs, err)
+	}
+
+	if aggs[0].Name != "latency" || aggs[0].Label() != "slo(latency<300)" || aggs[1].Label() != "apdex(latency<0.5)" {
+		t.Error("BUCKET AGGS PARSED WRONG", aggs)
+	}
+
+	if !reflect.DeepEqual(aggs[1].Bounds, []float64{0.5, 2}) || aggs[2].Label() != "buckets(latency)" {
+		t.Error("APDEX SHOULD COUNT UNDER T AND 4T", aggs[1].Bounds)
+	}
+
+	for _, bad := range []string{"slo(latency)", "slo(latency<abc)", "apdex(latency<0)"} {
+		if _, err := ParseAggs(bad, ","); err == nil {
+			t.Error("EXPECTED AN ERROR FOR", bad)
+		}
+	}
+
+	// sorts read the same aggregation, so they find its counts
+	if agg, _ := havingField("apdex(latency<0.5)"); agg.Label() != aggs[1].Label() {
+		t.Error("SORT AND HAVING READ A DIFFERENT APDEX", agg.Label())
+	}
+}
+
+func TestBucketAggs(t *testing.T) {
+	tableName := getTestTableName(t)
+	deleteTestDb(tableName)
+	defer deleteTestDb(tableName)
+
+	// each group gets runs of latencies from 0 to 99
+	blockCount := 3
+	addRecords(tableName, func(r *Record, index int) {
+		r.AddStrField("host", strconv.FormatInt(int64(index/100%2), 10))
+		r.AddIntField("latency", int64(index%100))
+	}, blockCount)
+
+	nt := saveAndReloadTable(t, tableName, blockCount)
+
+	aggs, err := ParseAggs("buckets(latency),slo(latency<30),apdex(latency<10)", ",")
+	if err != nil {
+		t.Fatal("COULDNT PARSE BUCKET AGGS", err)
+	}
+	aggs[0].Bounds = []float64{10, 50}
+
+	querySpec := newQuerySpec()
+	querySpec.Groups = append(querySpec.Groups, nt.Grouping("host"))
+	for _, agg := range aggs {
+		a := nt.Aggregation(agg.Name, agg.Op)
+		a.Bounds = agg.Bounds
+		querySpec.Aggregations = append(querySpec.Aggregations, a)
+	}
+
+	nt.MatchAndAggregate(querySpec)
+
+	if len(querySpec.Results) != 2 {
+		t.Fatal("BUCKET AGGS RETURNED WRONG NUMBER OF GROUPS", len(querySpec.Results))
+	}
+
+	for _, r := range querySpec.Results {
+		// the blocks' counts were added up
+		buckets := r.Buckets["buckets(latency)"]
+		if buckets == nil || buckets.Total() != r.Count {
+			t.Fatal("BUCKET COUNTS DONT ADD UP TO THE RESULT'S COUNT", buckets, r.Count)
+		}
+
+		per_value := float64(r.Count) / 100
+		expected := []float64{10 * per_value, 40 * per_value, 50 * per_value}
+		for i, count := range buckets.Counts {
+			if math.Abs(float64(count)-expected[i]) > 2 {
+				t.Error("BUCKET", buckets.labels()[i], "HAS", count, "EXPECTED", expected[i])
+			}
+		}
+
+		values := make(map[string]float64)
+		for _, agg := range querySpec.Aggregations {
+			values[agg.Label()], _ = r.AggValue(agg)
+		}
+
+		if math.Abs(values["slo(latency<30)"]-0.3) > 0.01 {
+			t.Error("SLO IS", values["slo(latency<30)"], "EXPECTED 0.3")
+		}
+
+		// 10 satisfied and 30 tolerating out of 100
+		if math.Abs(values["apdex(latency<10)"]-0.25) > 0.01 {
+			t.Error("APDEX IS", values["apdex(latency<10)"], "EXPECTED 0.25")
+		}
+
+		if val, ok := r.HavingValue("slo(latency<30)"); !ok || val != values["slo(latency<30)"] {
+			t.Error("HAVING DOESNT READ THE SLO", val, ok)
+		}
+	}
+}
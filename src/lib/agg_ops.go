@@ -0,0 +1,261 @@
+package sybil
+
+import "fmt"
+import "math"
+import "strconv"
+import "strings"
+
+// {{{ PER COLUMN AGGREGATIONS
+// -agg lets each column pick its own operator, ex:
+//
+//   -agg sum(bytes),p95(latency),max(queue_depth)
+//
+// avg, hist and percentile ops are answered from the column's histogram,
+// while sum, min, max and count are kept exactly in an AggStats, since
+// histograms drop outliers and only know the extents of the column
+
+const (
+	OP_SUM   = "sum"
+	OP_MIN   = "min"
+	OP_MAX   = "max"
+	OP_COUNT = "count"
+)
+
+// AggStats is the exact (weighted) count, sum, min and max of a column
+type AggStats struct {
+	Count int64
+	Sum   float64
+	Min   float64
+	Max   float64
+}
+
+func (s *AggStats) Add(val float64, weight int64) {
+	if s.Count == 0 || val < s.Min {
+		s.Min = val
+	}
+	if s.Count == 0 || val > s.Max {
+		s.Max = val
+	}
+
+	s.Count += weight
+	s.Sum += val * float64(weight)
+}
+
+func (s *AggStats) Combine(o *AggStats) {
+	if o == nil || o.Count == 0 {
+		return
+	}
+
+	if s.Count == 0 {
+		*s = *o
+		return
+	}
+
+	s.Min = math.Min(s.Min, o.Min)
+	s.Max = math.Max(s.Max, o.Max)
+	s.Count += o.Count
+	s.Sum += o.Sum
+}
+
+// returns N for a pN op, like p95 or p99.9
+func percentileOp(op string) (float64, bool) {
+	if len(op) < 2 || op[0] != 'p' {
+		return 0, false
+	}
+
+	p, err := parsePercentile(op[1:])
+	if err != nil || p <= 0 || p >= 100 {
+		return 0, false
+	}
+
+	return p, true
+}
+
+func isStatsOp(op string) bool {
+	switch op {
+	case OP_SUM, OP_MIN, OP_MAX, OP_COUNT:
+		return true
+	}
+
+	return false
+}
+
+func isValidAggOp(op string) bool {
+	if op == OP_AVG || op == OP_HIST || isStatsOp(op) || isBucketOp(op) {
+		return true
+	}
+
+	_, ok := percentileOp(op)
+	return ok
+}
+
+// NeedsPercentiles is true when any aggregation reads percentiles out of its
+// histogram, in which case the histograms have to be created in hist mode
+func NeedsPercentiles(aggs []Aggregation) bool {
+	for _, a := range aggs {
+		if _, ok := percentileOp(a.Op); ok || a.Op == OP_HIST {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseAggs parses a list of op(col) pairs. The returned aggregations only
+// have their Op and Name set, use t.Aggregation() to look up the column.
+func ParseAggs(spec string, sep string) ([]Aggregation, error) {
+	aggs := make([]Aggregation, 0)
+	if spec == "" {
+		return aggs, nil
+	}
+
+	for _, token := range strings.Split(spec, sep) {
+		token = strings.TrimSpace(token)
+		open := strings.Index(token, "(")
+		if open <= 0 || !strings.HasSuffix(token, ")") {
+			return nil, fmt.Errorf("invalid aggregation %q, expected op(col)", token)
+		}
+
+		op := strings.ToLower(strings.TrimSpace(token[:open]))
+		col := strings.TrimSpace(token[open+1 : len(token)-1])
+		if !isValidAggOp(op) {
+			return nil, fmt.Errorf("unknown aggregation op %q in %q", op, token)
+		}
+		if col == "" {
+			return nil, fmt.Errorf("aggregation %q is missing a column", token)
+		}
+
+		if isBucketOp(op) {
+			agg, err := parseBucketAgg(op, col)
+			if err != nil {
+				return nil, err
+			}
+
+			aggs = append(aggs, agg)
+			continue
+		}
+
+		aggs = append(aggs, Aggregation{Op: op, Name: col})
+	}
+
+	return aggs, nil
+}
+
+// splits the aggregations into the columns that need a histogram, the
+// columns that need AggStats and the bucket ops, which each keep their own
+// BucketCounts. A column is listed at most once in the first two, even when
+// several ops read from it
+func splitAggregations(aggs []Aggregation) ([]Aggregation, []Aggregation, []Aggregation) {
+	hist_aggs := make([]Aggregation, 0)
+	stat_aggs := make([]Aggregation, 0)
+	bucket_aggs := make([]Aggregation, 0)
+	seen_hists := make(map[string]bool)
+	seen_stats := make(map[string]bool)
+	seen_buckets := make(map[string]bool)
+
+	for _, a := range aggs {
+		if isBucketOp(a.Op) {
+			if !seen_buckets[a.Label()] {
+				seen_buckets[a.Label()] = true
+				bucket_aggs = append(bucket_aggs, a)
+			}
+		} else if isStatsOp(a.Op) {
+			if !seen_stats[a.Name] {
+				seen_stats[a.Name] = true
+				stat_aggs = append(stat_aggs, a)
+			}
+		} else if !seen_hists[a.Name] {
+			seen_hists[a.Name] = true
+			hist_aggs = append(hist_aggs, a)
+		}
+	}
+
+	return hist_aggs, stat_aggs, bucket_aggs
+}
+
+// Label is how the aggregation is keyed when printing. -int columns keep
+// their bare column name, -agg columns are printed as op(col)
+func (a Aggregation) Label() string {
+	if a.Op == OP_AVG || a.Op == OP_HIST {
+		return a.Name
+	}
+
+	if (a.Op == OP_SLO || a.Op == OP_APDEX) && len(a.Bounds) > 0 {
+		return fmt.Sprintf("%s(%s<%s)", a.Op, a.Name, formatBound(a.Bounds[0]))
+	}
+
+	return fmt.Sprintf("%s(%s)", a.Op, a.Name)
+}
+
+// AggValue returns the single value an aggregation computes for this result.
+// hist aggregations return their mean.
+func (r *Result) AggValue(a Aggregation) (float64, bool) {
+	if isBucketOp(a.Op) {
+		b, ok := r.Buckets[a.Label()]
+		if !ok {
+			return 0, false
+		}
+		return b.value(a.Op)
+	}
+
+	if isStatsOp(a.Op) {
+		s, ok := r.Stats[a.Name]
+		if a.Op == OP_COUNT {
+			if !ok {
+				return 0, true
+			}
+			return float64(s.Count), true
+		}
+
+		if !ok || s.Count == 0 {
+			return 0, false
+		}
+
+		switch a.Op {
+		case OP_SUM:
+			return s.Sum, true
+		case OP_MIN:
+			return s.Min, true
+		case OP_MAX:
+			return s.Max, true
+		}
+	}
+
+	h, ok := r.Hists[a.Name]
+	if !ok {
+		return 0, false
+	}
+
+	if p, ok := percentileOp(a.Op); ok {
+		if h.TotalCount() == 0 {
+			return 0, false
+		}
+
+		return h.GetPercentile(p), true
+	}
+
+	return h.Mean(), true
+}
+
+// formats a value of the aggregation for printing
+func (a Aggregation) formatValue(val float64) string {
+	if isStatsOp(a.Op) || a.Op == OP_BUCKETS {
+		return formatAggValue(val)
+	}
+
+	if a.Op == OP_SLO || a.Op == OP_APDEX {
+		return fmt.Sprintf("%.4f", val)
+	}
+
+	return fmt.Sprintf("%.2f", val)
+}
+
+func formatAggValue(val float64) string {
+	if val == math.Trunc(val) && math.Abs(val) < 1e15 {
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	}
+
+	return fmt.Sprintf("%.2f", val)
+}
+
+// }}} PER COLUMN AGGREGATIONS
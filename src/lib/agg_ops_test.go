@@ -0,0 +1,131 @@
+package sybil
+
+import "bytes"
+import "encoding/json"
+import "math"
+import "os"
+import "strconv"
+import "testing"
+
+func TestParseAggs(t *testing.T) {
+	aggs, err := ParseAggs("sum(bytes), P95(latency),max(queue_depth)", ",")
+	if err != nil || len(aggs) != 3 {
+		t.Fatal("COULDNT PARSE AGGS", aggs, err)
+	}
+
+	if aggs[0].Op != OP_SUM || aggs[1].Op != "p95" || aggs[1].Name != "latency" || aggs[2].Label() != "max(queue_depth)" {
+		t.Error("AGGS PARSED WRONG", aggs)
+	}
+
+	for _, bad := range []string{"bytes", "sum()", "stddev(bytes)", "p100(latency)", "sum(bytes"} {
+		if _, err := ParseAggs(bad, ","); err == nil {
+			t.Error("EXPECTED AN ERROR FOR", bad)
+		}
+	}
+}
+
+func TestAggOps(t *testing.T) {
+	tableName := getTestTableName(t)
+	deleteTestDb(tableName)
+	defer deleteTestDb(tableName)
+
+	blockCount := 3
+	sums := make(map[string]int64)
+	counts := make(map[string]int64)
+	addRecords(tableName, func(r *Record, index int) {
+		host := strconv.FormatInt(int64(index%2), 10)
+		r.AddStrField("host", host)
+		r.AddIntField("bytes", int64(index))
+		r.AddFloatField("latency", float64(index)+0.5)
+
+		// some records are missing the column, so count(bytes) != Count
+		if index%5 == 0 {
+			r.AddIntField("queue", int64(index))
+			counts[host]++
+		}
+
+		sums[host] += int64(index)
+	}, blockCount)
+
+	nt := saveAndReloadTable(t, tableName, blockCount)
+	DELETE_BLOCKS_AFTER_QUERY = false
+
+	FLAGS.OP = HIST_STR
+	defer func() { FLAGS.OP = "" }()
+
+	querySpec := newQuerySpec()
+	querySpec.Groups = append(querySpec.Groups, nt.Grouping("host"))
+	for _, agg := range []string{"sum", "min", "max", "avg", "p50"} {
+		querySpec.Aggregations = append(querySpec.Aggregations, nt.Aggregation("bytes", agg))
+	}
+	querySpec.Aggregations = append(querySpec.Aggregations, nt.Aggregation("latency", "max"))
+	querySpec.Aggregations = append(querySpec.Aggregations, nt.Aggregation("queue", "count"))
+
+	nt.MatchAndAggregate(querySpec)
+
+	if len(querySpec.Results) != 2 {
+		t.Fatal("AGG OPS RETURNED WRONG NUMBER OF GROUPS", len(querySpec.Results))
+	}
+
+	total := int64(CHUNK_SIZE * blockCount)
+	for k, r := range querySpec.Results {
+		host := k[:1]
+		values := make(map[string]float64)
+		for _, agg := range querySpec.Aggregations {
+			val, ok := r.AggValue(agg)
+			if !ok {
+				t.Error("MISSING AGG VALUE FOR", agg.Label())
+			}
+			values[agg.Label()] = val
+		}
+
+		// host 0 has the even values, host 1 has the odd ones
+		min_val := float64(0)
+		max_val := float64(total - 2)
+		if host == "1" {
+			min_val, max_val = 1, float64(total-1)
+		}
+
+		if values["sum(bytes)"] != float64(sums[host]) {
+			t.Error("SUM IS WRONG", host, values["sum(bytes)"], sums[host])
+		}
+		if values["min(bytes)"] != min_val || values["max(bytes)"] != max_val {
+			t.Error("MIN/MAX IS WRONG", host, values["min(bytes)"], values["max(bytes)"])
+		}
+		if values["max(latency)"] != max_val+0.5 {
+			t.Error("FLOAT MAX IS WRONG", host, values["max(latency)"])
+		}
+		if values["count(queue)"] != float64(counts[host]) {
+			t.Error("COUNT IS WRONG", host, values["count(queue)"], counts[host])
+		}
+
+		// bytes is aggregated by 5 ops, but its values only go in once
+		avg := float64(sums[host]) / float64(r.Count)
+		if math.Abs(values["bytes"]-avg) > 0.001 || r.Hists["bytes"].TotalCount() != r.Count {
+			t.Error("AVG IS WRONG", host, values["bytes"], avg, r.Hists["bytes"].TotalCount())
+		}
+		if math.Abs(values["p50(bytes)"]-avg) > float64(total)/10 {
+			t.Error("MEDIAN IS WAY OFF", host, values["p50(bytes)"], avg)
+		}
+	}
+
+	// the JSON printer keys the -agg columns by op(col)
+	var buf bytes.Buffer
+	OUTPUT = &buf
+	FLAGS.JSON = true
+	defer func() { OUTPUT = os.Stdout; FLAGS.JSON = false }()
+
+	printResults(querySpec)
+
+	var results []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &results); err != nil || len(results) != 2 {
+		t.Fatal("COULDNT READ JSON RESULTS", err, buf.String())
+	}
+
+	for _, res := range results {
+		host := res["host"].(string)
+		if res["sum(bytes)"] != float64(sums[host]) || res["count(queue)"] != float64(counts[host]) {
+			t.Error("JSON RESULTS ARE WRONG", res)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"runtime"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -28,38 +29,152 @@ const (
 )
 
 var GROUP_DELIMITER = "\t"
+
+// the group value of the remainder row when -other rolls up the groups that
+// didn't make a time bucket's top results
+var OTHER_GROUP = "(other)"
 var MISSING_VALUE = uint64(math.MaxUint64)
 
+// -sort and -prune-sort take any field that -having can compare (see
+// having.go): $COUNT, a column's average, op(col) like p99(latency) or
+// stddev(latency), samples or distinct. $KEY sorts by the group key itself.
+// Results sort in descending order, add " asc" to reverse it:
+//
+//   -sort "p99(latency) asc"
+
+const SORT_KEY = "$KEY"
+
+// ParseSortBy splits a sort into its field and whether it is ascending
+func ParseSortBy(spec string) (string, bool, error) {
+	field := strings.TrimSpace(spec)
+	asc := false
+	if i := strings.LastIndexAny(field, " \t"); i >= 0 {
+		switch strings.ToLower(field[i+1:]) {
+		case "asc":
+			asc = true
+			field = strings.TrimSpace(field[:i])
+		case "desc":
+			field = strings.TrimSpace(field[:i])
+		}
+	}
+
+	if field == "" {
+		return "", false, fmt.Errorf("EMPTY SORT")
+	}
+
+	if field == SORT_COUNT || field == SORT_KEY {
+		return field, asc, nil
+	}
+
+	agg, on_result := havingField(field)
+	if !on_result && (agg.Name == "" || (agg.Op != OP_STDDEV && !isValidAggOp(agg.Op))) {
+		return "", false, fmt.Errorf("CANT SORT BY %s", field)
+	}
+
+	return field, asc, nil
+}
+
+// SortAggregations returns the column aggregation a sort reads, if it reads
+// one, which the query has to aggregate for the sort to mean anything
+func SortAggregations(orderBy string) []Aggregation {
+	field, _, err := ParseSortBy(orderBy)
+	if err != nil || field == SORT_COUNT || field == SORT_KEY {
+		return nil
+	}
+
+	if agg, on_result := havingField(field); !on_result {
+		return []Aggregation{agg}
+	}
+
+	return nil
+}
+
 type SortResultsByCol struct {
 	Results []*Result
 
 	Col string
+
+	// the sort value of each result, looked up once before sorting
+	values []float64
+	asc    bool
+}
+
+func newResultSorter(results []*Result, orderBy string) SortResultsByCol {
+	field, asc, err := ParseSortBy(orderBy)
+	if err != nil {
+		Warn("COULDNT PARSE SORT", orderBy, err)
+		field, asc = SORT_COUNT, false
+	}
+
+	sorter := SortResultsByCol{Results: results, Col: field, asc: asc}
+	if field != SORT_KEY {
+		sorter.values = make([]float64, len(results))
+		for i, r := range results {
+			sorter.values[i] = r.sortValue(field)
+		}
+	}
+
+	return sorter
 }
 
-func (a SortResultsByCol) Len() int      { return len(a.Results) }
-func (a SortResultsByCol) Swap(i, j int) { a.Results[i], a.Results[j] = a.Results[j], a.Results[i] }
+func (a SortResultsByCol) Len() int { return len(a.Results) }
+func (a SortResultsByCol) Swap(i, j int) {
+	a.Results[i], a.Results[j] = a.Results[j], a.Results[i]
+	if a.values != nil {
+		a.values[i], a.values[j] = a.values[j], a.values[i]
+	}
+}
 
-// This sorts the records in descending order
+// This sorts the records in descending order (unless asc is set). Results
+// without the sort field go last and ties are broken by group key
 func (a SortResultsByCol) Less(i, j int) bool {
-	if a.Col == SORT_COUNT {
-		t1 := a.Results[i].Count
-		t2 := a.Results[j].Count
+	ki := a.Results[i].GroupByKey
+	kj := a.Results[j].GroupByKey
+	if a.values == nil {
+		if a.asc {
+			return ki < kj
+		}
+		return ki > kj
+	}
 
-		return t1 > t2
+	t1 := a.values[i]
+	t2 := a.values[j]
+	if math.IsNaN(t1) || math.IsNaN(t2) {
+		return !math.IsNaN(t1) && math.IsNaN(t2)
+	}
+
+	if t1 == t2 {
+		return ki < kj
+	}
+
+	if a.asc {
+		return t1 < t2
 	}
 
-	t1 := a.Results[i].Hists[a.Col].Mean()
-	t2 := a.Results[j].Hists[a.Col].Mean()
 	return t1 > t2
 }
 
+// returns NaN when the result doesn't have the field
+func (r *Result) sortValue(field string) float64 {
+	if field == SORT_COUNT {
+		return float64(r.Count)
+	}
+
+	if val, ok := r.HavingValue(field); ok {
+		return val
+	}
+
+	return math.NaN()
+}
+
 func FilterAndAggRecords(querySpec *QuerySpec, recordsPtr *RecordList) int {
 
 	// {{{ variable decls and func setup
 	var ok bool
 	var binarybuffer []byte = make([]byte, GROUP_BY_WIDTH*len(querySpec.Groups))
 	var distinctbuffer []byte = make([]byte, GROUP_BY_WIDTH*len(querySpec.Distincts))
-	var slowdistinctbuffer bytes.Buffer
+	var slowdistinctbuffer []byte
+	var keybuffer bytes.Buffer
 
 	bs := make([]byte, GROUP_BY_WIDTH)
 	zero := make([]byte, GROUP_BY_WIDTH)
@@ -90,6 +205,60 @@ func FilterAndAggRecords(querySpec *QuerySpec, recordsPtr *RecordList) int {
 		}
 	} // }}} count distinct check
 
+	// {{{ check if we are grouping by set columns, in which case each record
+	// is aggregated once for every combination of the tags it carries
+	has_set_groups := false
+	for _, g := range querySpec.Groups {
+		if querySpec.Table.KeyTypes[g.name_id] == SET_VAL {
+			has_set_groups = true
+		}
+	} // }}}
+
+	var bucketer *timeBucketer
+	if querySpec.TimeBucket > 0 {
+		bucketer = querySpec.newTimeBucketer()
+	}
+
+	// columns can be aggregated by more than one op, but we only want to add
+	// each value once
+	hist_aggs, stat_aggs, bucket_aggs := splitAggregations(querySpec.Aggregations)
+	bucket_labels := make([]string, len(bucket_aggs))
+	for i, a := range bucket_aggs {
+		bucket_labels[i] = a.Label()
+	}
+
+	// past the result limit, new groups are either dropped or (with
+	// -heavy-hitters) replace the smallest group, see heavy_hitters.go
+	result_limit := INTERNAL_RESULT_LIMIT
+	var hh *heavyHitters
+	use_heavy_hitters := querySpec.HeavyHitters > 0 && querySpec.TimeBucket == 0
+	if use_heavy_hitters && querySpec.HeavyHitters < result_limit {
+		result_limit = querySpec.HeavyHitters
+	}
+
+	// records of a joined table get their dimension columns before they are
+	// filtered, see join.go
+	join_table := querySpec.Table
+	if join_table.join == nil {
+		join_table = nil
+	}
+
+	// and then their computed columns, see computed.go
+	compute_table := querySpec.Table
+	if len(compute_table.computed) == 0 {
+		compute_table = nil
+	}
+
+	// a -lua script filters and reduces the records that pass the filters,
+	// see lua.go
+	lua_block := querySpec.newLuaBlock()
+
+	// -session queries keep the time of each actor's records, see sessions.go
+	sessions := querySpec.Sessions
+	if sessions != nil && querySpec.SessionEvents == nil {
+		querySpec.SessionEvents = make(SessionEvents)
+	}
+
 	// }}} func setup
 
 	// {{{ the main loop over all records
@@ -97,6 +266,13 @@ func FilterAndAggRecords(querySpec *QuerySpec, recordsPtr *RecordList) int {
 		add := true
 		r := records[i]
 
+		if join_table != nil {
+			join_table.joinRecord(r)
+		}
+		if compute_table != nil {
+			compute_table.computeRecord(r)
+		}
+
 		if OPTS.WEIGHT_COL && r.Populated[OPTS.WEIGHT_COL_ID] == INT_VAL {
 			weight = int64(r.Ints[OPTS.WEIGHT_COL_ID])
 		}
@@ -111,6 +287,10 @@ func FilterAndAggRecords(querySpec *QuerySpec, recordsPtr *RecordList) int {
 			}
 		}
 
+		if add && lua_block != nil {
+			add = lua_block.filter(r)
+		}
+
 		if !add {
 			continue
 		}
@@ -118,6 +298,12 @@ func FilterAndAggRecords(querySpec *QuerySpec, recordsPtr *RecordList) int {
 		if HOLD_MATCHES {
 			querySpec.Matched = append(querySpec.Matched, r)
 		}
+		if lua_block != nil {
+			lua_block.reduce(r)
+		}
+		if sessions != nil {
+			sessions.addRecord(querySpec.SessionEvents, r)
+		}
 
 		// }}} FILTERING
 
@@ -135,6 +321,11 @@ func FilterAndAggRecords(querySpec *QuerySpec, recordsPtr *RecordList) int {
 				binary.LittleEndian.PutUint64(bs, uint64(r.Ints[g.name_id]))
 			case STR_VAL:
 				binary.LittleEndian.PutUint64(bs, uint64(r.Strs[g.name_id]))
+			case FLOAT_VAL:
+				binary.LittleEndian.PutUint64(bs, math.Float64bits(float64(r.Floats[g.name_id])))
+			case SET_VAL:
+				// filled in by fill_set_group_by below
+				binary.LittleEndian.PutUint64(bs, MISSING_VALUE)
 			case _NO_VAL:
 				binary.LittleEndian.PutUint64(bs, MISSING_VALUE)
 			}
@@ -142,126 +333,239 @@ func FilterAndAggRecords(querySpec *QuerySpec, recordsPtr *RecordList) int {
 			copy(binarybuffer[i*GROUP_BY_WIDTH:], bs)
 		} // }}}
 
-		// {{{ time series aggregation
-		if querySpec.TimeBucket > 0 {
-			if len(r.Populated) <= int(OPTS.TIME_COL_ID) {
-				continue
-			}
+		num_keys := 1
+		if has_set_groups {
+			num_keys = set_combinations(r, querySpec.Groups)
+		}
 
-			if r.Populated[OPTS.TIME_COL_ID] != INT_VAL {
-				continue
+		for key := 0; key < num_keys; key++ {
+			if has_set_groups {
+				fill_set_group_by(r, querySpec.Groups, binarybuffer, key)
 			}
-			val := int64(r.Ints[OPTS.TIME_COL_ID])
-
-			big_record, b_ok := querySpec.Results[string(binarybuffer)]
-			if !b_ok {
-				if len(querySpec.Results) < INTERNAL_RESULT_LIMIT {
-					big_record = querySpec.NewResult()
-					big_record.BinaryByKey = string(binarybuffer)
-					querySpec.Results[string(binarybuffer)] = big_record
-					b_ok = true
+
+			var added_record *Result
+
+			// {{{ time series aggregation
+			if querySpec.TimeBucket > 0 {
+				if len(r.Populated) <= int(OPTS.TIME_COL_ID) {
+					continue
 				}
-			}
 
-			if b_ok {
-				big_record.Samples++
-				big_record.Count += weight
-			}
+				if r.Populated[OPTS.TIME_COL_ID] != INT_VAL {
+					continue
+				}
+				val := int64(r.Ints[OPTS.TIME_COL_ID])
+
+				big_record, b_ok := querySpec.Results[string(binarybuffer)]
+				if !b_ok {
+					if len(querySpec.Results) < INTERNAL_RESULT_LIMIT {
+						big_record = querySpec.NewResult()
+						big_record.BinaryByKey = string(binarybuffer)
+						querySpec.Results[string(binarybuffer)] = big_record
+						b_ok = true
+					}
+				}
 
-			// to do a time series aggregation, we treat each time bucket
-			// as its own ResultMap and promote the current time bucket to
-			// our result map for this record's aggregation
-			val = int64(int(val) / querySpec.TimeBucket * querySpec.TimeBucket)
-			result_map, ok = querySpec.TimeResults[int(val)]
+				if b_ok {
+					big_record.Samples++
+					big_record.Count += weight
 
-			if !ok {
-				// TODO: this make call is kind of slow...
-				result_map = make(ResultMap)
-				querySpec.TimeResults[int(val)] = result_map
-			}
+					// to do a time series aggregation, we treat each time bucket
+					// as its own ResultMap and promote the current time bucket to
+					// our result map for this record's aggregation
+					val = bucketer.bucket(val)
+					result_map, ok = querySpec.TimeResults[int(val)]
 
-		} // }}} time series
+					if !ok {
+						// TODO: this make call is kind of slow...
+						result_map = make(ResultMap)
+						querySpec.TimeResults[int(val)] = result_map
+					}
+				} else {
+					// a group that doesn't fit in the results is dropped from
+					// its time bucket too
+					group_key := translate_group_key(string(binarybuffer), querySpec.Groups, columns, &keybuffer)
+					added_record = querySpec.droppedResult(group_key)
+				}
 
-		// {{{ group by lookup in our result map
-		added_record, ok := result_map[string(binarybuffer)]
+			} // }}} time series
 
-		// this finds or creates a Result for the groupbykey
-		// we created earlier
-		if !ok {
-			// TODO: take into account whether we are doint time series or not...
-			if len(result_map) >= INTERNAL_RESULT_LIMIT {
-				continue
+			// {{{ group by lookup in our result map
+			if added_record == nil {
+				added_record, ok = result_map[string(binarybuffer)]
 			}
 
-			added_record = querySpec.NewResult()
-			added_record.BinaryByKey = string(binarybuffer)
+			// this finds or creates a Result for the groupbykey
+			// we created earlier
+			if added_record == nil {
+				if len(result_map) < result_limit {
+					added_record = querySpec.NewResult()
+					added_record.BinaryByKey = string(binarybuffer)
+
+					result_map[string(binarybuffer)] = added_record
+				} else if use_heavy_hitters {
+					if hh == nil {
+						hh = newHeavyHitters(result_map)
+					}
+
+					var evicted *Result
+					added_record, evicted = hh.replace(querySpec, string(binarybuffer))
+					delete(result_map, evicted.BinaryByKey)
+					result_map[added_record.BinaryByKey] = added_record
 
-			result_map[string(binarybuffer)] = added_record
-		} // }}}
+					group_key := translate_group_key(evicted.BinaryByKey, querySpec.Groups, columns, &keybuffer)
+					querySpec.dropResult(evicted, group_key)
+				} else {
+					group_key := translate_group_key(string(binarybuffer), querySpec.Groups, columns, &keybuffer)
+					added_record = querySpec.droppedResult(group_key)
+				}
+			} // }}}
+
+			added_record.Samples++
+			added_record.Count += weight
+			if hh != nil {
+				hh.update(added_record)
+			}
 
-		added_record.Samples++
-		added_record.Count += weight
-
-		// {{{ count distinct aggregation
-		if do_count_distinct {
-
-			if only_ints_in_distinct {
-				// if we are doing a count distinct, lets try to go the fast route
-				for i, g := range querySpec.Distincts {
-					copy(bs, zero)
-					switch r.Populated[g.name_id] {
-					case INT_VAL:
-						binary.LittleEndian.PutUint64(bs, uint64(r.Ints[g.name_id]))
-					case _NO_VAL:
-						binary.LittleEndian.PutUint64(bs, MISSING_VALUE)
+			// {{{ count distinct aggregation
+			if do_count_distinct {
+
+				if only_ints_in_distinct {
+					// if we are doing a count distinct, lets try to go the fast route
+					for i, g := range querySpec.Distincts {
+						copy(bs, zero)
+						switch r.Populated[g.name_id] {
+						case INT_VAL:
+							binary.LittleEndian.PutUint64(bs, uint64(r.Ints[g.name_id]))
+						case _NO_VAL:
+							binary.LittleEndian.PutUint64(bs, MISSING_VALUE)
+						}
+
+						copy(distinctbuffer[i*GROUP_BY_WIDTH:], bs)
+					}
+
+					added_record.Distinct.Add(distinctbuffer)
+
+				} else {
+					// slow path for count distinct on strings and sets. each tag
+					// in a set is counted as its own distinct value
+					num_distinct := set_combinations(r, querySpec.Distincts)
+					for dkey := 0; dkey < num_distinct; dkey++ {
+						tag := dkey
+						for _, g := range querySpec.Distincts {
+							switch r.Populated[g.name_id] {
+							case INT_VAL:
+								slowdistinctbuffer = strconv.AppendInt(slowdistinctbuffer, int64(r.Ints[g.name_id]), 10)
+							case FLOAT_VAL:
+								slowdistinctbuffer = strconv.AppendFloat(slowdistinctbuffer, float64(r.Floats[g.name_id]), 'g', -1, 64)
+							case STR_VAL:
+								col := r.block.GetColumnInfo(g.name_id)
+								slowdistinctbuffer = append(slowdistinctbuffer, col.get_string_for_val(int32(r.Strs[g.name_id]))...)
+							case SET_VAL:
+								set := r.SetMap[g.name_id]
+								if len(set) > 0 {
+									col := r.block.GetColumnInfo(g.name_id)
+									slowdistinctbuffer = append(slowdistinctbuffer, col.get_string_for_val(set[tag%len(set)])...)
+									tag /= len(set)
+								}
+
+							}
+							slowdistinctbuffer = append(slowdistinctbuffer, GROUP_DELIMITER...)
+						}
+
+						// the buffer is reused, so building the value doesn't allocate
+						added_record.Distinct.Add(slowdistinctbuffer)
+						slowdistinctbuffer = slowdistinctbuffer[:0]
 					}
 
-					copy(distinctbuffer[i*GROUP_BY_WIDTH:], bs)
 				}
 
-				added_record.Distinct.Add(distinctbuffer)
+			} // }}}
 
-			} else {
-				// slow path for count distinct on strings
-				for _, g := range querySpec.Distincts {
-					switch r.Populated[g.name_id] {
-					case INT_VAL:
-						slowdistinctbuffer.WriteString(strconv.FormatInt(int64(r.Ints[g.name_id]), 10))
-					case STR_VAL:
-						col := r.block.GetColumnInfo(g.name_id)
-						slowdistinctbuffer.WriteString(col.get_string_for_val(int32(r.Strs[g.name_id])))
+			// {{{ aggregations
+			for _, a := range hist_aggs {
+				switch r.Populated[a.name_id] {
+				case INT_VAL:
+					val := int64(r.Ints[a.name_id])
+
+					hist, ok := added_record.Hists[a.Name]
 
+					if !ok {
+						hist = r.block.table.NewHist(r.block.table.get_int_info(a.name_id))
+						added_record.Hists[a.Name] = hist
 					}
-					slowdistinctbuffer.WriteString(GROUP_DELIMITER)
-				}
 
-				added_record.Distinct.Add(slowdistinctbuffer.Bytes())
-				slowdistinctbuffer.Reset()
+					hist.AddWeightedValue(val, weight)
+				case FLOAT_VAL:
+					val := float64(r.Floats[a.name_id])
 
-			}
+					hist, ok := added_record.Hists[a.Name]
 
-		} // }}}
+					if !ok {
+						hist = r.block.table.NewFloatHist(r.block.table.get_float_info(a.name_id))
+						added_record.Hists[a.Name] = hist
+					}
 
-		// {{{ aggregations
-		for _, a := range querySpec.Aggregations {
-			switch r.Populated[a.name_id] {
-			case INT_VAL:
-				val := int64(r.Ints[a.name_id])
+					hist.(*FloatHist).AddWeightedFloat(val, weight)
+				}
 
-				hist, ok := added_record.Hists[a.Name]
+			}
 
+			for _, a := range stat_aggs {
+				var val float64
+				switch r.Populated[a.name_id] {
+				case INT_VAL:
+					val = float64(r.Ints[a.name_id])
+				case FLOAT_VAL:
+					val = float64(r.Floats[a.name_id])
+				default:
+					continue
+				}
+
+				stats, ok := added_record.Stats[a.Name]
 				if !ok {
-					hist = r.block.table.NewHist(r.block.table.get_int_info(a.name_id))
-					added_record.Hists[a.Name] = hist
+					stats = &AggStats{}
+					added_record.Stats[a.Name] = stats
 				}
 
-				hist.AddWeightedValue(val, weight)
+				stats.Add(val, weight)
 			}
 
-		} // }}}
+			for i, a := range bucket_aggs {
+				var val float64
+				switch r.Populated[a.name_id] {
+				case INT_VAL:
+					val = float64(r.Ints[a.name_id])
+				case FLOAT_VAL:
+					val = float64(r.Floats[a.name_id])
+				default:
+					continue
+				}
+
+				if added_record.Buckets == nil {
+					added_record.Buckets = make(map[string]*BucketCounts)
+				}
+
+				buckets, ok := added_record.Buckets[bucket_labels[i]]
+				if !ok {
+					buckets = newBucketCounts(a.Bounds)
+					added_record.Buckets[bucket_labels[i]] = buckets
+				}
+
+				buckets.Add(val, weight)
+			} // }}}
+		}
 
 	} // }}} main record loop
 
+	if hh != nil {
+		querySpec.HeavyHitterFloor = Max(querySpec.HeavyHitterFloor, hh.floor())
+	}
+
+	if lua_block != nil {
+		lua_block.finish(querySpec)
+	}
+
 	// {{{ translate group by
 	// turn the group by byte buffers into their
 	// actual string equivalents.
@@ -281,51 +585,97 @@ func FilterAndAggRecords(querySpec *QuerySpec, recordsPtr *RecordList) int {
 
 }
 
+// returns how many combinations of tags a record has across the set
+// columns in groups. records with empty sets are still counted once.
+func set_combinations(r *Record, groups []Grouping) int {
+	combinations := 1
+	for _, g := range groups {
+		if r.Populated[g.name_id] != SET_VAL {
+			continue
+		}
+
+		if n := len(r.SetMap[g.name_id]); n > 1 {
+			combinations *= n
+		}
+	}
+
+	return combinations
+}
+
+// writes the tag ids of the key'th combination of the record's set values
+// into the group by buffer
+func fill_set_group_by(r *Record, groups []Grouping, buffer []byte, key int) {
+	for i, g := range groups {
+		if r.Populated[g.name_id] != SET_VAL {
+			continue
+		}
+
+		bs := buffer[i*GROUP_BY_WIDTH : (i+1)*GROUP_BY_WIDTH]
+		set := r.SetMap[g.name_id]
+		if len(set) == 0 {
+			binary.LittleEndian.PutUint64(bs, MISSING_VALUE)
+			continue
+		}
+
+		binary.LittleEndian.PutUint64(bs, uint64(set[key%len(set)]))
+		key /= len(set)
+	}
+}
+
 func translate_group_by(Results ResultMap, Groups []Grouping, columns []*TableColumn) *ResultMap {
 
 	var buffer bytes.Buffer
 
 	var newResults = make(ResultMap)
-	var bs []byte
 
 	for _, r := range Results {
-		buffer.Reset()
-		if len(Groups) == 0 {
-			buffer.WriteString("total")
-		}
-		for i, g := range Groups {
-			bs = []byte(r.BinaryByKey[i*GROUP_BY_WIDTH : (i+1)*GROUP_BY_WIDTH])
+		r.GroupByKey = translate_group_key(r.BinaryByKey, Groups, columns, &buffer)
+		newResults[r.GroupByKey] = r
+	}
 
-			col := columns[g.name_id]
-			if col == nil {
-				buffer.WriteString(GROUP_DELIMITER)
-				continue
-			}
+	return &newResults
+}
 
-			val := binary.LittleEndian.Uint64(bs)
-			if val != MISSING_VALUE {
-				switch col.Type {
-				case INT_VAL:
-					buffer.WriteString(strconv.FormatInt(int64(val), 10))
-				case STR_VAL:
-					buffer.WriteString(col.get_string_for_val(int32(val)))
-				}
-			}
+// turns a group by byte buffer into its string equivalent
+func translate_group_key(binary_key string, Groups []Grouping, columns []*TableColumn, buffer *bytes.Buffer) string {
+	var bs []byte
 
+	buffer.Reset()
+	if len(Groups) == 0 {
+		buffer.WriteString("total")
+	}
+	for i, g := range Groups {
+		bs = []byte(binary_key[i*GROUP_BY_WIDTH : (i+1)*GROUP_BY_WIDTH])
+
+		col := columns[g.name_id]
+		if col == nil {
 			buffer.WriteString(GROUP_DELIMITER)
+			continue
+		}
 
+		val := binary.LittleEndian.Uint64(bs)
+		if val != MISSING_VALUE {
+			switch col.Type {
+			case INT_VAL:
+				buffer.WriteString(strconv.FormatInt(int64(val), 10))
+			case STR_VAL, SET_VAL:
+				buffer.WriteString(col.get_string_for_val(int32(val)))
+			case FLOAT_VAL:
+				buffer.WriteString(strconv.FormatFloat(math.Float64frombits(val), 'g', -1, 64))
+			}
 		}
 
-		r.GroupByKey = buffer.String()
-		newResults[r.GroupByKey] = r
+		buffer.WriteString(GROUP_DELIMITER)
+
 	}
 
-	return &newResults
+	return buffer.String()
 }
 
 func CopyQuerySpec(querySpec *QuerySpec) *QuerySpec {
 	blockQuery := QuerySpec{QueryParams: querySpec.QueryParams}
 	blockQuery.Table = querySpec.Table
+	blockQuery.EncodeResults = querySpec.EncodeResults
 	blockQuery.Punctuate()
 
 	return &blockQuery
@@ -345,14 +695,13 @@ func CombineMatches(block_specs map[string]*QuerySpec) RecordList {
 }
 
 func CombineAndPrune(querySpec *QuerySpec, block_specs map[string]*QuerySpec) *QuerySpec {
-
 	for _, spec := range block_specs {
-		spec.SortResults(spec.PruneBy)
+		spec.SortResults(spec.pruneBy())
 		spec.PruneResults(FLAGS.LIMIT)
 	}
 
 	resultSpec := CombineResults(querySpec, block_specs)
-	resultSpec.SortResults(resultSpec.PruneBy)
+	resultSpec.SortResults(resultSpec.pruneBy())
 	resultSpec.PruneResults(FLAGS.LIMIT)
 
 	return resultSpec
@@ -430,6 +779,7 @@ func CombineResults(querySpec *QuerySpec, block_specs map[string]*QuerySpec) *Qu
 	for _, spec := range block_specs {
 		master_result.Combine(&spec.Results)
 		resultSpec.MatchedCount += spec.MatchedCount
+		resultSpec.combineDropped(spec)
 
 		for _, result := range spec.Results {
 			cumulative_result.Combine(result)
@@ -453,10 +803,15 @@ func CombineResults(querySpec *QuerySpec, block_specs map[string]*QuerySpec) *Qu
 		}
 	}
 
+	// heavy hitter errors don't add up to anything for the total
+	cumulative_result.Error = 0
 	resultSpec.Cumulative = cumulative_result
 	resultSpec.TimeBucket = querySpec.TimeBucket
 	resultSpec.TimeResults = master_time_result
 	resultSpec.Results = master_result
+	resultSpec.addHeavyHitterFloors(block_specs)
+	resultSpec.combineLua(block_specs)
+	resultSpec.combineSessions(block_specs)
 
 	aend := time.Now()
 	if DEBUG_TIMING {
@@ -466,13 +821,33 @@ func CombineResults(querySpec *QuerySpec, block_specs map[string]*QuerySpec) *Qu
 	return &resultSpec
 }
 
+// -having only judges the fully combined results, so a result that is small
+// in a few blocks can still be one it keeps. While -having is pending,
+// pruning keeps HAVING_OVERFETCH times as many results, which keeps them
+// bounded but can still drop a result -having would have kept
+var HAVING_OVERFETCH = 10
+
 func (qs *QuerySpec) PruneResults(limit int) {
+	max_results := 1000
+	if qs.havingPending() {
+		limit *= HAVING_OVERFETCH
+		max_results *= HAVING_OVERFETCH
+	}
+
 	limit *= 10
-	if limit > 1000 {
-		limit = 1000
+	if limit > max_results {
+		limit = max_results
 	}
 
 	if len(qs.Sorted) > limit {
+		// a pruned heavy hitter could have counted as much as the biggest
+		// result that was pruned
+		if qs.HeavyHitters > 0 {
+			for _, r := range qs.Sorted[limit:] {
+				qs.HeavyHitterFloor = Max(qs.HeavyHitterFloor, r.Count)
+			}
+		}
+
 		qs.Sorted = qs.Sorted[:limit]
 	}
 
@@ -481,6 +856,13 @@ func (qs *QuerySpec) PruneResults(limit int) {
 		qs.Results[res.GroupByKey] = res
 	}
 
+	// time buckets keep their own top results, so a group that is only big
+	// in one bucket doesn't get pruned for being small overall
+	if qs.LimitPerBucket {
+		qs.PruneTimeResults(limit, qs.pruneBy())
+		return
+	}
+
 	for time_bucket, results := range qs.TimeResults {
 		interim_result := make(ResultMap)
 		for _, res := range results {
@@ -494,18 +876,108 @@ func (qs *QuerySpec) PruneResults(limit int) {
 	}
 }
 
+// PruneTimeResults keeps the top limit results of each time bucket by
+// orderBy. With qs.Other, the rest are rolled up into an other result
+// instead of being dropped.
+func (qs *QuerySpec) PruneTimeResults(limit int, orderBy string) {
+	other_key := qs.otherGroupByKey()
+	for time_bucket, results := range qs.TimeResults {
+		pruned := make(ResultMap)
+
+		var other *Result
+		for i, r := range qs.sortTimeBucket(results, orderBy) {
+			if i < limit && r.GroupByKey != other_key {
+				pruned[r.GroupByKey] = r
+				continue
+			}
+
+			if !qs.Other {
+				continue
+			}
+
+			if other == nil {
+				other = qs.NewResult()
+				other.GroupByKey = other_key
+			}
+			other.Combine(r)
+		}
+
+		if other != nil {
+			pruned[other_key] = other
+		}
+
+		qs.TimeResults[time_bucket] = pruned
+	}
+}
+
+// returns a time bucket's results sorted by orderBy, with the other result
+// (if there is one) last
+func (qs *QuerySpec) sortTimeBucket(results ResultMap, orderBy string) []*Result {
+	if orderBy == "" {
+		orderBy = SORT_COUNT
+	}
+
+	other_key := qs.otherGroupByKey()
+	sorted := make([]*Result, 0, len(results))
+
+	var other *Result
+	for k, r := range results {
+		if k == other_key {
+			other = r
+			continue
+		}
+
+		sorted = append(sorted, r)
+	}
+
+	sorter := newResultSorter(sorted, orderBy)
+	sort.Sort(sorter)
+
+	if other != nil {
+		sorted = append(sorted, other)
+	}
+
+	return sorted
+}
+
+func (qs *QuerySpec) otherGroupByKey() string {
+	return qs.fixedGroupByKey(OTHER_GROUP)
+}
+
+// returns the group by key with name as the value of every group
+func (qs *QuerySpec) fixedGroupByKey(name string) string {
+	if len(qs.Groups) == 0 {
+		return name
+	}
+
+	return strings.Repeat(name+GROUP_DELIMITER, len(qs.Groups))
+}
+
+// FinishResults runs once the results are fully combined: it drops the
+// results that don't match -having, sorts them and limits each time bucket
+func (qs *QuerySpec) FinishResults() {
+	// a node's results are only part of the query's, so -having waits
+	// until they are stitched together
+	if !qs.EncodeResults {
+		qs.ApplyHaving()
+	}
+
+	qs.SortResults(qs.OrderBy)
+	if qs.LimitPerBucket {
+		qs.PruneTimeResults(qs.Limit, qs.OrderBy)
+	}
+}
+
 func (qs *QuerySpec) SortResults(orderBy string) {
 	// SORT THE RESULTS
 	if orderBy != "" {
 		start := time.Now()
-		sorter := SortResultsByCol{}
-		sorter.Results = make([]*Result, 0)
+		results := make([]*Result, 0, len(qs.Results))
 		for _, v := range qs.Results {
-			sorter.Results = append(sorter.Results, v)
+			results = append(results, v)
 		}
-		qs.Sorted = sorter.Results
 
-		sorter.Col = qs.OrderBy
+		sorter := newResultSorter(results, orderBy)
 		sort.Sort(sorter)
 
 		end := time.Now()
@@ -518,6 +990,21 @@ func (qs *QuerySpec) SortResults(orderBy string) {
 
 }
 
+// intermediate results are pruned in the same order as the final sort, so
+// pruning keeps the results the final sort would show, unless -prune-sort
+// asks for a different order
+func (qs *QuerySpec) pruneBy() string {
+	if qs.PruneBy != "" {
+		return qs.PruneBy
+	}
+
+	if qs.OrderBy != "" {
+		return qs.OrderBy
+	}
+
+	return SORT_COUNT
+}
+
 // OLD SEARCHING FUNCTIONS BELOW HERE
 func SearchBlocks(querySpec *QuerySpec, block_list map[string]*TableBlock) map[string]*QuerySpec {
 	var wg sync.WaitGroup
@@ -555,6 +1042,8 @@ func (t *Table) MatchAndAggregate(querySpec *QuerySpec) {
 	start := time.Now()
 
 	querySpec.Table = t
+	t.prepareJoin()
+	t.prepareComputed()
 	block_specs := SearchBlocks(querySpec, t.BlockList)
 	querySpec.ResetResults()
 
@@ -566,6 +1055,11 @@ func (t *Table) MatchAndAggregate(querySpec *QuerySpec) {
 
 	querySpec.Results = resultSpec.Results
 	querySpec.TimeResults = resultSpec.TimeResults
+	querySpec.Dropped = resultSpec.Dropped
+	querySpec.DroppedGroups = resultSpec.DroppedGroups
+	querySpec.HeavyHitterFloor = resultSpec.HeavyHitterFloor
+	querySpec.LuaResult = resultSpec.LuaResult
+	querySpec.SessionEvents = resultSpec.SessionEvents
 
 	// Aggregating Matched Records
 	matched := CombineMatches(block_specs)
@@ -575,7 +1069,7 @@ func (t *Table) MatchAndAggregate(querySpec *QuerySpec) {
 
 	end := time.Now()
 
-	querySpec.SortResults(querySpec.OrderBy)
+	querySpec.FinishResults()
 
 	Debug(string(len(matched)), "RECORDS FILTERED AND AGGREGATED INTO", len(querySpec.Results), "RESULTS, TOOK", end.Sub(start))
 
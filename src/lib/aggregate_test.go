@@ -340,3 +340,250 @@ func TestOrderBy(t *testing.T) {
 	deleteTestDb(tableName)
 
 }
+
+func TestSetGroupBy(t *testing.T) {
+	tableName := getTestTableName(t)
+	deleteTestDb(tableName)
+	defer deleteTestDb(tableName)
+
+	blockCount := 3
+	tags := [][]string{{"mobile", "beta"}, {"mobile"}, {"desktop"}}
+	expected := make(map[string]int64)
+
+	addRecords(tableName, func(r *Record, index int) {
+		r.AddIntField("id", int64(index))
+		r.AddIntField("time", int64(index%2)*3600)
+
+		// every fourth record has no tags at all
+		if index%4 == 3 {
+			expected[""]++
+			return
+		}
+
+		r.AddSetField("tags", tags[index%4])
+		for _, tag := range tags[index%4] {
+			expected[tag]++
+		}
+	}, blockCount)
+
+	nt := saveAndReloadTable(t, tableName, blockCount)
+
+	querySpec := newQuerySpec()
+	querySpec.Groups = append(querySpec.Groups, nt.Grouping("tags"))
+	nt.MatchAndAggregate(querySpec)
+
+	if len(querySpec.Results) != len(expected) {
+		t.Error("SET GROUP BY RETURNED WRONG NUMBER OF GROUPS", len(querySpec.Results))
+	}
+
+	for k, v := range querySpec.Results {
+		tag := strings.TrimRight(k, GROUP_DELIMITER)
+		if v.Count != expected[tag] {
+			t.Error("SET GROUP BY HAS WRONG COUNT FOR", tag, v.Count, "EXPECTED", expected[tag])
+		}
+	}
+
+	// in time series mode, each tag goes into every bucket
+	old_time_col := OPTS.TIME_COL_ID
+	OPTS.TIME_COL_ID = nt.KeyTable["time"]
+	defer func() { OPTS.TIME_COL_ID = old_time_col }()
+
+	querySpec = newQuerySpec()
+	querySpec.Groups = append(querySpec.Groups, nt.Grouping("tags"))
+	querySpec.TimeBucket = 3600
+	nt.MatchAndAggregate(querySpec)
+
+	if len(querySpec.TimeResults) != 2 {
+		t.Error("SET TIME SERIES HAS WRONG NUMBER OF BUCKETS", len(querySpec.TimeResults))
+	}
+
+	for bucket, results := range querySpec.TimeResults {
+		count := int64(0)
+		for k, v := range results {
+			if strings.TrimRight(k, GROUP_DELIMITER) == "beta" {
+				count += v.Count
+			}
+		}
+
+		// beta is only on records whose index is a multiple of 4, which are
+		// all in the first bucket
+		expected_count := int64(0)
+		if bucket == 0 {
+			expected_count = expected["beta"]
+		}
+
+		if count != expected_count {
+			t.Error("SET TIME SERIES HAS WRONG COUNT FOR beta IN BUCKET", bucket, count)
+		}
+	}
+
+	// count distinct counts each tag (and the missing value) once, so it
+	// should match a sketch of just the tags
+	querySpec = newQuerySpec()
+	querySpec.Distincts = append(querySpec.Distincts, nt.Grouping("tags"))
+	nt.MatchAndAggregate(querySpec)
+
+	tag_sketch := NewDistinctCounter(DEFAULT_DISTINCT_PRECISION, false)
+	for tag := range expected {
+		tag_sketch.Add([]byte(tag + GROUP_DELIMITER))
+	}
+
+	for _, v := range querySpec.Results {
+		if v.Distinct.Cardinality() != tag_sketch.Cardinality() {
+			t.Error("SET COUNT DISTINCT IS WRONG", v.Distinct.Cardinality(), "EXPECTED", tag_sketch.Cardinality())
+		}
+	}
+}
+
+func TestLimitPerBucket(t *testing.T) {
+	tableName := getTestTableName(t)
+	deleteTestDb(tableName)
+	defer deleteTestDb(tableName)
+
+	// every hour has its own spike host, which is the biggest host in its
+	// hour but smaller than b2 and b3 overall
+	blockCount := 3
+	spikes := make(map[int64]int64)
+	totals := make(map[int64]int64)
+	addRecords(tableName, func(r *Record, index int) {
+		hour := int64(index % 3)
+		host := "b" + strconv.FormatInt(int64(index%4), 10)
+		if index%4 < 2 {
+			host = "spike" + strconv.FormatInt(hour, 10)
+			spikes[hour*3600]++
+		}
+
+		totals[hour*3600]++
+		r.AddIntField("time", hour*3600)
+		r.AddStrField("host", host)
+	}, blockCount)
+
+	nt := saveAndReloadTable(t, tableName, blockCount)
+
+	old_time_col := OPTS.TIME_COL_ID
+	OPTS.TIME_COL_ID = nt.KeyTable["time"]
+	defer func() { OPTS.TIME_COL_ID = old_time_col }()
+
+	querySpec := newQuerySpec()
+	querySpec.Groups = append(querySpec.Groups, nt.Grouping("host"))
+	querySpec.TimeBucket = 3600
+	querySpec.Limit = 1
+	querySpec.OrderBy = SORT_COUNT
+	querySpec.PruneBy = SORT_COUNT
+	querySpec.LimitPerBucket = true
+	querySpec.Other = true
+
+	nt.MatchAndAggregate(querySpec)
+
+	if len(querySpec.TimeResults) != 3 {
+		t.Fatal("WRONG NUMBER OF TIME BUCKETS", len(querySpec.TimeResults))
+	}
+
+	other_key := OTHER_GROUP + GROUP_DELIMITER
+	for bucket, results := range querySpec.TimeResults {
+		spike_key := "spike" + strconv.FormatInt(int64(bucket/3600), 10) + GROUP_DELIMITER
+		if len(results) != 2 || results[spike_key] == nil || results[other_key] == nil {
+			t.Error("BUCKET SHOULD ONLY HAVE ITS SPIKE AND OTHER", bucket, results)
+			continue
+		}
+
+		if results[spike_key].Count != spikes[int64(bucket)] {
+			t.Error("SPIKE HAS WRONG COUNT", bucket, results[spike_key].Count, spikes[int64(bucket)])
+		}
+		if results[other_key].Count != totals[int64(bucket)]-spikes[int64(bucket)] {
+			t.Error("OTHER HAS WRONG COUNT", bucket, results[other_key].Count)
+		}
+	}
+}
+
+func TestParseSortBy(t *testing.T) {
+	good := map[string]string{
+		"$COUNT": "$COUNT", "latency": "latency", "p99(latency) asc": "p99(latency)",
+		"stddev(latency) DESC": "stddev(latency)", "$KEY asc": "$KEY", "distinct": "distinct",
+	}
+
+	for spec, expected := range good {
+		field, asc, err := ParseSortBy(spec)
+		if err != nil || field != expected || asc != strings.HasSuffix(spec, "asc") {
+			t.Error("PARSED SORT WRONG", spec, field, asc, err)
+		}
+	}
+
+	for _, spec := range []string{"", "median(latency)", "p100(latency) asc"} {
+		if _, _, err := ParseSortBy(spec); err == nil {
+			t.Error("EXPECTED AN ERROR FOR SORT", spec)
+		}
+	}
+}
+
+func TestSortByAggregates(t *testing.T) {
+	tableName := getTestTableName(t)
+	deleteTestDb(tableName)
+	defer deleteTestDb(tableName)
+
+	// host N shows up 2N+1 times in every 400 records, and the hosts with
+	// the fewest records have the highest latency
+	blockCount := 3
+	addRecords(tableName, func(r *Record, index int) {
+		host := int64(math.Sqrt(float64(index % 400)))
+		r.AddStrField("host", strconv.FormatInt(host, 10))
+		r.AddIntField("latency", 100-host)
+	}, blockCount)
+
+	nt := saveAndReloadTable(t, tableName, blockCount)
+
+	FLAGS.OP = HIST_STR
+	defer func() { FLAGS.OP = "" }()
+
+	newSpec := func(orderBy string) *QuerySpec {
+		querySpec := newQuerySpec()
+		querySpec.Groups = append(querySpec.Groups, nt.Grouping("host"))
+		querySpec.Aggregations = append(querySpec.Aggregations, nt.Aggregation("latency", "hist"))
+		querySpec.OrderBy = orderBy
+		querySpec.Limit = 1
+		return querySpec
+	}
+
+	first_last := map[string][2]string{
+		"$COUNT":             {"", "0"},
+		"$COUNT asc":         {"0", ""},
+		"latency":            {"0", ""},
+		"p50(latency) asc":   {"", "0"},
+		"$KEY":               {"9", "0"},
+		"$KEY asc":           {"0", "9"},
+		"stddev(latency)":    {"", ""},
+		"sum(latency) asc":   {"0", ""},
+		"count(latency) asc": {"0", ""},
+	}
+
+	for orderBy, expected := range first_last {
+		querySpec := newSpec(orderBy)
+		nt.MatchAndAggregate(querySpec)
+
+		sorted := querySpec.Sorted
+		if len(sorted) == 0 {
+			t.Fatal("NO SORTED RESULTS FOR", orderBy)
+		}
+
+		first := strings.TrimRight(sorted[0].GroupByKey, GROUP_DELIMITER)
+		last := strings.TrimRight(sorted[len(sorted)-1].GroupByKey, GROUP_DELIMITER)
+		if expected[0] != "" && first != expected[0] || expected[1] != "" && last != expected[1] {
+			t.Error("SORTING BY", orderBy, "PUT", first, "FIRST AND", last, "LAST, EXPECTED", expected)
+		}
+	}
+
+	// pruning has to keep host 0 when sorting by latency, even though it has
+	// the fewest records
+	old_limit := FLAGS.LIMIT
+	FLAGS.LIMIT = 1
+	defer func() { FLAGS.LIMIT = old_limit }()
+
+	querySpec := newSpec("latency")
+	querySpec.Table = nt
+	block_specs := SearchBlocks(querySpec, nt.BlockList)
+	resultSpec := CombineAndPrune(querySpec, block_specs)
+
+	if _, ok := resultSpec.Results["0"+GROUP_DELIMITER]; !ok || len(resultSpec.Results) != 10 {
+		t.Error("PRUNING DROPPED THE TOP RESULT FOR THE FINAL SORT", len(resultSpec.Results))
+	}
+}
@@ -24,6 +24,11 @@ type SavedIntBucket struct {
 	Records []uint32
 }
 
+type SavedFloatBucket struct {
+	Value   float64
+	Records []uint32
+}
+
 type SavedSetBucket struct {
 	Value   int32
 	Records []uint32
@@ -39,8 +44,9 @@ type SavedBlockCache map[string]*SavedColumnInfo
 type SavedColumnInfo struct {
 	NumRecords int32
 
-	StrInfoMap SavedStrInfo
-	IntInfoMap SavedIntInfo
+	StrInfoMap   SavedStrInfo
+	IntInfoMap   SavedIntInfo
+	FloatInfoMap SavedFloatInfo
 }
 
 type SavedIntColumn struct {
@@ -53,6 +59,15 @@ type SavedIntColumn struct {
 	VERSION         int32
 }
 
+type SavedFloatColumn struct {
+	Name            string
+	DeltaEncodedIDs bool
+	BucketEncoded   bool
+	Bins            []SavedFloatBucket
+	Values          []float64
+	VERSION         int32
+}
+
 type SavedStrColumn struct {
 	Name            string
 	DeltaEncodedIDs bool
@@ -80,6 +95,14 @@ func NewSavedIntColumn() SavedIntColumn {
 	return ret
 
 }
+func NewSavedFloatColumn() SavedFloatColumn {
+	ret := SavedFloatColumn{}
+
+	ret.VERSION = BLOCK_VERSION
+	return ret
+
+}
+
 func NewSavedStrColumn() SavedStrColumn {
 	ret := SavedStrColumn{}
 
@@ -4,6 +4,7 @@ import "fmt"
 import "bytes"
 
 import "os"
+import "math"
 import "errors"
 import "encoding/gob"
 import "runtime/debug"
@@ -136,6 +137,74 @@ func (tb *TableBlock) SaveIntsToColumns(dirname string, same_ints map[int16]Valu
 
 }
 
+// float columns are bucketed by the bits of their values, so that they can
+// share the ValueMap machinery with the int columns
+func (tb *TableBlock) SaveFloatsToColumns(dirname string, same_floats map[int16]ValueMap) {
+	os.MkdirAll(dirname, 0777)
+	for k, v := range same_floats {
+		col_name := tb.get_string_for_key(k)
+		if col_name == "" {
+			Debug("CANT FIGURE OUT FIELD NAME FOR", k, "SOMETHING IS PROBABLY AWRY")
+			continue
+		}
+		floatCol := NewSavedFloatColumn()
+
+		floatCol.Name = col_name
+		floatCol.DeltaEncodedIDs = true
+
+		max_r := 0
+		record_to_value := make(map[uint32]float64)
+		for bits, records := range v {
+			bucket := math.Float64frombits(uint64(bits))
+			si := SavedFloatBucket{Value: bucket, Records: records}
+			floatCol.Bins = append(floatCol.Bins, si)
+			for _, r := range records {
+				record_to_value[r] = bucket
+				if int(r) >= max_r {
+					max_r = int(r) + 1
+				}
+			}
+
+			// bookkeeping for info.db
+			tb.update_float_info(k, bucket)
+			tb.table.update_float_info(k, bucket)
+		}
+
+		floatCol.BucketEncoded = true
+		// the column is high cardinality?
+		if len(floatCol.Bins) > CARDINALITY_THRESHOLD {
+			floatCol.BucketEncoded = false
+			floatCol.Bins = nil
+			floatCol.Values = make([]float64, max_r)
+
+			for r, val := range record_to_value {
+				floatCol.Values[r] = val
+			}
+		}
+
+		var network bytes.Buffer
+		col_fname := fmt.Sprintf("%s/float_%s.db", dirname, tb.get_string_for_key(k))
+		// Create an encoder and send a value.
+		enc := gob.NewEncoder(&network)
+		err := enc.Encode(floatCol)
+		if err != nil {
+			Error("encode:", err)
+		}
+
+		action := "SERIALIZED"
+		if floatCol.BucketEncoded {
+			action = "BUCKETED  "
+		}
+
+		Debug(action, "COLUMN BLOCK", col_fname, network.Len(), "BYTES", "( PER RECORD", network.Len()/len(tb.RecordList), ")")
+
+		w, _ := os.Create(col_fname)
+
+		network.WriteTo(w)
+	}
+
+}
+
 func (tb *TableBlock) SaveSetsToColumns(dirname string, same_sets map[int16]ValueMap) {
 	for k, v := range same_sets {
 		col_name := tb.get_string_for_key(k)
@@ -304,6 +373,7 @@ func (tb *TableBlock) SaveStrsToColumns(dirname string, same_strs map[int16]Valu
 
 type SavedIntInfo map[string]*IntInfo
 type SavedStrInfo map[string]*StrInfo
+type SavedFloatInfo map[string]*FloatInfo
 
 func (tb *TableBlock) SaveInfoToColumns(dirname string) {
 	records := tb.RecordList
@@ -318,6 +388,7 @@ func (tb *TableBlock) SaveInfoToColumns(dirname string) {
 
 	savedIntInfo := SavedIntInfo{}
 	savedStrInfo := SavedStrInfo{}
+	savedFloatInfo := SavedFloatInfo{}
 	if tb.Info != nil {
 		if tb.Info.IntInfoMap != nil {
 			savedIntInfo = tb.Info.IntInfoMap
@@ -325,6 +396,9 @@ func (tb *TableBlock) SaveInfoToColumns(dirname string) {
 		if tb.Info.StrInfoMap != nil {
 			savedStrInfo = tb.Info.StrInfoMap
 		}
+		if tb.Info.FloatInfoMap != nil {
+			savedFloatInfo = tb.Info.FloatInfoMap
+		}
 	}
 
 	for k, v := range tb.IntInfo {
@@ -337,7 +411,12 @@ func (tb *TableBlock) SaveInfoToColumns(dirname string) {
 		savedStrInfo[name] = v
 	}
 
-	colInfo := SavedColumnInfo{NumRecords: int32(len(records)), IntInfoMap: savedIntInfo, StrInfoMap: savedStrInfo}
+	for k, v := range tb.FloatInfo {
+		name := tb.get_string_for_key(k)
+		savedFloatInfo[name] = v
+	}
+
+	colInfo := SavedColumnInfo{NumRecords: int32(len(records)), IntInfoMap: savedIntInfo, StrInfoMap: savedStrInfo, FloatInfoMap: savedFloatInfo}
 	err := enc.Encode(colInfo)
 
 	if err != nil {
@@ -358,9 +437,10 @@ func (tb *TableBlock) SaveInfoToColumns(dirname string) {
 }
 
 type SeparatedColumns struct {
-	ints map[int16]ValueMap
-	strs map[int16]ValueMap
-	sets map[int16]ValueMap
+	ints   map[int16]ValueMap
+	strs   map[int16]ValueMap
+	sets   map[int16]ValueMap
+	floats map[int16]ValueMap
 }
 
 func (tb *TableBlock) SeparateRecordsIntoColumns() SeparatedColumns {
@@ -371,6 +451,7 @@ func (tb *TableBlock) SeparateRecordsIntoColumns() SeparatedColumns {
 	same_ints := make(map[int16]ValueMap)
 	same_strs := make(map[int16]ValueMap)
 	same_sets := make(map[int16]ValueMap)
+	same_floats := make(map[int16]ValueMap)
 
 	// parse record list and transfer book keeping data into the current
 	// table block, as well as separate record values by column type
@@ -380,6 +461,11 @@ func (tb *TableBlock) SeparateRecordsIntoColumns() SeparatedColumns {
 				record_value(same_ints, int32(i), int16(k), int64(v))
 			}
 		}
+		for k, v := range r.Floats {
+			if r.Populated[k] == FLOAT_VAL {
+				record_value(same_floats, int32(i), int16(k), int64(math.Float64bits(float64(v))))
+			}
+		}
 		for k, v := range r.Strs {
 
 			// record the transitioned key
@@ -410,8 +496,9 @@ func (tb *TableBlock) SeparateRecordsIntoColumns() SeparatedColumns {
 	delta_encode(same_ints)
 	delta_encode(same_strs)
 	delta_encode(same_sets)
+	delta_encode(same_floats)
 
-	ret := SeparatedColumns{ints: same_ints, strs: same_strs, sets: same_sets}
+	ret := SeparatedColumns{ints: same_ints, strs: same_strs, sets: same_sets, floats: same_floats}
 	return ret
 
 }
@@ -441,6 +528,7 @@ func (tb *TableBlock) SaveToColumns(filename string) bool {
 	tb.SaveIntsToColumns(partialname, separated_columns.ints)
 	tb.SaveStrsToColumns(partialname, separated_columns.strs)
 	tb.SaveSetsToColumns(partialname, separated_columns.sets)
+	tb.SaveFloatsToColumns(partialname, separated_columns.floats)
 	tb.SaveInfoToColumns(partialname)
 
 	end = time.Now()
@@ -778,3 +866,65 @@ func (tb *TableBlock) unpackIntCol(dec FileDecoder, info SavedColumnInfo) error
 
 	return nil
 }
+
+func (tb *TableBlock) unpackFloatCol(dec FileDecoder, info SavedColumnInfo) error {
+	records := tb.RecordList[:]
+
+	saved_col := NewSavedFloatColumn()
+	into := &saved_col
+	err := dec.Decode(into)
+	if err != nil {
+		Debug("DECODE COL ERR:", err)
+	}
+
+	key_table_len := len(records[0].Floats)
+	col_id := tb.table.get_key_id(into.Name)
+	if int(col_id) >= key_table_len {
+		Debug("IGNORING FLOAT COLUMN", into.Name, "SINCE ITS NOT IN KEY TABLE IN BLOCK", tb.Name)
+		return nil
+	}
+
+	num_records := uint32(tb.Info.NumRecords)
+
+	if into.BucketEncoded {
+		for _, bucket := range into.Bins {
+			if FLAGS.UPDATE_TABLE_INFO {
+				tb.update_float_info(col_id, bucket.Value)
+				tb.table.update_float_info(col_id, bucket.Value)
+			}
+
+			// DONT FORGET TO DELTA UNENCODE THE RECORD VALUES
+			prev := uint32(0)
+			for _, r := range bucket.Records {
+				if into.DeltaEncodedIDs {
+					r = r + prev
+				}
+
+				if r >= num_records {
+					return errors.New("BLOCK SIZE CHANGED DURING QUERY")
+				}
+
+				records[r].Floats[col_id] = FloatField(bucket.Value)
+				records[r].Populated[col_id] = FLOAT_VAL
+				prev = r
+			}
+
+		}
+	} else {
+		if uint32(len(into.Values)) > num_records {
+			return errors.New("BLOCK SIZE CHANGED DURING QUERY")
+		}
+
+		for r, v := range into.Values {
+			if FLAGS.UPDATE_TABLE_INFO {
+				tb.update_float_info(col_id, v)
+				tb.table.update_float_info(col_id, v)
+			}
+
+			records[r].Floats[col_id] = FloatField(v)
+			records[r].Populated[col_id] = FLOAT_VAL
+		}
+	}
+
+	return nil
+}
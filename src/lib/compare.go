@@ -0,0 +1,230 @@
+package sybil
+
+import "fmt"
+import "strconv"
+import "strings"
+import "time"
+
+// {{{ PERIOD OVER PERIOD
+// -compare-to -7d runs a query a second time over its -start / -end range
+// shifted back a week (the baseline) and prints how the count and each
+// aggregation of every group changed against it, ex:
+//
+//   sybil query -table TABLE -group host -int latency -start -1h -compare-to -7d
+//
+// groups are matched by their key and time buckets by their shifted start,
+// so 14:00 today is compared to 14:00 a week ago. Groups that aren't in the
+// baseline's results compare against null and groups that are only in the
+// baseline aren't printed.
+//
+// with -cache-queries, the baseline goes through the per block query cache
+// too (see query_cache.go): blocks that are completely inside the shifted
+// range drop its time filters from their cache key, so they are only read
+// the first time a window is compared against.
+
+// ParseCompareOffset parses a -compare-to offset like -7d or -1w into
+// seconds, offsets without a sign are in the past too
+func ParseCompareOffset(val string) (int64, error) {
+	val = strings.TrimSpace(val)
+	sign := int64(-1)
+	if strings.HasPrefix(val, "+") {
+		sign = 1
+	}
+
+	secs, err := parseTimeOffset(strings.TrimLeft(val, "+-"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid offset %q: %s", val, err)
+	}
+	if secs == 0 {
+		return 0, fmt.Errorf("invalid offset %q, it can't be 0", val)
+	}
+
+	return sign * secs, nil
+}
+
+// ShiftTimeRange returns the filters of a -compare-to baseline: the same
+// filters with -start and -end moved by offset seconds. A missing -end is now
+func (f FilterSpec) ShiftTimeRange(offset int64, now time.Time) (FilterSpec, error) {
+	if f.Start == "" {
+		return f, fmt.Errorf("there is no -start to shift")
+	}
+
+	start, end, err := ParseTimeRange(f.Start, f.End, now)
+	if err != nil {
+		return f, err
+	}
+	if f.End == "" {
+		end = now.Unix()
+	}
+
+	f.Start = strconv.FormatInt(start+offset, 10)
+	f.End = strconv.FormatInt(end+offset, 10)
+	return f, nil
+}
+
+type comparison struct {
+	offset  int64
+	matched map[*Result]*Result // each result's baseline result
+}
+
+// CompareTo matches the results of the query to the results of its baseline,
+// which ran over the query's time range shifted by offset seconds
+func (qs *QuerySpec) CompareTo(baseline *QuerySpec, offset int64) {
+	c := &comparison{offset: offset, matched: make(map[*Result]*Result)}
+	if qs.Cumulative != nil && baseline.Cumulative != nil {
+		c.matched[qs.Cumulative] = baseline.Cumulative
+	}
+
+	for k, r := range qs.Results {
+		if b, ok := baseline.Results[k]; ok {
+			c.matched[r] = b
+		}
+	}
+
+	bucketer := qs.newTimeBucketer()
+	for bucket, results := range qs.TimeResults {
+		shifted := baseline.TimeResults[int(bucketer.bucket(int64(bucket)+offset))]
+		for k, r := range results {
+			if b, ok := shifted[k]; ok {
+				c.matched[r] = b
+			}
+		}
+	}
+
+	qs.compare = c
+}
+
+// formats an offset with the largest unit that fits it, ex: -7d
+func formatOffset(offset int64) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+
+	for _, unit := range []string{"w", "d", "h", "m"} {
+		if offset%SQL_DURATIONS[unit] == 0 {
+			return sign + strconv.FormatInt(offset/SQL_DURATIONS[unit], 10) + unit
+		}
+	}
+
+	return sign + strconv.FormatInt(offset, 10) + "s"
+}
+
+// comparedValue is a value of a result next to the same value of the
+// result it is compared to
+type comparedValue struct {
+	Label       string
+	Value       float64
+	Baseline    float64
+	HasBaseline bool
+
+	format func(float64) string
+}
+
+// returns the count (or distinct count) of a result and the value of each of
+// its aggregations, next to their baseline values
+func (qs *QuerySpec) comparedValues(r *Result) []comparedValue {
+	values := make([]comparedValue, 0)
+	baseline := qs.compare.matched[r]
+
+	count_field := "count"
+	if len(qs.Distincts) > 0 {
+		count_field = "distinct"
+	}
+
+	if val, ok := r.HavingValue(count_field); ok {
+		cv := comparedValue{Label: strings.Title(count_field), Value: val, format: formatAggValue}
+		if baseline != nil {
+			cv.Baseline, cv.HasBaseline = baseline.HavingValue(count_field)
+		}
+		values = append(values, cv)
+	}
+
+	for _, agg := range qs.Aggregations {
+		val, ok := r.AggValue(agg)
+		if !ok {
+			continue
+		}
+
+		cv := comparedValue{Label: agg.Label(), Value: val, format: agg.formatValue}
+		if baseline != nil {
+			cv.Baseline, cv.HasBaseline = baseline.AggValue(agg)
+		}
+		values = append(values, cv)
+	}
+
+	return values
+}
+
+func (cv comparedValue) delta() (float64, bool) {
+	return cv.Value - cv.Baseline, cv.HasBaseline
+}
+
+// the change against the baseline, in percent
+func (cv comparedValue) change() (float64, bool) {
+	if !cv.HasBaseline || cv.Baseline == 0 {
+		return 0, false
+	}
+
+	return (cv.Value - cv.Baseline) / cv.Baseline * 100, true
+}
+
+// the baseline, delta and change of a value for printing, - when there's no
+// baseline
+func (cv comparedValue) formatted() (string, string, string) {
+	baseline, delta, change := "-", "-", "-"
+	if cv.HasBaseline {
+		baseline = cv.format(cv.Baseline)
+	}
+
+	if d, ok := cv.delta(); ok {
+		delta = cv.format(d)
+		if d > 0 {
+			delta = "+" + delta
+		}
+	}
+
+	if c, ok := cv.change(); ok {
+		change = fmt.Sprintf("%+.2f%%", c)
+	}
+
+	return baseline, delta, change
+}
+
+func (cv comparedValue) toJSON() ResultJSON {
+	res := ResultJSON{"Baseline": nil, "Delta": nil, "Change": nil}
+	if cv.HasBaseline {
+		res["Baseline"] = cv.Baseline
+	}
+	if d, ok := cv.delta(); ok {
+		res["Delta"] = d
+	}
+	if c, ok := cv.change(); ok {
+		res["Change"] = c
+	}
+
+	return res
+}
+
+// the comparison of a result to its baseline, keyed by each value's label
+func (qs *QuerySpec) comparisonJSON(r *Result) ResultJSON {
+	res := make(ResultJSON)
+	for _, cv := range qs.comparedValues(r) {
+		res[cv.Label] = cv.toJSON()
+	}
+
+	return res
+}
+
+// prints how a result's values changed against its baseline, under the
+// result's own lines
+func printComparison(querySpec *QuerySpec, r *Result) {
+	vs := "vs " + formatOffset(querySpec.compare.offset) + ":"
+	for _, cv := range querySpec.comparedValues(r) {
+		baseline, delta, change := cv.formatted()
+		fmt.Fprintln(OUTPUT, fmt.Sprintf("  %5s", cv.Label), vs, cv.format(cv.Value), "|", baseline, "|", delta, "|", change)
+	}
+}
+
+// }}} PERIOD OVER PERIOD
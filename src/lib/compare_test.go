@@ -0,0 +1,136 @@
+package sybil
+
+import "bytes"
+import "encoding/json"
+import "math"
+import "os"
+import "strconv"
+import "testing"
+import "time"
+
+func TestParseCompareOffset(t *testing.T) {
+	offsets := map[string]int64{"-7d": -7 * 24 * 3600, "1w": -7 * 24 * 3600, "+1h": 3600, "-90m": -90 * 60}
+	for val, expected := range offsets {
+		if offset, err := ParseCompareOffset(val); err != nil || offset != expected {
+			t.Error("PARSED OFFSET", val, "AS", offset, err, "EXPECTED", expected)
+		}
+	}
+
+	for _, bad := range []string{"", "abc", "-0d", "-7x"} {
+		if _, err := ParseCompareOffset(bad); err == nil {
+			t.Error("EXPECTED AN ERROR FOR OFFSET", bad)
+		}
+	}
+
+	if formatOffset(-7*24*3600) != "-1w" || formatOffset(-90*60) != "-90m" {
+		t.Error("OFFSETS FORMATTED WRONG", formatOffset(-7*24*3600), formatOffset(-90*60))
+	}
+
+	now := time.Unix(5000000, 0)
+	shifted, err := FilterSpec{Start: "1000000", Where: "host = 'a'"}.ShiftTimeRange(-1000, now)
+	if err != nil || shifted.Start != "999000" || shifted.End != "4999000" || shifted.Where != "host = 'a'" {
+		t.Error("SHIFTED TIME RANGE WRONG", shifted, err)
+	}
+
+	if _, err := (FilterSpec{End: "1000000"}).ShiftTimeRange(-1000, now); err == nil {
+		t.Error("COMPARING WITHOUT A -start SHOULD FAIL")
+	}
+}
+
+func TestCompareTo(t *testing.T) {
+	tableName := getTestTableName(t)
+	deleteTestDb(tableName)
+	defer deleteTestDb(tableName)
+
+	// this week has twice the records of last week, with twice the latency
+	week := int64(7 * 24 * 3600)
+	start := int64(1496275200)
+	blockCount := 3
+	addRecords(tableName, func(r *Record, index int) {
+		this_week := int64(0)
+		if index%3 != 0 {
+			this_week = 1
+		}
+
+		hour := int64(index / 3 % 2)
+		r.AddIntField("time", start+this_week*week+hour*3600)
+		r.AddIntField("latency", 50+this_week*50)
+		r.AddStrField("host", strconv.FormatInt(int64(index/6%2), 10))
+	}, blockCount)
+
+	nt := saveAndReloadTable(t, tableName, blockCount)
+
+	old_time_col, old_time_col_id := FLAGS.TIME_COL, OPTS.TIME_COL_ID
+	FLAGS.TIME_COL, OPTS.TIME_COL_ID = "time", nt.KeyTable["time"]
+	defer func() { FLAGS.TIME_COL, OPTS.TIME_COL_ID = old_time_col, old_time_col_id }()
+
+	var buf bytes.Buffer
+	OUTPUT = &buf
+	FLAGS.JSON = true
+	defer func() { OUTPUT = os.Stdout; FLAGS.JSON = false }()
+
+	filterSpec := FilterSpec{Start: strconv.FormatInt(start+week, 10), End: strconv.FormatInt(start+week+2*3600, 10)}
+	baselineSpec, err := filterSpec.ShiftTimeRange(-week, time.Now())
+	if err != nil {
+		t.Fatal("COULDNT SHIFT TIME RANGE", err)
+	}
+
+	query := func(filterSpec FilterSpec, time_bucket int) *QuerySpec {
+		loadSpec := nt.NewLoadSpec()
+		querySpec := newQuerySpec()
+		querySpec.Filters = BuildFilters(nt, &loadSpec, filterSpec)
+		querySpec.Groups = append(querySpec.Groups, nt.Grouping("host"))
+		querySpec.Aggregations = append(querySpec.Aggregations, nt.Aggregation("latency", OP_AVG))
+		querySpec.Limit = 10
+		querySpec.OrderBy = SORT_COUNT
+		querySpec.PruneBy = SORT_COUNT
+		querySpec.TimeBucket = time_bucket
+		nt.MatchAndAggregate(querySpec)
+		return querySpec
+	}
+
+	querySpec := query(filterSpec, 0)
+	querySpec.CompareTo(query(baselineSpec, 0), -week)
+	printSortedResults(querySpec)
+
+	var results []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &results); err != nil || len(results) != 2 {
+		t.Fatal("EXPECTED BOTH HOSTS", err, buf.String())
+	}
+
+	for _, res := range results {
+		compared := res["Compare"].(map[string]interface{})
+		count, latency := compared["Count"].(map[string]interface{}), compared["latency"].(map[string]interface{})
+		if math.Abs(count["Change"].(float64)-100) > 1 {
+			t.Error("COUNT SHOULD HAVE DOUBLED", count)
+		}
+
+		if latency["Baseline"] != float64(50) || latency["Delta"] != float64(50) || latency["Change"] != float64(100) {
+			t.Error("LATENCY SHOULD HAVE DOUBLED", latency)
+		}
+	}
+
+	// time buckets are compared to the bucket a week before them
+	querySpec = query(FilterSpec{}, 3600)
+	querySpec.CompareTo(querySpec, -week)
+
+	for bucket, results := range querySpec.TimeResults {
+		for _, r := range results {
+			values := querySpec.comparedValues(r)
+			if len(values) != 2 || values[0].Label != "Count" || values[1].Label != "latency" {
+				t.Fatal("COMPARED THE WRONG VALUES", values)
+			}
+
+			this_week := int64(bucket) >= start+week
+			if values[1].HasBaseline != this_week {
+				t.Error("BUCKET", bucket, "SHOULD ONLY HAVE A BASELINE THIS WEEK", values[1])
+			}
+
+			if this_week {
+				if _, _, change := values[1].formatted(); change != "+100.00%" {
+					t.Error("BUCKET", bucket, "CHANGED BY", change, "EXPECTED +100.00%")
+				}
+			}
+		}
+	}
+}
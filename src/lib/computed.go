@@ -0,0 +1,656 @@
+package sybil
+
+import "fmt"
+import "math"
+import "regexp"
+import "strconv"
+import "strings"
+
+// {{{ COMPUTED COLUMNS
+// -compute adds a column that is computed from each record's other columns
+// when the query reads it, so it can be grouped by, filtered on and
+// aggregated like a stored column:
+//
+//   sybil query -table TABLE -compute "status_class=status/100" -compute "route=regex(path,'^/api/([^/]+)')" -group status_class,route
+//
+// expressions use + - * / % and parentheses over columns, numbers, durations
+// (1h is 3600) and these functions:
+//
+//   regex(col, 'pattern')  the first capture group (or the whole match) of a str
+//   int(x), float(x)       converts a number or a numeric str
+//
+// arithmetic on ints stays an int (so status/100 is 5 for a 503) and turns
+// into a float once a float is involved. A record gets no value when a
+// column it needs is missing, when it divides by zero or when a regex
+// doesn't match. Computed columns are filled in as FilterAndAggRecords
+// reaches each record, after joined columns (see join.go), and can read the
+// computed columns before them.
+//
+// the hist range of a computed column comes from the ranges of the columns
+// it reads. When that can't be worked out (like int(str_col) or dividing by
+// a float that can be 0), int columns get hdr hists, which don't need a
+// range, and the percentiles of float columns are coarse.
+
+type ComputedColumn struct {
+	Name string
+	Spec string // the -compute flag this column was parsed from
+
+	expr     *computeExpr
+	col_type int8
+	id       int16 // the column in the (maybe shortened) key table, see prepareComputed
+	active   bool  // whether the query reads the column
+}
+
+type computeExpr struct {
+	Op   string // an operator, a function, "col", "int", "float" or "str"
+	Args []*computeExpr
+
+	Col   string
+	Int   int64
+	Float float64
+	Str   string
+
+	re       *regexp.Regexp
+	col_type int8 // the type the expression evaluates to, see check
+	id       int16
+}
+
+var COMPUTE_FUNCS = map[string]bool{"regex": true, "int": true, "float": true}
+
+// ParseComputedColumn parses a -compute flag like "status_class=status/100".
+// The expression isn't checked against a table until it is added to one
+func ParseComputedColumn(spec string) (*ComputedColumn, error) {
+	eq := strings.Index(spec, "=")
+	if eq < 0 {
+		return nil, fmt.Errorf("%q should look like name=expression", spec)
+	}
+
+	name := strings.TrimSpace(spec[:eq])
+	if name == "" {
+		return nil, fmt.Errorf("%q has no column name", spec)
+	}
+	for i := 0; i < len(name); i++ {
+		if !isIdentChar(name[i]) {
+			return nil, fmt.Errorf("%q isn't a valid column name", name)
+		}
+	}
+
+	tokens, err := tokenizeSQL(spec[eq+1:])
+	if err != nil {
+		return nil, err
+	}
+
+	p := &sqlParser{tokens: tokens}
+	expr, err := p.parseComputeSum()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != sql_end {
+		return nil, p.errorf(tok, "unexpected %s", tok)
+	}
+
+	return &ComputedColumn{Name: name, Spec: spec, expr: expr}, nil
+}
+
+func (p *sqlParser) parseComputeSum() (*computeExpr, error) {
+	left, err := p.parseComputeProduct()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOp("+") || p.isOp("-") {
+		op := p.next().value
+		right, err := p.parseComputeProduct()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &computeExpr{Op: op, Args: []*computeExpr{left, right}}
+	}
+
+	return left, nil
+}
+
+func (p *sqlParser) parseComputeProduct() (*computeExpr, error) {
+	left, err := p.parseComputeUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOp("*") || p.isOp("/") || p.isOp("%") {
+		op := p.next().value
+		right, err := p.parseComputeUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &computeExpr{Op: op, Args: []*computeExpr{left, right}}
+	}
+
+	return left, nil
+}
+
+func (p *sqlParser) parseComputeUnary() (*computeExpr, error) {
+	if p.acceptOp("-") {
+		arg, err := p.parseComputeUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return &computeExpr{Op: "neg", Args: []*computeExpr{arg}}, nil
+	}
+
+	return p.parseComputeValue()
+}
+
+func (p *sqlParser) parseComputeValue() (*computeExpr, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case sql_number:
+		p.next()
+		if !strings.Contains(tok.value, ".") {
+			if val, err := strconv.ParseInt(tok.value, 10, 64); err == nil {
+				return &computeExpr{Op: "int", Int: val}, nil
+			}
+		}
+
+		val, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, p.errorf(tok, "bad number %s", tok.value)
+		}
+		return &computeExpr{Op: "float", Float: val}, nil
+	case sql_duration:
+		secs, err := p.parseDuration()
+		if err != nil {
+			return nil, err
+		}
+		return &computeExpr{Op: "int", Int: secs}, nil
+	case sql_string:
+		p.next()
+		return &computeExpr{Op: "str", Str: tok.value}, nil
+	case sql_quoted_ident:
+		p.next()
+		return &computeExpr{Op: "col", Col: tok.value}, nil
+	case sql_ident:
+		p.next()
+		if !p.isOp("(") {
+			return &computeExpr{Op: "col", Col: tok.value}, nil
+		}
+
+		fn := strings.ToLower(tok.value)
+		if !COMPUTE_FUNCS[fn] {
+			return nil, p.errorf(tok, "unknown function %s", tok.value)
+		}
+
+		return p.parseComputeCall(fn)
+	case sql_op:
+		if p.acceptOp("(") {
+			expr, err := p.parseComputeSum()
+			if err != nil {
+				return nil, err
+			}
+
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			return expr, nil
+		}
+	}
+
+	return nil, p.errorf(tok, "expected a column, number or function, found %s", tok)
+}
+
+func (p *sqlParser) parseComputeCall(fn string) (*computeExpr, error) {
+	p.next() // (
+	arg, err := p.parseComputeSum()
+	if err != nil {
+		return nil, err
+	}
+
+	expr := &computeExpr{Op: fn, Args: []*computeExpr{arg}}
+	if fn == "regex" {
+		if err := p.expectOp(","); err != nil {
+			return nil, err
+		}
+
+		pattern := p.next()
+		if pattern.kind != sql_string {
+			return nil, p.errorf(pattern, "expected a quoted regex, found %s", pattern)
+		}
+
+		expr.re, err = regexp.Compile(pattern.value)
+		if err != nil {
+			return nil, p.errorf(pattern, "bad regex: %s", err)
+		}
+		expr.Str = pattern.value
+	}
+
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+
+	return expr, nil
+}
+
+// Columns returns the columns the computed column reads
+func (c *ComputedColumn) Columns() []string {
+	cols := make([]string, 0)
+	seen := make(map[string]bool)
+
+	var walk func(e *computeExpr)
+	walk = func(e *computeExpr) {
+		if e.Op == "col" && !seen[e.Col] {
+			seen[e.Col] = true
+			cols = append(cols, e.Col)
+		}
+
+		for _, arg := range e.Args {
+			walk(arg)
+		}
+	}
+	walk(c.expr)
+
+	return cols
+}
+
+func isNumeric(col_type int8) bool {
+	return col_type == INT_VAL || col_type == FLOAT_VAL
+}
+
+// check works out the type of an expression against the columns of t
+func (e *computeExpr) check(t *Table) (int8, error) {
+	arg_types := make([]int8, len(e.Args))
+	for i, arg := range e.Args {
+		col_type, err := arg.check(t)
+		if err != nil {
+			return _NO_VAL, err
+		}
+		arg_types[i] = col_type
+	}
+
+	switch e.Op {
+	case "int", "float":
+		e.col_type = INT_VAL
+		if e.Op == "float" {
+			e.col_type = FLOAT_VAL
+		}
+
+		// int(x) and float(x)
+		if len(e.Args) > 0 && arg_types[0] != STR_VAL && !isNumeric(arg_types[0]) {
+			return _NO_VAL, fmt.Errorf("%s() can't convert a %s", e.Op, colTypeName(arg_types[0]))
+		}
+	case "str":
+		e.col_type = STR_VAL
+	case "col":
+		id, ok := t.KeyTable[e.Col]
+		if !ok || t.KeyTypes[id] == _NO_VAL {
+			return _NO_VAL, fmt.Errorf("%s has no column %s", t.Name, e.Col)
+		}
+
+		e.col_type = t.KeyTypes[id]
+		if e.col_type == SET_VAL {
+			return _NO_VAL, fmt.Errorf("%s is a set column, sets can't be computed on", e.Col)
+		}
+	case "regex":
+		if arg_types[0] != STR_VAL {
+			return _NO_VAL, fmt.Errorf("regex() needs a str, not a %s", colTypeName(arg_types[0]))
+		}
+		e.col_type = STR_VAL
+	case "neg":
+		if !isNumeric(arg_types[0]) {
+			return _NO_VAL, fmt.Errorf("can't negate a %s", colTypeName(arg_types[0]))
+		}
+		e.col_type = arg_types[0]
+	default:
+		if !isNumeric(arg_types[0]) || !isNumeric(arg_types[1]) {
+			return _NO_VAL, fmt.Errorf("%s needs numbers, not a %s and a %s", e.Op, colTypeName(arg_types[0]), colTypeName(arg_types[1]))
+		}
+
+		e.col_type = INT_VAL
+		if arg_types[0] == FLOAT_VAL || arg_types[1] == FLOAT_VAL {
+			e.col_type = FLOAT_VAL
+		}
+
+		if e.Op == "%" && e.col_type != INT_VAL {
+			return _NO_VAL, fmt.Errorf("%% needs ints")
+		}
+	}
+
+	return e.col_type, nil
+}
+
+// AddComputedColumn checks the column's expression against t's columns and
+// adds it to t's key table. It has to be called before t's key table is
+// shortened and its records are loaded, and after t is joined to a
+// dimension table whose columns it reads
+func (t *Table) AddComputedColumn(c *ComputedColumn) error {
+	if _, ok := t.KeyTable[c.Name]; ok {
+		return fmt.Errorf("%s already has a column %s", t.Name, c.Name)
+	}
+
+	col_type, err := c.expr.check(t)
+	if err != nil {
+		return err
+	}
+	if !isNumeric(col_type) && col_type != STR_VAL {
+		return fmt.Errorf("%s doesn't compute a number or a str", c.Name)
+	}
+
+	c.col_type = col_type
+	t.computed = append(t.computed, c)
+	t.addComputedColumn(c)
+	return nil
+}
+
+// adds a computed column to the key table, with the range its values can be
+// in for int and float hists. Like joined columns, they are added again
+// after the table info is reloaded
+func (t *Table) addComputedColumn(c *ComputedColumn) {
+	id := t.get_key_id(c.Name)
+	t.KeyTypes[id] = c.col_type
+
+	min, max, ok := c.expr.bounds(t)
+	switch c.col_type {
+	case INT_VAL:
+		// without info, NewHist makes an hdr hist for the column
+		delete(t.IntInfo, id)
+		if ok && math.Abs(min) < 1<<62 && math.Abs(max) < 1<<62 {
+			t.IntInfo[id] = &IntInfo{Min: int64(math.Floor(min)), Max: int64(math.Ceil(max))}
+		}
+	case FLOAT_VAL:
+		if ok {
+			t.FloatInfo[id] = &FloatInfo{Min: min, Max: max}
+		}
+	}
+}
+
+func (t *Table) addComputedColumns() {
+	for _, c := range t.computed {
+		t.addComputedColumn(c)
+	}
+}
+
+// bounds estimates the smallest and largest value an expression can have
+// from the ranges of the columns it reads
+func (e *computeExpr) bounds(t *Table) (float64, float64, bool) {
+	switch e.Op {
+	case "int":
+		if len(e.Args) == 0 {
+			return float64(e.Int), float64(e.Int), true
+		}
+	case "float":
+		if len(e.Args) == 0 {
+			return e.Float, e.Float, true
+		}
+	case "col":
+		id := t.KeyTable[e.Col]
+		if info, ok := t.IntInfo[id]; ok && e.col_type == INT_VAL {
+			return float64(info.Min), float64(info.Max), true
+		}
+		if info, ok := t.FloatInfo[id]; ok && e.col_type == FLOAT_VAL {
+			return info.Min, info.Max, true
+		}
+		return 0, 0, false
+	case "str", "regex":
+		return 0, 0, false
+	}
+
+	bounds := make([][2]float64, len(e.Args))
+	for i, arg := range e.Args {
+		min, max, ok := arg.bounds(t)
+		if !ok {
+			return 0, 0, false
+		}
+		bounds[i] = [2]float64{min, max}
+	}
+
+	corners := func(f func(a, b float64) float64) (float64, float64, bool) {
+		min, max := math.Inf(1), math.Inf(-1)
+		for _, a := range bounds[0] {
+			for _, b := range bounds[1] {
+				v := f(a, b)
+				min, max = math.Min(min, v), math.Max(max, v)
+			}
+		}
+		return min, max, true
+	}
+
+	largest := func(b [2]float64) float64 {
+		return math.Max(math.Abs(b[0]), math.Abs(b[1]))
+	}
+
+	switch e.Op {
+	case "int", "float":
+		return bounds[0][0], bounds[0][1], true
+	case "neg":
+		return -bounds[0][1], -bounds[0][0], true
+	case "+":
+		return bounds[0][0] + bounds[1][0], bounds[0][1] + bounds[1][1], true
+	case "-":
+		return bounds[0][0] - bounds[1][1], bounds[0][1] - bounds[1][0], true
+	case "*":
+		return corners(func(a, b float64) float64 { return a * b })
+	case "/":
+		if bounds[1][0] > 0 || bounds[1][1] < 0 {
+			return corners(func(a, b float64) float64 { return a / b })
+		}
+
+		// dividing by an int that isn't 0 doesn't make anything bigger
+		if e.Args[1].col_type == INT_VAL {
+			m := largest(bounds[0])
+			return -m, m, true
+		}
+	case "%":
+		m := largest(bounds[1])
+		return math.Min(0, math.Max(bounds[0][0], -m)), math.Max(0, math.Min(bounds[0][1], m)), true
+	}
+
+	return 0, 0, false
+}
+
+// looks up the ids of the computed columns and the columns they read, which
+// change when the key table is shortened
+func (t *Table) prepareComputed() {
+	var resolve func(e *computeExpr)
+	resolve = func(e *computeExpr) {
+		if e.Op == "col" {
+			e.id = -1
+			if id, ok := t.KeyTable[e.Col]; ok {
+				e.id = id
+			}
+		}
+
+		for _, arg := range e.Args {
+			resolve(arg)
+		}
+	}
+
+	for _, c := range t.computed {
+		id, ok := t.KeyTable[c.Name]
+		c.id, c.active = id, ok
+		resolve(c.expr)
+	}
+}
+
+// the -compute flags of t's computed columns, which are part of the query's
+// cache key
+func (t *Table) computedSpecs() []string {
+	if len(t.computed) == 0 {
+		return nil
+	}
+
+	specs := make([]string, 0, len(t.computed))
+	for _, c := range t.computed {
+		specs = append(specs, c.Spec)
+	}
+
+	return specs
+}
+
+// whether a column of t is computed
+func (t *Table) isComputedColumn(id int16) bool {
+	if t == nil {
+		return false
+	}
+
+	for _, c := range t.computed {
+		if c.active && c.id == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// joined and computed columns aren't stored in any block
+func (t *Table) isVirtualColumn(id int16) bool {
+	return t.isJoinedColumn(id) || t.isComputedColumn(id)
+}
+
+// computeValue is the value of an expression for one record, kind is
+// _NO_VAL when it has none
+type computeValue struct {
+	kind int8
+	i    int64
+	f    float64
+	s    string
+}
+
+func (v computeValue) float() float64 {
+	if v.kind == INT_VAL {
+		return float64(v.i)
+	}
+
+	return v.f
+}
+
+func (e *computeExpr) eval(r *Record) computeValue {
+	switch e.Op {
+	case "col":
+		if e.id < 0 || int(e.id) >= len(r.Populated) || r.Populated[e.id] != e.col_type {
+			return computeValue{}
+		}
+
+		switch e.col_type {
+		case INT_VAL:
+			return computeValue{kind: INT_VAL, i: int64(r.Ints[e.id])}
+		case FLOAT_VAL:
+			return computeValue{kind: FLOAT_VAL, f: float64(r.Floats[e.id])}
+		case STR_VAL:
+			return computeValue{kind: STR_VAL, s: r.block.GetColumnInfo(e.id).get_string_for_val(int32(r.Strs[e.id]))}
+		}
+
+		return computeValue{}
+	case "int", "float", "str":
+		if len(e.Args) == 0 {
+			return computeValue{kind: e.col_type, i: e.Int, f: e.Float, s: e.Str}
+		}
+	}
+
+	args := make([]computeValue, len(e.Args))
+	for i, arg := range e.Args {
+		args[i] = arg.eval(r)
+		if args[i].kind == _NO_VAL {
+			return computeValue{}
+		}
+	}
+
+	switch e.Op {
+	case "int", "float":
+		val := args[0]
+		if val.kind == STR_VAL {
+			f, err := strconv.ParseFloat(strings.TrimSpace(val.s), 64)
+			if err != nil {
+				return computeValue{}
+			}
+			val = computeValue{kind: FLOAT_VAL, f: f}
+		}
+
+		if e.Op == "int" {
+			if val.kind == FLOAT_VAL {
+				return computeValue{kind: INT_VAL, i: int64(val.f)}
+			}
+			return val
+		}
+		return computeValue{kind: FLOAT_VAL, f: val.float()}
+	case "regex":
+		match := e.re.FindStringSubmatch(args[0].s)
+		if match == nil {
+			return computeValue{}
+		}
+		if len(match) > 1 {
+			return computeValue{kind: STR_VAL, s: match[1]}
+		}
+		return computeValue{kind: STR_VAL, s: match[0]}
+	case "neg":
+		return computeValue{kind: e.col_type, i: -args[0].i, f: -args[0].f}
+	}
+
+	a, b := args[0], args[1]
+	if e.col_type == INT_VAL {
+		switch e.Op {
+		case "+":
+			return computeValue{kind: INT_VAL, i: a.i + b.i}
+		case "-":
+			return computeValue{kind: INT_VAL, i: a.i - b.i}
+		case "*":
+			return computeValue{kind: INT_VAL, i: a.i * b.i}
+		case "/", "%":
+			if b.i == 0 {
+				return computeValue{}
+			}
+			if e.Op == "/" {
+				return computeValue{kind: INT_VAL, i: a.i / b.i}
+			}
+			return computeValue{kind: INT_VAL, i: a.i % b.i}
+		}
+	}
+
+	x, y := a.float(), b.float()
+	switch e.Op {
+	case "+":
+		return computeValue{kind: FLOAT_VAL, f: x + y}
+	case "-":
+		return computeValue{kind: FLOAT_VAL, f: x - y}
+	case "*":
+		return computeValue{kind: FLOAT_VAL, f: x * y}
+	case "/":
+		if y == 0 {
+			return computeValue{}
+		}
+		return computeValue{kind: FLOAT_VAL, f: x / y}
+	}
+
+	return computeValue{}
+}
+
+// fills in the computed columns of a record. Like joined columns, str values
+// are added to the string tables of the record's block
+func (t *Table) computeRecord(r *Record) {
+	for _, c := range t.computed {
+		if !c.active {
+			continue
+		}
+
+		growRecord(r, c.id, c.col_type)
+		val := c.expr.eval(r)
+		if val.kind == _NO_VAL {
+			r.Populated[c.id] = _NO_VAL
+			continue
+		}
+
+		switch c.col_type {
+		case INT_VAL:
+			r.Ints[c.id] = IntField(val.i)
+		case FLOAT_VAL:
+			r.Floats[c.id] = FloatField(val.f)
+		case STR_VAL:
+			r.Strs[c.id] = StrField(r.block.GetColumnInfo(c.id).get_val_id(val.s))
+		}
+
+		r.Populated[c.id] = c.col_type
+	}
+}
+
+// }}} COMPUTED COLUMNS
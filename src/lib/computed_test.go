@@ -0,0 +1,132 @@
+package sybil
+
+import "math"
+import "strconv"
+import "testing"
+
+func TestParseComputedColumn(t *testing.T) {
+	c, err := ParseComputedColumn("route = regex(path, '^/api/([^/]+)') ")
+	if err != nil || c.Name != "route" {
+		t.Fatal("COULDNT PARSE COMPUTED COLUMN", c, err)
+	}
+
+	c, err = ParseComputedColumn("per_hour=(bytes + 1.5 * overhead) / (time / 1h)")
+	if err != nil {
+		t.Fatal("COULDNT PARSE COMPUTED COLUMN", err)
+	}
+
+	cols := c.Columns()
+	if len(cols) != 3 || cols[0] != "bytes" || cols[1] != "overhead" || cols[2] != "time" {
+		t.Error("COMPUTED COLUMN READS THE WRONG COLUMNS", cols)
+	}
+
+	for _, spec := range []string{"status/100", "=status", "a b=status", "x=status +", "x=(status", "x=foo(status)", "x=regex(path, path)", "x=regex(path, '(')"} {
+		if _, err := ParseComputedColumn(spec); err == nil {
+			t.Error("EXPECTED", spec, "TO FAIL")
+		}
+	}
+}
+
+func TestComputedColumns(t *testing.T) {
+	tableName := getTestTableName(t)
+	deleteTestDb(tableName)
+	defer deleteTestDb(tableName)
+
+	statuses := []int64{200, 302, 404, 500}
+	blockCount := 3
+	addRecords(tableName, func(r *Record, index int) {
+		r.AddIntField("status", statuses[index%4])
+		r.AddIntField("bytes", int64(index%10))
+		r.AddStrField("path", "/api/v"+strconv.Itoa(index%3)+"/users")
+		r.AddStrField("size", strconv.Itoa(index%10*100))
+	}, blockCount)
+
+	nt := saveAndReloadTable(t, tableName, blockCount)
+
+	if err := nt.AddComputedColumn(mustParseComputed(t, "status=status/100")); err == nil {
+		t.Error("A COMPUTED COLUMN SHOULDNT REPLACE A STORED ONE")
+	}
+	if err := nt.AddComputedColumn(mustParseComputed(t, "x=path*2")); err == nil {
+		t.Error("ARITHMETIC ON A STR SHOULD FAIL")
+	}
+
+	for _, spec := range []string{"status_class=status/100", "version=regex(path, '/v(\\d+)/')", "kb=float(bytes)/1000", "big=status_class*bytes", "size_n=int(size)"} {
+		if err := nt.AddComputedColumn(mustParseComputed(t, spec)); err != nil {
+			t.Fatal("COULDNT ADD COMPUTED COLUMN", spec, err)
+		}
+	}
+
+	types := map[string]int8{"status_class": INT_VAL, "version": STR_VAL, "kb": FLOAT_VAL, "big": INT_VAL, "size_n": INT_VAL}
+	for name, col_type := range types {
+		if nt.GetColumnType(name) != col_type {
+			t.Error("COMPUTED COLUMN", name, "HAS THE WRONG TYPE", nt.GetColumnType(name))
+		}
+	}
+
+	if info := nt.IntInfo[nt.KeyTable["status_class"]]; info == nil || info.Min != 2 || info.Max != 5 {
+		t.Error("STATUS CLASS HAS THE WRONG RANGE", info)
+	}
+
+	loadSpec := nt.NewLoadSpec()
+	querySpec := newQuerySpec()
+	querySpec.Filters = BuildFilters(nt, &loadSpec, FilterSpec{Where: "status_class >= 4 and version != '2'"})
+	querySpec.Groups = append(querySpec.Groups, nt.Grouping("status_class"), nt.Grouping("version"))
+	querySpec.Aggregations = append(querySpec.Aggregations, nt.Aggregation("kb", OP_AVG))
+	nt.MatchAndAggregate(querySpec)
+
+	expected := map[string]int64{}
+	for i := 0; i < CHUNK_SIZE*blockCount; i++ {
+		class := statuses[i%4] / 100
+		if class < 4 || i%3 == 2 {
+			continue
+		}
+
+		expected[strconv.FormatInt(class, 10)+GROUP_DELIMITER+strconv.Itoa(i%3)+GROUP_DELIMITER]++
+	}
+
+	if len(querySpec.Results) != len(expected) {
+		t.Fatal("EXPECTED A GROUP PER STATUS CLASS AND VERSION", querySpec.Results)
+	}
+
+	for key, count := range expected {
+		r, ok := querySpec.Results[key]
+		if !ok || r.Count != count {
+			t.Error("GROUP", key, "HAS THE WRONG COUNT", r, "EXPECTED", count)
+			continue
+		}
+
+		// 404s have even bytes and 500s odd ones
+		if avg := r.Hists["kb"].Mean(); math.Abs(avg-0.004) > 0.0001 && math.Abs(avg-0.005) > 0.0001 {
+			t.Error("GROUP", key, "HAS THE WRONG AVERAGE", avg)
+		}
+	}
+
+	// the range of int(size) can't be worked out, so its values go into an
+	// hdr hist instead of being dropped as outliers
+	if _, ok := nt.IntInfo[nt.KeyTable["size_n"]]; ok {
+		t.Error("INT OF A STR SHOULDNT HAVE A RANGE")
+	}
+
+	querySpec = newQuerySpec()
+	querySpec.Aggregations = append(querySpec.Aggregations, nt.Aggregation("size_n", OP_AVG))
+	nt.MatchAndAggregate(querySpec)
+
+	r := querySpec.Results["total"]
+	if r == nil || r.Hists["size_n"] == nil {
+		t.Fatal("INT OF A STR WASNT AGGREGATED", querySpec.Results)
+	}
+
+	h := r.Hists["size_n"]
+	if h.TotalCount() != int64(CHUNK_SIZE*blockCount) || math.Abs(h.Mean()-450) > 0.001 || h.Max() != 900 {
+		t.Error("INT OF A STR AGGREGATED WRONG", h.TotalCount(), h.Mean(), h.Max())
+	}
+}
+
+func mustParseComputed(t *testing.T, spec string) *ComputedColumn {
+	c, err := ParseComputedColumn(spec)
+	if err != nil {
+		t.Fatal("COULDNT PARSE COMPUTED COLUMN", spec, err)
+	}
+
+	return c
+}
@@ -25,7 +25,6 @@ func NewTrueFlag() *bool {
 }
 
 var TEST_MODE = false
-var ENABLE_LUA = false
 
 type FlagDefs struct {
 	OP          string
@@ -38,12 +37,18 @@ type FlagDefs struct {
 	ENCODE_FLAGS   bool // print the query flags to stdout as binary
 	ENCODE_RESULTS bool // print the querySpec results to stdout as binary
 
-	INT_FILTERS string
-	STR_FILTERS string
-	STR_REPLACE string // regex replacement for strings
-	SET_FILTERS string
+	INT_FILTERS   string
+	STR_FILTERS   string
+	STR_REPLACE   string // regex replacement for strings
+	SET_FILTERS   string
+	FLOAT_FILTERS string
+	WHERE         string // boolean filter expression, see filter_expr.go
+	HAVING        string // filters on the aggregated results, see having.go
+	START         string // time range on TIME_COL, see time_range.go
+	END           string
 
 	INTS        string
+	AGGS        string // per column aggregations, ex: sum(bytes),p95(latency)
 	STRS        string
 	SETS        string
 	SAMPLE_COLS string
@@ -52,13 +57,34 @@ type FlagDefs struct {
 
 	ADD_RECORDS int
 
-	TIME        bool
-	TIME_COL    string
-	TIME_BUCKET int
-	HIST_BUCKET int
-	HDR_HIST    bool
-	LOG_HIST    bool
-	T_DIGEST    bool
+	TIME          bool
+	TIME_COL      string
+	TIME_BUCKET   int
+	TIME_CALENDAR string // day, week or month, see time_bucket.go
+	TIME_ZONE     string
+	FILL          string // zero, null or previous, see time_fill.go
+	TRANSFORM     string // series derived from time results, see time_transform.go
+	WINDOW        int    // how many buckets -transform mavg and mpNN cover
+	COMPARE_TO    string // offset of the baseline, see compare.go
+	JOIN_TABLE    string // the dimension table of a lookup join, see join.go
+	JOIN_KEY      string
+	COMPUTE       []string // name=expression columns, see computed.go
+	LUA_FILE      string   // a script to filter and reduce records with, see lua.go
+	SESSION_COL   string   // the actor column of a session query, see sessions.go
+	SESSION_GAP   string
+	FUNNEL_COL    string
+	FUNNEL        string // comma separated funnel steps
+	HIST_BUCKET   int
+	BUCKET_BOUNDS string // bounds of buckets(col) aggregations, see agg_buckets.go
+	HDR_HIST      bool
+	HDR_DIGITS    int // significant digits of -hdr hists
+	LOG_HIST      bool
+	T_DIGEST      bool
+
+	MERGEABLE_HISTS bool // encode int hists as HDR hists, see hist.go
+
+	LIMIT_PER_BUCKET bool // limit each time bucket to its own top results
+	OTHER            bool // roll the rest of each bucket up into an other row
 
 	FIELD_SEPARATOR    string
 	FILTER_SEPARATOR   string
@@ -67,6 +93,7 @@ type FlagDefs struct {
 	LOAD_THEN_QUERY    bool
 	READ_INGESTION_LOG bool
 	READ_ROWSTORE      bool
+	SKIP_OLD_LOGS      bool // skip row store logs last written before -start, see rowStoreStartBound
 	SKIP_COMPACT       bool
 	SAVE_AS_SRB        bool
 
@@ -83,6 +110,13 @@ type FlagDefs struct {
 	LIMIT        int
 	NUM_DISTINCT int
 
+	HEAVY_HITTERS int // approximate top groups per block, see heavy_hitters.go
+
+	PERCENTILES string // the percentiles -op hist prints, see percentiles.go
+
+	DISTINCT_PRECISION int // count distinct sketch size, see distinct.go
+	DISTINCT_EXACT     bool
+
 	DEBUG bool
 	JSON  bool
 	GC    bool
@@ -111,6 +145,7 @@ type OptionDefs struct {
 	TIME_COL_ID      int16
 	TIME_FORMAT      string
 	MERGE_TABLE      *Table
+	MULTI_TABLE      bool // the query runs over several tables, see multi_table.go
 }
 
 // TODO: merge these two into one thing
@@ -144,7 +179,7 @@ func setDefaults() {
 	FLAGS.READ_INGESTION_LOG = false
 	FLAGS.READ_ROWSTORE = false
 	flag.StringVar(&FLAGS.DIR, "dir", "./db/", "Directory to store DB files")
-	flag.StringVar(&FLAGS.TABLE, "table", "", "Table to operate on [REQUIRED]")
+	flag.StringVar(&FLAGS.TABLE, "table", "", "Table to operate on, queries also take a list of tables and globs, ex: logs_a,logs_* [REQUIRED]")
 
 	flag.BoolVar(&FLAGS.DEBUG, "debug", false, "enable debug logging")
 	flag.StringVar(&FLAGS.FIELD_SEPARATOR, "field-separator", ",", "Field separator used in command line params")
@@ -159,6 +194,9 @@ func setDefaults() {
 	FLAGS.CACHED_QUERIES = false
 
 	FLAGS.HDR_HIST = false
+	FLAGS.HDR_DIGITS = DEFAULT_HDR_DIGITS
+	FLAGS.WINDOW = DEFAULT_TRANSFORM_WINDOW
+	FLAGS.MERGEABLE_HISTS = true
 	FLAGS.LOG_HIST = false
 	FLAGS.T_DIGEST = false
 
@@ -3,12 +3,17 @@ package sybil
 import "log"
 import "fmt"
 import "os"
+import "strings"
 
 // extracted from and influenced by
 // https://groups.google.com/forum/#!topic/golang-nuts/ct99dtK2Jo4
 // use env variable DEBUG=1 to turn on debug output
 var ENV_FLAG = os.Getenv("DEBUG")
 
+// long running processes (like sybil serve) set PANIC_ON_ERROR so that a bad
+// query can be recovered from instead of exiting the whole process
+var PANIC_ON_ERROR = false
+
 func Print(args ...interface{}) {
 	fmt.Println(args...)
 }
@@ -24,5 +29,17 @@ func Debug(args ...interface{}) {
 }
 
 func Error(args ...interface{}) {
+	if PANIC_ON_ERROR {
+		log.Println(append([]interface{}{"ERROR"}, args...)...)
+		panic(SybilError(strings.TrimSpace(fmt.Sprintln(args...))))
+	}
+
 	log.Fatalln(append([]interface{}{"ERROR"}, args...)...)
 }
+
+// SybilError is the value that Error() panics with when PANIC_ON_ERROR is set
+type SybilError string
+
+func (e SybilError) Error() string {
+	return string(e)
+}
@@ -0,0 +1,232 @@
+package sybil
+
+import "bytes"
+import "encoding/gob"
+import "fmt"
+import "math"
+
+import metro "github.com/dgryski/go-metro"
+
+// {{{ DISTINCT COUNTER
+// count distincts are estimated with a HyperLogLog sketch of 2^precision
+// registers (-distinct-precision, 4 through 18). Its standard error is
+// 1.04 / sqrt(2^precision), about 0.8% at the default precision of 14, and it
+// takes 2^precision bytes per result.
+//
+// with -distinct-exact, each result starts out counting the hashes of its
+// values in a set, so small cardinalities are exact. Once the set holds more
+// than DISTINCT_EXACT_LIMIT values, it is turned into a sketch.
+//
+// values are hashed the same way loglogbeta (which sybil used before) hashes
+// them, so its encoded sketches decode as precision 14 sketches.
+
+const (
+	MIN_DISTINCT_PRECISION     = 4
+	MAX_DISTINCT_PRECISION     = 18
+	DEFAULT_DISTINCT_PRECISION = 14
+)
+
+var DISTINCT_EXACT_LIMIT = 10000
+
+type DistinctCounter struct {
+	precision uint8
+	exact     map[uint64]bool
+	registers []uint8
+}
+
+type savedDistinctCounter struct {
+	Precision uint8
+	Hashes    []uint64
+	Sketch    []uint8
+}
+
+// what loglogbeta encodes its sketches as
+type savedLogLogBeta struct {
+	Registers [1 << 14]uint8
+	Alpha     float64
+	Version   int
+}
+
+// ParseDistinctPrecision validates a -distinct-precision value, 0 means the
+// default
+func ParseDistinctPrecision(precision int) (int, error) {
+	if precision == 0 {
+		return DEFAULT_DISTINCT_PRECISION, nil
+	}
+
+	if precision < MIN_DISTINCT_PRECISION || precision > MAX_DISTINCT_PRECISION {
+		return 0, fmt.Errorf("distinct precision must be between %d and %d, not %d",
+			MIN_DISTINCT_PRECISION, MAX_DISTINCT_PRECISION, precision)
+	}
+
+	return precision, nil
+}
+
+func NewDistinctCounter(precision int, exact bool) *DistinctCounter {
+	precision, err := ParseDistinctPrecision(precision)
+	if err != nil {
+		Warn(err, "USING", DEFAULT_DISTINCT_PRECISION)
+		precision = DEFAULT_DISTINCT_PRECISION
+	}
+
+	dc := &DistinctCounter{precision: uint8(precision)}
+	if exact {
+		dc.exact = make(map[uint64]bool)
+	} else {
+		dc.registers = make([]uint8, 1<<dc.precision)
+	}
+
+	return dc
+}
+
+func (dc *DistinctCounter) Add(value []byte) {
+	dc.addHash(metro.Hash64(value, 1337))
+}
+
+func (dc *DistinctCounter) addHash(h uint64) {
+	if dc.exact != nil {
+		dc.exact[h] = true
+		if len(dc.exact) > DISTINCT_EXACT_LIMIT {
+			dc.toSketch()
+		}
+		return
+	}
+
+	// the top bits pick the register, the rest are for counting leading zeros
+	p := uint(dc.precision)
+	k := h >> (64 - p)
+	w := h<<p | 1<<(p-1)
+
+	val := uint8(1)
+	for w&(1<<63) == 0 {
+		val++
+		w <<= 1
+	}
+
+	if dc.registers[k] < val {
+		dc.registers[k] = val
+	}
+}
+
+func (dc *DistinctCounter) toSketch() {
+	exact := dc.exact
+	dc.exact = nil
+	dc.registers = make([]uint8, 1<<dc.precision)
+	for h := range exact {
+		dc.addHash(h)
+	}
+}
+
+func (dc *DistinctCounter) IsExact() bool {
+	return dc.exact != nil
+}
+
+// Merge makes dc the union of both counters
+func (dc *DistinctCounter) Merge(other *DistinctCounter) {
+	if other.precision != dc.precision {
+		Warn("CANT MERGE DISTINCT COUNTS WITH PRECISION", other.precision, "INTO", dc.precision)
+		return
+	}
+
+	if other.exact != nil {
+		for h := range other.exact {
+			dc.addHash(h)
+		}
+		return
+	}
+
+	if dc.exact != nil {
+		dc.toSketch()
+	}
+
+	for i, v := range other.registers {
+		if dc.registers[i] < v {
+			dc.registers[i] = v
+		}
+	}
+}
+
+func (dc *DistinctCounter) Cardinality() uint64 {
+	if dc.exact != nil {
+		return uint64(len(dc.exact))
+	}
+
+	m := float64(len(dc.registers))
+	sum := 0.0
+	zeros := 0.0
+	for _, v := range dc.registers {
+		if v == 0 {
+			zeros++
+		}
+		sum += math.Ldexp(1, -int(v))
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	switch len(dc.registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	}
+
+	estimate := alpha * m * m / sum
+
+	// small cardinalities leave registers empty, which linear counting
+	// estimates better
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/zeros)
+	}
+
+	return uint64(estimate + 0.5)
+}
+
+// StdError is the standard error of the cardinality, 0 while it is exact
+func (dc *DistinctCounter) StdError() float64 {
+	if dc.exact != nil {
+		return 0
+	}
+
+	return 1.04 / math.Sqrt(float64(len(dc.registers))) * float64(dc.Cardinality())
+}
+
+func (dc *DistinctCounter) MarshalBinary() ([]byte, error) {
+	saved := savedDistinctCounter{Precision: dc.precision, Sketch: dc.registers}
+	for h := range dc.exact {
+		saved.Hashes = append(saved.Hashes, h)
+	}
+
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(saved)
+	return buf.Bytes(), err
+}
+
+func (dc *DistinctCounter) UnmarshalBinary(data []byte) error {
+	var saved savedDistinctCounter
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&saved); err != nil {
+		var llb savedLogLogBeta
+		if llb_err := gob.NewDecoder(bytes.NewReader(data)).Decode(&llb); llb_err != nil {
+			return err
+		}
+
+		dc.precision = 14
+		dc.exact = nil
+		dc.registers = llb.Registers[:]
+		return nil
+	}
+
+	dc.precision = saved.Precision
+	dc.registers = saved.Sketch
+	dc.exact = nil
+	if dc.registers == nil {
+		dc.exact = make(map[uint64]bool, len(saved.Hashes))
+		for _, h := range saved.Hashes {
+			dc.exact[h] = true
+		}
+	}
+
+	return nil
+}
+
+// }}} DISTINCT COUNTER
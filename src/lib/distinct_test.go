@@ -0,0 +1,104 @@
+package sybil
+
+import "math"
+import "reflect"
+import "strconv"
+import "testing"
+
+import hll "github.com/logv/loglogbeta"
+
+func TestParseDistinctPrecision(t *testing.T) {
+	for val, expected := range map[int]int{0: DEFAULT_DISTINCT_PRECISION, 4: 4, 18: 18} {
+		if precision, err := ParseDistinctPrecision(val); err != nil || precision != expected {
+			t.Error("PARSED DISTINCT PRECISION WRONG", val, precision, err)
+		}
+	}
+
+	for _, val := range []int{-1, 3, 19} {
+		if _, err := ParseDistinctPrecision(val); err == nil {
+			t.Error("EXPECTED AN ERROR FOR DISTINCT PRECISION", val)
+		}
+	}
+}
+
+func TestDistinctCounter(t *testing.T) {
+	for _, precision := range []int{10, 14, 16} {
+		for _, n := range []int{100, 5000, 100000} {
+			dc := NewDistinctCounter(precision, false)
+			for i := 0; i < n; i++ {
+				dc.Add([]byte(strconv.Itoa(i)))
+				dc.Add([]byte(strconv.Itoa(i)))
+			}
+
+			// 4 standard errors, so the test doesn't flake
+			card := float64(dc.Cardinality())
+			if math.Abs(card-float64(n)) > 4*dc.StdError() {
+				t.Error("DISTINCT ESTIMATE AT PRECISION", precision, "IS", card, "EXPECTED", n, "+/-", dc.StdError())
+			}
+		}
+	}
+
+	old_limit := DISTINCT_EXACT_LIMIT
+	DISTINCT_EXACT_LIMIT = 1000
+	defer func() { DISTINCT_EXACT_LIMIT = old_limit }()
+
+	exact := NewDistinctCounter(0, true)
+	other := NewDistinctCounter(0, true)
+	for i := 0; i < 600; i++ {
+		exact.Add([]byte(strconv.Itoa(i)))
+		other.Add([]byte(strconv.Itoa(i + 300)))
+	}
+
+	if exact.Cardinality() != 600 || !exact.IsExact() || exact.StdError() != 0 {
+		t.Error("EXACT DISTINCT COUNT IS WRONG", exact.Cardinality())
+	}
+
+	// the union has 900 values, so it stays exact
+	exact.Merge(other)
+	if exact.Cardinality() != 900 || !exact.IsExact() {
+		t.Error("MERGED EXACT DISTINCT COUNT IS WRONG", exact.Cardinality())
+	}
+
+	for i := 900; i < 2000; i++ {
+		exact.Add([]byte(strconv.Itoa(i)))
+	}
+
+	card := float64(exact.Cardinality())
+	if exact.IsExact() || math.Abs(card-2000) > 4*exact.StdError() {
+		t.Error("EXACT DISTINCT COUNT SHOULD HAVE TURNED INTO A SKETCH", exact.IsExact(), card)
+	}
+}
+
+func TestDistinctCounterEncoding(t *testing.T) {
+	for _, exact := range []bool{true, false} {
+		dc := NewDistinctCounter(12, exact)
+		for i := 0; i < 500; i++ {
+			dc.Add([]byte(strconv.Itoa(i)))
+		}
+
+		data, err := dc.MarshalBinary()
+		if err != nil {
+			t.Fatal("COULDNT ENCODE DISTINCT COUNTER", err)
+		}
+
+		decoded := &DistinctCounter{}
+		if err := decoded.UnmarshalBinary(data); err != nil || !reflect.DeepEqual(dc, decoded) {
+			t.Error("DISTINCT COUNTER CHANGED WHEN DECODED", exact, err)
+		}
+	}
+
+	// results encoded before -distinct-precision hold loglogbeta sketches,
+	// which decode into the same registers
+	llb := hll.New()
+	dc := NewDistinctCounter(14, false)
+	for i := 0; i < 5000; i++ {
+		llb.Add([]byte(strconv.Itoa(i)))
+		dc.Add([]byte(strconv.Itoa(i)))
+	}
+
+	data, _ := llb.MarshalBinary()
+	decoded := &DistinctCounter{}
+	if err := decoded.UnmarshalBinary(data); err != nil || !reflect.DeepEqual(dc, decoded) {
+		t.Error("COULDNT DECODE A LOGLOGBETA SKETCH", err)
+	}
+}
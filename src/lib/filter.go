@@ -4,12 +4,17 @@ import "regexp"
 
 import "strings"
 import "strconv"
+import "time"
 
 // This is the passed in flags
 type FilterSpec struct {
-	Int string
-	Str string
-	Set string
+	Int   string
+	Str   string
+	Set   string
+	Float string
+	Where string
+	Start string // lower bound (inclusive) on FLAGS.TIME_COL, see time_range.go
+	End   string // upper bound (exclusive) on FLAGS.TIME_COL
 }
 
 func checkTable(tokens []string, t *Table) bool {
@@ -24,6 +29,7 @@ func (filterSpec *FilterSpec) GetFilterCols() []string {
 	strfilters := make([]string, 0)
 	intfilters := make([]string, 0)
 	setfilters := make([]string, 0)
+	floatfilters := make([]string, 0)
 	filtercols := make([]string, 0)
 	if filterSpec.Int != "" {
 		intfilters = strings.Split(filterSpec.Int, FLAGS.FIELD_SEPARATOR)
@@ -36,11 +42,20 @@ func (filterSpec *FilterSpec) GetFilterCols() []string {
 		setfilters = strings.Split(filterSpec.Set, FLAGS.FIELD_SEPARATOR)
 	}
 
+	if filterSpec.Float != "" {
+		floatfilters = strings.Split(filterSpec.Float, FLAGS.FIELD_SEPARATOR)
+	}
+
 	for _, filt := range intfilters {
 		tokens := strings.Split(filt, FLAGS.FILTER_SEPARATOR)
 		col := tokens[0]
 		filtercols = append(filtercols, col)
 	}
+	for _, filt := range floatfilters {
+		tokens := strings.Split(filt, FLAGS.FILTER_SEPARATOR)
+		col := tokens[0]
+		filtercols = append(filtercols, col)
+	}
 	for _, filt := range strfilters {
 		tokens := strings.Split(filt, FLAGS.FILTER_SEPARATOR)
 		col := tokens[0]
@@ -52,6 +67,17 @@ func (filterSpec *FilterSpec) GetFilterCols() []string {
 		filtercols = append(filtercols, col)
 	}
 
+	if filterSpec.Where != "" {
+		where, err := ParseWhere(filterSpec.Where)
+		if err == nil {
+			filtercols = append(filtercols, where.Columns()...)
+		}
+	}
+
+	if filterSpec.Start != "" || filterSpec.End != "" {
+		filtercols = append(filtercols, FLAGS.TIME_COL)
+	}
+
 	return filtercols
 
 }
@@ -60,6 +86,7 @@ func BuildFilters(t *Table, loadSpec *LoadSpec, filterSpec FilterSpec) []Filter
 	strfilters := make([]string, 0)
 	intfilters := make([]string, 0)
 	setfilters := make([]string, 0)
+	floatfilters := make([]string, 0)
 	if filterSpec.Int != "" {
 		intfilters = strings.Split(filterSpec.Int, FLAGS.FIELD_SEPARATOR)
 	}
@@ -71,6 +98,10 @@ func BuildFilters(t *Table, loadSpec *LoadSpec, filterSpec FilterSpec) []Filter
 		setfilters = strings.Split(filterSpec.Set, FLAGS.FIELD_SEPARATOR)
 	}
 
+	if filterSpec.Float != "" {
+		floatfilters = strings.Split(filterSpec.Float, FLAGS.FIELD_SEPARATOR)
+	}
+
 	filters := []Filter{}
 
 	for _, filt := range intfilters {
@@ -83,21 +114,29 @@ func BuildFilters(t *Table, loadSpec *LoadSpec, filterSpec FilterSpec) []Filter
 			continue
 		}
 
-		// we align the Time Filter to the Time Bucket iff we are doing a time series query
-		if col == FLAGS.TIME_COL && FLAGS.TIME {
-			bucket := int64(FLAGS.TIME_BUCKET)
-			new_val := int64(val/bucket) * bucket
-
-			if val != new_val {
-				Debug("ALIGNING TIME FILTER TO BUCKET", val, new_val)
-				val = new_val
-			}
-		}
+		val = align_time_filter(col, val)
 
 		filters = append(filters, t.IntFilter(col, op, int(val)))
 		loadSpec.Int(col)
 	}
 
+	for _, filt := range floatfilters {
+		tokens := strings.Split(filt, FLAGS.FILTER_SEPARATOR)
+		col := tokens[0]
+		op := tokens[1]
+		val, err := strconv.ParseFloat(tokens[2], 64)
+		if err != nil {
+			Error("COULDNT PARSE FLOAT FILTER VALUE", tokens[2])
+		}
+
+		if checkTable(tokens, t) != true {
+			continue
+		}
+
+		filters = append(filters, t.FloatFilter(col, op, val))
+		loadSpec.Float(col)
+	}
+
 	for _, filter := range setfilters {
 		tokens := strings.Split(filter, FLAGS.FILTER_SEPARATOR)
 		col := tokens[0]
@@ -129,10 +168,58 @@ func BuildFilters(t *Table, loadSpec *LoadSpec, filterSpec FilterSpec) []Filter
 
 	}
 
+	if filterSpec.Where != "" {
+		where, err := ParseWhere(filterSpec.Where)
+		if err != nil {
+			Error("COULDNT PARSE WHERE EXPRESSION", filterSpec.Where, err)
+		}
+
+		// a top level AND is split into separate filters, so each can be
+		// used for block skipping and cache keys on its own
+		exprs := []*WhereExpr{where}
+		if where.Op == "and" {
+			exprs = where.Children
+		}
+
+		for _, expr := range exprs {
+			filter, err := expr.Compile(t, loadSpec)
+			if err != nil {
+				Error("COULDNT BUILD WHERE EXPRESSION", filterSpec.Where, err)
+			}
+
+			filters = append(filters, filter)
+		}
+	}
+
+	if filterSpec.Start != "" || filterSpec.End != "" {
+		time_filters, err := t.timeRangeFilters(filterSpec, time.Now())
+		if err != nil {
+			Error("COULDNT PARSE TIME RANGE", err)
+		}
+
+		filters = append(filters, time_filters...)
+		loadSpec.Int(FLAGS.TIME_COL)
+	}
+
 	return filters
 
 }
 
+// we align the Time Filter to the Time Bucket iff we are doing a time series query
+func align_time_filter(col string, val int64) int64 {
+	if col == FLAGS.TIME_COL && FLAGS.TIME {
+		bucketer := newTimeBucketer(FLAGS.TIME_BUCKET, FLAGS.TIME_CALENDAR, FLAGS.TIME_ZONE)
+		new_val := bucketer.bucket(val)
+
+		if val != new_val {
+			Debug("ALIGNING TIME FILTER TO BUCKET", val, new_val)
+			val = new_val
+		}
+	}
+
+	return val
+}
+
 // FILTERS RETURN TRUE ON MATCH SUCCESS
 type NoFilter struct{}
 
@@ -149,6 +236,15 @@ type IntFilter struct {
 	table *Table
 }
 
+type FloatFilter struct {
+	Field   string
+	FieldId int16
+	Op      string
+	Value   float64
+
+	table *Table
+}
+
 type StrFilter struct {
 	Field   string
 	FieldId int16
@@ -168,6 +264,20 @@ type SetFilter struct {
 	table *Table
 }
 
+// AndFilter, OrFilter and NotFilter combine other filters into a tree, they
+// are built from -where expressions
+type AndFilter struct {
+	Filters []Filter
+}
+
+type OrFilter struct {
+	Filters []Filter
+}
+
+type NotFilter struct {
+	Child Filter
+}
+
 func (filter IntFilter) Filter(r *Record) bool {
 	if r.Populated[filter.FieldId] == 0 {
 		return false
@@ -181,6 +291,12 @@ func (filter IntFilter) Filter(r *Record) bool {
 	case "lt":
 		return int(field) < int(filter.Value)
 
+	case "gte":
+		return int(field) >= int(filter.Value)
+
+	case "lte":
+		return int(field) <= int(filter.Value)
+
 	case "eq":
 		return int(field) == int(filter.Value)
 
@@ -194,6 +310,62 @@ func (filter IntFilter) Filter(r *Record) bool {
 	return false
 }
 
+func (filter FloatFilter) Filter(r *Record) bool {
+	if r.Populated[filter.FieldId] != FLOAT_VAL {
+		return false
+	}
+
+	field := float64(r.Floats[filter.FieldId])
+	switch filter.Op {
+	case "gt":
+		return field > filter.Value
+
+	case "lt":
+		return field < filter.Value
+
+	case "gte":
+		return field >= filter.Value
+
+	case "lte":
+		return field <= filter.Value
+
+	case "eq":
+		return field == filter.Value
+
+	case "neq":
+		return field != filter.Value
+
+	default:
+
+	}
+
+	return false
+}
+
+func (filter AndFilter) Filter(r *Record) bool {
+	for _, f := range filter.Filters {
+		if !f.Filter(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (filter OrFilter) Filter(r *Record) bool {
+	for _, f := range filter.Filters {
+		if f.Filter(r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (filter NotFilter) Filter(r *Record) bool {
+	return !filter.Child.Filter(r)
+}
+
 var REGEX_CACHE_SIZE = 100000
 
 func (filter StrFilter) Filter(r *Record) bool {
@@ -214,24 +386,27 @@ func (filter StrFilter) Filter(r *Record) bool {
 		fallthrough
 	case "re":
 		cardinality := len(col.StringTable)
-		// we can cache results if the cardinality is reasonably low
-		ok = true
-
+		// we can cache results if the cardinality is reasonably low. Each
+		// pattern gets its own cache, since a -where can have several
+		// regexes on the same column
 		if cardinality < REGEX_CACHE_SIZE {
-			ret, ok = col.RCache[int(val)]
+			cache, has_cache := col.RCache[filter.Value]
+			if !has_cache {
+				cache = make(map[int]bool)
+				col.RCache[filter.Value] = cache
+			}
+
+			ret, ok = cache[int(val)]
 			if !ok {
 				str_val := col.get_string_for_val(int32(val))
 				ret = filter.regex.MatchString(str_val)
+				cache[int(val)] = ret
 			}
 		} else {
 			str_val := col.get_string_for_val(int32(val))
 			ret = filter.regex.MatchString(str_val)
 		}
 
-		if cardinality < REGEX_CACHE_SIZE && !ok {
-			col.RCache[int(val)] = ret
-		}
-
 		if invert {
 			ret = !ret
 		}
@@ -292,6 +467,14 @@ func (t *Table) IntFilter(name string, op string, value int) IntFilter {
 
 }
 
+func (t *Table) FloatFilter(name string, op string, value float64) FloatFilter {
+	floatFilter := FloatFilter{Field: name, FieldId: t.get_key_id(name), Op: op, Value: value}
+	floatFilter.table = t
+
+	return floatFilter
+
+}
+
 func (t *Table) StrFilter(name string, op string, value string) StrFilter {
 	strFilter := StrFilter{Field: name, FieldId: t.get_key_id(name), Op: op, Value: value}
 	strFilter.table = t
@@ -0,0 +1,401 @@
+package sybil
+
+import "errors"
+import "fmt"
+import "strconv"
+import "strings"
+
+// {{{ WHERE EXPRESSIONS
+// -where takes a boolean expression over columns, like:
+//
+//   (status = 500 or status = 503) and not host ~ '^canary'
+//
+// comparisons are "col op value", where op is one of = != < > <= >= ~ !~ and
+// the value is a number, a bare word or a quoted string. Comparisons are
+// combined with "and", "or", "not" and parentheses. The expression is parsed
+// without a table (so we know which columns it touches before the key table
+// is shortened) and then compiled into a tree of Filters against the table.
+
+type WhereExpr struct {
+	Op       string // "and", "or", "not" or "cmp"
+	Children []*WhereExpr
+
+	// only used by "cmp" nodes
+	Col   string
+	CmpOp string
+	Value string
+}
+
+const (
+	where_word = iota
+	where_string
+	where_op
+	where_lparen
+	where_rparen
+)
+
+type whereToken struct {
+	kind  int
+	value string
+}
+
+var WHERE_OPS = []string{"<=", ">=", "!=", "<>", "==", "!~", "=", "<", ">", "~"}
+
+func tokenizeWhere(expr string) ([]whereToken, error) {
+	tokens := make([]whereToken, 0)
+
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, whereToken{where_lparen, "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, whereToken{where_rparen, ")"})
+			i++
+		case c == '\'' || c == '"':
+			// quoted strings run until the matching quote. A backslash escapes
+			// the quote or another backslash, other backslashes are kept so
+			// regexes like '^a\.b' and '\d+' work
+			val := make([]byte, 0)
+			j := i + 1
+			for ; j < len(expr) && expr[j] != c; j++ {
+				if expr[j] == '\\' && j+1 < len(expr) && (expr[j+1] == c || expr[j+1] == '\\') {
+					j++
+				}
+				val = append(val, expr[j])
+			}
+
+			if j >= len(expr) {
+				return nil, fmt.Errorf("UNTERMINATED STRING AT %d", i)
+			}
+
+			tokens = append(tokens, whereToken{where_string, string(val)})
+			i = j + 1
+		default:
+			matched := false
+			for _, op := range WHERE_OPS {
+				if strings.HasPrefix(expr[i:], op) {
+					tokens = append(tokens, whereToken{where_op, op})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+
+			if matched {
+				continue
+			}
+
+			j := i
+			for ; j < len(expr) && !strings.ContainsRune(" \t\n\r()'\"=!<>~", rune(expr[j])); j++ {
+			}
+
+			if j == i {
+				return nil, fmt.Errorf("UNEXPECTED CHARACTER %c AT %d", c, i)
+			}
+
+			tokens = append(tokens, whereToken{where_word, expr[i:j]})
+			i = j
+		}
+	}
+
+	return tokens, nil
+}
+
+type whereParser struct {
+	tokens []whereToken
+	pos    int
+}
+
+func (p *whereParser) peek() *whereToken {
+	if p.pos >= len(p.tokens) {
+		return nil
+	}
+
+	return &p.tokens[p.pos]
+}
+
+func (p *whereParser) peekKeyword(keyword string) bool {
+	tok := p.peek()
+	return tok != nil && tok.kind == where_word && strings.ToLower(tok.value) == keyword
+}
+
+func (p *whereParser) parseOr() (*WhereExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	node := &WhereExpr{Op: "or", Children: []*WhereExpr{left}}
+	for p.peekKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		node.Children = append(node.Children, right)
+	}
+
+	if len(node.Children) == 1 {
+		return left, nil
+	}
+
+	return node, nil
+}
+
+func (p *whereParser) parseAnd() (*WhereExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	node := &WhereExpr{Op: "and", Children: []*WhereExpr{left}}
+	for p.peekKeyword("and") {
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		node.Children = append(node.Children, right)
+	}
+
+	if len(node.Children) == 1 {
+		return left, nil
+	}
+
+	return node, nil
+}
+
+func (p *whereParser) parseNot() (*WhereExpr, error) {
+	if p.peekKeyword("not") {
+		p.pos++
+		child, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		return &WhereExpr{Op: "not", Children: []*WhereExpr{child}}, nil
+	}
+
+	tok := p.peek()
+	if tok != nil && tok.kind == where_lparen {
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		tok = p.peek()
+		if tok == nil || tok.kind != where_rparen {
+			return nil, errors.New("MISSING CLOSING PAREN")
+		}
+		p.pos++
+
+		return node, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *whereParser) parseComparison() (*WhereExpr, error) {
+	col := p.peek()
+	if col == nil || (col.kind != where_word && col.kind != where_string) {
+		return nil, fmt.Errorf("EXPECTED COLUMN NAME AT TOKEN %d", p.pos)
+	}
+	p.pos++
+
+	// -having compares aggregates, so op(col) is read as a single name
+	name := col.value
+	if col.kind == where_word && p.pos+2 < len(p.tokens) && p.tokens[p.pos].kind == where_lparen &&
+		p.tokens[p.pos+1].kind == where_word && p.tokens[p.pos+2].kind == where_rparen {
+		name = fmt.Sprintf("%s(%s)", col.value, p.tokens[p.pos+1].value)
+		p.pos += 3
+	}
+
+	op := p.peek()
+	if op == nil || op.kind != where_op {
+		return nil, fmt.Errorf("EXPECTED COMPARISON AFTER %s", name)
+	}
+	p.pos++
+
+	val := p.peek()
+	if val == nil || (val.kind != where_word && val.kind != where_string) {
+		return nil, fmt.Errorf("EXPECTED VALUE AFTER %s %s", name, op.value)
+	}
+	p.pos++
+
+	return &WhereExpr{Op: "cmp", Col: name, CmpOp: op.value, Value: val.value}, nil
+}
+
+func ParseWhere(expr string) (*WhereExpr, error) {
+	tokens, err := tokenizeWhere(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, errors.New("EMPTY EXPRESSION")
+	}
+
+	p := whereParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("UNEXPECTED TOKEN %s", p.tokens[p.pos].value)
+	}
+
+	return node, nil
+}
+
+// Columns returns the names of all columns referenced in the expression
+func (w *WhereExpr) Columns() []string {
+	if w.Op == "cmp" {
+		return []string{w.Col}
+	}
+
+	cols := make([]string, 0)
+	for _, c := range w.Children {
+		cols = append(cols, c.Columns()...)
+	}
+
+	return cols
+}
+
+// quoteWhere leaves plain words alone and quotes anything the tokenizer
+// would split up or mistake for a keyword
+func quoteWhere(s string) string {
+	lower := strings.ToLower(s)
+	if s != "" && lower != "and" && lower != "or" && lower != "not" &&
+		!strings.ContainsAny(s, " \t\n\r()'\"=!<>~\\") {
+		return s
+	}
+
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "'", "\\'", -1)
+	return "'" + s + "'"
+}
+
+// String renders the expression back into the -where syntax
+func (w *WhereExpr) String() string {
+	switch w.Op {
+	case "cmp":
+		return fmt.Sprintf("%s %s %s", quoteWhere(w.Col), w.CmpOp, quoteWhere(w.Value))
+	case "not":
+		return fmt.Sprintf("not (%s)", w.Children[0].String())
+	}
+
+	children := make([]string, 0)
+	for _, c := range w.Children {
+		children = append(children, c.String())
+	}
+
+	return "(" + strings.Join(children, " "+w.Op+" ") + ")"
+}
+
+var INT_WHERE_OPS = map[string]string{
+	"=": "eq", "==": "eq", "!=": "neq", "<>": "neq",
+	"<": "lt", ">": "gt", "<=": "lte", ">=": "gte",
+}
+
+var STR_WHERE_OPS = map[string]string{
+	"=": "eq", "==": "eq", "!=": "neq", "<>": "neq",
+	"~": "re", "!~": "nre",
+}
+
+var SET_WHERE_OPS = map[string]string{
+	"=": "in", "==": "in", "!=": "nin", "<>": "nin",
+}
+
+func (w *WhereExpr) compileComparison(t *Table, loadSpec *LoadSpec) (Filter, error) {
+	t.string_id_m.RLock()
+	col_id, ok := t.KeyTable[w.Col]
+	t.string_id_m.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("COLUMN %s DOES NOT EXIST", w.Col)
+	}
+
+	switch t.KeyTypes[col_id] {
+	case INT_VAL:
+		op, ok := INT_WHERE_OPS[w.CmpOp]
+		if !ok {
+			return nil, fmt.Errorf("CANT USE %s ON INT COLUMN %s", w.CmpOp, w.Col)
+		}
+
+		val, err := strconv.ParseInt(w.Value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("COULDNT PARSE %s AS INT FOR COLUMN %s", w.Value, w.Col)
+		}
+
+		loadSpec.Int(w.Col)
+		return t.IntFilter(w.Col, op, int(align_time_filter(w.Col, val))), nil
+	case FLOAT_VAL:
+		op, ok := INT_WHERE_OPS[w.CmpOp]
+		if !ok {
+			return nil, fmt.Errorf("CANT USE %s ON FLOAT COLUMN %s", w.CmpOp, w.Col)
+		}
+
+		val, err := strconv.ParseFloat(w.Value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("COULDNT PARSE %s AS FLOAT FOR COLUMN %s", w.Value, w.Col)
+		}
+
+		loadSpec.Float(w.Col)
+		return t.FloatFilter(w.Col, op, val), nil
+	case STR_VAL:
+		op, ok := STR_WHERE_OPS[w.CmpOp]
+		if !ok {
+			return nil, fmt.Errorf("CANT USE %s ON STR COLUMN %s", w.CmpOp, w.Col)
+		}
+
+		loadSpec.Str(w.Col)
+		return t.StrFilter(w.Col, op, w.Value), nil
+	case SET_VAL:
+		op, ok := SET_WHERE_OPS[w.CmpOp]
+		if !ok {
+			return nil, fmt.Errorf("CANT USE %s ON SET COLUMN %s", w.CmpOp, w.Col)
+		}
+
+		loadSpec.Set(w.Col)
+		return t.SetFilter(w.Col, op, w.Value), nil
+	}
+
+	return nil, fmt.Errorf("COLUMN %s HAS NO TYPE", w.Col)
+}
+
+// Compile turns the expression into a Filter for the given table, adding the
+// columns it uses to the loadSpec
+func (w *WhereExpr) Compile(t *Table, loadSpec *LoadSpec) (Filter, error) {
+	switch w.Op {
+	case "cmp":
+		return w.compileComparison(t, loadSpec)
+	case "not":
+		child, err := w.Children[0].Compile(t, loadSpec)
+		if err != nil {
+			return nil, err
+		}
+		return NotFilter{Child: child}, nil
+	}
+
+	children := make([]Filter, 0)
+	for _, c := range w.Children {
+		child, err := c.Compile(t, loadSpec)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+
+	if w.Op == "and" {
+		return AndFilter{Filters: children}, nil
+	}
+
+	return OrFilter{Filters: children}, nil
+}
+
+// }}} WHERE EXPRESSIONS
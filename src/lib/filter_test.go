@@ -5,6 +5,8 @@ import "math/rand"
 import "strconv"
 import "math"
 import "strings"
+import "bytes"
+import "encoding/gob"
 
 func TestFilters(t *testing.T) {
 	tableName := getTestTableName(t)
@@ -36,6 +38,10 @@ func TestFilters(t *testing.T) {
 	testStrNeq(t, tableName)
 	testSetIn(t, tableName)
 	testSetNin(t, tableName)
+	testWhereOr(t, tableName)
+	testWhereNot(t, tableName)
+	testWhereSet(t, tableName)
+	testWhereRegexOr(t, tableName)
 
 }
 
@@ -308,3 +314,172 @@ func testSetNin(t *testing.T, tableName string) {
 	}
 
 }
+
+func runWhereQuery(t *testing.T, tableName string, where string) *QuerySpec {
+	nt := GetTable(tableName)
+	loadSpec := nt.NewLoadSpec()
+	filters := BuildFilters(nt, &loadSpec, FilterSpec{Where: where})
+
+	aggs := []Aggregation{}
+	aggs = append(aggs, nt.Aggregation("age", "avg"))
+
+	groupings := []Grouping{}
+	groupings = append(groupings, nt.Grouping("age"))
+
+	querySpec := QuerySpec{QueryParams: QueryParams{Filters: filters, Aggregations: aggs, Groups: groupings}}
+
+	nt.MatchAndAggregate(&querySpec)
+
+	return &querySpec
+}
+
+func testWhereOr(t *testing.T, tableName string) {
+	querySpec := runWhereQuery(t, tableName, "age = 15 or (age >= 25 and age < 26)")
+
+	if len(querySpec.Results) != 2 {
+		t.Error("Where OR expression returned wrong number of groups", len(querySpec.Results), querySpec.Results)
+	}
+
+	for k, v := range querySpec.Results {
+		k = strings.Replace(k, GROUP_DELIMITER, "", 1)
+
+		if k != "15" && k != "25" {
+			t.Error("WHERE OR YIELDED UNEXPECTED RESULTS", k, v.Hists["age"].Mean())
+		}
+	}
+}
+
+func testWhereNot(t *testing.T, tableName string) {
+	querySpec := runWhereQuery(t, tableName, "not age_str ~ '^1' and age != 20")
+
+	if len(querySpec.Results) != 9 {
+		t.Error("Where NOT expression returned wrong number of groups", len(querySpec.Results), querySpec.Results)
+	}
+
+	for k, v := range querySpec.Results {
+		k = strings.Replace(k, GROUP_DELIMITER, "", 1)
+
+		if v.Hists["age"].Mean() <= 20 {
+			t.Error("WHERE NOT YIELDED UNEXPECTED RESULTS", k, v.Hists["age"].Mean())
+		}
+	}
+}
+
+func testWhereSet(t *testing.T, tableName string) {
+	querySpec := runWhereQuery(t, tableName, "age_set = \"12\" or age_set = '13'")
+
+	if len(querySpec.Results) != 2 {
+		t.Error("Where set expression returned wrong number of groups", len(querySpec.Results), querySpec.Results)
+	}
+}
+
+// regexes on the same column don't share their cached matches
+func testWhereRegexOr(t *testing.T, tableName string) {
+	querySpec := runWhereQuery(t, tableName, "age_str ~ '^1' or age_str ~ '^2'")
+
+	if len(querySpec.Results) != 20 {
+		t.Error("Where regex OR expression returned wrong number of groups", len(querySpec.Results), querySpec.Results)
+	}
+
+	querySpec = runWhereQuery(t, tableName, "age_str ~ '^1' and not age_str ~ '5$'")
+
+	if len(querySpec.Results) != 9 {
+		t.Error("Where regex AND NOT expression returned wrong number of groups", len(querySpec.Results), querySpec.Results)
+	}
+}
+
+func TestParseWhere(t *testing.T) {
+	where, err := ParseWhere("(status = 500 or status=503) and not host ~ '^can\\'ary'")
+	if err != nil {
+		t.Fatal("COULDNT PARSE WHERE EXPRESSION", err)
+	}
+
+	if where.Op != "and" || len(where.Children) != 2 {
+		t.Fatal("WHERE EXPRESSION PARSED INTO WRONG TREE", where)
+	}
+
+	if where.Children[0].Op != "or" || where.Children[1].Op != "not" {
+		t.Error("WHERE EXPRESSION PARSED INTO WRONG TREE", where.Children[0], where.Children[1])
+	}
+
+	regex := where.Children[1].Children[0]
+	if regex.Col != "host" || regex.CmpOp != "~" || regex.Value != "^can'ary" {
+		t.Error("WHERE COMPARISON PARSED WRONG", regex)
+	}
+
+	cols := where.Columns()
+	if strings.Join(cols, ",") != "status,status,host" {
+		t.Error("WHERE EXPRESSION HAS WRONG COLUMNS", cols)
+	}
+
+	// only quotes and backslashes are unescaped, so regex escapes survive and
+	// values round trip through String()
+	escapes := map[string]string{`'^a\.b'`: `^a\.b`, `'\d+'`: `\d+`, `'a\\b'`: `a\b`, `"say \"hi\""`: `say "hi"`}
+	for quoted, expected := range escapes {
+		where, err := ParseWhere("path ~ " + quoted)
+		if err != nil || where.Value != expected {
+			t.Error("WHERE STRING", quoted, "PARSED AS", where, err, "EXPECTED", expected)
+			continue
+		}
+
+		if reparsed, err := ParseWhere(where.String()); err != nil || reparsed.Value != expected {
+			t.Error("WHERE STRING", quoted, "DOESNT ROUND TRIP", where.String(), err)
+		}
+	}
+
+	bad := []string{"", "status =", "(status = 1", "status = 1 or", "status 1", "a = 'b", "a ! b", "a = 1)"}
+	for _, expr := range bad {
+		_, err := ParseWhere(expr)
+		if err == nil {
+			t.Error("EXPECTED PARSE ERROR FOR", expr)
+		}
+	}
+}
+
+func TestWhereBlockSkipping(t *testing.T) {
+	min_record := Record{}
+	max_record := Record{}
+	min_record.ResizeFields(1)
+	max_record.ResizeFields(1)
+	min_record.Ints[1] = 10
+	max_record.Ints[1] = 20
+	min_record.Populated[1] = INT_VAL
+	max_record.Populated[1] = INT_VAL
+
+	low := IntFilter{FieldId: 1, Op: "lt", Value: 5}
+	high := IntFilter{FieldId: 1, Op: "gte", Value: 20}
+
+	if block_may_match(low, &min_record, &max_record) {
+		t.Error("lt 5 should skip a block of 10..20")
+	}
+	if !block_may_match(high, &min_record, &max_record) {
+		t.Error("gte 20 should not skip a block of 10..20")
+	}
+	if !block_may_match(OrFilter{Filters: []Filter{low, high}}, &min_record, &max_record) {
+		t.Error("OR should not skip a block if one branch can match")
+	}
+	if block_may_match(AndFilter{Filters: []Filter{low, high}}, &min_record, &max_record) {
+		t.Error("AND should skip a block if one branch can't match")
+	}
+	if !block_may_match(NotFilter{Child: high}, &min_record, &max_record) {
+		t.Error("NOT should never skip a block")
+	}
+
+	// filter trees go through gob for the query cache and distributed queries
+	var buf bytes.Buffer
+	var filters []Filter
+	filters = append(filters, OrFilter{Filters: []Filter{low, NotFilter{Child: high}}})
+	if err := gob.NewEncoder(&buf).Encode(filters); err != nil {
+		t.Fatal("COULDNT ENCODE FILTER TREE", err)
+	}
+
+	var decoded []Filter
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		t.Fatal("COULDNT DECODE FILTER TREE", err)
+	}
+
+	or, ok := decoded[0].(OrFilter)
+	if !ok || len(or.Filters) != 2 {
+		t.Error("FILTER TREE DECODED INCORRECTLY", decoded)
+	}
+}
@@ -0,0 +1,169 @@
+package sybil
+
+import "testing"
+import "math"
+import "math/rand"
+import "strconv"
+import "strings"
+
+func TestFloatColumns(t *testing.T) {
+	tableName := getTestTableName(t)
+	deleteTestDb(tableName)
+	defer deleteTestDb(tableName)
+
+	blockCount := 3
+	total := float64(0)
+	count := 0
+	addRecords(tableName, func(r *Record, index int) {
+		// score has a high cardinality, ratio has a low one so that both the
+		// bucketed and the value encodings get written
+		score := float64(index) + 0.25
+		ratio := float64(rand.Intn(8)) / 4
+		total += score
+		count++
+
+		r.AddIntField("id", int64(index))
+		r.AddFloatField("score", score)
+		r.AddFloatField("ratio", ratio)
+	}, blockCount)
+
+	nt := saveAndReloadTable(t, tableName, blockCount)
+	DELETE_BLOCKS_AFTER_QUERY = false
+
+	for _, b := range nt.BlockList {
+		for _, r := range b.RecordList {
+			score, ok := r.GetFloatVal("score")
+			if !ok || score-math.Floor(score) != 0.25 {
+				t.Error("FLOAT COLUMN UNPACKED INCORRECTLY", score, ok)
+				break
+			}
+		}
+	}
+
+	testFloatAvg(t, tableName, total/float64(count))
+	testFloatFilter(t, tableName)
+	testFloatGroupBy(t, tableName)
+	testFloatHist(t, tableName)
+}
+
+func testFloatAvg(t *testing.T, tableName string, avg float64) {
+	nt := GetTable(tableName)
+	querySpec := newQuerySpec()
+	querySpec.Aggregations = append(querySpec.Aggregations, nt.Aggregation("score", "avg"))
+
+	nt.MatchAndAggregate(querySpec)
+
+	for k, v := range querySpec.Results {
+		if math.Abs(avg-v.Hists["score"].Mean()) > 0.001 {
+			t.Error("FLOAT AVG YIELDED UNEXPECTED RESULTS", k, avg, v.Hists["score"].Mean())
+		}
+	}
+}
+
+func testFloatFilter(t *testing.T, tableName string) {
+	nt := GetTable(tableName)
+	querySpec := newQuerySpec()
+	querySpec.Filters = append(querySpec.Filters, nt.FloatFilter("ratio", "lt", 0.5))
+	querySpec.Aggregations = append(querySpec.Aggregations, nt.Aggregation("ratio", "avg"))
+
+	nt.MatchAndAggregate(querySpec)
+
+	if len(querySpec.Results) <= 0 {
+		t.Error("Float Filter for ratio < 0.5 returned no results")
+	}
+
+	for k, v := range querySpec.Results {
+		if v.Hists["ratio"].Mean() >= 0.5 {
+			t.Error("FLOAT FILTER YIELDED UNEXPECTED RESULTS", k, v.Hists["ratio"].Mean())
+		}
+	}
+}
+
+func testFloatGroupBy(t *testing.T, tableName string) {
+	nt := GetTable(tableName)
+	querySpec := newQuerySpec()
+	querySpec.Groups = append(querySpec.Groups, nt.Grouping("ratio"))
+	querySpec.Aggregations = append(querySpec.Aggregations, nt.Aggregation("ratio", "avg"))
+
+	nt.MatchAndAggregate(querySpec)
+
+	if len(querySpec.Results) != 8 {
+		t.Error("Float Group By returned the wrong number of groups", len(querySpec.Results))
+	}
+
+	for k, v := range querySpec.Results {
+		k = strings.Replace(k, GROUP_DELIMITER, "", 1)
+		val, err := strconv.ParseFloat(k, 64)
+		if err != nil || val != v.Hists["ratio"].Mean() {
+			t.Error("FLOAT GROUP BY YIELDED UNEXPECTED RESULTS", k, v.Hists["ratio"].Mean())
+		}
+	}
+}
+
+func testFloatHist(t *testing.T, tableName string) {
+	nt := GetTable(tableName)
+	FLAGS.OP = HIST_STR
+	defer func() { FLAGS.OP = "" }()
+
+	querySpec := newQuerySpec()
+	querySpec.Aggregations = append(querySpec.Aggregations, nt.Aggregation("score", HIST_STR))
+
+	nt.MatchAndAggregate(querySpec)
+
+	for k, v := range querySpec.Results {
+		hist, ok := v.Hists["score"].(FloatHistogram)
+		if !ok {
+			t.Fatal("FLOAT COLUMN DID NOT MAKE A FLOAT HISTOGRAM", k)
+		}
+
+		if hist.FloatMin() != 0.25 {
+			t.Error("FLOAT HIST HAS WRONG MIN", hist.FloatMin())
+		}
+
+		percentiles := hist.GetFloatPercentiles()
+		if len(percentiles) != 100 {
+			t.Fatal("FLOAT HIST HAS WRONG NUMBER OF PERCENTILES", len(percentiles))
+		}
+
+		median := (hist.FloatMax() + hist.FloatMin()) / 2
+		if math.Abs(percentiles[50]-median)/median > 0.05 {
+			t.Error("FLOAT HIST HAS A BAD MEDIAN", percentiles[50], median)
+		}
+	}
+}
+
+func TestFloatRowStore(t *testing.T) {
+	tableName := getTestTableName(t)
+	deleteTestDb(tableName)
+	defer deleteTestDb(tableName)
+
+	blockCount := 1
+	addRecords(tableName, func(r *Record, index int) {
+		r.AddIntField("id", int64(index))
+		r.AddFloatField("score", float64(index)/2)
+	}, blockCount)
+
+	tbl := GetTable(tableName)
+	tbl.IngestRecords("ingest")
+
+	unloadTestTable(tableName)
+	nt := GetTable(tableName)
+	FLAGS.TABLE = tableName // TODO: eliminate global use
+	FLAGS.READ_INGESTION_LOG = true
+
+	nt.LoadTableInfo()
+	nt.LoadRecords(nil)
+
+	if len(nt.RowBlock.RecordList) != CHUNK_SIZE*blockCount {
+		t.Error("Row Store didn't read back right number of records", len(nt.RowBlock.RecordList))
+	}
+
+	for _, r := range nt.RowBlock.RecordList {
+		id, _ := r.GetIntVal("id")
+		score, ok := r.GetFloatVal("score")
+		if !ok || score != float64(id)/2 {
+			t.Error("ROW STORE FLOAT READ BACK INCORRECTLY", id, score, ok)
+			break
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package sybil
+
+import "fmt"
+import "strconv"
+import "strings"
+
+// {{{ HAVING
+// -having filters the grouped results after they are aggregated, with the
+// same expression syntax as -where:
+//
+//   -having "count > 1000 and p99(latency) > 500"
+//
+// the left side of a comparison is one of count, samples or distinct, a
+// column's bare name (its average) or op(col), where op is avg, sum, min,
+// max, count, stddev or pNN. sum, min, max and count use the exact -agg
+// stats when the column has them and its histogram otherwise.
+//
+// predicates see the fully combined results, so they are skipped on nodes
+// that encode their results and applied when the results are stitched.
+// Groups are kept or dropped by their overall result, except with
+// -limit-per-bucket, where each time bucket's results are checked on their own.
+
+const OP_STDDEV = "stddev"
+
+// ParseHaving parses a -having expression and checks that every comparison
+// is against a number and a result field
+func ParseHaving(expr string) (*WhereExpr, error) {
+	node, err := ParseWhere(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := node.checkHaving(); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+func (w *WhereExpr) checkHaving() error {
+	if w.Op != "cmp" {
+		for _, c := range w.Children {
+			if err := c.checkHaving(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if _, ok := INT_WHERE_OPS[w.CmpOp]; !ok {
+		return fmt.Errorf("CANT USE %s IN HAVING", w.CmpOp)
+	}
+
+	if _, err := strconv.ParseFloat(w.Value, 64); err != nil {
+		return fmt.Errorf("HAVING %s %s NEEDS A NUMBER, NOT %s", w.Col, w.CmpOp, w.Value)
+	}
+
+	agg, on_result := havingField(w.Col)
+	if on_result {
+		return nil
+	}
+
+	if agg.Name == "" || (agg.Op != OP_STDDEV && !isValidAggOp(agg.Op)) {
+		return fmt.Errorf("CANT USE %s IN HAVING", w.Col)
+	}
+
+	return nil
+}
+
+// splits a having field into the aggregation it reads, bare column names
+// are their average. The bool is true for count, samples and distinct, which
+// are read off the result itself
+func havingField(field string) (Aggregation, bool) {
+	switch strings.ToLower(field) {
+	case "count", "count(*)", "samples", "distinct":
+		return Aggregation{Op: strings.ToLower(field), Name: field}, true
+	}
+
+	open := strings.Index(field, "(")
+	if open > 0 && strings.HasSuffix(field, ")") {
+		op := strings.ToLower(strings.TrimSpace(field[:open]))
+		arg := strings.TrimSpace(field[open+1 : len(field)-1])
+		if isBucketOp(op) {
+			if agg, err := parseBucketAgg(op, arg); err == nil {
+				return agg, false
+			}
+		}
+		return Aggregation{Op: op, Name: arg}, false
+	}
+
+	return Aggregation{Op: OP_AVG, Name: field}, false
+}
+
+// HavingAggregations returns the column aggregations a having expression
+// reads, which the query has to aggregate for it to match anything
+func (w *WhereExpr) HavingAggregations() []Aggregation {
+	aggs := make([]Aggregation, 0)
+	for _, field := range w.Columns() {
+		if agg, on_result := havingField(field); !on_result {
+			aggs = append(aggs, agg)
+		}
+	}
+
+	return aggs
+}
+
+// HavingValue returns the value of a having field for this result
+func (r *Result) HavingValue(field string) (float64, bool) {
+	agg, on_result := havingField(field)
+	if on_result {
+		switch agg.Op {
+		case "samples":
+			return float64(r.Samples), true
+		case "distinct":
+			if r.Distinct == nil {
+				return 0, false
+			}
+			return float64(r.Distinct.Cardinality()), true
+		}
+
+		return float64(r.Count), true
+	}
+
+	if _, ok := r.Stats[agg.Name]; ok && isStatsOp(agg.Op) {
+		return r.AggValue(agg)
+	}
+
+	if isBucketOp(agg.Op) {
+		return r.AggValue(agg)
+	}
+
+	h, ok := r.Hists[agg.Name]
+	if !ok {
+		// -agg sum(col) etc. only keep stats, which still have an average
+		if s, ok := r.Stats[agg.Name]; ok && agg.Op == OP_AVG && s.Count > 0 {
+			return s.Sum / float64(s.Count), true
+		}
+		return 0, false
+	}
+
+	switch agg.Op {
+	case OP_SUM:
+		return h.Mean() * float64(h.TotalCount()), true
+	case OP_COUNT:
+		return float64(h.TotalCount()), true
+	case OP_MIN:
+		return float64(h.Min()), true
+	case OP_MAX:
+		return float64(h.Max()), true
+	case OP_STDDEV:
+		return h.StdDev(), true
+	}
+
+	return r.AggValue(agg)
+}
+
+// MatchResult checks a having expression against a result. Results without
+// the field never match.
+func (w *WhereExpr) MatchResult(r *Result) bool {
+	switch w.Op {
+	case "and":
+		for _, c := range w.Children {
+			if !c.MatchResult(r) {
+				return false
+			}
+		}
+		return true
+	case "or":
+		for _, c := range w.Children {
+			if c.MatchResult(r) {
+				return true
+			}
+		}
+		return false
+	case "not":
+		return !w.Children[0].MatchResult(r)
+	}
+
+	val, ok := r.HavingValue(w.Col)
+	if !ok {
+		return false
+	}
+
+	target, err := strconv.ParseFloat(w.Value, 64)
+	if err != nil {
+		return false
+	}
+
+	switch INT_WHERE_OPS[w.CmpOp] {
+	case "eq":
+		return val == target
+	case "neq":
+		return val != target
+	case "lt":
+		return val < target
+	case "gt":
+		return val > target
+	case "lte":
+		return val <= target
+	case "gte":
+		return val >= target
+	}
+
+	return false
+}
+
+// whether -having still has to judge the results once they are combined
+func (qs *QuerySpec) havingPending() bool {
+	return qs.Having != "" || qs.HavingPending
+}
+
+// ApplyHaving drops the results (and their time series) that don't match
+// the query's -having expression
+func (qs *QuerySpec) ApplyHaving() {
+	if qs.Having == "" {
+		return
+	}
+
+	having, err := ParseHaving(qs.Having)
+	if err != nil {
+		Warn("COULDNT PARSE HAVING", qs.Having, err)
+		return
+	}
+
+	for k, r := range qs.Results {
+		if !having.MatchResult(r) {
+			delete(qs.Results, k)
+		}
+	}
+
+	for _, results := range qs.TimeResults {
+		for k, r := range results {
+			if qs.LimitPerBucket {
+				if !having.MatchResult(r) {
+					delete(results, k)
+				}
+			} else if _, ok := qs.Results[k]; !ok {
+				delete(results, k)
+			}
+		}
+	}
+}
+
+// }}} HAVING
@@ -0,0 +1,183 @@
+package sybil
+
+import "runtime"
+import "sort"
+import "strconv"
+import "strings"
+import "testing"
+
+func TestParseHaving(t *testing.T) {
+	for _, expr := range []string{"count > 10 and p99(latency) >= 5", "not (distinct = 3) or samples < 2", "count(*) > 1", "stddev(latency) < 0.5"} {
+		if _, err := ParseHaving(expr); err != nil {
+			t.Error("COULDNT PARSE HAVING", expr, err)
+		}
+	}
+
+	for _, expr := range []string{"count ~ 5", "count > abc", "median(latency) > 1", "latency >"} {
+		if _, err := ParseHaving(expr); err == nil {
+			t.Error("EXPECTED AN ERROR FOR HAVING", expr)
+		}
+	}
+
+	having, _ := ParseHaving("count > 1 and (p99(latency) > 5 or sum(bytes) > 0)")
+	aggs := having.HavingAggregations()
+	if len(aggs) != 2 || aggs[0].Op != "p99" || aggs[1].Name != "bytes" {
+		t.Error("WRONG HAVING AGGREGATIONS", aggs)
+	}
+}
+
+// runs a query the way LoadAndQueryRecords does: the block results go
+// through MultiCombineResults, which prunes them when there are enough of them
+// per CPU. Each block is searched reps times, so there always are
+func havingQuery(nt *Table, querySpec *QuerySpec, reps int) *QuerySpec {
+	querySpec.Table = nt
+	block_specs := make(map[string]*QuerySpec)
+	for i := 0; i < reps; i++ {
+		for name, spec := range SearchBlocks(querySpec, nt.BlockList) {
+			block_specs[name+strconv.Itoa(i)] = spec
+		}
+	}
+
+	resultSpec := MultiCombineResults(querySpec, block_specs)
+	resultSpec.FinishResults()
+	return resultSpec
+}
+
+func havingReps(nt *Table) int {
+	return 4*runtime.NumCPU()/len(nt.BlockList) + 1
+}
+
+func TestHaving(t *testing.T) {
+	tableName := getTestTableName(t)
+	deleteTestDb(tableName)
+	defer deleteTestDb(tableName)
+
+	// h0 gets 40% of the records, h1 30%, h2 20% and h3 10%. Each host's
+	// latency is 100 times its number
+	blockCount := 3
+	hosts := []int{0, 0, 0, 0, 1, 1, 1, 2, 2, 3}
+	addRecords(tableName, func(r *Record, index int) {
+		host := hosts[index%10]
+		r.AddStrField("host", "h"+strconv.FormatInt(int64(host), 10))
+		r.AddIntField("latency", int64(host*100))
+	}, blockCount)
+
+	nt := saveAndReloadTable(t, tableName, blockCount)
+
+	FLAGS.OP = HIST_STR
+	defer func() { FLAGS.OP = "" }()
+
+	reps := havingReps(nt)
+	total := CHUNK_SIZE * blockCount * reps
+	tests := map[string]string{
+		"count > " + strconv.Itoa(total*15/100) + " and latency > 50": "h1,h2",
+		"not (count < " + strconv.Itoa(total*25/100) + ")":            "h0,h1",
+		"p50(latency) = 300 or sum(latency) = 0":                      "h0,h3",
+		"distinct > 0":                                                "",
+	}
+
+	for having, expected := range tests {
+		querySpec := newQuerySpec()
+		querySpec.Groups = append(querySpec.Groups, nt.Grouping("host"))
+		querySpec.Aggregations = append(querySpec.Aggregations, nt.Aggregation("latency", "hist"))
+		querySpec.Having = having
+		querySpec.OrderBy = SORT_COUNT
+		querySpec.PruneBy = SORT_COUNT
+
+		resultSpec := havingQuery(nt, querySpec, reps)
+
+		hosts := make([]string, 0)
+		for k := range resultSpec.Results {
+			hosts = append(hosts, strings.TrimRight(k, GROUP_DELIMITER))
+		}
+		sort.Strings(hosts)
+
+		if strings.Join(hosts, ",") != expected {
+			t.Error("HAVING", having, "KEPT", hosts, "EXPECTED", expected)
+		}
+
+		if len(resultSpec.Sorted) != len(hosts) {
+			t.Error("HAVING SHOULD RUN BEFORE THE RESULTS ARE SORTED", having, len(resultSpec.Sorted))
+		}
+	}
+}
+
+func TestHavingBeforePruning(t *testing.T) {
+	tableName := getTestTableName(t)
+	deleteTestDb(tableName)
+	defer deleteTestDb(tableName)
+
+	// hosts 40 to 49 get twice the records of hosts 0 to 39
+	blockCount := 6
+	addRecords(tableName, func(r *Record, index int) {
+		host := index % 60
+		if host >= 50 {
+			host -= 10
+		}
+		r.AddStrField("host", "h"+strconv.Itoa(host))
+	}, blockCount)
+
+	nt := saveAndReloadTable(t, tableName, blockCount)
+
+	// intermediate results are pruned to the 10 biggest hosts, which are
+	// the ones -having drops
+	old_limit := FLAGS.LIMIT
+	FLAGS.LIMIT = 1
+	defer func() { FLAGS.LIMIT = old_limit }()
+
+	reps := havingReps(nt)
+	querySpec := newQuerySpec()
+	querySpec.Groups = append(querySpec.Groups, nt.Grouping("host"))
+	querySpec.Having = "count < " + strconv.Itoa(15*reps)
+	querySpec.OrderBy = SORT_COUNT
+	querySpec.PruneBy = SORT_COUNT
+
+	resultSpec := havingQuery(nt, querySpec, reps)
+	if len(resultSpec.Results) != 40 {
+		t.Fatal("HAVING SHOULD SEE THE RESULTS BEFORE THEY ARE PRUNED", len(resultSpec.Results))
+	}
+
+	for k, r := range resultSpec.Results {
+		if r.Count != int64(10*reps) {
+			t.Error("HOST", k, "HAS THE WRONG COUNT", r.Count)
+		}
+	}
+}
+
+func TestHavingPrunesFewer(t *testing.T) {
+	// pruning keeps 10 results per -limit, and HAVING_OVERFETCH times as
+	// many while -having is pending, so the results stay bounded
+	pruned := func(having string, pending bool) int {
+		querySpec := newQuerySpec()
+		querySpec.Having = having
+		querySpec.HavingPending = pending
+		for i := 0; i < 2000; i++ {
+			r := querySpec.NewResult()
+			r.GroupByKey = strconv.Itoa(i)
+			querySpec.Sorted = append(querySpec.Sorted, r)
+		}
+
+		querySpec.PruneResults(5)
+		return len(querySpec.Results)
+	}
+
+	if n := pruned("", false); n != 50 {
+		t.Error("PRUNING WITHOUT HAVING KEPT", n, "RESULTS")
+	}
+	if n := pruned("count > 1", false); n != 50*HAVING_OVERFETCH {
+		t.Error("PRUNING WITH HAVING KEPT", n, "RESULTS")
+	}
+	if n := pruned("", true); n != 50*HAVING_OVERFETCH {
+		t.Error("PRUNING WITH A PENDING HAVING KEPT", n, "RESULTS")
+	}
+
+	// encoded results are stitched together before -having judges them
+	querySpec := newQuerySpec()
+	querySpec.Having = "count > 1"
+	querySpec.EncodeResults = true
+	querySpec.Results = ResultMap{"a": querySpec.NewResult()}
+	querySpec.FinishResults()
+	if len(querySpec.Results) != 1 {
+		t.Error("HAVING SHOULDNT FILTER ENCODED RESULTS")
+	}
+}
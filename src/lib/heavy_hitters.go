@@ -0,0 +1,204 @@
+package sybil
+
+import "container/heap"
+
+// {{{ DROPPED RESULTS AND HEAVY HITTERS
+// each block can hold at most INTERNAL_RESULT_LIMIT groups. The records of
+// any group past the limit are aggregated into a single dropped result, which
+// is printed after the results along with an estimate of how many groups it
+// holds. With -time, a record whose group doesn't fit is dropped from its
+// time bucket too.
+//
+// -heavy-hitters N keeps the N biggest groups of each block with the
+// Space-Saving algorithm instead: once N groups are full, a new group replaces
+// the smallest one and inherits its count. A result's Count is then an upper
+// bound, its Error is how far over it can be, and the replaced groups are
+// rolled into the dropped result. When blocks are combined, a group missing
+// from a block that replaced groups picks up that block's HeavyHitterFloor,
+// the most it could have counted there.
+
+// the group value of the row that holds the records of dropped groups
+var DROPPED_GROUP = "(dropped)"
+
+// keeps the results of a block in a min heap by count, so the smallest one
+// can be replaced
+type heavyHitters struct {
+	results []*Result
+	index   map[string]int
+}
+
+func newHeavyHitters(results ResultMap) *heavyHitters {
+	hh := &heavyHitters{index: make(map[string]int)}
+	for _, r := range results {
+		hh.index[r.BinaryByKey] = len(hh.results)
+		hh.results = append(hh.results, r)
+	}
+
+	heap.Init(hh)
+	return hh
+}
+
+func (hh *heavyHitters) Len() int { return len(hh.results) }
+
+func (hh *heavyHitters) Less(i, j int) bool {
+	return hh.results[i].Count < hh.results[j].Count
+}
+
+func (hh *heavyHitters) Swap(i, j int) {
+	hh.results[i], hh.results[j] = hh.results[j], hh.results[i]
+	hh.index[hh.results[i].BinaryByKey] = i
+	hh.index[hh.results[j].BinaryByKey] = j
+}
+
+func (hh *heavyHitters) Push(x interface{}) {
+	r := x.(*Result)
+	hh.index[r.BinaryByKey] = len(hh.results)
+	hh.results = append(hh.results, r)
+}
+
+func (hh *heavyHitters) Pop() interface{} {
+	last := hh.results[len(hh.results)-1]
+	hh.results = hh.results[:len(hh.results)-1]
+	delete(hh.index, last.BinaryByKey)
+	return last
+}
+
+// replaces the smallest result with a new one for key, which inherits its
+// count. Returns the new result and the one it replaced
+func (hh *heavyHitters) replace(qs *QuerySpec, key string) (*Result, *Result) {
+	evicted := heap.Pop(hh).(*Result)
+
+	added := qs.NewResult()
+	added.BinaryByKey = key
+	added.Count = evicted.Count
+	added.Error = evicted.Count
+	heap.Push(hh, added)
+
+	return added, evicted
+}
+
+// restores the heap order after a result's count went up
+func (hh *heavyHitters) update(r *Result) {
+	if i, ok := hh.index[r.BinaryByKey]; ok {
+		heap.Fix(hh, i)
+	}
+}
+
+// the most a group that isn't in the heap could have counted
+func (hh *heavyHitters) floor() int64 {
+	if len(hh.results) == 0 {
+		return 0
+	}
+
+	return hh.results[0].Count
+}
+
+// returns the result that the records of a dropped group are aggregated
+// into, key is the group's translated group by key
+func (qs *QuerySpec) droppedResult(key string) *Result {
+	if qs.Dropped == nil {
+		qs.Dropped = qs.NewResult()
+		qs.Dropped.GroupByKey = qs.fixedGroupByKey(DROPPED_GROUP)
+	}
+
+	if qs.DroppedGroups == nil {
+		qs.DroppedGroups = NewDistinctCounter(DEFAULT_DISTINCT_PRECISION, false)
+	}
+
+	qs.DroppedGroups.Add([]byte(key))
+	return qs.Dropped
+}
+
+// rolls a replaced heavy hitter into the dropped result
+func (qs *QuerySpec) dropResult(r *Result, key string) {
+	dropped := qs.droppedResult(key)
+
+	// the replacement inherits r's count, so only r's own records are dropped
+	dropped.Combine(r)
+	dropped.Count -= r.Error
+	dropped.Error -= r.Error
+}
+
+// adds another spec's dropped result and groups into this one's
+func (qs *QuerySpec) combineDropped(spec *QuerySpec) {
+	if spec.Dropped == nil {
+		return
+	}
+
+	if qs.Dropped == nil {
+		qs.Dropped = qs.NewResult()
+		qs.Dropped.GroupByKey = qs.fixedGroupByKey(DROPPED_GROUP)
+	}
+	qs.Dropped.Combine(spec.Dropped)
+
+	if spec.DroppedGroups != nil {
+		if qs.DroppedGroups == nil {
+			qs.DroppedGroups = NewDistinctCounter(DEFAULT_DISTINCT_PRECISION, false)
+		}
+		qs.DroppedGroups.Merge(spec.DroppedGroups)
+	}
+}
+
+// DroppedGroupCount estimates how many groups were dropped
+func (qs *QuerySpec) DroppedGroupCount() uint64 {
+	if qs.DroppedGroups == nil {
+		return 0
+	}
+
+	return qs.DroppedGroups.Cardinality()
+}
+
+// adds the floors of the blocks that replaced groups to the results that
+// are missing from them, see above
+func (qs *QuerySpec) addHeavyHitterFloors(block_specs map[string]*QuerySpec) {
+	total := int64(0)
+	seen := make(map[string]int64)
+	for _, spec := range block_specs {
+		if spec.HeavyHitterFloor == 0 {
+			continue
+		}
+
+		total += spec.HeavyHitterFloor
+		for k := range spec.Results {
+			seen[k] += spec.HeavyHitterFloor
+		}
+	}
+
+	if total == 0 {
+		return
+	}
+
+	for k, r := range qs.Results {
+		missing := total - seen[k]
+		r.Count += missing
+		r.Error += missing
+	}
+
+	qs.HeavyHitterFloor = total
+}
+
+// warns about (and returns the row for) the records of dropped groups
+func (qs *QuerySpec) printableDropped() *Result {
+	if qs.Dropped == nil || qs.Dropped.Samples == 0 {
+		return nil
+	}
+
+	Warn("DROPPED", qs.Dropped.Count, "RECORDS FROM ABOUT", qs.DroppedGroupCount(),
+		"GROUPS OVER THE RESULT LIMIT, SEE -heavy-hitters")
+
+	return qs.Dropped
+}
+
+// returns the JSON row for the records of dropped groups, if there are any
+func (qs *QuerySpec) droppedResultJSON() []ResultJSON {
+	dropped := qs.printableDropped()
+	if dropped == nil {
+		return nil
+	}
+
+	res := dropped.toResultJSON(qs)
+	res["Groups"] = qs.DroppedGroupCount()
+	return []ResultJSON{res}
+}
+
+// }}} DROPPED RESULTS AND HEAVY HITTERS
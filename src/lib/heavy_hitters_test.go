@@ -0,0 +1,147 @@
+package sybil
+
+import "bytes"
+import "encoding/json"
+import "os"
+import "strconv"
+import "testing"
+
+func TestDroppedResults(t *testing.T) {
+	tableName := getTestTableName(t)
+	deleteTestDb(tableName)
+	defer deleteTestDb(tableName)
+
+	blockCount := 3
+	addRecords(tableName, func(r *Record, index int) {
+		r.AddStrField("host", "h"+strconv.FormatInt(int64(index%20), 10))
+		r.AddIntField("latency", int64(index%20))
+		r.AddIntField("time", int64(index%4)*3600)
+	}, blockCount)
+
+	nt := saveAndReloadTable(t, tableName, blockCount)
+
+	old_limit := INTERNAL_RESULT_LIMIT
+	INTERNAL_RESULT_LIMIT = 5
+	defer func() { INTERNAL_RESULT_LIMIT = old_limit }()
+
+	FLAGS.OP = HIST_STR
+	defer func() { FLAGS.OP = "" }()
+
+	total := int64(CHUNK_SIZE * blockCount)
+
+	querySpec := newQuerySpec()
+	querySpec.Groups = append(querySpec.Groups, nt.Grouping("host"))
+	querySpec.Aggregations = append(querySpec.Aggregations, nt.Aggregation("latency", "hist"))
+	querySpec.OrderBy = SORT_COUNT
+	nt.MatchAndAggregate(querySpec)
+
+	kept := int64(0)
+	for _, r := range querySpec.Results {
+		kept += r.Count
+	}
+
+	if querySpec.Dropped == nil || kept+querySpec.Dropped.Count != total {
+		t.Fatal("DROPPED RECORDS WEREN'T COUNTED", kept, querySpec.Dropped)
+	}
+
+	if querySpec.Dropped.Hists["latency"] == nil {
+		t.Error("DROPPED RECORDS WEREN'T AGGREGATED")
+	}
+
+	dropped_groups := int64(20 - len(querySpec.Results))
+	if groups := int64(querySpec.DroppedGroupCount()); groups < dropped_groups-2 || groups > dropped_groups+2 {
+		t.Error("DROPPED GROUP ESTIMATE IS OFF", groups, "EXPECTED", dropped_groups)
+	}
+
+	var buf bytes.Buffer
+	OUTPUT = &buf
+	FLAGS.JSON = true
+	defer func() { OUTPUT = os.Stdout; FLAGS.JSON = false }()
+
+	printSortedResults(querySpec)
+
+	var results []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &results); err != nil {
+		t.Fatal("COULDNT READ JSON RESULTS", err, buf.String())
+	}
+
+	last := results[len(results)-1]
+	if last["host"] != DROPPED_GROUP || last["Count"] != float64(querySpec.Dropped.Count) || last["Groups"] == nil {
+		t.Error("JSON RESULTS SHOULD END WITH THE DROPPED ROW", last)
+	}
+
+	// time series drop the records of groups that didn't fit from their
+	// buckets too
+	old_time_col := OPTS.TIME_COL_ID
+	OPTS.TIME_COL_ID = nt.KeyTable["time"]
+	defer func() { OPTS.TIME_COL_ID = old_time_col }()
+
+	querySpec = newQuerySpec()
+	querySpec.Groups = append(querySpec.Groups, nt.Grouping("host"))
+	querySpec.TimeBucket = 3600
+	querySpec.OrderBy = SORT_COUNT
+	nt.MatchAndAggregate(querySpec)
+
+	in_buckets := int64(0)
+	for _, results := range querySpec.TimeResults {
+		for _, r := range results {
+			in_buckets += r.Count
+		}
+	}
+
+	if querySpec.Dropped == nil || in_buckets+querySpec.Dropped.Count != total {
+		t.Error("DROPPED TIME SERIES RECORDS WEREN'T COUNTED", in_buckets, querySpec.Dropped)
+	}
+}
+
+func TestHeavyHitters(t *testing.T) {
+	tableName := getTestTableName(t)
+	deleteTestDb(tableName)
+	defer deleteTestDb(tableName)
+
+	// half the records go to 4 big hosts, the other half are spread over
+	// 20 small ones
+	counts := make(map[string]int64)
+	blockCount := 3
+	addRecords(tableName, func(r *Record, index int) {
+		host := "big" + strconv.FormatInt(int64(index/2%4), 10)
+		if index%2 == 1 {
+			host = "small" + strconv.FormatInt(int64(index/2%20), 10)
+		}
+
+		counts[host]++
+		r.AddStrField("host", host)
+	}, blockCount)
+
+	nt := saveAndReloadTable(t, tableName, blockCount)
+
+	querySpec := newQuerySpec()
+	querySpec.Groups = append(querySpec.Groups, nt.Grouping("host"))
+	querySpec.OrderBy = SORT_COUNT
+	querySpec.HeavyHitters = 6
+	nt.MatchAndAggregate(querySpec)
+
+	if len(querySpec.Results) > 6*blockCount {
+		t.Error("HEAVY HITTERS KEPT TOO MANY GROUPS", len(querySpec.Results))
+	}
+
+	replaced := false
+	for _, r := range querySpec.Results {
+		host := r.GroupByKey[:len(r.GroupByKey)-len(GROUP_DELIMITER)]
+		if r.Count < counts[host] || r.Count-r.Error > counts[host] {
+			t.Error("HEAVY HITTER COUNT FOR", host, "IS", r.Count, "-", r.Error, "BUT IT HAS", counts[host])
+		}
+
+		replaced = replaced || r.Error > 0
+	}
+
+	if !replaced || querySpec.Dropped == nil {
+		t.Error("HEAVY HITTERS SHOULD HAVE REPLACED SOME GROUPS")
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, ok := querySpec.Results["big"+strconv.Itoa(i)+GROUP_DELIMITER]; !ok {
+			t.Error("HEAVY HITTERS LOST A BIG HOST", i)
+		}
+	}
+}
@@ -5,6 +5,15 @@ var DEBUG_OUTLIERS = false
 
 // histogram types:
 // BasicHist (which gets wrapped in HistCompat to implement the Histogram interface)
+// HDRHist (-hdr, see hist_hdr.go)
+//
+// sybil aggregate merges the hists of each node's encoded results. Basic and
+// multi hists are bucketed over the column's extents on that node, so they
+// have to be re-bucketed to merge, which loses percentiles whenever the
+// extents differ. HDR hists bucket values the same way everywhere and merge
+// exactly, so -encode-results uses them for int columns by default (unless
+// -mergeable-hists=false or another hist type is asked for). The aggregated
+// percentiles are then the same as a -hdr query over all of the data.
 
 type Histogram interface {
 	Mean() float64
@@ -14,6 +23,7 @@ type Histogram interface {
 
 	AddWeightedValue(int64, int64)
 	GetPercentiles() []int64
+	GetPercentile(float64) float64
 	GetStrBuckets() map[string]int64
 	GetIntBuckets() map[int64]int64
 
@@ -26,13 +36,44 @@ type Histogram interface {
 
 func (t *Table) NewHist(info *IntInfo) Histogram {
 	var hist Histogram
-	if FLAGS.LOG_HIST {
+	switch histType(info) {
+	case "multi":
 		hist = newMultiHist(t, info)
-	} else if FLAGS.T_DIGEST && ENABLE_TDIGEST {
+	case "hdr":
+		hist = newHDRHist(t, info, FLAGS.HDR_DIGITS)
+	case "tdigest":
 		hist = t.NewTDigestHist(info)
-	} else {
+	default:
 		hist = newBasicHist(t, info)
 	}
 
 	return hist
 }
+
+// the kind of hist NewHist makes for an int column, which Aggregation
+// reports as its HistType. Columns without info (like computed columns
+// whose range can't be worked out) get hdr hists, which don't need one
+func histType(info *IntInfo) string {
+	switch {
+	case info == nil:
+		return "hdr"
+	case FLAGS.LOG_HIST:
+		return "multi"
+	case FLAGS.HDR_HIST || (mergeableHists() && FLAGS.OP == HIST_STR):
+		return "hdr"
+	case FLAGS.T_DIGEST && ENABLE_TDIGEST:
+		return "tdigest"
+	}
+
+	return "basic"
+}
+
+// whether int hists should be HDR hists so they merge across nodes (or
+// across the tables of a multi table query)
+func mergeableHists() bool {
+	if !(FLAGS.ENCODE_RESULTS || OPTS.MULTI_TABLE) || !FLAGS.MERGEABLE_HISTS {
+		return false
+	}
+
+	return !FLAGS.LOG_HIST && !(FLAGS.T_DIGEST && ENABLE_TDIGEST)
+}
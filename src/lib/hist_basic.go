@@ -182,6 +182,30 @@ func (h *BasicHist) GetPercentiles() []int64 {
 	return percentiles[:100]
 }
 
+// GetPercentile interpolates the pth percentile within the bucket it falls in
+func (h *BasicHist) GetPercentile(p float64) float64 {
+	if h.Count == 0 || !h.PercentileMode {
+		return h.Avg
+	}
+
+	walk := newPercentileWalk(p, h.Count)
+	for k, count := range h.Values {
+		if fraction, ok := walk.next(count); ok {
+			low := float64(int64(k)*int64(h.BucketSize) + h.Min)
+			high := low + float64(h.BucketSize-1)
+
+			// the last bucket also holds the outliers
+			if k == len(h.Values)-1 {
+				high = float64(h.Max)
+			}
+
+			return interpolatePercentile(low, high, fraction, float64(h.Min), float64(h.Max))
+		}
+	}
+
+	return float64(h.Max)
+}
+
 // VARIANCE is defined as the squared error from the mean
 func (h *BasicHist) GetVariance() float64 {
 	std := h.GetStdDev()
@@ -227,30 +251,37 @@ func (h *BasicHist) GetSparseBuckets() map[int64]int64 {
 		}
 	}
 
+	// outliers and underliers are counted in the edge buckets too. Their
+	// weights aren't kept, so weighted hists leave them there
+	if h.Samples > 0 || len(h.Values) == 0 {
+		return ret
+	}
+
+	last := int64(len(h.Values)-1)*int64(h.BucketSize) + h.Min
 	for _, v := range h.Outliers {
+		ret[last]--
 		ret[int64(v)] += 1
 	}
 
 	for _, v := range h.Underliers {
+		ret[h.Min]--
 		ret[int64(v)] += 1
 	}
 
+	for k, v := range ret {
+		if v <= 0 {
+			delete(ret, k)
+		}
+	}
+
 	return ret
 }
 
 func (h *BasicHist) GetStrBuckets() map[string]int64 {
 	ret := make(map[string]int64, 0)
 
-	for k, v := range h.Values {
-		ret[strconv.FormatInt(int64(k)*int64(h.BucketSize)+h.Min, 10)] = v
-	}
-
-	for _, v := range h.Outliers {
-		ret[strconv.FormatInt(int64(v), 10)] += 1
-	}
-
-	for _, v := range h.Underliers {
-		ret[strconv.FormatInt(int64(v), 10)] += 1
+	for k, v := range h.GetSparseBuckets() {
+		ret[strconv.FormatInt(k, 10)] = v
 	}
 
 	return ret
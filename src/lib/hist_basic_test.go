@@ -0,0 +1,59 @@
+package sybil
+
+import "testing"
+
+func TestBasicHistOutlierBuckets(t *testing.T) {
+	FLAGS.OP = HIST_STR
+	defer func() { FLAGS.OP = "" }()
+
+	info := IntInfo{Min: 0, Max: 1000}
+	h := newBasicHist(&Table{}, &info)
+	weighted := newBasicHist(&Table{}, &info)
+	for i := int64(0); i < 1000; i++ {
+		h.AddWeightedValue(i, 1)
+		weighted.AddWeightedValue(i, 2)
+	}
+
+	outliers := []int64{5000, 7000}
+	for _, v := range outliers {
+		h.AddWeightedValue(v, 1)
+		weighted.AddWeightedValue(v, 2)
+	}
+
+	basic := h.BasicHist
+	last := int64(len(basic.Values)-1)*int64(basic.BucketSize) + basic.Min
+	edge := basic.Values[len(basic.Values)-1]
+
+	// outliers used to be counted both in the last bucket and at their own
+	// value, which made the buckets add up to more than the hist's count
+	buckets := h.GetSparseBuckets()
+	total := int64(0)
+	for _, v := range buckets {
+		total += v
+	}
+	if total != h.TotalCount() || total != 1002 {
+		t.Error("HIST BUCKETS ADD UP TO", total, "EXPECTED", h.TotalCount())
+	}
+
+	if buckets[last] != edge-int64(len(outliers)) {
+		t.Error("LAST HIST BUCKET HAS", buckets[last], "EXPECTED", edge-int64(len(outliers)))
+	}
+
+	for _, v := range outliers {
+		if buckets[v] != 1 {
+			t.Error("OUTLIER", v, "HAS", buckets[v], "IN ITS BUCKET")
+		}
+	}
+
+	str_buckets := h.GetStrBuckets()
+	if len(str_buckets) != len(buckets) || str_buckets["5000"] != 1 {
+		t.Error("STR BUCKETS DONT MATCH THE SPARSE BUCKETS", str_buckets)
+	}
+
+	// weighted hists don't know the outliers' weights, so the outliers stay
+	// in the last bucket
+	weighted_buckets := weighted.GetSparseBuckets()
+	if weighted_buckets[last] != 2*edge || weighted_buckets[5000] != 0 {
+		t.Error("WEIGHTED HIST BUCKETS CHANGED", weighted_buckets[last], weighted_buckets[5000])
+	}
+}
@@ -0,0 +1,269 @@
+package sybil
+
+import "math"
+import "strconv"
+
+// {{{ FLOAT HIST
+
+// FloatHistogram is implemented by histograms over FLOAT_VAL columns. The
+// Histogram interface deals in int64s, so printers use these methods to show
+// float values without rounding them away.
+type FloatHistogram interface {
+	FloatMin() float64
+	FloatMax() float64
+	GetFloatPercentiles() []float64
+}
+
+// FloatHist keeps a running mean and variance (using Welford's method) and,
+// when tracking percentiles, a fixed number of buckets between the column's
+// min and max
+type FloatHist struct {
+	Info           FloatInfo
+	PercentileMode bool
+	NumBuckets     int
+	BucketSize     float64
+	Values         []int64
+
+	Low     float64
+	High    float64
+	Count   int64
+	Samples int
+	Avg     float64
+	M2      float64
+
+	table *Table
+}
+
+func (t *Table) NewFloatHist(info *FloatInfo) *FloatHist {
+	h := &FloatHist{table: t}
+	if info != nil {
+		h.Info = *info
+	}
+
+	if FLAGS.OP == HIST_STR {
+		h.TrackPercentiles()
+	}
+
+	return h
+}
+
+func (h *FloatHist) TrackPercentiles() {
+	h.PercentileMode = true
+
+	h.NumBuckets = NUM_BUCKETS
+	h.BucketSize = (h.Info.Max - h.Info.Min) / float64(h.NumBuckets)
+	if FLAGS.HIST_BUCKET > 0 {
+		h.BucketSize = float64(FLAGS.HIST_BUCKET)
+		h.NumBuckets = int((h.Info.Max-h.Info.Min)/h.BucketSize) + 1
+	}
+
+	if h.BucketSize <= 0 {
+		h.BucketSize = 1
+		h.NumBuckets = 1
+	}
+
+	h.Values = make([]int64, h.NumBuckets)
+}
+
+func (h *FloatHist) bucketFor(value float64) int {
+	bucket := int((value - h.Info.Min) / h.BucketSize)
+	if bucket < 0 {
+		return 0
+	}
+
+	if bucket >= len(h.Values) {
+		return len(h.Values) - 1
+	}
+
+	return bucket
+}
+
+func (h *FloatHist) bucketValue(bucket int) float64 {
+	val := h.Info.Min + float64(bucket)*h.BucketSize
+	return math.Min(math.Max(val, h.Low), h.High)
+}
+
+func (h *FloatHist) AddWeightedFloat(value float64, weight int64) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return
+	}
+
+	if h.Count == 0 || value < h.Low {
+		h.Low = value
+	}
+	if h.Count == 0 || value > h.High {
+		h.High = value
+	}
+
+	if OPTS.WEIGHT_COL || weight > 1 {
+		h.Samples++
+	}
+
+	h.Count += weight
+	delta := value - h.Avg
+	h.Avg += delta * float64(weight) / float64(h.Count)
+	h.M2 += float64(weight) * delta * (value - h.Avg)
+
+	if h.PercentileMode {
+		h.Values[h.bucketFor(value)] += weight
+	}
+}
+
+func (h *FloatHist) AddWeightedValue(value int64, weight int64) {
+	h.AddWeightedFloat(float64(value), weight)
+}
+
+func (h *FloatHist) Mean() float64 {
+	return h.Avg
+}
+
+func (h *FloatHist) Min() int64 {
+	return int64(math.Floor(h.Low))
+}
+
+func (h *FloatHist) Max() int64 {
+	return int64(math.Ceil(h.High))
+}
+
+func (h *FloatHist) FloatMin() float64 {
+	return h.Low
+}
+
+func (h *FloatHist) FloatMax() float64 {
+	return h.High
+}
+
+func (h *FloatHist) TotalCount() int64 {
+	return h.Count
+}
+
+func (h *FloatHist) StdDev() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+
+	return math.Sqrt(h.M2 / float64(h.Count))
+}
+
+func (h *FloatHist) Range() (int64, int64) {
+	return int64(math.Floor(h.Info.Min)), int64(math.Ceil(h.Info.Max))
+}
+
+func (h *FloatHist) GetFloatPercentiles() []float64 {
+	if h.Count == 0 || !h.PercentileMode {
+		return make([]float64, 0)
+	}
+
+	percentiles := make([]float64, 100)
+
+	p := 0
+	count := int64(0)
+	for bucket, bucket_count := range h.Values {
+		count += bucket_count
+		for p < 100 && float64(count)*100 > float64(p)*float64(h.Count) {
+			percentiles[p] = h.bucketValue(bucket)
+			p++
+		}
+	}
+
+	for ; p < 100; p++ {
+		percentiles[p] = h.High
+	}
+
+	percentiles[0] = h.Low
+
+	return percentiles
+}
+
+// GetPercentile interpolates the pth percentile within the bucket it falls in
+func (h *FloatHist) GetPercentile(p float64) float64 {
+	if h.Count == 0 || !h.PercentileMode {
+		return h.Avg
+	}
+
+	walk := newPercentileWalk(p, h.Count)
+	for bucket, count := range h.Values {
+		if fraction, ok := walk.next(count); ok {
+			low := h.Info.Min + float64(bucket)*h.BucketSize
+			return interpolatePercentile(low, low+h.BucketSize, fraction, h.Low, h.High)
+		}
+	}
+
+	return h.High
+}
+
+func (h *FloatHist) GetPercentiles() []int64 {
+	floats := h.GetFloatPercentiles()
+	percentiles := make([]int64, len(floats))
+	for i, v := range floats {
+		percentiles[i] = int64(v)
+	}
+
+	return percentiles
+}
+
+func (h *FloatHist) GetStrBuckets() map[string]int64 {
+	ret := make(map[string]int64)
+	for bucket, count := range h.Values {
+		if count > 0 {
+			ret[strconv.FormatFloat(h.bucketValue(bucket), 'g', 6, 64)] += count
+		}
+	}
+
+	return ret
+}
+
+func (h *FloatHist) GetIntBuckets() map[int64]int64 {
+	ret := make(map[int64]int64)
+	for bucket, count := range h.Values {
+		if count > 0 {
+			ret[int64(h.bucketValue(bucket))] += count
+		}
+	}
+
+	return ret
+}
+
+func (h *FloatHist) NewHist() Histogram {
+	return h.table.NewFloatHist(&h.Info)
+}
+
+func (h *FloatHist) Combine(oh interface{}) {
+	next_hist := oh.(*FloatHist)
+	if next_hist.Count == 0 {
+		return
+	}
+
+	if h.Count == 0 || next_hist.Low < h.Low {
+		h.Low = next_hist.Low
+	}
+	if h.Count == 0 || next_hist.High > h.High {
+		h.High = next_hist.High
+	}
+
+	// merge the running means and variances
+	total := h.Count + next_hist.Count
+	delta := next_hist.Avg - h.Avg
+	h.M2 = h.M2 + next_hist.M2 + delta*delta*float64(h.Count)*float64(next_hist.Count)/float64(total)
+	h.Avg = h.Avg + delta*float64(next_hist.Count)/float64(total)
+
+	if h.PercentileMode && next_hist.PercentileMode {
+		same_buckets := h.Info.Min == next_hist.Info.Min &&
+			h.BucketSize == next_hist.BucketSize &&
+			len(h.Values) == len(next_hist.Values)
+
+		for bucket, count := range next_hist.Values {
+			if same_buckets {
+				h.Values[bucket] += count
+			} else if count > 0 {
+				val := next_hist.Info.Min + float64(bucket)*next_hist.BucketSize
+				h.Values[h.bucketFor(val)] += count
+			}
+		}
+	}
+
+	h.Samples += next_hist.Samples
+	h.Count = total
+}
+
+// }}} FLOAT HIST
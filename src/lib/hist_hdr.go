@@ -0,0 +1,300 @@
+package sybil
+
+import "math"
+import "sort"
+import "strconv"
+
+// {{{ HDR HIST
+
+// HDRHist is a high dynamic range histogram: every value is kept to Digits
+// significant digits, whatever its magnitude, without knowing the column's
+// range up front. Values below 2 * 10^Digits (roughly) get their own bucket,
+// bigger values share buckets that are at most 1 / 10^Digits of the value
+// wide. Buckets are only allocated once a value lands in them and two hists
+// with the same Digits merge exactly, by adding their buckets.
+//
+// Min, Max, the mean and the standard deviation are exact.
+type HDRHist struct {
+	Digits int
+	Counts map[int64]int64 // bucket index, negative values at -(index + 1)
+
+	Low   int64
+	High  int64
+	Count int64
+	Avg   float64
+	M2    float64
+
+	table *Table
+	info  *IntInfo
+	bits  uint
+}
+
+var DEFAULT_HDR_DIGITS = 3
+
+const MAX_HDR_DIGITS = 5
+
+func newHDRHist(t *Table, info *IntInfo, digits int) *HDRHist {
+	if digits <= 0 {
+		digits = DEFAULT_HDR_DIGITS
+	}
+	if digits > MAX_HDR_DIGITS {
+		digits = MAX_HDR_DIGITS
+	}
+
+	return &HDRHist{Digits: digits, Counts: make(map[int64]int64), table: t, info: info}
+}
+
+// values below 2^bits get their own bucket, bigger ones are shifted right
+// until they are below it
+func (h *HDRHist) subBucketBits() uint {
+	if h.bits == 0 {
+		h.bits = 1
+		for (int64(1) << h.bits) < 2*int64(math.Pow10(h.Digits)) {
+			h.bits++
+		}
+	}
+
+	return h.bits
+}
+
+func (h *HDRHist) addToBucket(value int64, count int64) {
+	if h.Counts == nil {
+		h.Counts = make(map[int64]int64)
+	}
+
+	h.Counts[h.bucketKey(value)] += count
+}
+
+// the key of the bucket that holds a value
+func (h *HDRHist) bucketKey(value int64) int64 {
+	if value < 0 {
+		return -h.bucketIndex(uint64(-value)) - 1
+	}
+
+	return h.bucketIndex(uint64(value))
+}
+
+// index of the bucket that holds a non negative value
+func (h *HDRHist) bucketIndex(value uint64) int64 {
+	bits := h.subBucketBits()
+	half := int64(1) << (bits - 1)
+
+	shift := uint(0)
+	for value>>shift >= uint64(1)<<bits {
+		shift++
+	}
+
+	return int64(shift)*half + int64(value>>shift)
+}
+
+// the lowest value of a bucket and how many values it holds
+func (h *HDRHist) bucketRange(index int64) (uint64, uint64) {
+	bits := h.subBucketBits()
+	half := int64(1) << (bits - 1)
+
+	shift := index/half - 1
+	if shift < 0 {
+		shift = 0
+	}
+
+	sub := index - shift*half
+	return uint64(sub) << uint(shift), uint64(1) << uint(shift)
+}
+
+// the value that stands in for a bucket: the middle of the bucket, so it is
+// within the bucket's precision of every value in it
+func (h *HDRHist) bucketValue(key int64) int64 {
+	if key < 0 {
+		low, width := h.bucketRange(-key - 1)
+		return -int64(low + (width-1)/2)
+	}
+
+	low, width := h.bucketRange(key)
+	return int64(low + (width-1)/2)
+}
+
+// the lowest and highest values a bucket holds
+func (h *HDRHist) bucketBounds(key int64) (int64, int64) {
+	if key < 0 {
+		low, width := h.bucketRange(-key - 1)
+		return -int64(low + width - 1), -int64(low)
+	}
+
+	low, width := h.bucketRange(key)
+	return int64(low), int64(low + width - 1)
+}
+
+func (h *HDRHist) AddWeightedValue(value int64, weight int64) {
+	if h.Count == 0 || value < h.Low {
+		h.Low = value
+	}
+	if h.Count == 0 || value > h.High {
+		h.High = value
+	}
+
+	h.Count += weight
+	delta := float64(value) - h.Avg
+	h.Avg += delta * float64(weight) / float64(h.Count)
+	h.M2 += float64(weight) * delta * (float64(value) - h.Avg)
+
+	h.addToBucket(value, weight)
+}
+
+func (h *HDRHist) Mean() float64 {
+	return h.Avg
+}
+
+func (h *HDRHist) Min() int64 {
+	return h.Low
+}
+
+func (h *HDRHist) Max() int64 {
+	return h.High
+}
+
+func (h *HDRHist) TotalCount() int64 {
+	return h.Count
+}
+
+func (h *HDRHist) StdDev() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+
+	return math.Sqrt(h.M2 / float64(h.Count))
+}
+
+func (h *HDRHist) Range() (int64, int64) {
+	return h.Low, h.High
+}
+
+// returns the bucket keys ordered by the values they hold
+func (h *HDRHist) sortedKeys() []int64 {
+	keys := make([]int64, 0, len(h.Counts))
+	for k := range h.Counts {
+		keys = append(keys, k)
+	}
+
+	sort.Sort(int64Slice(keys))
+
+	// negative values are stored by their magnitude, so the biggest
+	// negative key holds the lowest values
+	neg := 0
+	for neg < len(keys) && keys[neg] < 0 {
+		neg++
+	}
+	for i, j := 0, neg-1; i < j; i, j = i+1, j-1 {
+		keys[i], keys[j] = keys[j], keys[i]
+	}
+
+	return keys
+}
+
+func (h *HDRHist) GetPercentiles() []int64 {
+	if h.Count == 0 {
+		return make([]int64, 0)
+	}
+
+	percentiles := make([]int64, 100)
+
+	p := 0
+	count := int64(0)
+	for _, k := range h.sortedKeys() {
+		count += h.Counts[k]
+		for p < 100 && count*100 > int64(p)*h.Count {
+			percentiles[p] = Max(h.Low, Min(h.High, h.bucketValue(k)))
+			p++
+		}
+	}
+
+	for ; p < 100; p++ {
+		percentiles[p] = h.High
+	}
+
+	percentiles[0] = h.Low
+
+	return percentiles
+}
+
+// GetPercentile interpolates the pth percentile within the bucket it falls in
+func (h *HDRHist) GetPercentile(p float64) float64 {
+	if h.Count == 0 {
+		return 0
+	}
+
+	walk := newPercentileWalk(p, h.Count)
+	for _, k := range h.sortedKeys() {
+		if fraction, ok := walk.next(h.Counts[k]); ok {
+			low, high := h.bucketBounds(k)
+			return interpolatePercentile(float64(low), float64(high), fraction, float64(h.Low), float64(h.High))
+		}
+	}
+
+	return float64(h.High)
+}
+
+func (h *HDRHist) GetIntBuckets() map[int64]int64 {
+	ret := make(map[int64]int64)
+	for k, count := range h.Counts {
+		ret[h.bucketValue(k)] += count
+	}
+
+	return ret
+}
+
+func (h *HDRHist) GetStrBuckets() map[string]int64 {
+	ret := make(map[string]int64)
+	for k, count := range h.Counts {
+		ret[strconv.FormatInt(h.bucketValue(k), 10)] += count
+	}
+
+	return ret
+}
+
+func (h *HDRHist) NewHist() Histogram {
+	return newHDRHist(h.table, h.info, h.Digits)
+}
+
+func (h *HDRHist) Combine(oh interface{}) {
+	next_hist := oh.(*HDRHist)
+	if next_hist.Count == 0 {
+		return
+	}
+
+	if h.Count == 0 || next_hist.Low < h.Low {
+		h.Low = next_hist.Low
+	}
+	if h.Count == 0 || next_hist.High > h.High {
+		h.High = next_hist.High
+	}
+
+	// merge the running means and variances
+	total := h.Count + next_hist.Count
+	delta := next_hist.Avg - h.Avg
+	h.M2 = h.M2 + next_hist.M2 + delta*delta*float64(h.Count)*float64(next_hist.Count)/float64(total)
+	h.Avg = h.Avg + delta*float64(next_hist.Count)/float64(total)
+
+	if h.Counts == nil {
+		h.Counts = make(map[int64]int64)
+	}
+
+	for k, count := range next_hist.Counts {
+		if next_hist.Digits == h.Digits {
+			h.Counts[k] += count
+			continue
+		}
+
+		// hists with different precisions can only be merged by value
+		h.addToBucket(next_hist.bucketValue(k), count)
+	}
+
+	h.Count = total
+}
+
+type int64Slice []int64
+
+func (s int64Slice) Len() int           { return len(s) }
+func (s int64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// }}} HDR HIST
@@ -0,0 +1,236 @@
+package sybil
+
+import "bytes"
+import "encoding/gob"
+import "math"
+import "strconv"
+import "testing"
+
+func TestHDRHistPrecision(t *testing.T) {
+	for _, digits := range []int{1, 3, 5} {
+		h := newHDRHist(nil, nil, digits)
+		precision := math.Pow10(-digits)
+
+		values := []int64{0, 1, 7, 999, 123456, 98765432, 1 << 50, -5, -123456}
+		for _, v := range values {
+			h.AddWeightedValue(v, 1)
+		}
+
+		// every value comes back from its bucket to within the precision
+		for _, v := range values {
+			got := float64(h.bucketValue(h.bucketKey(v)))
+			if math.Abs(got-float64(v)) > math.Abs(float64(v))*precision {
+				t.Error("HDR HIST WITH", digits, "DIGITS BUCKETED", v, "AS", got)
+			}
+		}
+
+		// small values get their own buckets
+		for v := int64(-10); v <= 10; v++ {
+			if h.bucketValue(h.bucketKey(v)) != v {
+				t.Error("HDR HIST WITH", digits, "DIGITS LOST SMALL VALUE", v)
+			}
+		}
+
+		if h.Min() != -123456 || h.Max() != 1<<50 || h.TotalCount() != int64(len(values)) {
+			t.Error("HDR HIST STATS ARE WRONG", h.Min(), h.Max(), h.TotalCount())
+		}
+	}
+}
+
+func TestHDRHistPercentiles(t *testing.T) {
+	h := newHDRHist(nil, nil, 3)
+	for i := int64(1); i <= 100000; i++ {
+		h.AddWeightedValue(i, 1)
+	}
+
+	percentiles := h.GetPercentiles()
+	for p := 1; p < 100; p++ {
+		expected := float64(p * 1000)
+		if math.Abs(float64(percentiles[p])-expected) > expected*0.002 {
+			t.Error("HDR HIST PERCENTILE", p, "IS", percentiles[p], "EXPECTED", expected)
+		}
+	}
+
+	if percentiles[0] != 1 || math.Abs(h.Mean()-50000.5) > 0.001 {
+		t.Error("HDR HIST MIN OR MEAN IS WRONG", percentiles[0], h.Mean())
+	}
+}
+
+func TestHDRHistCombine(t *testing.T) {
+	all := newHDRHist(nil, nil, 3)
+	left := newHDRHist(nil, nil, 3)
+	right := newHDRHist(nil, nil, 3)
+	for i := int64(0); i < 5000; i++ {
+		v := i*i - 1000
+		all.AddWeightedValue(v, 2)
+		if i%3 == 0 {
+			left.AddWeightedValue(v, 2)
+		} else {
+			right.AddWeightedValue(v, 2)
+		}
+	}
+
+	// merging across "nodes" goes through fullMergeHist, which doesn't need
+	// a merge table for hdr hists
+	merged := fullMergeHist(left, right).(*HDRHist)
+
+	if merged.TotalCount() != all.TotalCount() || merged.Min() != all.Min() || merged.Max() != all.Max() {
+		t.Error("MERGED HDR HIST STATS ARE WRONG", merged.TotalCount(), merged.Min(), merged.Max())
+	}
+
+	if math.Abs(merged.Mean()-all.Mean()) > 0.001 || math.Abs(merged.StdDev()-all.StdDev()) > 0.001 {
+		t.Error("MERGED HDR HIST MEAN OR STDDEV IS WRONG", merged.Mean(), merged.StdDev())
+	}
+
+	merged_p := merged.GetPercentiles()
+	all_p := all.GetPercentiles()
+	for p := range all_p {
+		if merged_p[p] != all_p[p] {
+			t.Error("MERGED HDR HIST PERCENTILE", p, "IS", merged_p[p], "EXPECTED", all_p[p])
+		}
+	}
+}
+
+func TestHDRHistEncoding(t *testing.T) {
+	h := newHDRHist(nil, nil, 2)
+	for i := int64(-500); i < 500000; i += 37 {
+		h.AddWeightedValue(i, 1)
+	}
+
+	// hists are encoded behind the Histogram interface in cached and node
+	// results
+	hists := map[string]Histogram{"latency": h}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(hists); err != nil {
+		t.Fatal("COULDNT ENCODE HDR HIST", err)
+	}
+
+	var decoded map[string]Histogram
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		t.Fatal("COULDNT DECODE HDR HIST", err)
+	}
+
+	dh, ok := decoded["latency"].(*HDRHist)
+	if !ok || dh.Digits != 2 || dh.TotalCount() != h.TotalCount() {
+		t.Fatal("HDR HIST CHANGED WHEN DECODED", decoded["latency"])
+	}
+
+	dh_p := dh.GetPercentiles()
+	for p, v := range h.GetPercentiles() {
+		if dh_p[p] != v {
+			t.Error("DECODED HDR HIST PERCENTILE", p, "IS", dh_p[p], "EXPECTED", v)
+		}
+	}
+}
+
+func TestMergeableHists(t *testing.T) {
+	FLAGS.OP = HIST_STR
+	FLAGS.ENCODE_RESULTS = true
+	defer func() { FLAGS.OP = ""; FLAGS.ENCODE_RESULTS = false }()
+
+	// the nodes see very different extents of the column, so their basic
+	// hists would have to be re-bucketed to merge
+	node_values := []func(int) int64{
+		func(index int) int64 { return int64(index) },
+		func(index int) int64 { return 1000000 + int64(index)*37 },
+	}
+
+	blockCount := 2
+	node_specs := make(map[string]*QuerySpec)
+	for i, values := range node_values {
+		tableName := getTestTableName(t) + strconv.Itoa(i)
+		deleteTestDb(tableName)
+		defer deleteTestDb(tableName)
+
+		addRecords(tableName, func(r *Record, index int) {
+			r.AddIntField("latency", values(index))
+		}, blockCount)
+		nt := saveAndReloadTable(t, tableName, blockCount)
+
+		querySpec := newQuerySpec()
+		querySpec.Aggregations = append(querySpec.Aggregations, nt.Aggregation("latency", HIST_STR))
+		nt.MatchAndAggregate(querySpec)
+
+		if querySpec.Aggregations[0].HistType != "hdr" {
+			t.Error("ENCODED RESULTS SHOULD USE HDR HISTS, NOT", querySpec.Aggregations[0].HistType)
+		}
+
+		node_specs[tableName] = querySpec
+	}
+
+	// the same records on a single node, queried with -hdr
+	FLAGS.ENCODE_RESULTS = false
+	FLAGS.HDR_HIST = true
+	defer func() { FLAGS.HDR_HIST = false }()
+
+	tableName := getTestTableName(t) + "all"
+	deleteTestDb(tableName)
+	defer deleteTestDb(tableName)
+
+	addRecords(tableName, func(r *Record, index int) {
+		r.AddIntField("latency", node_values[index%2](index/2))
+	}, 2*blockCount)
+	nt := saveAndReloadTable(t, tableName, 2*blockCount)
+
+	single := newQuerySpec()
+	single.Aggregations = append(single.Aggregations, nt.Aggregation("latency", HIST_STR))
+	nt.MatchAndAggregate(single)
+	FLAGS.HDR_HIST = false
+
+	old_merge_table := OPTS.MERGE_TABLE
+	OPTS.MERGE_TABLE = &Table{}
+	defer func() { OPTS.MERGE_TABLE = old_merge_table }()
+
+	aggregated := newQuerySpec()
+	aggregated.Aggregations = single.Aggregations
+	aggregated = CombineResults(aggregated, node_specs)
+
+	if len(aggregated.Results) != 1 || len(single.Results) != 1 {
+		t.Fatal("EXPECTED A SINGLE RESULT", len(aggregated.Results), len(single.Results))
+	}
+
+	for k, r := range single.Results {
+		expected := r.Hists["latency"]
+		merged, ok := aggregated.Results[k].Hists["latency"].(*HDRHist)
+		if !ok || merged.TotalCount() != expected.TotalCount() {
+			t.Fatal("AGGREGATED HIST IS WRONG", aggregated.Results[k].Hists["latency"])
+		}
+
+		for _, p := range []float64{0, 1, 25, 50, 75, 90, 99, 99.9, 100} {
+			if merged.GetPercentile(p) != expected.GetPercentile(p) {
+				t.Error("AGGREGATED PERCENTILE", p, "IS", merged.GetPercentile(p), "EXPECTED", expected.GetPercentile(p))
+			}
+		}
+	}
+
+	// -mergeable-hists=false keeps the basic hists
+	FLAGS.ENCODE_RESULTS = true
+	FLAGS.MERGEABLE_HISTS = false
+	defer func() { FLAGS.MERGEABLE_HISTS = true }()
+
+	if _, ok := nt.NewHist(&IntInfo{Min: 0, Max: 100}).(*HDRHist); ok {
+		t.Error("-mergeable-hists=false SHOULDNT USE HDR HISTS")
+	}
+}
+
+func TestAggregationHistType(t *testing.T) {
+	FLAGS.ENCODE_RESULTS = true
+	defer func() { FLAGS.OP = ""; FLAGS.ENCODE_RESULTS = false }()
+
+	nt := GetTable(getTestTableName(t))
+	defer UnloadTable(nt.Name)
+	info := &IntInfo{Min: 0, Max: 100}
+	nt.IntInfo[nt.get_key_id("latency")] = info
+
+	// the aggregation reports the kind of hist the query builds, -op avg
+	// queries keep basic hists even when their results are encoded
+	for op, expected := range map[string]string{"avg": "basic", HIST_STR: "hdr"} {
+		FLAGS.OP = op
+		agg := nt.Aggregation("latency", "p95")
+		_, is_hdr := nt.NewHist(info).(*HDRHist)
+		if agg.HistType != expected || is_hdr != (expected == "hdr") {
+			t.Error("-op", op, "AGGREGATION SAYS", agg.HistType, "FOR AN HDR HIST", is_hdr)
+		}
+	}
+}
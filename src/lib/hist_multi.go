@@ -130,6 +130,30 @@ func (h *MultiHist) GetPercentiles() []int64 {
 	return percentiles[:100]
 }
 
+// GetPercentile finds the subhist that holds the pth percentile and asks it
+// for the percentile within its own values
+func (h *MultiHist) GetPercentile(p float64) float64 {
+	total := int64(0)
+	for _, sh := range h.Subhists {
+		total += sh.Count
+	}
+
+	if total == 0 {
+		return h.Avg
+	}
+
+	// the last subhist holds the lowest values
+	walk := newPercentileWalk(p, total)
+	for i := len(h.Subhists) - 1; i >= 0; i-- {
+		sh := h.Subhists[i]
+		if fraction, ok := walk.next(sh.Count); ok {
+			return sh.GetPercentile(100 * fraction)
+		}
+	}
+
+	return float64(h.Max)
+}
+
 func (h *MultiHist) GetMeanVariance() float64 {
 	return h.GetVariance() / float64(h.Count)
 }
@@ -104,6 +104,10 @@ func (th *TDigestHist) GetPercentiles() []int64 {
 	return ret
 }
 
+func (th *TDigestHist) GetPercentile(p float64) float64 {
+	return th.TDigest.Quantile(p / 100.0)
+}
+
 func (th *TDigestHist) GetStrBuckets() map[string]int64 {
 	ret := make(map[string]int64)
 	// TODO: implement this!
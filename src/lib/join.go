@@ -0,0 +1,261 @@
+package sybil
+
+import "fmt"
+import "sort"
+import "strconv"
+import "strings"
+
+// {{{ LOOKUP JOINS
+// -join-table users -join-key user_id looks up the record of a small
+// dimension table with the same user_id for every record a query reads, so
+// the query can group by, filter on and sample its columns as users.<col>:
+//
+//   sybil query -table events -join-table users -join-key user_id -group users.country -where "users.plan = 'pro'"
+//
+// the dimension table is loaded into memory (keyed by join_lookup) before the
+// query runs and each record is joined as FilterAndAggRecords reaches it, so
+// the wide table is never written out. -join-key user_id:id joins on columns
+// with different names. Keys are compared as strings, so int keys join str
+// keys. Records without a match have no value for the joined columns.
+//
+// joined queries skip the per block query cache, the dimension table can
+// change without the queried blocks changing.
+
+// lookupJoin is the dimension table a table is joined to
+type lookupJoin struct {
+	dim     *Table
+	key     string // the key column of the joined table
+	dim_key string // the key column of the dimension table
+
+	// the join key and the joined columns in the (maybe shortened) key table
+	// of the joined table, see prepareJoin
+	key_id  int16
+	columns []joinColumn
+}
+
+type joinColumn struct {
+	id       int16 // the column in the joined table
+	dim_id   int16 // the column in the dimension table
+	col_type int8
+}
+
+// ParseJoinKey splits a -join-key into the key columns of the queried and
+// the dimension table, a single name is used for both
+func ParseJoinKey(spec string) (string, string) {
+	tokens := strings.SplitN(spec, FLAGS.FILTER_SEPARATOR, 2)
+	if len(tokens) == 1 {
+		return spec, spec
+	}
+
+	return tokens[0], tokens[1]
+}
+
+// the name a dimension table column is queried by
+func joinedColumnName(dim *Table, col string) string {
+	return dim.Name + "." + col
+}
+
+// JoinTable loads every record of the dimension table dim and adds its
+// columns to t's key table as dim.<col>. It has to be called before t's key
+// table is shortened and its records are loaded
+func (t *Table) JoinTable(dim *Table, key string, dim_key string) error {
+	if dim.Name == t.Name {
+		return fmt.Errorf("%s can't be joined to itself", t.Name)
+	}
+
+	if _, ok := t.KeyTable[key]; !ok {
+		return fmt.Errorf("%s has no column %s", t.Name, key)
+	}
+
+	dim.LoadTableInfo()
+	dim_key_id, ok := dim.KeyTable[dim_key]
+	if !ok {
+		return fmt.Errorf("%s has no column %s", dim.Name, dim_key)
+	}
+
+	// the dimension's records are held onto for the whole query
+	old_delete := DELETE_BLOCKS_AFTER_QUERY
+	DELETE_BLOCKS_AFTER_QUERY = false
+	loadSpec := dim.NewLoadSpec()
+	loadSpec.LoadAllColumns = true
+	dim.LoadRecords(&loadSpec)
+	DELETE_BLOCKS_AFTER_QUERY = old_delete
+
+	t.join_lookup = make(map[string]*Record)
+	records := make(RecordList, 0)
+	for _, block := range dim.BlockList {
+		records = append(records, block.RecordList...)
+	}
+	if dim.RowBlock != nil {
+		records = append(records, dim.RowBlock.RecordList...)
+	}
+
+	for _, r := range records {
+		if k, ok := joinKey(r, dim_key_id); ok {
+			t.join_lookup[k] = r
+		}
+	}
+	Debug("JOINED", len(t.join_lookup), "KEYS FROM", dim.Name)
+
+	t.join = &lookupJoin{dim: dim, key: key, dim_key: dim_key}
+	t.addJoinedColumns()
+	return nil
+}
+
+// adds the dimension table's columns to the key table. Reloading the table
+// info resets the key table, so this runs again after it (in sorted order,
+// so the columns keep their ids)
+func (t *Table) addJoinedColumns() {
+	if t.join == nil {
+		return
+	}
+
+	dim := t.join.dim
+	names := make([]string, 0, len(dim.KeyTable))
+	for name := range dim.KeyTable {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		dim_id := dim.KeyTable[name]
+		id := t.get_key_id(joinedColumnName(dim, name))
+		t.KeyTypes[id] = dim.KeyTypes[dim_id]
+		if info, ok := dim.IntInfo[dim_id]; ok {
+			t.IntInfo[id] = info
+		}
+		if info, ok := dim.FloatInfo[dim_id]; ok {
+			t.FloatInfo[id] = info
+		}
+	}
+}
+
+// looks up the ids of the join key and the joined columns, which change when
+// the key table is shortened
+func (t *Table) prepareJoin() {
+	if t.join == nil {
+		return
+	}
+
+	j := t.join
+	j.key_id = t.get_key_id(j.key)
+	j.columns = make([]joinColumn, 0)
+	for name, dim_id := range j.dim.KeyTable {
+		if id, ok := t.KeyTable[joinedColumnName(j.dim, name)]; ok {
+			j.columns = append(j.columns, joinColumn{id: id, dim_id: dim_id, col_type: j.dim.KeyTypes[dim_id]})
+		}
+	}
+}
+
+// joined queries can't use the query cache
+func (qs *QuerySpec) joined() bool {
+	return qs.Table != nil && qs.Table.join != nil
+}
+
+// whether a column of t comes from its dimension table
+func (t *Table) isJoinedColumn(id int16) bool {
+	if t == nil || t.join == nil {
+		return false
+	}
+
+	for _, c := range t.join.columns {
+		if c.id == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// the join key of a record as a string
+func joinKey(r *Record, id int16) (string, bool) {
+	if int(id) >= len(r.Populated) {
+		return "", false
+	}
+
+	switch r.Populated[id] {
+	case INT_VAL:
+		return strconv.FormatInt(int64(r.Ints[id]), 10), true
+	case STR_VAL:
+		return r.block.GetColumnInfo(id).get_string_for_val(int32(r.Strs[id])), true
+	}
+
+	return "", false
+}
+
+// makes room for a joined or computed column in a record. The fields of loaded records
+// are slices of one array per block (see record_slab.go), so they are copied
+// instead of appended to
+func growRecord(r *Record, id int16, col_type int8) {
+	length := int(id) + 1
+	if len(r.Populated) < length {
+		populated := make([]int8, length)
+		copy(populated, r.Populated)
+		r.Populated = populated
+	}
+
+	switch col_type {
+	case INT_VAL:
+		if len(r.Ints) < length {
+			ints := make([]IntField, length)
+			copy(ints, r.Ints)
+			r.Ints = ints
+		}
+	case FLOAT_VAL:
+		if len(r.Floats) < length {
+			floats := make([]FloatField, length)
+			copy(floats, r.Floats)
+			r.Floats = floats
+		}
+	case STR_VAL:
+		if len(r.Strs) < length {
+			strs := make([]StrField, length)
+			copy(strs, r.Strs)
+			r.Strs = strs
+		}
+	}
+}
+
+// fills in the joined columns of a record from its dimension record. String
+// values are added to the string tables of the record's block, so they group
+// and filter like the block's own columns
+func (t *Table) joinRecord(r *Record) {
+	j := t.join
+	var dim_r *Record
+	if k, ok := joinKey(r, j.key_id); ok {
+		dim_r = t.join_lookup[k]
+	}
+
+	for _, c := range j.columns {
+		growRecord(r, c.id, c.col_type)
+		r.Populated[c.id] = _NO_VAL
+		if dim_r == nil || int(c.dim_id) >= len(dim_r.Populated) || dim_r.Populated[c.dim_id] != c.col_type {
+			continue
+		}
+
+		switch c.col_type {
+		case INT_VAL:
+			r.Ints[c.id] = dim_r.Ints[c.dim_id]
+		case FLOAT_VAL:
+			r.Floats[c.id] = dim_r.Floats[c.dim_id]
+		case STR_VAL:
+			val := dim_r.block.GetColumnInfo(c.dim_id).get_string_for_val(int32(dim_r.Strs[c.dim_id]))
+			r.Strs[c.id] = StrField(r.block.GetColumnInfo(c.id).get_val_id(val))
+		case SET_VAL:
+			dim_col := dim_r.block.GetColumnInfo(c.dim_id)
+			col := r.block.GetColumnInfo(c.id)
+			set := make(SetField, len(dim_r.SetMap[c.dim_id]))
+			for i, v := range dim_r.SetMap[c.dim_id] {
+				set[i] = col.get_val_id(dim_col.get_string_for_val(v))
+			}
+			if r.SetMap == nil {
+				r.SetMap = make(map[int16]SetField)
+			}
+			r.SetMap[c.id] = set
+		}
+
+		r.Populated[c.id] = c.col_type
+	}
+}
+
+// }}} LOOKUP JOINS
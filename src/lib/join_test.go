@@ -0,0 +1,94 @@
+package sybil
+
+import "strconv"
+import "testing"
+
+func TestParseJoinKey(t *testing.T) {
+	if key, dim_key := ParseJoinKey("user_id"); key != "user_id" || dim_key != "user_id" {
+		t.Error("A SINGLE JOIN KEY SHOULD BE USED FOR BOTH TABLES", key, dim_key)
+	}
+
+	if key, dim_key := ParseJoinKey("user_id:id"); key != "user_id" || dim_key != "id" {
+		t.Error("JOIN KEY PARSED WRONG", key, dim_key)
+	}
+}
+
+func TestLookupJoin(t *testing.T) {
+	tableName := getTestTableName(t)
+	dimName := tableName + "Users"
+	deleteTestDb(tableName)
+	deleteTestDb(dimName)
+	defer deleteTestDb(tableName)
+	defer deleteTestDb(dimName)
+
+	// users 0 to 99, every fifth one is on the pro plan
+	addRecords(dimName, func(r *Record, index int) {
+		r.AddIntField("id", int64(index))
+		r.AddStrField("country", []string{"us", "de"}[index%2])
+		r.AddStrField("plan", map[bool]string{true: "pro", false: "free"}[index%5 == 0])
+		r.AddIntField("age", int64(20+index%10))
+	}, 1)
+	GetTable(dimName).SaveRecordsToColumns()
+	unloadTestTable(dimName)
+
+	// events of users 0 to 119, the last 20 aren't in the users table
+	blockCount := 3
+	addRecords(tableName, func(r *Record, index int) {
+		r.AddStrField("user_id", strconv.FormatInt(int64(index%120), 10))
+		r.AddIntField("latency", 100)
+	}, blockCount)
+
+	nt := saveAndReloadTable(t, tableName, blockCount)
+	dim := GetTable(dimName)
+
+	if err := nt.JoinTable(dim, "user", "id"); err == nil {
+		t.Error("JOINING ON A MISSING COLUMN SHOULD FAIL")
+	}
+
+	if err := nt.JoinTable(dim, "user_id", "id"); err != nil {
+		t.Fatal("COULDNT JOIN", err)
+	}
+
+	expected := map[string]int64{}
+	for i := 0; i < CHUNK_SIZE*blockCount; i++ {
+		user := i % 120
+		if user >= 100 || user%5 != 0 {
+			continue
+		}
+
+		expected[[]string{"us", "de"}[user%2]]++
+	}
+
+	loadSpec := nt.NewLoadSpec()
+	querySpec := newQuerySpec()
+	querySpec.Filters = BuildFilters(nt, &loadSpec, FilterSpec{Where: dimName + ".plan = 'pro' AND " + dimName + ".age >= 20"})
+	querySpec.Groups = append(querySpec.Groups, nt.Grouping(dimName+".country"))
+	querySpec.Aggregations = append(querySpec.Aggregations, nt.Aggregation(dimName+".age", OP_AVG))
+	nt.MatchAndAggregate(querySpec)
+
+	if len(querySpec.Results) != len(expected) {
+		t.Fatal("EXPECTED A GROUP PER COUNTRY", querySpec.Results)
+	}
+
+	for country, count := range expected {
+		r, ok := querySpec.Results[country+GROUP_DELIMITER]
+		if !ok || r.Count != count {
+			t.Error("COUNTRY", country, "HAS THE WRONG COUNT", r, "EXPECTED", count)
+			continue
+		}
+
+		if avg := r.Hists[dimName+".age"].Mean(); avg != 20 && avg != 25 {
+			t.Error("COUNTRY", country, "HAS THE WRONG AVERAGE AGE", avg)
+		}
+	}
+
+	// unmatched records have no joined values, so they fall into the empty group
+	querySpec = newQuerySpec()
+	querySpec.Groups = append(querySpec.Groups, nt.Grouping(dimName+".plan"))
+	nt.MatchAndAggregate(querySpec)
+
+	unmatched := int64(CHUNK_SIZE * blockCount / 120 * 20)
+	if r, ok := querySpec.Results[GROUP_DELIMITER]; !ok || r.Count != unmatched {
+		t.Error("UNMATCHED RECORDS HAVE THE WRONG COUNT", r, "EXPECTED", unmatched)
+	}
+}
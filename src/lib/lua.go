@@ -0,0 +1,443 @@
+// +build lua
+
+package sybil
+
+import "encoding/gob"
+import "fmt"
+import "sort"
+import "strings"
+import "sync"
+
+import lua "github.com/yuin/gopher-lua"
+import "github.com/yuin/gopher-lua/parse"
+
+var ENABLE_LUA = true
+
+// {{{ LUA SCRIPTS
+// -lua script.lua runs a lua script (through gopher-lua, a pure Go
+// interpreter) over the records a query matches. The script defines some of:
+//
+//   function filter(record) return record:GetIntVal("status") >= 500 end
+//   function map(record) return record:GetStrVal("path") end
+//   function reduce(acc, value) acc = acc or {}; acc[value] = (acc[value] or 0) + 1; return acc end
+//   function combine(a, b) for k, v in pairs(b) do a[k] = (a[k] or 0) + v end; return a end
+//
+// filter runs after the query's own filters and drops the records it
+// returns false for, from the groups and aggregations too. Each record then
+// goes through map (records that map to nil are skipped) and is folded into
+// its block's accumulator by reduce, which starts out as nil. Without map,
+// reduce gets the record itself. Blocks are reduced in parallel, each in its
+// own interpreter, and their accumulators are merged with combine. A script
+// with reduce prints its combined accumulator as JSON instead of the query's
+// results.
+//
+// records have GetIntVal, GetFloatVal, GetStrVal and GetSetVal, which return
+// nil when the record has no value for the column. Records are only valid
+// during the call they are passed to. Accumulators move between blocks (and
+// nodes, with -encode-results) as plain values: numbers, strs, bools and
+// tables, whose keys become strs unless the table is a list.
+//
+// the script can read any column, so lua queries load every column of the
+// blocks they read.
+
+const LUA_RECORD_TYPE = "record"
+
+var LUA_FUNCS = []string{"filter", "map", "reduce", "combine"}
+
+func init() {
+	// the types accumulators are made of, see fromLua
+	gob.Register([]interface{}{})
+	gob.Register(map[string]interface{}{})
+}
+
+type luaScript struct {
+	proto *lua.FunctionProto
+	funcs map[string]bool // which of LUA_FUNCS the script defines
+}
+
+// scripts are compiled once and then loaded into an interpreter per block
+var lua_scripts = make(map[string]*luaScript)
+var lua_scripts_m = &sync.Mutex{}
+
+func compileLuaScript(source string) (*luaScript, error) {
+	lua_scripts_m.Lock()
+	defer lua_scripts_m.Unlock()
+
+	if script, ok := lua_scripts[source]; ok {
+		return script, nil
+	}
+
+	chunk, err := parse.Parse(strings.NewReader(source), "lua")
+	if err != nil {
+		return nil, err
+	}
+
+	proto, err := lua.Compile(chunk, "lua")
+	if err != nil {
+		return nil, err
+	}
+
+	script := &luaScript{proto: proto, funcs: make(map[string]bool)}
+	L, err := script.newState()
+	if err != nil {
+		return nil, err
+	}
+	defer L.Close()
+
+	for _, name := range LUA_FUNCS {
+		script.funcs[name] = L.GetGlobal(name).Type() == lua.LTFunction
+	}
+
+	if !script.funcs["filter"] && !script.funcs["reduce"] {
+		return nil, fmt.Errorf("the script defines neither filter nor reduce")
+	}
+	if script.funcs["reduce"] != script.funcs["combine"] {
+		return nil, fmt.Errorf("reduce and combine go together, combine merges the accumulators of different blocks")
+	}
+	if script.funcs["map"] && !script.funcs["reduce"] {
+		return nil, fmt.Errorf("map needs a reduce")
+	}
+
+	lua_scripts[source] = script
+	return script, nil
+}
+
+// LoadLuaScript compiles a -lua script and checks which functions it defines
+func LoadLuaScript(source string) error {
+	_, err := compileLuaScript(source)
+	return err
+}
+
+// scripts get the libs that can't touch the process or the disk, so no os,
+// io, package or debug
+var LUA_LIBS = []struct {
+	name string
+	fn   lua.LGFunction
+}{
+	{lua.BaseLibName, lua.OpenBase},
+	{lua.TabLibName, lua.OpenTable},
+	{lua.StringLibName, lua.OpenString},
+	{lua.MathLibName, lua.OpenMath},
+}
+
+// returns an interpreter that ran the script, so its functions are defined
+func (s *luaScript) newState() (*lua.LState, error) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range LUA_LIBS {
+		L.Push(L.NewFunction(lib.fn))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	// the base lib can read (and run) files
+	for _, name := range []string{"dofile", "loadfile"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	methods := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"GetIntVal":   luaGetIntVal,
+		"GetFloatVal": luaGetFloatVal,
+		"GetStrVal":   luaGetStrVal,
+		"GetSetVal":   luaGetSetVal,
+	})
+	L.SetField(L.NewTypeMetatable(LUA_RECORD_TYPE), "__index", methods)
+
+	L.Push(L.NewFunctionFromProto(s.proto))
+	if err := L.PCall(0, lua.MultRet, nil); err != nil {
+		L.Close()
+		return nil, err
+	}
+
+	return L, nil
+}
+
+// {{{ RECORD ACCESSORS
+// returns the record a method was called on and the column it asked for, the
+// record is nil when its table has no such column
+func luaRecordArgs(L *lua.LState) (*Record, string) {
+	ud := L.CheckUserData(1)
+	name := L.CheckString(2)
+	r, ok := ud.Value.(*Record)
+	if !ok || r == nil {
+		L.ArgError(1, "record expected")
+	}
+
+	// the accessors add unknown columns to the key table, so they are
+	// looked up first
+	t := r.block.table
+	t.string_id_m.RLock()
+	_, ok = t.KeyTable[name]
+	t.string_id_m.RUnlock()
+	if !ok {
+		return nil, name
+	}
+
+	return r, name
+}
+
+func luaGetIntVal(L *lua.LState) int {
+	if r, name := luaRecordArgs(L); r != nil {
+		if val, ok := r.GetIntVal(name); ok {
+			L.Push(lua.LNumber(val))
+			return 1
+		}
+	}
+
+	L.Push(lua.LNil)
+	return 1
+}
+
+func luaGetFloatVal(L *lua.LState) int {
+	if r, name := luaRecordArgs(L); r != nil {
+		if val, ok := r.GetFloatVal(name); ok {
+			L.Push(lua.LNumber(val))
+			return 1
+		}
+	}
+
+	L.Push(lua.LNil)
+	return 1
+}
+
+func luaGetStrVal(L *lua.LState) int {
+	if r, name := luaRecordArgs(L); r != nil {
+		if val, ok := r.GetStrVal(name); ok {
+			L.Push(lua.LString(val))
+			return 1
+		}
+	}
+
+	L.Push(lua.LNil)
+	return 1
+}
+
+func luaGetSetVal(L *lua.LState) int {
+	if r, name := luaRecordArgs(L); r != nil {
+		if vals, ok := r.GetSetVal(name); ok {
+			set := L.NewTable()
+			for _, val := range vals {
+				set.Append(lua.LString(val))
+			}
+			L.Push(set)
+			return 1
+		}
+	}
+
+	L.Push(lua.LNil)
+	return 1
+}
+
+// }}} RECORD ACCESSORS
+
+// {{{ VALUES
+// fromLua turns a lua value into a value that can be gob and JSON encoded
+func fromLua(v lua.LValue) interface{} {
+	switch val := v.(type) {
+	case lua.LBool:
+		return bool(val)
+	case lua.LNumber:
+		return float64(val)
+	case lua.LString:
+		return string(val)
+	case *lua.LTable:
+		// tables with only the keys 1 to n are lists
+		n := val.MaxN()
+		count := 0
+		val.ForEach(func(lua.LValue, lua.LValue) { count++ })
+		if n > 0 && n == count {
+			list := make([]interface{}, 0, n)
+			for i := 1; i <= n; i++ {
+				list = append(list, fromLua(val.RawGetInt(i)))
+			}
+			return list
+		}
+
+		table := make(map[string]interface{}, count)
+		val.ForEach(func(k lua.LValue, v lua.LValue) {
+			table[lua.LVAsString(k)] = fromLua(v)
+		})
+		return table
+	case *lua.LNilType:
+		return nil
+	}
+
+	// functions and records don't outlive the interpreter
+	return v.String()
+}
+
+func toLua(L *lua.LState, v interface{}) lua.LValue {
+	switch val := v.(type) {
+	case bool:
+		return lua.LBool(val)
+	case float64:
+		return lua.LNumber(val)
+	case string:
+		return lua.LString(val)
+	case []interface{}:
+		list := L.NewTable()
+		for _, item := range val {
+			list.Append(toLua(L, item))
+		}
+		return list
+	case map[string]interface{}:
+		table := L.NewTable()
+		for k, item := range val {
+			table.RawSetString(k, toLua(L, item))
+		}
+		return table
+	}
+
+	return lua.LNil
+}
+
+// }}} VALUES
+
+// calls a function of the script, errors in the script stop the query
+func luaCall(L *lua.LState, fn lua.LValue, args ...lua.LValue) lua.LValue {
+	if err := L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}, args...); err != nil {
+		Error("LUA ERROR", err)
+	}
+
+	ret := L.Get(-1)
+	L.Pop(1)
+	return ret
+}
+
+// luaBlock runs the script over the records of one block
+type luaBlock struct {
+	L      *lua.LState
+	record *lua.LUserData
+
+	filter_fn, map_fn, reduce_fn lua.LValue // nil when the script doesn't define them
+	acc                          lua.LValue
+}
+
+// returns nil if the query has no script
+func (qs *QuerySpec) newLuaBlock() *luaBlock {
+	if qs.LuaScript == "" {
+		return nil
+	}
+
+	script, err := compileLuaScript(qs.LuaScript)
+	if err != nil {
+		Error("LUA ERROR", err)
+	}
+
+	L, err := script.newState()
+	if err != nil {
+		Error("LUA ERROR", err)
+	}
+
+	lb := &luaBlock{L: L, acc: lua.LNil}
+	lb.record = L.NewUserData()
+	L.SetMetatable(lb.record, L.GetTypeMetatable(LUA_RECORD_TYPE))
+
+	fns := []*lua.LValue{&lb.filter_fn, &lb.map_fn, &lb.reduce_fn}
+	for i, name := range LUA_FUNCS[:len(fns)] {
+		if script.funcs[name] {
+			*fns[i] = L.GetGlobal(name)
+		}
+	}
+
+	return lb
+}
+
+func (lb *luaBlock) filter(r *Record) bool {
+	if lb.filter_fn == nil {
+		return true
+	}
+
+	lb.record.Value = r
+	return lua.LVAsBool(luaCall(lb.L, lb.filter_fn, lb.record))
+}
+
+func (lb *luaBlock) reduce(r *Record) {
+	if lb.reduce_fn == nil {
+		return
+	}
+
+	lb.record.Value = r
+	var val lua.LValue = lb.record
+	if lb.map_fn != nil {
+		val = luaCall(lb.L, lb.map_fn, lb.record)
+		if val == lua.LNil {
+			return
+		}
+	}
+
+	lb.acc = luaCall(lb.L, lb.reduce_fn, lb.acc, val)
+}
+
+// saves the block's accumulator into its results
+func (lb *luaBlock) finish(qs *QuerySpec) {
+	if lb.reduce_fn != nil {
+		qs.LuaResult = fromLua(lb.acc)
+	}
+
+	lb.L.Close()
+}
+
+// combines the accumulators of several blocks (or tables or nodes)
+func (qs *QuerySpec) combineLua(block_specs map[string]*QuerySpec) {
+	if qs.LuaScript == "" {
+		return
+	}
+
+	script, err := compileLuaScript(qs.LuaScript)
+	if err != nil {
+		Error("LUA ERROR", err)
+	}
+	if !script.funcs["reduce"] {
+		return
+	}
+
+	L, err := script.newState()
+	if err != nil {
+		Error("LUA ERROR", err)
+	}
+	defer L.Close()
+
+	combine := L.GetGlobal("combine")
+
+	// in a stable order, so the same blocks always combine the same way
+	names := make([]string, 0, len(block_specs))
+	for name := range block_specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var acc lua.LValue = lua.LNil
+	for _, name := range names {
+		result := block_specs[name].LuaResult
+		if result == nil {
+			continue
+		}
+
+		if acc == lua.LNil {
+			acc = toLua(L, result)
+		} else {
+			acc = luaCall(L, combine, acc, toLua(L, result))
+		}
+	}
+
+	qs.LuaResult = fromLua(acc)
+}
+
+// prints the combined accumulator of a script with a reduce, it returns
+// false if there's none to print
+func (qs *QuerySpec) printLuaResult() bool {
+	if qs.LuaScript == "" {
+		return false
+	}
+
+	script, err := compileLuaScript(qs.LuaScript)
+	if err != nil || !script.funcs["reduce"] {
+		return false
+	}
+
+	printJson(qs.LuaResult)
+	fmt.Fprintln(OUTPUT)
+	return true
+}
+
+// }}} LUA SCRIPTS
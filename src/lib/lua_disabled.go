@@ -0,0 +1,35 @@
+// +build !lua
+
+package sybil
+
+import "fmt"
+
+var ENABLE_LUA = false
+
+// without the lua build tag queries can't have a -lua script, see lua.go
+type luaBlock struct{}
+
+func LoadLuaScript(source string) error {
+	return fmt.Errorf("sybil was built without lua, build it with -tags lua")
+}
+
+func (qs *QuerySpec) newLuaBlock() *luaBlock {
+	return nil
+}
+
+func (lb *luaBlock) filter(r *Record) bool {
+	return true
+}
+
+func (lb *luaBlock) reduce(r *Record) {
+}
+
+func (lb *luaBlock) finish(qs *QuerySpec) {
+}
+
+func (qs *QuerySpec) combineLua(block_specs map[string]*QuerySpec) {
+}
+
+func (qs *QuerySpec) printLuaResult() bool {
+	return false
+}
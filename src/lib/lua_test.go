@@ -0,0 +1,82 @@
+// +build lua
+
+package sybil
+
+import "strconv"
+import "testing"
+
+func TestLoadLuaScript(t *testing.T) {
+	if err := LoadLuaScript("function filter(record) return true end"); err != nil {
+		t.Error("COULDNT LOAD A FILTER", err)
+	}
+
+	if err := LoadLuaScript("x = string.upper(table.concat({'a'})) .. math.floor(1.5) function filter(record) return true end"); err != nil {
+		t.Error("SCRIPTS SHOULD HAVE THE STRING, TABLE AND MATH LIBS", err)
+	}
+
+	bad := []string{
+		"function filter(record) return true",
+		"x = 1",
+		"function reduce(acc, record) return acc end",
+		"function map(record) return 1 end function filter(record) return true end",
+		"error('boom')",
+		// scripts can't reach the process or the disk
+		"os.execute('true') function filter(record) return true end",
+		"io.open('/tmp/x', 'w') function filter(record) return true end",
+		"dofile('/etc/passwd') function filter(record) return true end",
+		"require('os') function filter(record) return true end",
+	}
+	for _, source := range bad {
+		if err := LoadLuaScript(source); err == nil {
+			t.Error("EXPECTED", source, "TO FAIL")
+		}
+	}
+}
+
+func TestLuaScript(t *testing.T) {
+	tableName := getTestTableName(t)
+	deleteTestDb(tableName)
+	defer deleteTestDb(tableName)
+
+	blockCount := 3
+	addRecords(tableName, func(r *Record, index int) {
+		r.AddIntField("status", int64(200+100*(index%4)))
+		r.AddStrField("path", "/p"+strconv.Itoa(index%3))
+	}, blockCount)
+
+	nt := saveAndReloadTable(t, tableName, blockCount)
+
+	querySpec := newQuerySpec()
+	querySpec.Groups = append(querySpec.Groups, nt.Grouping("path"))
+	querySpec.LuaScript = `
+function filter(record) return record:GetIntVal("status") >= 400 and record:GetIntVal("missing") == nil end
+function map(record) return record:GetStrVal("path") end
+function reduce(acc, path) acc = acc or {}; acc[path] = (acc[path] or 0) + 1; return acc end
+function combine(a, b) for k, v in pairs(b) do a[k] = (a[k] or 0) + v end; return a end
+`
+	nt.MatchAndAggregate(querySpec)
+
+	expected := map[string]float64{}
+	for i := 0; i < CHUNK_SIZE*blockCount; i++ {
+		if 200+100*(i%4) >= 400 {
+			expected["/p"+strconv.Itoa(i%3)]++
+		}
+	}
+
+	counts, ok := querySpec.LuaResult.(map[string]interface{})
+	if !ok || len(counts) != len(expected) {
+		t.Fatal("LUA REDUCED THE WRONG RESULT", querySpec.LuaResult)
+	}
+
+	for path, count := range expected {
+		if counts[path] != count {
+			t.Error("PATH", path, "HAS THE WRONG COUNT", counts[path], "EXPECTED", count)
+		}
+
+		// the script's filter applies to the query's groups too
+		r := querySpec.Results[path+GROUP_DELIMITER]
+		if r == nil || float64(r.Count) != count {
+			t.Error("LUA FILTER MATCHED THE WRONG RECORDS FOR", path, r)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package sybil
+
+import "fmt"
+import "path"
+import "strconv"
+import "strings"
+
+// {{{ MULTI TABLE QUERIES
+// -table takes a list of tables and globs, ex: -table "logs_*,errors". The
+// query runs over each table on its own and their results are combined like
+// the results of several nodes (see node_aggregator.go), so the tables need
+// the columns the query reads, with the same types. -group $table groups by
+// the table each record came from:
+//
+//   sybil query -table "logs_*" -group '$table,host' -int latency
+//
+// filters that end with a table name (ex: -int-filter latency:gt:100:logs_a)
+// only apply to that table. -having is applied once the tables' results are
+// combined.
+
+const TABLE_GROUP = "$table"
+
+// ExpandTables turns a -table list into the names of the tables to query,
+// globs are matched against the tables in FLAGS.DIR
+func ExpandTables(spec string) ([]string, error) {
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	for _, token := range strings.Split(spec, FLAGS.FIELD_SEPARATOR) {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+
+		if !strings.ContainsAny(token, "*?[") {
+			add(token)
+			continue
+		}
+
+		matched := false
+		for _, name := range ListTables() {
+			ok, err := path.Match(token, name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid table glob %q: %s", token, err)
+			}
+
+			if ok {
+				add(name)
+				matched = true
+			}
+		}
+
+		if !matched {
+			return nil, fmt.Errorf("no tables in %s match %s", FLAGS.DIR, token)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no tables to query")
+	}
+
+	return names, nil
+}
+
+func colTypeName(col_type int8) string {
+	switch col_type {
+	case INT_VAL:
+		return "int"
+	case FLOAT_VAL:
+		return "float"
+	case STR_VAL:
+		return "str"
+	case SET_VAL:
+		return "set"
+	}
+
+	return "missing"
+}
+
+// CheckTableColumns makes sure each table has the columns a query reads and
+// that they have the same type in all of them
+func CheckTableColumns(tables []*Table, cols []string) error {
+	for _, col := range cols {
+		var first *Table
+		col_type := int8(_NO_VAL)
+		for _, t := range tables {
+			id, ok := t.KeyTable[col]
+			if !ok || t.KeyTypes[id] == _NO_VAL {
+				return fmt.Errorf("%s has no column %s", t.Name, col)
+			}
+
+			if first == nil {
+				first, col_type = t, t.KeyTypes[id]
+			} else if t.KeyTypes[id] != col_type {
+				return fmt.Errorf("%s is a %s column in %s but a %s column in %s",
+					col, colTypeName(col_type), first.Name, colTypeName(t.KeyTypes[id]), t.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// AddTableGroup adds the table's name to the group key of each result, as
+// the index'th group
+func (qs *QuerySpec) AddTableGroup(table string, index int) {
+	num_groups := len(qs.Groups)
+	if index > num_groups {
+		index = num_groups
+	}
+
+	rekey := func(results ResultMap) ResultMap {
+		rekeyed := make(ResultMap, len(results))
+		for _, r := range results {
+			r.GroupByKey = tableGroupKey(r.GroupByKey, num_groups, index, table)
+			rekeyed[r.GroupByKey] = r
+		}
+
+		return rekeyed
+	}
+
+	qs.Results = rekey(qs.Results)
+	for bucket, results := range qs.TimeResults {
+		qs.TimeResults[bucket] = rekey(results)
+	}
+
+	groups := make([]Grouping, 0, num_groups+1)
+	groups = append(groups, qs.Groups[:index]...)
+	groups = append(groups, Grouping{Name: TABLE_GROUP})
+	qs.Groups = append(groups, qs.Groups[index:]...)
+
+	if qs.Dropped != nil {
+		qs.Dropped.GroupByKey = qs.fixedGroupByKey(DROPPED_GROUP)
+	}
+	if qs.Cumulative != nil {
+		qs.Cumulative.GroupByKey = "TOTAL" + strings.Repeat(GROUP_DELIMITER, len(qs.Groups)-1)
+	}
+}
+
+func tableGroupKey(key string, num_groups int, index int, table string) string {
+	if num_groups == 0 {
+		return table + GROUP_DELIMITER
+	}
+
+	parts := strings.Split(key, GROUP_DELIMITER)
+	if len(parts) > num_groups {
+		parts = parts[:num_groups]
+	}
+
+	grouped := make([]string, 0, len(parts)+1)
+	grouped = append(grouped, parts[:index]...)
+	grouped = append(grouped, table)
+	grouped = append(grouped, parts[index:]...)
+	return strings.Join(grouped, GROUP_DELIMITER) + GROUP_DELIMITER
+}
+
+// CombineTableResults combines the results of the same query over several
+// tables. The combined results still have to be finished, see FinishResults
+func CombineTableResults(specs []*QuerySpec) *QuerySpec {
+	table_specs := make(map[string]*QuerySpec)
+	for i, spec := range specs {
+		table_specs[strconv.Itoa(i)] = spec
+	}
+
+	// the tables' columns have different extents, so their percentiles are
+	// re-bucketed like the results of different nodes. Averages combine
+	// without it
+	if FLAGS.OP == HIST_STR {
+		merge_table := Table{Name: TABLE_GROUP}
+		merge_table.init_data_structures()
+
+		old_merge_table := OPTS.MERGE_TABLE
+		OPTS.MERGE_TABLE = &merge_table
+		defer func() { OPTS.MERGE_TABLE = old_merge_table }()
+	}
+
+	return CombineResults(specs[0], table_specs)
+}
+
+// MergeTableInfo returns a table with the blocks and columns of several
+// tables, for printing their -info
+func MergeTableInfo(tables []*Table) *Table {
+	names := make([]string, 0, len(tables))
+	for _, t := range tables {
+		names = append(names, t.Name)
+	}
+
+	merged := Table{Name: strings.Join(names, FLAGS.FIELD_SEPARATOR)}
+	merged.init_data_structures()
+
+	for _, t := range tables {
+		for name, block := range t.BlockList {
+			merged.BlockList[path.Join(t.Name, name)] = block
+		}
+
+		key_table, key_types := t.KeyTable, t.KeyTypes
+		if t.AllKeyInfo != nil {
+			key_table, key_types = t.AllKeyInfo.KeyTable, t.AllKeyInfo.KeyTypes
+		}
+
+		for name, id := range key_table {
+			merged.set_key_type(merged.get_key_id(name), key_types[id])
+		}
+	}
+
+	return &merged
+}
+
+// PrintTablesSamples prints the first -limit records the query matched in
+// several tables, with the table each one came from as $table
+func PrintTablesSamples(tables []*Table) {
+	records := make(RecordList, 0)
+	for _, t := range tables {
+		records = append(records, t.matchedRecords(FLAGS.LIMIT-len(records))...)
+	}
+
+	printSamples(records, true)
+}
+
+// }}} MULTI TABLE QUERIES
@@ -0,0 +1,105 @@
+package sybil
+
+import "math"
+import "strconv"
+import "testing"
+
+func TestMultiTableQuery(t *testing.T) {
+	tableName := getTestTableName(t)
+	names := []string{tableName + "A", tableName + "B", tableName + "C"}
+	for _, name := range names {
+		deleteTestDb(name)
+		defer deleteTestDb(name)
+	}
+
+	// the tables have the same columns, with latencies 100 apart. The third
+	// one's host column is an int
+	blockCount := 2
+	tables := make([]*Table, 0)
+	for i, name := range names {
+		offset := int64(i * 100)
+		addRecords(name, func(r *Record, index int) {
+			if i == 2 {
+				r.AddIntField("host", int64(index%2))
+			} else {
+				r.AddStrField("host", "h"+strconv.Itoa(index%2))
+			}
+			r.AddIntField("latency", offset+int64(index%10))
+		}, blockCount)
+
+		tables = append(tables, saveAndReloadTable(t, name, blockCount))
+	}
+
+	expanded, err := ExpandTables(tableName + "[AB]," + tableName + "A")
+	if err != nil || len(expanded) != 2 || expanded[0] != names[0] || expanded[1] != names[1] {
+		t.Error("TABLE GLOB EXPANDED WRONG", expanded, err)
+	}
+
+	if _, err := ExpandTables(tableName + "Z*"); err == nil {
+		t.Error("A GLOB THAT MATCHES NOTHING SHOULD FAIL")
+	}
+
+	if err := CheckTableColumns(tables[:2], []string{"host", "latency"}); err != nil {
+		t.Error("TABLES WITH THE SAME COLUMNS SHOULD BE COMPATIBLE", err)
+	}
+
+	if err := CheckTableColumns(tables, []string{"host"}); err == nil {
+		t.Error("HOST IS AN INT IN ONE TABLE, THE TABLES SHOULDNT BE COMPATIBLE")
+	}
+
+	if err := CheckTableColumns(tables[:2], []string{"missing"}); err == nil {
+		t.Error("A MISSING COLUMN SHOULDNT BE COMPATIBLE")
+	}
+
+	query := func(nt *Table) *QuerySpec {
+		querySpec := newQuerySpec()
+		querySpec.Groups = append(querySpec.Groups, nt.Grouping("host"))
+		querySpec.Aggregations = append(querySpec.Aggregations, nt.Aggregation("latency", OP_AVG))
+		querySpec.OrderBy = SORT_COUNT
+		nt.MatchAndAggregate(querySpec)
+		return querySpec
+	}
+
+	// the hosts' results are combined across tables
+	combined := CombineTableResults([]*QuerySpec{query(tables[0]), query(tables[1])})
+	combined.FinishResults()
+
+	total := int64(CHUNK_SIZE * blockCount * 2)
+	if combined.Cumulative.Count != total || len(combined.Results) != 2 {
+		t.Fatal("COMBINED THE WRONG RESULTS", combined.Cumulative.Count, combined.Results)
+	}
+
+	for _, host := range []string{"h0", "h1"} {
+		r := combined.Results[host+GROUP_DELIMITER]
+		if r == nil || r.Count != total/2 {
+			t.Error("HOST", host, "HAS THE WRONG COUNT", r)
+		}
+	}
+
+	// $table groups each table's results by the table's name
+	specs := make([]*QuerySpec, 0)
+	for _, nt := range tables[:2] {
+		querySpec := query(nt)
+		querySpec.AddTableGroup(nt.Name, 0)
+		specs = append(specs, querySpec)
+	}
+
+	combined = CombineTableResults(specs)
+	combined.FinishResults()
+
+	if len(combined.Groups) != 2 || combined.Groups[0].Name != TABLE_GROUP || len(combined.Results) != 4 {
+		t.Fatal("EXPECTED A RESULT PER TABLE AND HOST", combined.Groups, combined.Results)
+	}
+
+	for i, nt := range tables[:2] {
+		r := combined.Results[nt.Name+GROUP_DELIMITER+"h1"+GROUP_DELIMITER]
+		if r == nil || r.Count != total/4 {
+			t.Fatal("TABLE", nt.Name, "HAS THE WRONG COUNT", r)
+		}
+
+		// h1 has the odd latencies
+		if avg := r.Hists["latency"].Mean(); math.Abs(avg-float64(i*100+5)) > 0.001 {
+			t.Error("TABLE", nt.Name, "HAS THE WRONG AVERAGE", avg)
+		}
+	}
+}
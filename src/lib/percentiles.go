@@ -0,0 +1,94 @@
+package sybil
+
+import "fmt"
+import "strconv"
+import "strings"
+
+// {{{ PERCENTILES
+// -percentiles 50,90,99,99.9 picks which percentiles hist queries print,
+// instead of the fixed p0, p25, p50, p75 and p99. Every histogram answers
+// GetPercentile(p) by walking its buckets until it has seen p percent of its
+// count and interpolating within the bucket it stops in, so fractional
+// percentiles get their own value. Percentile ops take fractions too, ex:
+//
+//   -agg p99.9(latency) -sort "p99.9(latency)"
+
+// parses a percentile like 99 or 99.9
+func parsePercentile(s string) (float64, error) {
+	if s == "" || strings.Trim(s, "0123456789.") != "" || strings.Count(s, ".") > 1 {
+		return 0, fmt.Errorf("invalid percentile %q", s)
+	}
+
+	p, err := strconv.ParseFloat(s, 64)
+	if err != nil || p < 0 || p > 100 {
+		return 0, fmt.Errorf("percentile %q must be between 0 and 100", s)
+	}
+
+	return p, nil
+}
+
+// ParsePercentiles parses a comma separated list of percentiles
+func ParsePercentiles(spec string) ([]float64, error) {
+	percentiles := make([]float64, 0)
+	if strings.TrimSpace(spec) == "" {
+		return percentiles, nil
+	}
+
+	for _, token := range strings.Split(spec, ",") {
+		p, err := parsePercentile(strings.TrimSpace(token))
+		if err != nil {
+			return nil, err
+		}
+
+		percentiles = append(percentiles, p)
+	}
+
+	return percentiles, nil
+}
+
+func formatPercentile(p float64) string {
+	return strconv.FormatFloat(p, 'f', -1, 64)
+}
+
+// percentileWalk is fed the counts of a histogram's buckets in value order
+// and stops at the bucket that holds the pth percentile of the total
+type percentileWalk struct {
+	target float64
+	seen   float64
+	done   bool
+}
+
+func newPercentileWalk(p float64, total int64) *percentileWalk {
+	return &percentileWalk{target: p / 100 * float64(total)}
+}
+
+// returns whether the percentile is in a bucket holding count values, and
+// how far into the bucket it is
+func (w *percentileWalk) next(count int64) (float64, bool) {
+	if w.done || count <= 0 {
+		return 0, false
+	}
+
+	if w.seen+float64(count) < w.target {
+		w.seen += float64(count)
+		return 0, false
+	}
+
+	w.done = true
+	return (w.target - w.seen) / float64(count), true
+}
+
+// the value fraction of the way from low to high, kept inside min and max
+func interpolatePercentile(low, high, fraction, min, max float64) float64 {
+	val := low + (high-low)*fraction
+	if val < min {
+		return min
+	}
+	if val > max {
+		return max
+	}
+
+	return val
+}
+
+// }}} PERCENTILES
@@ -0,0 +1,88 @@
+package sybil
+
+import "math"
+import "testing"
+
+func TestParsePercentiles(t *testing.T) {
+	percentiles, err := ParsePercentiles("50, 90,99,99.9,0,100")
+	if err != nil || len(percentiles) != 6 || percentiles[3] != 99.9 || percentiles[5] != 100 {
+		t.Error("PARSED PERCENTILES WRONG", percentiles, err)
+	}
+
+	for _, bad := range []string{"50,", "abc", "101", "-1", "99.9.9", "1e2"} {
+		if _, err := ParsePercentiles(bad); err == nil {
+			t.Error("EXPECTED AN ERROR FOR PERCENTILES", bad)
+		}
+	}
+
+	// fractional percentiles work as ops and sorts too
+	if aggs, err := ParseAggs("p99.9(latency)", ","); err != nil || len(aggs) != 1 {
+		t.Error("COULDNT PARSE A FRACTIONAL PERCENTILE OP", err)
+	}
+
+	if _, _, err := ParseSortBy("p99.9(latency) asc"); err != nil {
+		t.Error("COULDNT SORT BY A FRACTIONAL PERCENTILE", err)
+	}
+}
+
+// returns a hist of each kind over values between 0 and 100000
+func newTestHists() map[string]Histogram {
+	info := IntInfo{Min: 0, Max: 100000}
+	table := &Table{}
+
+	return map[string]Histogram{
+		"basic": newBasicHist(table, &info),
+		"multi": newMultiHist(table, &info),
+		"hdr":   newHDRHist(table, &info, 3),
+		"float": table.NewFloatHist(&FloatInfo{Min: 0, Max: 100000}),
+	}
+}
+
+func TestHistPercentiles(t *testing.T) {
+	FLAGS.OP = HIST_STR
+	defer func() { FLAGS.OP = "" }()
+
+	all := newTestHists()
+	left := newTestHists()
+	right := newTestHists()
+	for i := int64(0); i <= 100000; i++ {
+		for name, h := range all {
+			h.AddWeightedValue(i, 1)
+			if i%2 == 0 {
+				left[name].AddWeightedValue(i, 1)
+			} else {
+				right[name].AddWeightedValue(i, 1)
+			}
+		}
+	}
+
+	old_merge_table := OPTS.MERGE_TABLE
+	OPTS.MERGE_TABLE = &Table{}
+	defer func() { OPTS.MERGE_TABLE = old_merge_table }()
+
+	for name, h := range all {
+		// blocks are combined into a fresh hist, node results are merged
+		// with fullMergeHist
+		blocks := h.NewHist()
+		blocks.Combine(left[name])
+		blocks.Combine(right[name])
+		FLAGS.LOG_HIST = name == "multi"
+		nodes := fullMergeHist(left[name], right[name])
+		FLAGS.LOG_HIST = false
+
+		for _, p := range []float64{0, 1, 50, 90, 99, 99.9, 99.99, 100} {
+			expected := p * 1000
+
+			// the buckets are at most 100 wide
+			for kind, merged := range map[string]Histogram{"ALL": h, "BLOCKS": blocks, "NODES": nodes} {
+				if val := merged.GetPercentile(p); math.Abs(val-expected) > 100 {
+					t.Error(name, "HIST PERCENTILE", p, "OF", kind, "IS", val, "EXPECTED", expected)
+				}
+			}
+		}
+
+		if h.GetPercentile(99.9) >= h.GetPercentile(99.99) {
+			t.Error(name, "HIST DOESNT TELL FRACTIONAL PERCENTILES APART")
+		}
+	}
+}
@@ -8,14 +8,20 @@ import "encoding/json"
 import "strconv"
 import "os"
 import "fmt"
+import "io"
 import "io/ioutil"
+import "math"
 import "text/tabwriter"
 import "time"
 
+// OUTPUT is where query results get printed. It defaults to stdout, but long
+// running processes (like sybil serve) can point it at a response buffer
+var OUTPUT io.Writer = os.Stdout
+
 func printJson(data interface{}) {
 	b, err := json.Marshal(data)
 	if err == nil {
-		os.Stdout.Write(b)
+		OUTPUT.Write(b)
 	} else {
 		Error("JSON encoding error", err)
 	}
@@ -44,6 +50,14 @@ func printTimeResults(querySpec *QuerySpec) {
 	sort.Ints(keys)
 
 	Debug("RESULT COUNT", len(keys))
+	querySpec.printableDropped()
+	querySpec.transformed = querySpec.transformTimeResults()
+
+	if querySpec.Fill != "" {
+		printFilledTimeResults(querySpec, querySpec.timeGroups(sorted))
+		return
+	}
+
 	if FLAGS.JSON {
 
 		marshalled_results := make(map[string][]ResultJSON)
@@ -51,6 +65,14 @@ func printTimeResults(querySpec *QuerySpec) {
 			key := strconv.FormatInt(int64(k), 10)
 			marshalled_results[key] = make([]ResultJSON, 0)
 
+			// each bucket was already pruned to its own top results
+			if querySpec.LimitPerBucket {
+				for _, r := range querySpec.sortTimeBucket(v, querySpec.OrderBy) {
+					marshalled_results[key] = append(marshalled_results[key], r.toResultJSON(querySpec))
+				}
+				continue
+			}
+
 			for _, r := range v {
 				_, ok := is_top_result[r.GroupByKey]
 				if ok {
@@ -69,30 +91,96 @@ func printTimeResults(querySpec *QuerySpec) {
 	}
 
 	w := new(tabwriter.Writer)
-	w.Init(os.Stdout, 0, 1, 0, ' ', tabwriter.AlignRight)
+	w.Init(OUTPUT, 0, 1, 0, ' ', tabwriter.AlignRight)
 
+	loc := mustLoadTimeZone(querySpec.TimeZone)
 	for _, time_bucket := range keys {
 
-		time_str := time.Unix(int64(time_bucket), 0).Format(OPTS.TIME_FORMAT)
+		time_str := time.Unix(int64(time_bucket), 0).In(loc).Format(OPTS.TIME_FORMAT)
 		results := querySpec.TimeResults[time_bucket]
+		if querySpec.LimitPerBucket {
+			for _, r := range querySpec.sortTimeBucket(results, querySpec.OrderBy) {
+				printTimeResultRows(w, time_str, r, querySpec)
+			}
+			continue
+		}
+
 		for _, r := range results {
-			if len(querySpec.Distincts) > 0 {
-				fmt.Fprintln(w, time_str, "\t", r.Distinct.Cardinality(), "\t", r.GroupByKey, "\t")
+			printTimeResultRows(w, time_str, r, querySpec)
+		}
+	}
 
-			} else if len(r.Hists) == 0 {
-				fmt.Fprintln(w, time_str, "\t", r.Count, "\t", r.GroupByKey, "\t")
-			} else {
-				for agg, hist := range r.Hists {
-					avg_str := fmt.Sprintf("%.2f", hist.Mean())
-					fmt.Fprintln(w, time_str, "\t", r.Count, "\t", r.GroupByKey, "\t", agg, "\t", avg_str, "\t")
-				}
+	w.Flush()
+
+}
+
+func printTimeResultRows(w *tabwriter.Writer, time_str string, r *Result, querySpec *QuerySpec) {
+	if querySpec.compare != nil {
+		for _, cv := range querySpec.comparedValues(r) {
+			baseline, delta, change := cv.formatted()
+			fmt.Fprintln(w, time_str, "\t", r.Count, "\t", r.GroupByKey, "\t", cv.Label, "\t", cv.format(cv.Value), "\t", baseline, "\t", delta, "\t", change, "\t")
+		}
+		printTransformRows(w, time_str, r, querySpec)
+		return
+	}
+
+	if len(querySpec.Distincts) > 0 {
+		fmt.Fprintln(w, time_str, "\t", r.Distinct.Cardinality(), "\t", r.GroupByKey, "\t")
+		return
+	}
+
+	printed := false
+	for _, agg := range querySpec.Aggregations {
+		val, ok := r.AggValue(agg)
+		if !ok {
+			continue
+		}
+
+		if b, ok := r.Buckets[agg.Label()]; ok && agg.Op == OP_BUCKETS {
+			for i, label := range b.labels() {
+				fmt.Fprintln(w, time_str, "\t", r.Count, "\t", r.GroupByKey, "\t", agg.Label()+" "+label, "\t", b.Counts[i], "\t")
 			}
+			printed = true
+			continue
+		}
+
+		fmt.Fprintln(w, time_str, "\t", r.Count, "\t", r.GroupByKey, "\t", agg.Label(), "\t", agg.formatValue(val), "\t")
+		printed = true
+	}
 
+	if !printTransformRows(w, time_str, r, querySpec) && !printed {
+		fmt.Fprintln(w, time_str, "\t", r.Count, "\t", r.GroupByKey, "\t")
+	}
+}
+
+// prints the -transform values of a time result, returns whether it had any
+func printTransformRows(w *tabwriter.Writer, time_str string, r *Result, querySpec *QuerySpec) bool {
+	printed := false
+	for _, t := range querySpec.Transforms {
+		if val, ok := querySpec.transformed[r][t.Label()]; ok {
+			fmt.Fprintln(w, time_str, "\t", r.Count, "\t", r.GroupByKey, "\t", t.Label(), "\t", fmt.Sprintf("%.2f", val), "\t")
+			printed = true
 		}
 	}
 
-	w.Flush()
+	return printed
+}
+
+// formats the given percentiles of a hist for printing
+func formatPercentiles(h Histogram, percentiles []float64) []string {
+	_, is_float := h.(FloatHistogram)
 
+	ret := make([]string, len(percentiles))
+	for i, p := range percentiles {
+		val := h.GetPercentile(p)
+		if is_float {
+			ret[i] = fmt.Sprintf("%.2f", val)
+		} else {
+			ret[i] = formatAggValue(val)
+		}
+	}
+
+	return ret
 }
 
 func getSparseBuckets(buckets map[string]int64) map[string]int64 {
@@ -110,12 +198,22 @@ func (r *Result) toResultJSON(querySpec *QuerySpec) ResultJSON {
 
 	var res = make(ResultJSON)
 	for _, agg := range querySpec.Aggregations {
-		if FLAGS.OP == "hist" {
+		if agg.Op == OP_HIST {
 			inner := make(ResultJSON)
 			res[agg.Name] = inner
 			h := r.Hists[agg.Name]
 			if h != nil {
 				inner["percentiles"] = r.Hists[agg.Name].GetPercentiles()
+				if fh, ok := h.(FloatHistogram); ok {
+					inner["percentiles"] = fh.GetFloatPercentiles()
+				}
+				if len(querySpec.Percentiles) > 0 {
+					percentiles := make(map[string]float64)
+					for _, p := range querySpec.Percentiles {
+						percentiles[formatPercentile(p)] = h.GetPercentile(p)
+					}
+					inner["percentiles"] = percentiles
+				}
 				inner["buckets"] = getSparseBuckets(r.Hists[agg.Name].GetStrBuckets())
 				inner["stddev"] = r.Hists[agg.Name].StdDev()
 				inner["avg"] = r.Hists[agg.Name].Mean()
@@ -124,16 +222,28 @@ func (r *Result) toResultJSON(querySpec *QuerySpec) ResultJSON {
 			}
 		}
 
-		if FLAGS.OP == "avg" {
-			result, ok := r.Hists[agg.Name]
+		if b, ok := r.Buckets[agg.Label()]; ok && agg.Op == OP_BUCKETS {
+			res[agg.Label()] = b.labeledCounts()
+		} else if agg.Op != OP_HIST {
+			val, ok := r.AggValue(agg)
 			if ok {
-				res[agg.Name] = result.Mean()
+				res[agg.Label()] = val
 			} else {
-				res[agg.Name] = nil
+				res[agg.Label()] = nil
 			}
 		}
 	}
 
+	if querySpec.transformed != nil {
+		for _, t := range querySpec.Transforms {
+			res[t.Label()] = querySpec.transformedValue(r, t)
+		}
+	}
+
+	if querySpec.compare != nil {
+		res["Compare"] = querySpec.comparisonJSON(r)
+	}
+
 	var group_key = strings.Split(r.GroupByKey, GROUP_DELIMITER)
 	for i, g := range querySpec.Groups {
 		res[g.Name] = group_key[i]
@@ -142,11 +252,16 @@ func (r *Result) toResultJSON(querySpec *QuerySpec) ResultJSON {
 	if len(querySpec.Distincts) > 0 {
 		res["Distinct"] = r.Distinct.Cardinality()
 		res["Count"] = r.Distinct.Cardinality()
+		res["DistinctError"] = math.Floor(r.Distinct.StdError() + 0.5)
 	} else {
 		res["Count"] = r.Count
 		res["Samples"] = r.Samples
 	}
 
+	if querySpec.HeavyHitters > 0 {
+		res["Error"] = r.Error
+	}
+
 	return res
 
 }
@@ -165,7 +280,7 @@ func printSortedResults(querySpec *QuerySpec) {
 			results = append(results, res)
 		}
 
-		printJson(results)
+		printJson(append(results, querySpec.droppedResultJSON()...))
 		return
 	}
 
@@ -182,6 +297,8 @@ func printSortedResults(querySpec *QuerySpec) {
 	for _, v := range sorted {
 		printResult(querySpec, v)
 	}
+
+	printResult(querySpec, querySpec.printableDropped())
 }
 
 func printResult(querySpec *QuerySpec, v *Result) {
@@ -192,20 +309,31 @@ func printResult(querySpec *QuerySpec, v *Result) {
 	group_key := strings.Replace(v.GroupByKey, GROUP_DELIMITER, ",", -1)
 	group_key = strings.TrimRight(group_key, ",")
 
-	fmt.Printf(fmt.Sprintf("%-20s", group_key)[:20])
+	fmt.Fprint(OUTPUT, fmt.Sprintf("%-20s", group_key)[:20])
 
-	fmt.Printf("%.0d", v.Count)
+	fmt.Fprintf(OUTPUT, "%.0d", v.Count)
 	if OPTS.WEIGHT_COL {
-		fmt.Print(" (")
-		fmt.Print(v.Samples)
-		fmt.Print(")")
+		fmt.Fprint(OUTPUT, " (")
+		fmt.Fprint(OUTPUT, v.Samples)
+		fmt.Fprint(OUTPUT, ")")
 	}
 
 	if len(querySpec.Distincts) > 0 {
-		fmt.Print(" Distinct: ", v.Distinct.Cardinality())
+		fmt.Fprint(OUTPUT, " Distinct: ", v.Distinct.Cardinality())
+		if std_err := v.Distinct.StdError(); std_err >= 0.5 {
+			fmt.Fprintf(OUTPUT, " +/- %.0f", std_err)
+		}
+	}
+
+	if v.Error > 0 {
+		fmt.Fprint(OUTPUT, " Error: ", v.Error)
+	}
+
+	if v == querySpec.Dropped {
+		fmt.Fprint(OUTPUT, " Groups: ", querySpec.DroppedGroupCount())
 	}
 
-	fmt.Printf("\n")
+	fmt.Fprintf(OUTPUT, "\n")
 
 	for _, agg := range querySpec.Aggregations {
 		col_name := fmt.Sprintf("  %5s", agg.Name)
@@ -215,20 +343,54 @@ func printResult(querySpec *QuerySpec, v *Result) {
 				Debug("NO HIST AROUND FOR KEY", agg.Name, v.GroupByKey)
 				continue
 			}
+			if len(querySpec.Percentiles) > 0 && h.TotalCount() > 0 {
+				extents := formatPercentiles(h, []float64{0, 100})
+				avg_str := fmt.Sprintf("%.2f", h.Mean())
+				std_str := fmt.Sprintf("%.2f", h.StdDev())
+				percentiles := strings.Join(formatPercentiles(h, querySpec.Percentiles), " ")
+				fmt.Fprintln(OUTPUT, col_name, "|", extents[0], extents[1], "|", avg_str, "|", percentiles, "|", std_str)
+				continue
+			}
+
 			p := h.GetPercentiles()
 
-			if len(p) > 0 {
+			if fh, ok := h.(FloatHistogram); ok && len(p) > 0 {
+				fp := fh.GetFloatPercentiles()
+				f := func(v float64) string { return fmt.Sprintf("%.2f", v) }
 				avg_str := fmt.Sprintf("%.2f", h.Mean())
 				std_str := fmt.Sprintf("%.2f", h.StdDev())
-				fmt.Println(col_name, "|", p[0], p[99], "|", avg_str, "|", p[0], p[25], p[50], p[75], p[99], "|", std_str)
+				fmt.Fprintln(OUTPUT, col_name, "|", f(fp[0]), f(fp[99]), "|", avg_str, "|", f(fp[0]), f(fp[25]), f(fp[50]), f(fp[75]), f(fp[99]), "|", std_str)
+			} else if len(p) > 0 {
+				avg_str := fmt.Sprintf("%.2f", h.Mean())
+				std_str := fmt.Sprintf("%.2f", h.StdDev())
+				fmt.Fprintln(OUTPUT, col_name, "|", p[0], p[99], "|", avg_str, "|", p[0], p[25], p[50], p[75], p[99], "|", std_str)
 			} else {
-				fmt.Println(col_name, "No Data")
+				fmt.Fprintln(OUTPUT, col_name, "No Data")
 			}
 		} else if agg.Op == "avg" {
-			fmt.Println(col_name, fmt.Sprintf("%.2f", v.Hists[agg.Name].Mean()))
+			// records without the column (like unjoined ones) leave no hist
+			h, ok := v.Hists[agg.Name]
+			if !ok {
+				Debug("NO HIST AROUND FOR KEY", agg.Name, v.GroupByKey)
+				continue
+			}
+			fmt.Fprintln(OUTPUT, col_name, fmt.Sprintf("%.2f", h.Mean()))
+		} else if b, ok := v.Buckets[agg.Label()]; ok && agg.Op == OP_BUCKETS {
+			counts := make([]string, len(b.Counts))
+			for i, label := range b.labels() {
+				counts[i] = fmt.Sprintf("%s: %d", label, b.Counts[i])
+			}
+
+			fmt.Fprintln(OUTPUT, fmt.Sprintf("  %5s", agg.Label()), strings.Join(counts, " "))
+		} else if val, ok := v.AggValue(agg); ok {
+			fmt.Fprintln(OUTPUT, fmt.Sprintf("  %5s", agg.Label()), agg.formatValue(val))
 		}
 	}
 
+	if querySpec.compare != nil && v != querySpec.Dropped {
+		printComparison(querySpec, v)
+	}
+
 }
 
 type ResultJSON map[string]interface{}
@@ -249,10 +411,12 @@ func printResults(querySpec *QuerySpec) {
 			results = append(results, res)
 		}
 
-		printJson(results)
+		printJson(append(results, querySpec.droppedResultJSON()...))
 		return
 	}
 
+	defer printResult(querySpec, querySpec.printableDropped())
+
 	count := 0
 
 	Debug("PRINTING CUMULATIVE RESULT")
@@ -297,6 +461,15 @@ func (qs *QuerySpec) PrintResults() {
 	}
 
 	if FLAGS.PRINT {
+		if qs.printLuaResult() {
+			return
+		}
+
+		if qs.Sessions != nil {
+			qs.printSessions()
+			return
+		}
+
 		if qs.TimeBucket > 0 {
 			printTimeResults(qs)
 		} else if qs.OrderBy != "" {
@@ -317,6 +490,11 @@ func (r *Record) toTSVRow() []string {
 			row = append(row, strconv.FormatInt(int64(val), 10))
 		}
 	}
+	for name, val := range r.Floats {
+		if r.Populated[name] == FLOAT_VAL {
+			row = append(row, strconv.FormatFloat(float64(val), 'g', -1, 64))
+		}
+	}
 	for name, val := range r.Strs {
 		if r.Populated[name] == STR_VAL {
 			col := r.block.GetColumnInfo(int16(name))
@@ -340,6 +518,12 @@ func (r *Record) sampleHeader() []string {
 			header = append(header, col.get_string_for_key(name))
 		}
 	}
+	for name, _ := range r.Floats {
+		if r.Populated[name] == FLOAT_VAL {
+			col := r.block.GetColumnInfo(int16(name))
+			header = append(header, col.get_string_for_key(name))
+		}
+	}
 	for name, _ := range r.Strs {
 		if r.Populated[name] == STR_VAL {
 			col := r.block.GetColumnInfo(int16(name))
@@ -363,6 +547,12 @@ func (r *Record) toSample() *Sample {
 
 		}
 	}
+	for name, val := range r.Floats {
+		if r.Populated[name] == FLOAT_VAL {
+			col := r.block.GetColumnInfo(int16(name))
+			sample[col.get_string_for_key(name)] = val
+		}
+	}
 	for name, val := range r.Strs {
 		if r.Populated[name] == STR_VAL {
 			col := r.block.GetColumnInfo(int16(name))
@@ -386,35 +576,39 @@ func (r *Record) toSample() *Sample {
 }
 
 func (t *Table) PrintSamples() {
+	printSamples(t.matchedRecords(FLAGS.LIMIT), false)
+}
+
+// the first limit records the query matched
+func (t *Table) matchedRecords(limit int) RecordList {
 	count := 0
-	records := make(RecordList, FLAGS.LIMIT)
+	records := make(RecordList, 0, limit)
 	for _, b := range t.BlockList {
 		for _, r := range b.Matched {
-			if r == nil {
-				records = records[:count]
+			if r == nil || count >= limit {
 				break
 			}
 
-			if count >= FLAGS.LIMIT {
-				break
-			}
-
-			records[count] = r
+			records = append(records, r)
 			count++
 		}
 
-		if count >= FLAGS.LIMIT {
+		if count >= limit {
 			break
 		}
 	}
 
+	return records
+}
+
+// prints sampled records, with_table adds the table each record came from
+func printSamples(records RecordList, with_table bool) {
 	samples := make([]*Sample, 0)
 	for _, r := range records {
-		if r == nil {
-			break
-		}
-
 		s := r.toSample()
+		if with_table {
+			(*s)[TABLE_GROUP] = r.block.table.Name
+		}
 		samples = append(samples, s)
 	}
 
@@ -431,11 +625,10 @@ func (t *Table) PrintSamples() {
 	}
 
 	for _, r := range records {
-		if r == nil {
-			break
+		r.block.table.PrintRecord(r)
+		if with_table {
+			Print("  ", TABLE_GROUP, r.block.table.Name)
 		}
-
-		t.PrintRecord(r)
 	}
 }
 
@@ -472,7 +665,7 @@ func printTablesToOutput(tables []string) {
 	if FLAGS.JSON {
 		b, err := json.Marshal(tables)
 		if err == nil {
-			os.Stdout.Write(b)
+			OUTPUT.Write(b)
 		} else {
 			Error("JSON encoding error", err)
 		}
@@ -481,10 +674,10 @@ func printTablesToOutput(tables []string) {
 	}
 
 	for _, name := range tables {
-		fmt.Print(name, " ")
+		fmt.Fprint(OUTPUT, name, " ")
 	}
 
-	fmt.Println("")
+	fmt.Fprintln(OUTPUT, "")
 }
 
 func (t *Table) getColsOfType(wanted_type int8) []string {
@@ -516,7 +709,7 @@ func (t *Table) getColsOfType(wanted_type int8) []string {
 }
 func (t *Table) printColsOfType(wanted_type int8) {
 	for _, v := range t.getColsOfType(wanted_type) {
-		fmt.Println(" ", v)
+		fmt.Fprintln(OUTPUT, " ", v)
 	}
 }
 
@@ -529,9 +722,10 @@ type TableInfo struct {
 }
 
 type ColumnInfo struct {
-	Strs []string `json:"strs"`
-	Ints []string `json:"ints"`
-	Sets []string `json:"sets"`
+	Strs   []string `json:"strs"`
+	Ints   []string `json:"ints"`
+	Sets   []string `json:"sets"`
+	Floats []string `json:"floats"`
 }
 
 func (t *Table) TableInfo() *TableInfo {
@@ -548,6 +742,7 @@ func (t *Table) TableInfo() *TableInfo {
 	r.Columns.Strs = t.getColsOfType(STR_VAL)
 	r.Columns.Ints = t.getColsOfType(INT_VAL)
 	r.Columns.Sets = t.getColsOfType(SET_VAL)
+	r.Columns.Floats = t.getColsOfType(FLOAT_VAL)
 	return r
 }
 
@@ -586,17 +781,19 @@ func (t *Table) PrintTableInfo() {
 		return
 	}
 
-	fmt.Printf("\nString Columns\n")
+	fmt.Fprintf(OUTPUT, "\nString Columns\n")
 	t.printColsOfType(STR_VAL)
-	fmt.Printf("\nInteger Columns\n")
+	fmt.Fprintf(OUTPUT, "\nInteger Columns\n")
 	t.printColsOfType(INT_VAL)
-	fmt.Printf("\nSet Columns\n")
+	fmt.Fprintf(OUTPUT, "\nFloat Columns\n")
+	t.printColsOfType(FLOAT_VAL)
+	fmt.Fprintf(OUTPUT, "\nSet Columns\n")
 	t.printColsOfType(SET_VAL)
-	fmt.Println("")
-	fmt.Println("Stats")
-	fmt.Println("  count", count)
-	fmt.Println("  storageSize", small_size, suffixes[suffix_idx])
-	fmt.Println("  avgObjSize", fmt.Sprintf("%.02f", float64(size)/float64(count)), "bytes")
+	fmt.Fprintln(OUTPUT, "")
+	fmt.Fprintln(OUTPUT, "Stats")
+	fmt.Fprintln(OUTPUT, "  count", count)
+	fmt.Fprintln(OUTPUT, "  storageSize", small_size, suffixes[suffix_idx])
+	fmt.Fprintln(OUTPUT, "  avgObjSize", fmt.Sprintf("%.02f", float64(size)/float64(count)), "bytes")
 
 }
 
@@ -609,7 +806,7 @@ func PrintVersionInfo() {
 
 	} else {
 		for k, v := range version_info {
-			fmt.Println(k, ":", v)
+			fmt.Fprintln(OUTPUT, k, ":", v)
 		}
 
 	}
@@ -19,12 +19,19 @@ func registerTypesForQueryCache() {
 	gob.Register(IntFilter{})
 	gob.Register(StrFilter{})
 	gob.Register(SetFilter{})
+	gob.Register(FloatFilter{})
+	gob.Register(AndFilter{})
+	gob.Register(OrFilter{})
+	gob.Register(NotFilter{})
 
 	gob.Register(IntField(0))
 	gob.Register(StrField(0))
 	gob.Register(SetField{})
+	gob.Register(FloatField(0))
 	gob.Register(&HistCompat{})
 	gob.Register(&MultiHistCompat{})
+	gob.Register(&FloatHist{})
+	gob.Register(&HDRHist{})
 }
 
 func (t *Table) getCachedQueryForBlock(dirname string, querySpec *QuerySpec) (*TableBlock, *QuerySpec) {
@@ -84,7 +91,7 @@ func (querySpec *QuerySpec) GetCacheRelevantFilters(blockname string) []Filter {
 	max_record := Record{Ints: IntArr{}, Strs: StrArr{}}
 	min_record := Record{Ints: IntArr{}, Strs: StrArr{}}
 
-	if len(info.IntInfoMap) == 0 {
+	if len(info.IntInfoMap) == 0 && len(info.FloatInfoMap) == 0 {
 		return filters
 	}
 
@@ -106,12 +113,35 @@ func (querySpec *QuerySpec) GetCacheRelevantFilters(blockname string) []Filter {
 		max_record.Populated[field_id] = INT_VAL
 	}
 
+	for field_name, field_info := range info.FloatInfoMap {
+		field_id := t.get_key_id(field_name)
+		min_record.ResizeFields(field_id)
+		max_record.ResizeFields(field_id)
+
+		min_record.Floats[field_id] = FloatField(field_info.Min)
+		max_record.Floats[field_id] = FloatField(field_info.Max)
+
+		min_record.Populated[field_id] = FLOAT_VAL
+		max_record.Populated[field_id] = FLOAT_VAL
+	}
+
 	for _, f := range querySpec.Filters {
 		// make the minima record and the maxima records...
 		switch fil := f.(type) {
 		case IntFilter:
 			// we only use block extents for skipping gt and lt filters
-			if fil.Op != "lt" && fil.Op != "gt" {
+			if fil.Op != "lt" && fil.Op != "gt" && fil.Op != "lte" && fil.Op != "gte" {
+				filters = append(filters, f)
+				continue
+			}
+
+			if f.Filter(&min_record) && f.Filter(&max_record) {
+			} else {
+				filters = append(filters, f)
+			}
+
+		case FloatFilter:
+			if fil.Op != "lt" && fil.Op != "gt" && fil.Op != "lte" && fil.Op != "gte" {
 				filters = append(filters, f)
 				continue
 			}
@@ -144,7 +174,7 @@ func (qs *QuerySpec) GetCacheKey(blockname string) string {
 }
 
 func (qs *QuerySpec) LoadCachedResults(blockname string) bool {
-	if FLAGS.CACHED_QUERIES == false {
+	if FLAGS.CACHED_QUERIES == false || qs.joined() {
 		return false
 	}
 
@@ -172,7 +202,7 @@ func (qs *QuerySpec) LoadCachedResults(blockname string) bool {
 }
 
 func (qs *QuerySpec) SaveCachedResults(blockname string) {
-	if FLAGS.CACHED_QUERIES == false {
+	if FLAGS.CACHED_QUERIES == false || qs.joined() {
 		return
 	}
 
@@ -4,8 +4,7 @@ import (
 	"crypto/md5"
 	"encoding/json"
 	"fmt"
-
-	hll "github.com/logv/loglogbeta"
+	"math"
 )
 
 type ResultMap map[string]*Result
@@ -20,6 +19,17 @@ type savedQueryResults struct {
 	MatchedCount int
 	Sorted       []*Result
 	Matched      RecordList
+
+	// the records of groups over the result limit, see heavy_hitters.go
+	Dropped          *Result
+	DroppedGroups    *DistinctCounter
+	HeavyHitterFloor int64
+
+	// the combined accumulator of a -lua script, see lua.go
+	LuaResult interface{} `json:",omitempty"`
+
+	// the matched records of each -session actor, see sessions.go
+	SessionEvents SessionEvents `json:",omitempty"`
 }
 
 type savedQueryParams struct {
@@ -28,6 +38,9 @@ type savedQueryParams struct {
 	Aggregations []Aggregation         `json:",omitempty"`
 	Distincts    []Grouping            `json:",omitempty"` // list of columns we are creating a count distinct query on
 	StrReplace   map[string]StrReplace `json:",omitempty"`
+	Computed     []string              `json:",omitempty"` // the -compute columns, see computed.go
+	LuaScript    string                `json:",omitempty"` // the source of the -lua script, see lua.go
+	Sessions     *SessionSpec          `json:",omitempty"` // how to split records into sessions, see sessions.go
 
 	OrderBy     string `json:",omitempty"`
 	PruneBy     string `json:",omitempty"`
@@ -35,6 +48,29 @@ type savedQueryParams struct {
 	NumDistinct int    `json:",omitempty"` // Exit early once we have NumDistinct records
 	TimeBucket  int    `json:",omitempty"`
 
+	TimeCalendar string `json:",omitempty"` // day, week or month buckets instead of fixed ones
+	TimeZone     string `json:",omitempty"` // for calendar buckets and printing time results
+
+	Having        string `json:",omitempty"` // filters the results after they are combined, see having.go
+	HavingPending bool   `json:",omitempty"` // -having is applied once these results are combined with other tables'
+
+	HeavyHitters int `json:",omitempty"` // keep this many groups per block with approximate counts
+
+	Percentiles []float64 `json:",omitempty"` // the percentiles hist queries print, see percentiles.go
+
+	DistinctPrecision int  `json:",omitempty"` // log2 of the count distinct sketch size, see distinct.go
+	DistinctExact     bool `json:",omitempty"` // count small distincts exactly
+
+	LimitPerBucket bool `json:",omitempty"` // each time bucket gets its own top Limit results
+	Other          bool `json:",omitempty"` // roll the rest of a bucket's results up into an other result
+
+	Fill      string `json:",omitempty"` // how to print time buckets without results
+	FillStart int64  `json:",omitempty"` // the time range to fill, 0 means the first or last bucket
+	FillEnd   int64  `json:",omitempty"`
+
+	Transforms      []Transform `json:",omitempty"` // series derived from the time results, see time_transform.go
+	TransformWindow int         `json:",omitempty"` // how many buckets moving transforms cover
+
 	Samples       bool `json:",omitempty"`
 	CachedQueries bool `json:",omitempty"`
 }
@@ -63,6 +99,12 @@ type QuerySpec struct {
 
 	BlockList map[string]TableBlock
 	Table     *Table
+
+	// the results are encoded for sybil aggregate, which applies -having
+	EncodeResults bool `json:"-"`
+
+	transformed map[*Result]map[string]float64 // see time_transform.go
+	compare     *comparison                    // see compare.go
 }
 
 type Filter interface {
@@ -79,24 +121,30 @@ type Aggregation struct {
 	Name     string
 	name_id  int16
 	HistType string
+
+	Bounds []float64 `json:",omitempty"` // what bucket ops count under, see agg_buckets.go
 }
 
 type Result struct {
 	Hists    map[string]Histogram
-	Distinct *hll.LogLogBeta
+	Stats    map[string]*AggStats
+	Buckets  map[string]*BucketCounts // keyed by the aggregation's label
+	Distinct *DistinctCounter
 
 	GroupByKey  string
 	BinaryByKey string
 	Count       int64
 	Samples     int64
+	Error       int64 // with -heavy-hitters, how much Count can be over by
 }
 
 func (qs *QuerySpec) NewResult() *Result {
 	added_record := &Result{}
 	added_record.Hists = make(map[string]Histogram)
+	added_record.Stats = make(map[string]*AggStats)
 
 	if len(qs.Distincts) > 0 {
-		added_record.Distinct = hll.New()
+		added_record.Distinct = NewDistinctCounter(qs.DistinctPrecision, qs.DistinctExact)
 	}
 
 	added_record.Count = 0
@@ -115,6 +163,30 @@ func (master_result *ResultMap) Combine(results *ResultMap) {
 }
 
 func fullMergeHist(h, ph Histogram) Histogram {
+	// hdr hists don't depend on the column's extents, so they merge exactly
+	hh, ok := h.(*HDRHist)
+	phh, p_ok := ph.(*HDRHist)
+	if ok && p_ok {
+		nh := hh.NewHist()
+		nh.Combine(hh)
+		nh.Combine(phh)
+
+		return nh
+	}
+
+	// float hists can re-bucket each other's values when combining, so we
+	// only need to make sure the new hist covers both of their extents
+	fh, ok := h.(*FloatHist)
+	pfh, p_ok := ph.(*FloatHist)
+	if ok && p_ok {
+		info := FloatInfo{Min: math.Min(fh.Info.Min, pfh.Info.Min), Max: math.Max(fh.Info.Max, pfh.Info.Max)}
+		nh := OPTS.MERGE_TABLE.NewFloatHist(&info)
+		nh.Combine(fh)
+		nh.Combine(pfh)
+
+		return nh
+	}
+
 	l1, r1 := h.Range()
 	l2, r2 := ph.Range()
 
@@ -176,6 +248,36 @@ func (rs *Result) Combine(next_result *Result) {
 		}
 	}
 
+	// combine exact stats
+	if len(next_result.Stats) > 0 && rs.Stats == nil {
+		rs.Stats = make(map[string]*AggStats)
+	}
+
+	for k, s := range next_result.Stats {
+		ps, ok := rs.Stats[k]
+		if !ok {
+			ps = &AggStats{}
+			rs.Stats[k] = ps
+		}
+
+		ps.Combine(s)
+	}
+
+	// combine bucket counts
+	if len(next_result.Buckets) > 0 && rs.Buckets == nil {
+		rs.Buckets = make(map[string]*BucketCounts)
+	}
+
+	for k, b := range next_result.Buckets {
+		pb, ok := rs.Buckets[k]
+		if !ok {
+			pb = newBucketCounts(b.Bounds)
+			rs.Buckets[k] = pb
+		}
+
+		pb.Combine(b)
+	}
+
 	// combine count distincts
 	if next_result.Distinct != nil {
 
@@ -189,11 +291,15 @@ func (rs *Result) Combine(next_result *Result) {
 
 	rs.Samples = total_samples
 	rs.Count = total_count
+	rs.Error += next_result.Error
 }
 
 func (querySpec *QuerySpec) Punctuate() {
 	querySpec.Results = make(ResultMap)
 	querySpec.TimeResults = make(map[int]ResultMap)
+	querySpec.Dropped = nil
+	querySpec.DroppedGroups = nil
+	querySpec.HeavyHitterFloor = 0
 }
 
 func (querySpec *QuerySpec) ResetResults() {
@@ -204,7 +310,7 @@ func (querySpec *QuerySpec) ResetResults() {
 		for _, b := range querySpec.Table.BlockList {
 			for _, c := range b.columns {
 				if len(c.RCache) > 0 {
-					c.RCache = make(map[int]bool)
+					c.RCache = make(map[string]map[int]bool)
 				}
 			}
 		}
@@ -220,23 +326,18 @@ func (t *Table) Aggregation(name string, op string) Aggregation {
 
 	agg := Aggregation{Name: name, name_id: col_id, Op: op}
 
-	if op == "hist" {
-		agg.HistType = "basic"
-		if FLAGS.T_DIGEST {
-			agg.HistType = "tdigest"
-
-		}
-		if FLAGS.LOG_HIST {
-			agg.HistType = "multi"
-
-		}
-
-		if FLAGS.HDR_HIST {
-			agg.HistType = "hdr"
+	_, is_percentile := percentileOp(op)
+	if op == "hist" || is_percentile {
+		agg.HistType = histType(t.get_int_info(col_id))
+		if t.KeyTypes[col_id] == FLOAT_VAL {
+			agg.HistType = "float"
 		}
 	}
 
 	_, ok := t.IntInfo[col_id]
+	if !ok {
+		_, ok = t.FloatInfo[col_id]
+	}
 	if !ok {
 		// TODO: tell our table we need to load all records!
 		Debug("MISSING CACHED INFO FOR", agg)
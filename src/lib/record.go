@@ -3,6 +3,7 @@ package sybil
 type Record struct {
 	Strs      []StrField
 	Ints      []IntField
+	Floats    []FloatField
 	SetMap    map[int16]SetField
 	Populated []int8
 
@@ -16,45 +17,56 @@ const (
 	INT_VAL = iota
 	STR_VAL = iota
 	SET_VAL = iota
+	// FLOAT_VAL comes last so that the KeyTypes of existing tables stay valid
+	FLOAT_VAL = iota
 )
 
+// GetStrVal and the other Get*Val accessors return false when the record
+// has no value of that type for the column
 func (r *Record) GetStrVal(name string) (string, bool) {
 	id := r.block.get_key_id(name)
-
-	is := r.Strs[id]
-	ok := r.Populated[id] == STR_VAL
+	if int(id) >= len(r.Populated) || r.Populated[id] != STR_VAL {
+		return "", false
+	}
 
 	col := r.block.GetColumnInfo(id)
-	val := col.get_string_for_val(int32(is))
+	val := col.get_string_for_val(int32(r.Strs[id]))
 
-	return val, ok
+	return val, true
 }
 
 func (r *Record) GetIntVal(name string) (int, bool) {
 	id := r.block.get_key_id(name)
+	if int(id) >= len(r.Populated) || r.Populated[id] != INT_VAL {
+		return 0, false
+	}
 
-	is := r.Ints[id]
-	ok := r.Populated[id] == INT_VAL
-	return int(is), ok
+	return int(r.Ints[id]), true
 }
 
-func (r *Record) GetSetVal(name string) ([]string, bool) {
+func (r *Record) GetFloatVal(name string) (float64, bool) {
 	id := r.block.get_key_id(name)
+	if int(id) >= len(r.Populated) || r.Populated[id] != FLOAT_VAL {
+		return 0, false
+	}
 
-	is := r.SetMap[id]
-	ok := r.Populated[id] == SET_VAL
+	return float64(r.Floats[id]), true
+}
 
-	col := r.block.GetColumnInfo(id)
+func (r *Record) GetSetVal(name string) ([]string, bool) {
+	id := r.block.get_key_id(name)
 	rets := make([]string, 0)
+	if int(id) >= len(r.Populated) || r.Populated[id] != SET_VAL {
+		return rets, false
+	}
 
-	if ok {
-		for _, v := range is {
-			val := col.get_string_for_val(int32(v))
-			rets = append(rets, val)
-		}
+	col := r.block.GetColumnInfo(id)
+	for _, v := range r.SetMap[id] {
+		val := col.get_string_for_val(int32(v))
+		rets = append(rets, val)
 	}
 
-	return rets, ok
+	return rets, true
 }
 
 func (r *Record) getVal(name string) (int, bool) {
@@ -98,6 +110,12 @@ func (r *Record) ResizeFields(length int16) {
 		r.Ints = append(r.Ints, delta_records...)
 	}
 
+	if int(length) >= len(r.Floats) {
+		delta_records := make([]FloatField, int(float64(length)))
+
+		r.Floats = append(r.Floats, delta_records...)
+	}
+
 }
 
 func (r *Record) AddStrField(name string, val string) {
@@ -127,6 +145,18 @@ func (r *Record) AddIntField(name string, val int64) {
 	}
 }
 
+func (r *Record) AddFloatField(name string, val float64) {
+	name_id := r.block.get_key_id(name)
+	r.block.table.update_float_info(name_id, val)
+
+	r.ResizeFields(name_id)
+	r.Floats[name_id] = FloatField(val)
+	r.Populated[name_id] = FLOAT_VAL
+	if r.block.table.set_key_type(name_id, FLOAT_VAL) == false {
+		Error("COULDNT SET FLOAT VAL", name, val, name_id)
+	}
+}
+
 func (r *Record) AddSetField(name string, val []string) {
 	name_id := r.block.get_key_id(name)
 	vals := make([]int32, len(val))
@@ -168,6 +198,15 @@ func (r *Record) CopyRecord() *Record {
 		}
 	}
 
+	if len(r.Floats) > 0 {
+		if COPY_RECORD_INTERNS {
+			nr.Floats = r.Floats
+		} else {
+			nr.Floats = make([]FloatField, len(r.Populated))
+			copy(nr.Floats, r.Floats)
+		}
+	}
+
 	if len(r.SetMap) > 0 {
 		nr.SetMap = r.SetMap
 	}
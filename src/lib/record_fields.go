@@ -1,10 +1,12 @@
 package sybil
 
 type IntArr []IntField
+type FloatArr []FloatField
 type StrArr []StrField
 type SetArr []SetField
 type SetMap map[int16]SetField
 
 type IntField int64
+type FloatField float64
 type StrField int32
 type SetField []int32
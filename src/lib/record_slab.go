@@ -34,10 +34,12 @@ func (tb *TableBlock) makeRecordSlab(loadSpec *LoadSpec, info SavedColumnInfo, l
 	var alloced []Record
 	var bigIntArr IntArr
 	var bigStrArr StrArr
+	var bigFloatArr FloatArr
 	var bigPopArr []int8
 	var has_sets = false
 	var has_strs = false
 	var has_ints = false
+	var has_floats = false
 	max_key_id := 0
 
 	t.string_id_m.RLock()
@@ -57,6 +59,8 @@ func (tb *TableBlock) makeRecordSlab(loadSpec *LoadSpec, info SavedColumnInfo, l
 			switch t.KeyTypes[v] {
 			case INT_VAL:
 				has_ints = true
+			case FLOAT_VAL:
+				has_floats = true
 			case SET_VAL:
 				has_sets = true
 			case STR_VAL:
@@ -69,6 +73,7 @@ func (tb *TableBlock) makeRecordSlab(loadSpec *LoadSpec, info SavedColumnInfo, l
 		has_sets = true
 		has_ints = true
 		has_strs = true
+		has_floats = true
 	}
 
 	if loadSpec != nil || load_records {
@@ -81,6 +86,9 @@ func (tb *TableBlock) makeRecordSlab(loadSpec *LoadSpec, info SavedColumnInfo, l
 		if has_strs {
 			bigStrArr = make(StrArr, max_key_id*int(info.NumRecords))
 		}
+		if has_floats {
+			bigFloatArr = make(FloatArr, max_key_id*int(info.NumRecords))
+		}
 		bigPopArr = make([]int8, max_key_id*int(info.NumRecords))
 		mend := time.Now()
 
@@ -99,6 +107,10 @@ func (tb *TableBlock) makeRecordSlab(loadSpec *LoadSpec, info SavedColumnInfo, l
 				r.Strs = bigStrArr[i*max_key_id : (i+1)*max_key_id]
 			}
 
+			if has_floats {
+				r.Floats = bigFloatArr[i*max_key_id : (i+1)*max_key_id]
+			}
+
 			// TODO: move this allocation next to the allocations above
 			if has_sets {
 				r.SetMap = make(SetMap)
@@ -150,6 +162,12 @@ func (rl RecordList) ResetRecords(tb *TableBlock) {
 					record.Strs[i] = 0
 				}
 			}
+
+			if record.Floats != nil {
+				for i := range record.Floats {
+					record.Floats[i] = 0
+				}
+			}
 		}
 	}
 
@@ -18,15 +18,21 @@ type RowSavedStr struct {
 	Value string
 }
 
+type RowSavedFloat struct {
+	Name  int16
+	Value float64
+}
+
 type RowSavedSet struct {
 	Name  int16
 	Value []string
 }
 
 type SavedRecord struct {
-	Ints []RowSavedInt
-	Strs []RowSavedStr
-	Sets []RowSavedSet
+	Ints   []RowSavedInt
+	Strs   []RowSavedStr
+	Sets   []RowSavedSet
+	Floats []RowSavedFloat
 }
 
 type SavedRecordBlock struct {
@@ -117,6 +123,15 @@ func (srb *SavedRecordBlock) toRecord(t *Table, s *SavedRecord) *Record {
 		r.AddStrField(t.get_string_for_key(key_id), v.Value)
 	}
 
+	for _, v := range s.Floats {
+		key_id = int(get_short_key_id(t, key_exchange, v.Name))
+		if key_id == -1 {
+			continue
+		}
+
+		r.AddFloatField(t.get_string_for_key(key_id), v.Value)
+	}
+
 	for _, v := range s.Sets {
 		key_id = int(get_short_key_id(t, key_exchange, v.Name))
 		if key_id == -1 {
@@ -136,6 +151,12 @@ func (r Record) toSavedRecord() *SavedRecord {
 		}
 	}
 
+	for k, v := range r.Floats {
+		if r.Populated[k] == FLOAT_VAL {
+			s.Floats = append(s.Floats, RowSavedFloat{int16(k), float64(v)})
+		}
+	}
+
 	for k, v := range r.Strs {
 		if r.Populated[k] == STR_VAL {
 			col := r.block.GetColumnInfo(int16(k))
@@ -0,0 +1,361 @@
+package sybil
+
+import "fmt"
+import "math"
+import "sort"
+import "strconv"
+import "strings"
+import "text/tabwriter"
+
+// {{{ SESSIONS
+// -session user_id splits each actor's matched records into sessions: the
+// records of a session are ordered by -time-col and none of them is more than
+// -session-gap after the one before it. The query prints how many sessions
+// (and actors) there are and how long the sessions last, ex:
+//
+//   sybil query -table TABLE -session user_id -session-gap 30m -funnel-col event -funnel view,add_to_cart,checkout
+//
+// with a -funnel, each session also walks its steps in order: a record whose
+// -funnel-col is the session's next step moves it forward, other records are
+// skipped. The query prints how many sessions reached each step and how many
+// of the sessions at the step before (and at the first step) that is.
+//
+// an actor's records can be in any block, table or node, so each block keeps
+// the time (and funnel step) of its actors' records and the sessions are only
+// built once the results of every block are combined. That is about 16 bytes
+// per matched record, so sessions over big tables should be filtered down
+// with -start and -end.
+
+const DEFAULT_SESSION_GAP = 30 * 60
+
+// funnel steps are kept in an int8, NO_FUNNEL_STEP is for records that
+// aren't a step
+const MAX_FUNNEL_STEPS = math.MaxInt8
+const NO_FUNNEL_STEP = -1
+
+var DEFAULT_SESSION_PERCENTILES = []float64{25, 50, 75, 99}
+
+type SessionSpec struct {
+	Actor     string
+	TimeCol   string
+	Gap       int64    // seconds without records that end a session
+	FunnelCol string   `json:",omitempty"`
+	Funnel    []string `json:",omitempty"`
+
+	actor_id, time_id, funnel_id int16
+	steps                        map[string]int8
+}
+
+// SessionEvent is one of an actor's matched records
+type SessionEvent struct {
+	Time int64
+	Step int8 // the record's funnel step or NO_FUNNEL_STEP
+}
+
+// the matched records of each actor, by the actor's value
+type SessionEvents map[string][]SessionEvent
+
+// ParseSessionGap parses a -session-gap like 1800, 30m or 1h into seconds
+func ParseSessionGap(val string) (int64, error) {
+	val = strings.TrimSpace(val)
+	if secs, err := strconv.ParseInt(val, 10, 64); err == nil {
+		if secs <= 0 {
+			return 0, fmt.Errorf("session gap %q must be positive", val)
+		}
+		return secs, nil
+	}
+
+	secs, err := parseTimeOffset(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid session gap %q: %s", val, err)
+	}
+	if secs <= 0 {
+		return 0, fmt.Errorf("session gap %q must be positive", val)
+	}
+
+	return secs, nil
+}
+
+// ParseFunnel parses a -funnel like view,add_to_cart,checkout into its steps
+func ParseFunnel(spec string, sep string) ([]string, error) {
+	steps := make([]string, 0)
+	if strings.TrimSpace(spec) == "" {
+		return steps, nil
+	}
+
+	seen := make(map[string]bool)
+	for _, step := range strings.Split(spec, sep) {
+		step = strings.TrimSpace(step)
+		if step == "" {
+			return nil, fmt.Errorf("funnel %q has an empty step", spec)
+		}
+		if seen[step] {
+			return nil, fmt.Errorf("funnel step %q is repeated", step)
+		}
+
+		seen[step] = true
+		steps = append(steps, step)
+	}
+
+	if len(steps) > MAX_FUNNEL_STEPS {
+		return nil, fmt.Errorf("funnels can have at most %d steps", MAX_FUNNEL_STEPS)
+	}
+
+	return steps, nil
+}
+
+// Sessions returns a copy of spec that reads the table's columns
+func (t *Table) Sessions(spec SessionSpec) *SessionSpec {
+	s := spec
+	s.actor_id = t.get_key_id(s.Actor)
+	s.time_id = t.get_key_id(s.TimeCol)
+	s.funnel_id = -1
+	if s.FunnelCol != "" {
+		s.funnel_id = t.get_key_id(s.FunnelCol)
+	}
+
+	s.steps = make(map[string]int8, len(s.Funnel))
+	for i, step := range s.Funnel {
+		s.steps[step] = int8(i)
+	}
+
+	return &s
+}
+
+// returns the value of an int or str column as a str
+func (s *SessionSpec) recordValue(r *Record, id int16) (string, bool) {
+	if id < 0 || int(id) >= len(r.Populated) {
+		return "", false
+	}
+
+	switch r.Populated[id] {
+	case STR_VAL:
+		col := r.block.GetColumnInfo(id)
+		return col.get_string_for_val(int32(r.Strs[id])), true
+	case INT_VAL:
+		return strconv.FormatInt(int64(r.Ints[id]), 10), true
+	}
+
+	return "", false
+}
+
+// adds a matched record to its actor's events, records without an actor or
+// a time aren't part of any session
+func (s *SessionSpec) addRecord(events SessionEvents, r *Record) {
+	if int(s.time_id) >= len(r.Populated) || r.Populated[s.time_id] != INT_VAL {
+		return
+	}
+
+	actor, ok := s.recordValue(r, s.actor_id)
+	if !ok {
+		return
+	}
+
+	step := int8(NO_FUNNEL_STEP)
+	if val, ok := s.recordValue(r, s.funnel_id); ok {
+		if i, ok := s.steps[val]; ok {
+			step = i
+		}
+	}
+
+	events[actor] = append(events[actor], SessionEvent{Time: int64(r.Ints[s.time_id]), Step: step})
+}
+
+// combines the session events of several blocks (or tables or nodes)
+func (qs *QuerySpec) combineSessions(block_specs map[string]*QuerySpec) {
+	if qs.Sessions == nil {
+		return
+	}
+
+	// the events are appended to new slices, so the blocks' events (which
+	// may be cached) are left alone
+	events := make(SessionEvents)
+	for _, spec := range block_specs {
+		for actor, actor_events := range spec.SessionEvents {
+			events[actor] = append(events[actor], actor_events...)
+		}
+	}
+
+	qs.SessionEvents = events
+}
+
+// {{{ SESSION REPORT
+type SessionReport struct {
+	Actors   int
+	Sessions int
+	Records  int
+	Duration SessionDurations
+	Funnel   []FunnelStep `json:",omitempty"`
+}
+
+// how long the sessions last, in seconds
+type SessionDurations struct {
+	Min         int64
+	Max         int64
+	Avg         float64
+	Percentiles map[string]int64
+}
+
+type FunnelStep struct {
+	Step           string
+	Sessions       int
+	Conversion     float64 // the fraction of the first step's sessions that got here
+	StepConversion float64 // the fraction of the previous step's sessions that got here
+}
+
+type sessionEventsByTime []SessionEvent
+
+func (a sessionEventsByTime) Len() int      { return len(a) }
+func (a sessionEventsByTime) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+// records at the same time are ordered by their step, so they reach the
+// funnel's steps the same way however their blocks were combined
+func (a sessionEventsByTime) Less(i, j int) bool {
+	if a[i].Time != a[j].Time {
+		return a[i].Time < a[j].Time
+	}
+	return a[i].Step < a[j].Step
+}
+
+// SessionReport builds the sessions of the combined session events
+func (qs *QuerySpec) SessionReport() *SessionReport {
+	report := &SessionReport{Duration: SessionDurations{Percentiles: make(map[string]int64)}}
+	if qs.Sessions == nil {
+		return report
+	}
+
+	durations := make([]int64, 0)
+	reached := make([]int, len(qs.Sessions.Funnel))
+
+	for _, events := range qs.SessionEvents {
+		if len(events) == 0 {
+			continue
+		}
+
+		report.Actors++
+		report.Records += len(events)
+		sort.Sort(sessionEventsByTime(events))
+
+		start := events[0].Time
+		last := start
+		next_step := 0
+		for _, e := range events {
+			if e.Time-last > qs.Sessions.Gap {
+				durations = append(durations, last-start)
+				for i := 0; i < next_step; i++ {
+					reached[i]++
+				}
+
+				start = e.Time
+				next_step = 0
+			}
+
+			if next_step < len(reached) && int(e.Step) == next_step {
+				next_step++
+			}
+			last = e.Time
+		}
+
+		durations = append(durations, last-start)
+		for i := 0; i < next_step; i++ {
+			reached[i]++
+		}
+	}
+
+	report.Sessions = len(durations)
+	for i, step := range qs.Sessions.Funnel {
+		fs := FunnelStep{Step: step, Sessions: reached[i]}
+		if reached[0] > 0 {
+			fs.Conversion = float64(reached[i]) / float64(reached[0])
+		}
+		if i == 0 {
+			fs.StepConversion = fs.Conversion
+		} else if reached[i-1] > 0 {
+			fs.StepConversion = float64(reached[i]) / float64(reached[i-1])
+		}
+
+		report.Funnel = append(report.Funnel, fs)
+	}
+
+	if len(durations) == 0 {
+		return report
+	}
+
+	sort.Sort(int64Slice(durations))
+
+	total := int64(0)
+	for _, d := range durations {
+		total += d
+	}
+
+	report.Duration.Min = durations[0]
+	report.Duration.Max = durations[len(durations)-1]
+	report.Duration.Avg = float64(total) / float64(len(durations))
+	for _, p := range qs.sessionPercentiles() {
+		report.Duration.Percentiles["p"+formatPercentile(p)] = nearestRank(durations, p)
+	}
+
+	return report
+}
+
+func (qs *QuerySpec) sessionPercentiles() []float64 {
+	if len(qs.Percentiles) > 0 {
+		return qs.Percentiles
+	}
+
+	return DEFAULT_SESSION_PERCENTILES
+}
+
+// returns the pth percentile of sorted values, which are exact here
+func nearestRank(sorted []int64, p float64) int64 {
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+
+	return sorted[i]
+}
+
+func (qs *QuerySpec) printSessions() {
+	report := qs.SessionReport()
+	if FLAGS.JSON {
+		printJson(report)
+		return
+	}
+
+	fmt.Fprintln(OUTPUT, "Sessions")
+	fmt.Fprintln(OUTPUT, "  count", report.Sessions)
+	fmt.Fprintln(OUTPUT, "  actors", report.Actors)
+	fmt.Fprintln(OUTPUT, "  records", report.Records)
+	if report.Sessions > 0 {
+		d := report.Duration
+		percentiles := make([]string, 0)
+		for _, p := range qs.sessionPercentiles() {
+			percentiles = append(percentiles, strconv.FormatInt(d.Percentiles["p"+formatPercentile(p)], 10))
+		}
+
+		fmt.Fprintln(OUTPUT, "  duration |", d.Min, d.Max, "|", fmt.Sprintf("%.2f", d.Avg), "|", strings.Join(percentiles, " "))
+	}
+
+	if len(report.Funnel) == 0 {
+		return
+	}
+
+	fmt.Fprintln(OUTPUT, "")
+	fmt.Fprintln(OUTPUT, "Funnel")
+
+	w := new(tabwriter.Writer)
+	w.Init(OUTPUT, 0, 1, 2, ' ', 0)
+	fmt.Fprintln(w, "  step\tsessions\tof previous\tof first")
+	for i, fs := range report.Funnel {
+		fmt.Fprintf(w, "  %d. %s\t%d\t%.2f%%\t%.2f%%\n", i+1, fs.Step, fs.Sessions, fs.StepConversion*100, fs.Conversion*100)
+	}
+	w.Flush()
+}
+
+// }}} SESSION REPORT
+
+// }}} SESSIONS
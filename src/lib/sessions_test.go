@@ -0,0 +1,78 @@
+package sybil
+
+import "strconv"
+import "testing"
+
+func TestParseSessions(t *testing.T) {
+	gaps := map[string]int64{"1800": 1800, "30m": 1800, "1.5h": 5400}
+	for val, expected := range gaps {
+		if gap, err := ParseSessionGap(val); err != nil || gap != expected {
+			t.Error("SESSION GAP", val, "PARSED WRONG", gap, err)
+		}
+	}
+
+	for _, val := range []string{"0", "-5", "30x", ""} {
+		if _, err := ParseSessionGap(val); err == nil {
+			t.Error("EXPECTED SESSION GAP", val, "TO FAIL")
+		}
+	}
+
+	steps, err := ParseFunnel("view, add_to_cart,checkout", ",")
+	if err != nil || len(steps) != 3 || steps[1] != "add_to_cart" {
+		t.Error("FUNNEL PARSED WRONG", steps, err)
+	}
+
+	for _, spec := range []string{"view,,checkout", "view,view"} {
+		if _, err := ParseFunnel(spec, ","); err == nil {
+			t.Error("EXPECTED FUNNEL", spec, "TO FAIL")
+		}
+	}
+}
+
+func TestSessions(t *testing.T) {
+	tableName := getTestTableName(t)
+	deleteTestDb(tableName)
+	defer deleteTestDb(tableName)
+
+	// each user has a record every 10 seconds, that walk the funnel's steps
+	// over and over. Every 10th record comes an hour later, which starts a
+	// new session
+	users := 10
+	steps := []string{"view", "add_to_cart", "checkout", "other"}
+	blockCount := 3
+	addRecords(tableName, func(r *Record, index int) {
+		n := index / users
+		r.AddStrField("user", "u"+strconv.Itoa(index%users))
+		r.AddIntField("time", int64(n*10+(n/10)*3600))
+		r.AddStrField("event", steps[(n%10)%len(steps)])
+	}, blockCount)
+
+	nt := saveAndReloadTable(t, tableName, blockCount)
+
+	querySpec := newQuerySpec()
+	querySpec.Sessions = nt.Sessions(SessionSpec{Actor: "user", TimeCol: "time", Gap: 1800,
+		FunnelCol: "event", Funnel: []string{"view", "add_to_cart", "checkout"}})
+	nt.MatchAndAggregate(querySpec)
+
+	report := querySpec.SessionReport()
+
+	records := CHUNK_SIZE * blockCount
+	sessions := users * ((records/users + 9) / 10)
+	if report.Actors != users || report.Records != records || report.Sessions != sessions {
+		t.Fatal("WRONG SESSIONS", report.Actors, report.Records, report.Sessions, "EXPECTED", sessions)
+	}
+
+	if report.Duration.Max != 90 || report.Duration.Percentiles["p99"] != 90 {
+		t.Error("WRONG SESSION DURATIONS", report.Duration)
+	}
+
+	if len(report.Funnel) != 3 {
+		t.Fatal("EXPECTED A RESULT PER FUNNEL STEP", report.Funnel)
+	}
+
+	for _, fs := range report.Funnel {
+		if fs.Sessions != sessions || fs.Conversion != 1 {
+			t.Error("EVERY SESSION SHOULD REACH", fs.Step, fs)
+		}
+	}
+}
@@ -1,11 +1,12 @@
 package sybil
 
 type KeyInfo struct {
-	Table    *Table
-	KeyTypes map[int16]int8
-	KeyTable map[string]int16
-	IntInfo  IntInfoTable
-	StrInfo  StrInfoTable
+	Table     *Table
+	KeyTypes  map[int16]int8
+	KeyTable  map[string]int16
+	IntInfo   IntInfoTable
+	StrInfo   StrInfoTable
+	FloatInfo FloatInfoTable
 
 	KeyExchange map[int16]int16 // the key exchange maps the original table's keytable -> new key table
 }
@@ -34,6 +35,11 @@ func (ki *KeyInfo) addKeys(keys []string) {
 			ki.IntInfo[local_key_id] = int_info
 		}
 
+		float_info, ok := ki.Table.FloatInfo[key_id]
+		if ok {
+			ki.FloatInfo[local_key_id] = float_info
+		}
+
 		str_info, ok := ki.Table.StrInfo[key_id]
 		if ok {
 			ki.StrInfo[local_key_id] = str_info
@@ -48,6 +54,7 @@ func (ki *KeyInfo) init_data_structures(t *Table) {
 	ki.KeyTable = make(map[string]int16)
 	ki.IntInfo = make(IntInfoTable)
 	ki.StrInfo = make(StrInfoTable)
+	ki.FloatInfo = make(FloatInfoTable)
 	ki.KeyExchange = make(map[int16]int16)
 }
 
@@ -76,6 +83,7 @@ func (t *Table) ShortenKeyTable() {
 	t.KeyTable = t.ShortKeyInfo.KeyTable
 	t.IntInfo = t.ShortKeyInfo.IntInfo
 	t.StrInfo = t.ShortKeyInfo.StrInfo
+	t.FloatInfo = t.ShortKeyInfo.FloatInfo
 	Debug("NEW KEY TABLE", t.KeyTable)
 	Debug("NEW KEY TYPES", t.KeyTypes)
 
@@ -0,0 +1,959 @@
+package sybil
+
+import "fmt"
+import "regexp"
+import "strconv"
+import "strings"
+import "time"
+
+// {{{ SQL
+// sybil sql takes a practical subset of SQL:
+//
+//   SELECT host, count(*), p99(latency) FROM requests
+//   WHERE time > now() - 1h AND status IN (500, 503)
+//   GROUP BY host ORDER BY count DESC LIMIT 20
+//
+// and lowers it into the same FLAGS that sybil query reads, so the query runs
+// through the exact same QuerySpec / LoadSpec setup. The WHERE clause becomes
+// a WhereExpr (see filter_expr.go).
+
+type SQLQuery struct {
+	Table string
+
+	Groups     []string
+	Ints       []string
+	Aggs       []string // sum, min, max and count(col), passed through -agg
+	Op         string
+	TimeBucket int
+
+	Samples    bool
+	SampleCols []string
+
+	Where   *WhereExpr
+	OrderBy string
+	Limit   int
+}
+
+// SQLError is a parse error, Pos is the byte offset into the query
+type SQLError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SQLError) Error() string {
+	return fmt.Sprintf("syntax error at position %d: %s", e.Pos, e.Msg)
+}
+
+// {{{ TOKENIZER
+const (
+	sql_end = iota
+	sql_ident
+	sql_quoted_ident
+	sql_number
+	sql_duration
+	sql_string
+	sql_op
+)
+
+type sqlToken struct {
+	kind  int
+	value string
+	pos   int
+}
+
+func (tok sqlToken) String() string {
+	switch tok.kind {
+	case sql_end:
+		return "end of query"
+	case sql_string:
+		return fmt.Sprintf("'%s'", tok.value)
+	}
+
+	return fmt.Sprintf("\"%s\"", tok.value)
+}
+
+var SQL_OPS = []string{"<=", ">=", "!=", "<>", "==", "!~", "=", "<", ">", "~", "(", ")", ",", "*", "/", "%", "+", "-", ";"}
+
+var SQL_DURATIONS = map[string]int64{
+	"s": 1,
+	"m": 60,
+	"h": 60 * 60,
+	"d": 60 * 60 * 24,
+	"w": 60 * 60 * 24 * 7,
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '.' || c == '$' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func tokenizeSQL(query string) ([]sqlToken, error) {
+	tokens := make([]sqlToken, 0)
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'' || c == '"' || c == '`':
+			// single quotes are strings, double quotes and backticks are
+			// identifiers. Doubling the quote or a backslash before it escapes
+			// it, other backslashes are kept so regexes like '\d+' work
+			val := make([]byte, 0)
+			j := i + 1
+			for ; j < len(query); j++ {
+				if query[j] == '\\' && j+1 < len(query) && query[j+1] == c {
+					j++
+				} else if query[j] == c {
+					if j+1 < len(query) && query[j+1] == c {
+						j++
+					} else {
+						break
+					}
+				}
+				val = append(val, query[j])
+			}
+
+			if j >= len(query) {
+				return nil, &SQLError{i, "unterminated quote"}
+			}
+
+			kind := sql_quoted_ident
+			if c == '\'' {
+				kind = sql_string
+			}
+
+			tokens = append(tokens, sqlToken{kind, string(val), i})
+			i = j + 1
+		case c >= '0' && c <= '9':
+			j := i
+			for ; j < len(query) && ((query[j] >= '0' && query[j] <= '9') || query[j] == '.'); j++ {
+			}
+
+			// a number followed by a unit (1h, 30m) is a duration in seconds
+			k := j
+			for ; k < len(query) && isIdentChar(query[k]); k++ {
+			}
+
+			if k == j {
+				tokens = append(tokens, sqlToken{sql_number, query[i:j], i})
+			} else if _, ok := SQL_DURATIONS[strings.ToLower(query[j:k])]; ok {
+				tokens = append(tokens, sqlToken{sql_duration, query[i:k], i})
+			} else {
+				return nil, &SQLError{i, fmt.Sprintf("bad number or duration %s", query[i:k])}
+			}
+
+			i = k
+		case isIdentChar(c):
+			j := i
+			for ; j < len(query) && isIdentChar(query[j]); j++ {
+			}
+
+			tokens = append(tokens, sqlToken{sql_ident, query[i:j], i})
+			i = j
+		default:
+			matched := false
+			for _, op := range SQL_OPS {
+				if strings.HasPrefix(query[i:], op) {
+					tokens = append(tokens, sqlToken{sql_op, op, i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+
+			if !matched {
+				return nil, &SQLError{i, fmt.Sprintf("unexpected character %c", c)}
+			}
+		}
+	}
+
+	tokens = append(tokens, sqlToken{sql_end, "", len(query)})
+	return tokens, nil
+}
+
+// }}} TOKENIZER
+
+// {{{ PARSER
+type sqlSelectItem struct {
+	Star     bool
+	Func     string // empty for plain columns
+	Col      string
+	Distinct bool
+	Alias    string
+}
+
+type sqlParser struct {
+	tokens []sqlToken
+	pos    int
+}
+
+func (p *sqlParser) peek() sqlToken {
+	return p.tokens[p.pos]
+}
+
+func (p *sqlParser) next() sqlToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != sql_end {
+		p.pos++
+	}
+
+	return tok
+}
+
+func (p *sqlParser) errorf(tok sqlToken, format string, args ...interface{}) error {
+	return &SQLError{tok.pos, fmt.Sprintf(format, args...)}
+}
+
+func (p *sqlParser) isKeyword(keyword string) bool {
+	tok := p.peek()
+	return tok.kind == sql_ident && strings.ToLower(tok.value) == keyword
+}
+
+func (p *sqlParser) acceptKeyword(keyword string) bool {
+	if p.isKeyword(keyword) {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *sqlParser) expectKeyword(keyword string) error {
+	if !p.acceptKeyword(keyword) {
+		return p.errorf(p.peek(), "expected %s, found %s", strings.ToUpper(keyword), p.peek())
+	}
+
+	return nil
+}
+
+func (p *sqlParser) isOp(op string) bool {
+	tok := p.peek()
+	return tok.kind == sql_op && tok.value == op
+}
+
+func (p *sqlParser) acceptOp(op string) bool {
+	if p.isOp(op) {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *sqlParser) expectOp(op string) error {
+	if !p.acceptOp(op) {
+		return p.errorf(p.peek(), "expected %s, found %s", op, p.peek())
+	}
+
+	return nil
+}
+
+var SQL_KEYWORDS = map[string]bool{
+	"select": true, "from": true, "where": true, "group": true, "order": true,
+	"by": true, "limit": true, "and": true, "or": true, "not": true, "as": true,
+	"asc": true, "desc": true, "in": true, "like": true, "between": true,
+	"distinct": true,
+}
+
+func (p *sqlParser) parseIdent() (string, error) {
+	tok := p.peek()
+	if tok.kind == sql_quoted_ident || (tok.kind == sql_ident && !SQL_KEYWORDS[strings.ToLower(tok.value)]) {
+		p.pos++
+		return tok.value, nil
+	}
+
+	return "", p.errorf(tok, "expected a column name, found %s", tok)
+}
+
+func (p *sqlParser) parseDuration() (int64, error) {
+	tok := p.next()
+	switch tok.kind {
+	case sql_duration:
+		i := len(tok.value) - 1
+		for ; i >= 0 && !(tok.value[i] >= '0' && tok.value[i] <= '9'); i-- {
+		}
+
+		num, err := strconv.ParseFloat(tok.value[:i+1], 64)
+		if err != nil {
+			return 0, p.errorf(tok, "bad duration %s", tok.value)
+		}
+
+		return int64(num * float64(SQL_DURATIONS[strings.ToLower(tok.value[i+1:])])), nil
+	case sql_number:
+		num, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return 0, p.errorf(tok, "bad number %s", tok.value)
+		}
+		return int64(num), nil
+	}
+
+	return 0, p.errorf(tok, "expected a duration like 1h, found %s", tok)
+}
+
+func (p *sqlParser) parseSelectItem() (sqlSelectItem, error) {
+	item := sqlSelectItem{}
+
+	if p.acceptOp("*") {
+		item.Star = true
+		return item, nil
+	}
+
+	tok := p.peek()
+	name, err := p.parseIdent()
+	if err != nil {
+		return item, err
+	}
+
+	if tok.kind == sql_ident && p.acceptOp("(") {
+		item.Func = strings.ToLower(name)
+
+		if item.Func == "time" {
+			bucket, err := p.parseDuration()
+			if err != nil {
+				return item, err
+			}
+			item.Col = strconv.FormatInt(bucket, 10)
+		} else if p.acceptOp("*") {
+			item.Star = true
+		} else if !p.isOp(")") {
+			item.Distinct = p.acceptKeyword("distinct")
+			if item.Col, err = p.parseIdent(); err != nil {
+				return item, err
+			}
+
+			// percentile(col, 95) is the same as p95(col)
+			if item.Func == "percentile" {
+				if err := p.expectOp(","); err != nil {
+					return item, err
+				}
+
+				num := p.next()
+				if num.kind != sql_number {
+					return item, p.errorf(num, "expected a percentile, found %s", num)
+				}
+				item.Func = "p" + num.value
+			}
+		}
+
+		if err := p.expectOp(")"); err != nil {
+			return item, err
+		}
+	} else {
+		item.Col = name
+	}
+
+	if p.acceptKeyword("as") {
+		if item.Alias, err = p.parseIdent(); err != nil {
+			return item, err
+		}
+	} else if p.peek().kind == sql_quoted_ident || (p.peek().kind == sql_ident && !SQL_KEYWORDS[strings.ToLower(p.peek().value)]) {
+		item.Alias, _ = p.parseIdent()
+	}
+
+	return item, nil
+}
+
+// {{{ WHERE
+func (p *sqlParser) parseOr() (*WhereExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	node := &WhereExpr{Op: "or", Children: []*WhereExpr{left}}
+	for p.acceptKeyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		node.Children = append(node.Children, right)
+	}
+
+	if len(node.Children) == 1 {
+		return left, nil
+	}
+
+	return node, nil
+}
+
+func (p *sqlParser) parseAnd() (*WhereExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	node := &WhereExpr{Op: "and", Children: []*WhereExpr{left}}
+	for p.acceptKeyword("and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		node.Children = append(node.Children, right)
+	}
+
+	if len(node.Children) == 1 {
+		return left, nil
+	}
+
+	return node, nil
+}
+
+func (p *sqlParser) parseNot() (*WhereExpr, error) {
+	if p.acceptKeyword("not") {
+		child, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		return &WhereExpr{Op: "not", Children: []*WhereExpr{child}}, nil
+	}
+
+	if p.acceptOp("(") {
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+
+		return node, nil
+	}
+
+	return p.parsePredicate()
+}
+
+// likeToRegex turns a LIKE pattern into an anchored regex
+func likeToRegex(pattern string) string {
+	var buf []string
+	for _, c := range pattern {
+		switch c {
+		case '%':
+			buf = append(buf, ".*")
+		case '_':
+			buf = append(buf, ".")
+		default:
+			buf = append(buf, regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return "^" + strings.Join(buf, "") + "$"
+}
+
+func negate(node *WhereExpr, not bool) *WhereExpr {
+	if not {
+		return &WhereExpr{Op: "not", Children: []*WhereExpr{node}}
+	}
+
+	return node
+}
+
+func (p *sqlParser) parsePredicate() (*WhereExpr, error) {
+	col, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+
+	not := p.acceptKeyword("not")
+	tok := p.peek()
+
+	switch {
+	case p.acceptKeyword("like"):
+		pattern := p.next()
+		if pattern.kind != sql_string {
+			return nil, p.errorf(pattern, "LIKE expects a string, found %s", pattern)
+		}
+
+		node := &WhereExpr{Op: "cmp", Col: col, CmpOp: "~", Value: likeToRegex(pattern.value)}
+		return negate(node, not), nil
+
+	case p.acceptKeyword("in"):
+		if err := p.expectOp("("); err != nil {
+			return nil, err
+		}
+
+		node := &WhereExpr{Op: "or"}
+		for {
+			val, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, &WhereExpr{Op: "cmp", Col: col, CmpOp: "=", Value: val})
+
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+
+		if len(node.Children) == 1 {
+			node = node.Children[0]
+		}
+
+		return negate(node, not), nil
+
+	case p.acceptKeyword("between"):
+		low, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("and"); err != nil {
+			return nil, err
+		}
+		high, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		node := &WhereExpr{Op: "and", Children: []*WhereExpr{
+			&WhereExpr{Op: "cmp", Col: col, CmpOp: ">=", Value: low},
+			&WhereExpr{Op: "cmp", Col: col, CmpOp: "<=", Value: high},
+		}}
+		return negate(node, not), nil
+	}
+
+	if not {
+		return nil, p.errorf(tok, "expected LIKE, IN or BETWEEN after NOT, found %s", tok)
+	}
+
+	op := p.next()
+	if op.kind != sql_op || !strings.Contains(" = == != <> < > <= >= ~ !~ ", " "+op.value+" ") {
+		return nil, p.errorf(op, "expected a comparison after %s, found %s", col, op)
+	}
+
+	val, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	return &WhereExpr{Op: "cmp", Col: col, CmpOp: op.value, Value: val}, nil
+}
+
+// NOW is used to evaluate now() in SQL queries, tests can override it
+var SQL_NOW = time.Now
+
+// parseValue reads a string or a numeric expression made of numbers,
+// durations and now(), joined by + and -
+func (p *sqlParser) parseValue() (string, error) {
+	tok := p.peek()
+	if tok.kind == sql_string {
+		p.pos++
+		return tok.value, nil
+	}
+
+	total := 0.0
+	sign := 1.0
+	for {
+		if p.acceptOp("-") {
+			sign = -sign
+			continue
+		}
+
+		tok := p.peek()
+		val := 0.0
+		switch {
+		case tok.kind == sql_number:
+			p.pos++
+			num, err := strconv.ParseFloat(tok.value, 64)
+			if err != nil {
+				return "", p.errorf(tok, "bad number %s", tok.value)
+			}
+			val = num
+		case tok.kind == sql_duration:
+			secs, err := p.parseDuration()
+			if err != nil {
+				return "", err
+			}
+			val = float64(secs)
+		case tok.kind == sql_ident && strings.ToLower(tok.value) == "now":
+			p.pos++
+			if err := p.expectOp("("); err != nil {
+				return "", err
+			}
+			if err := p.expectOp(")"); err != nil {
+				return "", err
+			}
+			val = float64(SQL_NOW().Unix())
+		case tok.kind == sql_ident && !SQL_KEYWORDS[strings.ToLower(tok.value)]:
+			// bare words are treated as strings
+			p.pos++
+			if sign < 0 || total != 0 {
+				return "", p.errorf(tok, "can't do arithmetic on %s", tok)
+			}
+			return tok.value, nil
+		default:
+			return "", p.errorf(tok, "expected a value, found %s", tok)
+		}
+
+		total += sign * val
+		sign = 1.0
+
+		if p.acceptOp("+") {
+			continue
+		}
+
+		if p.isOp("-") {
+			p.pos++
+			sign = -1.0
+			continue
+		}
+
+		break
+	}
+
+	if total == float64(int64(total)) {
+		return strconv.FormatInt(int64(total), 10), nil
+	}
+
+	return strconv.FormatFloat(total, 'g', -1, 64), nil
+}
+
+// }}} WHERE
+
+func (p *sqlParser) parse() (*sqlStatement, error) {
+	stmt := &sqlStatement{}
+
+	if err := p.expectKeyword("select"); err != nil {
+		return nil, err
+	}
+
+	for {
+		start := p.peek()
+		item, err := p.parseSelectItem()
+		if err != nil {
+			return nil, err
+		}
+
+		stmt.Items = append(stmt.Items, item)
+		stmt.ItemTokens = append(stmt.ItemTokens, start)
+
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+
+	if err := p.expectKeyword("from"); err != nil {
+		return nil, err
+	}
+
+	table, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Table = table
+
+	if p.acceptKeyword("where") {
+		if stmt.Where, err = p.parseOr(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("group") {
+		if err := p.expectKeyword("by"); err != nil {
+			return nil, err
+		}
+
+		for {
+			tok := p.peek()
+			col, err := p.parseIdent()
+			if err != nil {
+				return nil, err
+			}
+
+			if tok.kind == sql_ident && strings.ToLower(col) == "time" && p.acceptOp("(") {
+				if stmt.TimeBucket, err = p.parseDuration(); err != nil {
+					return nil, err
+				}
+				if err := p.expectOp(")"); err != nil {
+					return nil, err
+				}
+			} else {
+				stmt.Groups = append(stmt.Groups, col)
+			}
+
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+
+	if p.acceptKeyword("order") {
+		if err := p.expectKeyword("by"); err != nil {
+			return nil, err
+		}
+
+		stmt.OrderTok = p.peek()
+		if stmt.Order, err = p.parseSelectItem(); err != nil {
+			return nil, err
+		}
+
+		if p.acceptKeyword("asc") {
+			stmt.OrderAsc = true
+		} else {
+			p.acceptKeyword("desc")
+		}
+		stmt.HasOrder = true
+	}
+
+	if p.acceptKeyword("limit") {
+		tok := p.next()
+		limit, err := strconv.Atoi(tok.value)
+		if tok.kind != sql_number || err != nil || limit <= 0 {
+			return nil, p.errorf(tok, "LIMIT expects a positive number, found %s", tok)
+		}
+		stmt.Limit = limit
+	}
+
+	p.acceptOp(";")
+
+	if tok := p.peek(); tok.kind != sql_end {
+		return nil, p.errorf(tok, "unexpected %s", tok)
+	}
+
+	return stmt, nil
+}
+
+type sqlStatement struct {
+	Items      []sqlSelectItem
+	ItemTokens []sqlToken
+	Table      string
+	Where      *WhereExpr
+	Groups     []string
+	TimeBucket int64
+	Order      sqlSelectItem
+	OrderTok   sqlToken
+	OrderAsc   bool
+	HasOrder   bool
+	Limit      int
+}
+
+// }}} PARSER
+
+// {{{ LOWERING
+var PERCENTILE_FUNC = regexp.MustCompile(`^p[0-9]+(\.[0-9]+)?$`)
+
+// which sybil op each -int aggregate needs, hist can answer all of them.
+// sum, min, max and count(col) are exact -agg ops instead, see agg_ops.go
+func sqlAggregateOp(fn string) (string, bool) {
+	switch fn {
+	case "avg", "mean":
+		return "avg", true
+	case "median", "hist":
+		return HIST_STR, true
+	}
+
+	if PERCENTILE_FUNC.MatchString(fn) {
+		return HIST_STR, true
+	}
+
+	return "", false
+}
+
+func (p *sqlParser) lower(stmt *sqlStatement) (*SQLQuery, error) {
+	q := &SQLQuery{Table: stmt.Table, Where: stmt.Where, Limit: stmt.Limit, Op: "avg"}
+	q.Groups = stmt.Groups
+	q.TimeBucket = int(stmt.TimeBucket)
+
+	grouped := make(map[string]bool)
+	for _, g := range stmt.Groups {
+		grouped[g] = true
+	}
+
+	aliases := make(map[string]sqlSelectItem)
+	ints := make(map[string]bool)
+	stats := make(map[string]bool)
+	bare := make([]sqlToken, 0)
+	has_aggs := false
+	has_star := false
+	has_hist := false
+
+	for i, item := range stmt.Items {
+		tok := stmt.ItemTokens[i]
+		if item.Alias != "" {
+			aliases[item.Alias] = item
+		}
+
+		switch {
+		case item.Func == "":
+			if item.Star {
+				has_star = true
+				continue
+			}
+
+			if len(stmt.Groups) > 0 && !grouped[item.Col] {
+				return nil, p.errorf(tok, "%s must appear in GROUP BY", item.Col)
+			}
+
+			if len(stmt.Groups) == 0 {
+				bare = append(bare, tok)
+				q.SampleCols = append(q.SampleCols, item.Col)
+			}
+		case item.Func == "time":
+			bucket, _ := strconv.Atoi(item.Col)
+			if q.TimeBucket != 0 && q.TimeBucket != bucket {
+				return nil, p.errorf(tok, "time(...) doesn't match the GROUP BY time bucket")
+			}
+			q.TimeBucket = bucket
+		case item.Func == "count" && item.Distinct:
+			if len(stmt.Groups) > 0 {
+				return nil, p.errorf(tok, "count(DISTINCT ...) can't be used with GROUP BY")
+			}
+			q.Op = DISTINCT_STR
+			q.Groups = append(q.Groups, item.Col)
+			has_aggs = true
+		case item.Func == OP_COUNT && (item.Star || item.Col == ""):
+			has_aggs = true
+		case isStatsOp(item.Func):
+			if item.Star || item.Col == "" {
+				return nil, p.errorf(tok, "%s needs a column", item.Func)
+			}
+
+			has_aggs = true
+			agg := fmt.Sprintf("%s(%s)", item.Func, item.Col)
+			if !stats[agg] {
+				stats[agg] = true
+				q.Aggs = append(q.Aggs, agg)
+			}
+		default:
+			op, ok := sqlAggregateOp(item.Func)
+			if !ok {
+				return nil, p.errorf(tok, "unsupported function %s", item.Func)
+			}
+			if item.Star || item.Col == "" {
+				return nil, p.errorf(tok, "%s needs a column", item.Func)
+			}
+
+			has_aggs = true
+			if op == HIST_STR {
+				has_hist = true
+			}
+
+			if !ints[item.Col] {
+				ints[item.Col] = true
+				q.Ints = append(q.Ints, item.Col)
+			}
+		}
+	}
+
+	if has_hist {
+		if q.Op == DISTINCT_STR {
+			return nil, p.errorf(stmt.ItemTokens[0], "count(DISTINCT ...) can't be combined with other aggregates")
+		}
+		q.Op = HIST_STR
+	}
+
+	if q.Op == DISTINCT_STR && (len(q.Ints) > 0 || len(q.Aggs) > 0) {
+		return nil, p.errorf(stmt.ItemTokens[0], "count(DISTINCT ...) can't be combined with other aggregates")
+	}
+
+	// without aggregates or a GROUP BY, the query returns raw records
+	if !has_aggs && len(stmt.Groups) == 0 && q.TimeBucket == 0 {
+		q.Samples = true
+		if has_star {
+			q.SampleCols = nil
+		}
+	} else if len(bare) > 0 {
+		return nil, p.errorf(bare[0], "%s must appear in GROUP BY or be aggregated", q.SampleCols[0])
+	} else if has_star {
+		return nil, p.errorf(stmt.ItemTokens[0], "* can only be used without aggregates or GROUP BY")
+	}
+
+	if stmt.HasOrder {
+		order := stmt.Order
+		if aliased, ok := aliases[order.Col]; ok && order.Func == "" {
+			order = aliased
+		}
+
+		switch {
+		case order.Func == OP_COUNT && (order.Star || order.Col == ""):
+			q.OrderBy = SORT_COUNT
+		case order.Func == "" && strings.ToLower(order.Col) == "count":
+			q.OrderBy = SORT_COUNT
+		case order.Func == "" && len(q.Groups) > 0 && order.Col == q.Groups[0]:
+			q.OrderBy = SORT_KEY
+		case order.Func == "" && ints[order.Col]:
+			q.OrderBy = order.Col
+		case isStatsOp(order.Func) && stats[fmt.Sprintf("%s(%s)", order.Func, order.Col)]:
+			q.OrderBy = fmt.Sprintf("%s(%s)", order.Func, order.Col)
+		case order.Func == "median" && ints[order.Col]:
+			q.OrderBy = fmt.Sprintf("p50(%s)", order.Col)
+		case order.Func != OP_AVG && order.Func != OP_HIST && isValidAggOp(order.Func) && ints[order.Col]:
+			q.OrderBy = fmt.Sprintf("%s(%s)", order.Func, order.Col)
+		case order.Func != "" && ints[order.Col]:
+			q.OrderBy = order.Col
+		default:
+			return nil, p.errorf(stmt.OrderTok, "can only ORDER BY count, the first GROUP BY column or an aggregated column")
+		}
+
+		if stmt.OrderAsc {
+			q.OrderBy += " asc"
+		}
+
+		if q.Samples {
+			return nil, p.errorf(stmt.OrderTok, "ORDER BY needs an aggregate")
+		}
+	}
+
+	return q, nil
+}
+
+// }}} LOWERING
+
+// ParseSQL parses and validates a query, errors are *SQLError
+func ParseSQL(query string) (*SQLQuery, error) {
+	tokens, err := tokenizeSQL(query)
+	if err != nil {
+		return nil, err
+	}
+
+	p := sqlParser{tokens: tokens}
+	stmt, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.lower(stmt)
+}
+
+// SetFlags points the query FLAGS at this query
+func (q *SQLQuery) SetFlags() {
+	FLAGS.TABLE = q.Table
+	FLAGS.GROUPS = strings.Join(q.Groups, FLAGS.FIELD_SEPARATOR)
+	FLAGS.INTS = strings.Join(q.Ints, FLAGS.FIELD_SEPARATOR)
+	FLAGS.AGGS = strings.Join(q.Aggs, FLAGS.FIELD_SEPARATOR)
+	FLAGS.OP = q.Op
+
+	if q.Where != nil {
+		if FLAGS.WHERE != "" {
+			FLAGS.WHERE = fmt.Sprintf("(%s) and %s", FLAGS.WHERE, q.Where.String())
+		} else {
+			FLAGS.WHERE = q.Where.String()
+		}
+	}
+
+	if q.TimeBucket > 0 {
+		FLAGS.TIME = true
+		FLAGS.TIME_BUCKET = q.TimeBucket
+		FLAGS.TIME_CALENDAR = ""
+	}
+
+	if q.Samples {
+		FLAGS.SAMPLES = true
+		FLAGS.SAMPLE_COLS = strings.Join(q.SampleCols, FLAGS.FIELD_SEPARATOR)
+	}
+
+	if q.OrderBy != "" {
+		FLAGS.SORT = q.OrderBy
+	}
+
+	if q.Limit > 0 {
+		FLAGS.LIMIT = q.Limit
+	}
+}
+
+// }}} SQL
@@ -0,0 +1,112 @@
+package sybil
+
+import "strings"
+import "testing"
+import "time"
+
+func TestParseSQL(t *testing.T) {
+	SQL_NOW = func() time.Time { return time.Unix(100000, 0) }
+	defer func() { SQL_NOW = time.Now }()
+
+	q, err := ParseSQL("SELECT host, count(*), p99(latency), avg(latency) AS lat FROM requests " +
+		"WHERE time > now()-1h and (status IN (500, 503) OR host NOT LIKE 'can%') " +
+		"GROUP BY host ORDER BY lat DESC LIMIT 20;")
+	if err != nil {
+		t.Fatal("COULDNT PARSE SQL", err)
+	}
+
+	if q.Table != "requests" || strings.Join(q.Groups, ",") != "host" || strings.Join(q.Ints, ",") != "latency" {
+		t.Error("SQL PARSED INTO WRONG COLUMNS", q.Table, q.Groups, q.Ints)
+	}
+
+	if q.Op != HIST_STR || q.OrderBy != "latency" || q.Limit != 20 || q.Samples {
+		t.Error("SQL PARSED INTO WRONG QUERY", q.Op, q.OrderBy, q.Limit, q.Samples)
+	}
+
+	where := q.Where.String()
+	expected := "(time > 96400 and ((status = 500 or status = 503) or not (host ~ ^can.*$)))"
+	if where != expected {
+		t.Error("SQL WHERE LOWERED WRONG", where, "EXPECTED", expected)
+	}
+
+	// the lowered expression has to survive the trip through -where
+	reparsed, err := ParseWhere(where)
+	if err != nil || reparsed.String() != where {
+		t.Error("SQL WHERE DOESNT ROUND TRIP", where, err)
+	}
+}
+
+func TestParseSQLQueryTypes(t *testing.T) {
+	q, err := ParseSQL("select * from t where age between 10 and 20 limit 5")
+	if err != nil || !q.Samples || len(q.SampleCols) != 0 || q.Limit != 5 {
+		t.Error("SELECT * SHOULD BE A SAMPLES QUERY", q, err)
+	}
+
+	q, err = ParseSQL("select a, b from t")
+	if err != nil || !q.Samples || strings.Join(q.SampleCols, ",") != "a,b" {
+		t.Error("SELECT WITHOUT AGGREGATES SHOULD BE A SAMPLES QUERY", q, err)
+	}
+
+	q, err = ParseSQL("select count(distinct host) from t")
+	if err != nil || q.Op != DISTINCT_STR || strings.Join(q.Groups, ",") != "host" {
+		t.Error("COUNT DISTINCT PARSED WRONG", q, err)
+	}
+
+	q, err = ParseSQL("select host, sum(bytes) from t group by host")
+	if err != nil || strings.Join(q.Aggs, ",") != "sum(bytes)" || len(q.Ints) != 0 {
+		t.Error("SUM PARSED WRONG", q, err)
+	}
+
+	// min, max and count(col) are exact -agg ops like sum, not hists
+	q, err = ParseSQL("select host, min(age), max(age), count(age), count(*) from t group by host order by max(age)")
+	if err != nil || strings.Join(q.Aggs, ",") != "min(age),max(age),count(age)" || len(q.Ints) != 0 || q.Op != "avg" || q.OrderBy != "max(age)" {
+		t.Error("MIN AND MAX PARSED WRONG", q, err)
+	}
+
+	q, err = ParseSQL("select host, count(age) from t group by host order by count(*)")
+	if err != nil || strings.Join(q.Aggs, ",") != "count(age)" || q.OrderBy != SORT_COUNT {
+		t.Error("COUNT OF A COLUMN PARSED WRONG", q, err)
+	}
+
+	q, err = ParseSQL("select host, p99(age) from t group by host order by p99(age) asc")
+	if err != nil || q.OrderBy != "p99(age) asc" {
+		t.Error("ORDER BY PERCENTILE PARSED WRONG", q, err)
+	}
+
+	q, err = ParseSQL("select host, count(*) from t group by host order by host asc")
+	if err != nil || q.OrderBy != SORT_KEY+" asc" {
+		t.Error("ORDER BY GROUP PARSED WRONG", q, err)
+	}
+
+	q, err = ParseSQL("select time(1h), avg(age) from t group by time(1h) order by count")
+	if err != nil || q.TimeBucket != 3600 || q.Op != "avg" || q.OrderBy != SORT_COUNT {
+		t.Error("TIME SERIES PARSED WRONG", q, err)
+	}
+}
+
+func TestSQLErrors(t *testing.T) {
+	bad := map[string]int{
+		"select host from t group host":               25,
+		"select host, count(*) from t group by state": 7,
+		"select stddev(age) from t":                   7,
+		"select count(*) from t where age >":          34,
+		"select count(*) from t limit 0":              29,
+		"select count(*) from t order by age":         32,
+		"select avg(age) from t order by size asc":    32,
+		"select 'a from t":                            7,
+		"select count(*) from":                        20,
+	}
+
+	for query, pos := range bad {
+		_, err := ParseSQL(query)
+		sql_err, ok := err.(*SQLError)
+		if !ok {
+			t.Error("EXPECTED SQL ERROR FOR", query, err)
+			continue
+		}
+
+		if sql_err.Pos != pos {
+			t.Error("SQL ERROR AT WRONG POSITION", query, sql_err)
+		}
+	}
+}
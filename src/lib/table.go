@@ -23,6 +23,8 @@ type Table struct {
 	StrInfo StrInfoTable
 	IntInfo IntInfoTable
 
+	FloatInfo FloatInfoTable
+
 	BlockInfoCache map[string]*SavedColumnInfo
 	NewBlockInfos  []string
 
@@ -32,8 +34,12 @@ type Table struct {
 	key_string_id_lookup map[int16]string
 	val_string_id_lookup map[int32]string
 
-	// This is used for join tables
+	// This is used for join tables, see join.go
 	join_lookup map[string]*Record
+	join        *lookupJoin
+
+	// query time columns, see computed.go
+	computed []*ComputedColumn
 
 	string_id_m *sync.RWMutex
 	record_m    *sync.Mutex
@@ -85,6 +91,7 @@ func (t *Table) init_data_structures() {
 
 	t.StrInfo = make(StrInfoTable)
 	t.IntInfo = make(IntInfoTable)
+	t.FloatInfo = make(FloatInfoTable)
 
 	t.LastBlock = newTableBlock()
 	t.LastBlock.RecordList = t.newRecords
@@ -197,6 +204,12 @@ func (t *Table) PrintRecord(r *Record) {
 			Print("  ", name, col.get_string_for_key(name), col.get_string_for_val(int32(val)))
 		}
 	}
+	for name, val := range r.Floats {
+		if r.Populated[name] == FLOAT_VAL {
+			col := r.block.GetColumnInfo(int16(name))
+			Print("  ", name, col.get_string_for_key(name), val)
+		}
+	}
 	for name, vals := range r.SetMap {
 		if r.Populated[name] == SET_VAL {
 			col := r.block.GetColumnInfo(int16(name))
@@ -10,8 +10,9 @@ type TableBlock struct {
 	Size       int64
 	Matched    RecordList
 
-	IntInfo IntInfoTable
-	StrInfo StrInfoTable
+	IntInfo   IntInfoTable
+	StrInfo   StrInfoTable
+	FloatInfo FloatInfoTable
 
 	table       *Table
 	string_id_m *sync.Mutex
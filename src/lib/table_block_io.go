@@ -117,7 +117,7 @@ func (t *Table) ShouldLoadBlockFromDir(dirname string, querySpec *QuerySpec) boo
 	max_record := Record{Ints: IntArr{}, Strs: StrArr{}}
 	min_record := Record{Ints: IntArr{}, Strs: StrArr{}}
 
-	if len(info.IntInfoMap) == 0 {
+	if len(info.IntInfoMap) == 0 && len(info.FloatInfoMap) == 0 {
 		return true
 	}
 
@@ -150,35 +150,104 @@ func (t *Table) ShouldLoadBlockFromDir(dirname string, querySpec *QuerySpec) boo
 		max_record.Populated[field_id] = INT_VAL
 	}
 
+	for field_name, field_info := range info.FloatInfoMap {
+		t.string_id_m.Lock()
+		field_id, ok := t.KeyTable[field_name]
+		t.string_id_m.Unlock()
+		if !ok {
+			continue
+		}
+
+		min_record.ResizeFields(field_id)
+		max_record.ResizeFields(field_id)
+
+		min_record.Floats[field_id] = FloatField(field_info.Min)
+		max_record.Floats[field_id] = FloatField(field_info.Max)
+
+		min_record.Populated[field_id] = FLOAT_VAL
+		max_record.Populated[field_id] = FLOAT_VAL
+	}
+
 	add := true
 	for _, f := range querySpec.Filters {
-		// make the minima record and the maxima records...
-		switch fil := f.(type) {
-		case IntFilter:
-			if fil.Op == "gt" || fil.Op == "lt" {
-				if f.Filter(&min_record) != true && f.Filter(&max_record) != true {
-					add = false
-					break
-				}
+		if !block_may_match(f, &min_record, &max_record) {
+			add = false
+			break
+		}
+	}
+
+	return add
+}
+
+// block_may_match checks a filter against the minima and maxima records of a
+// block, it returns false only if no record in the block can match
+func block_may_match(f Filter, min_record *Record, max_record *Record) bool {
+	switch fil := f.(type) {
+	case IntFilter:
+		// joined and computed columns aren't in any block
+		if fil.table.isVirtualColumn(fil.FieldId) {
+			return true
+		}
+		// the column isn't in this block, so none of its records can match
+		if len(min_record.Populated) <= int(fil.FieldId) {
+			return false
+		}
+		if fil.Op == "gt" || fil.Op == "lt" || fil.Op == "gte" || fil.Op == "lte" {
+			if f.Filter(min_record) != true && f.Filter(max_record) != true {
+				return false
+			}
+		}
+		if fil.Op == "eq" {
+			if min_record.Populated[fil.FieldId] != INT_VAL {
+				return false
+			}
+
+			if int(min_record.Ints[fil.FieldId]) > fil.Value ||
+				int(max_record.Ints[fil.FieldId]) < fil.Value {
+				return false
+			}
+
+		}
+	case FloatFilter:
+		if fil.table.isVirtualColumn(fil.FieldId) {
+			return true
+		}
+		if len(min_record.Populated) <= int(fil.FieldId) {
+			return false
+		}
+		if fil.Op == "gt" || fil.Op == "lt" || fil.Op == "gte" || fil.Op == "lte" {
+			if f.Filter(min_record) != true && f.Filter(max_record) != true {
+				return false
+			}
+		}
+		if fil.Op == "eq" {
+			if min_record.Populated[fil.FieldId] != FLOAT_VAL {
+				return false
 			}
-			if fil.Op == "eq" {
-				if len(min_record.Populated) <= int(fil.FieldId) ||
-					min_record.Populated[fil.FieldId] != INT_VAL {
-					add = false
-					break
-				}
-
-				if int(min_record.Ints[fil.FieldId]) > fil.Value ||
-					int(max_record.Ints[fil.FieldId]) < fil.Value {
-					add = false
-					break
-				}
 
+			if float64(min_record.Floats[fil.FieldId]) > fil.Value ||
+				float64(max_record.Floats[fil.FieldId]) < fil.Value {
+				return false
+			}
+		}
+	case AndFilter:
+		for _, child := range fil.Filters {
+			if !block_may_match(child, min_record, max_record) {
+				return false
 			}
 		}
+	case OrFilter:
+		for _, child := range fil.Filters {
+			if block_may_match(child, min_record, max_record) {
+				return true
+			}
+		}
+
+		return len(fil.Filters) == 0
 	}
 
-	return add
+	// NOT and string / set filters can't be checked against the block extents
+	return true
 }
 
 func (t *Table) LoadBlockInfo(dirname string) *SavedColumnInfo {
@@ -291,6 +360,8 @@ func (t *Table) LoadBlockFromDir(dirname string, loadSpec *LoadSpec, load_record
 			err = tb.unpackSetCol(dec, *info)
 		case strings.HasPrefix(fname, "int"):
 			err = tb.unpackIntCol(dec, *info)
+		case strings.HasPrefix(fname, "float"):
+			err = tb.unpackFloatCol(dec, *info)
 		}
 
 		dec.CloseFile()
@@ -319,7 +390,7 @@ type AfterLoadQueryCB struct {
 
 func (cb *AfterLoadQueryCB) CB(digestname string, records RecordList) {
 	if digestname == NO_MORE_BLOCKS {
-		// TODO: add sessionization call over here, too
+		// this also collects the records' session events, see sessions.go
 		count := FilterAndAggRecords(cb.querySpec, &cb.records)
 		cb.count += count
 
@@ -5,7 +5,7 @@ import "sync"
 type TableColumn struct {
 	Type        int8
 	StringTable map[string]int32
-	RCache      map[int]bool
+	RCache      map[string]map[int]bool // regex matches of each value, by pattern
 
 	block *TableBlock
 
@@ -19,7 +19,7 @@ func (tb *TableBlock) newTableColumn() *TableColumn {
 	tc.val_string_id_lookup = make([]string, CHUNK_SIZE+1)
 	tc.string_id_m = &sync.Mutex{}
 	tc.block = tb
-	tc.RCache = make(map[int]bool)
+	tc.RCache = make(map[string]map[int]bool)
 
 	return &tc
 }
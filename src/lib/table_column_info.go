@@ -4,11 +4,11 @@ import "sort"
 import "math"
 
 // THIS FILE HAS BOOKKEEPING FOR COLUMN DATA ON A TABLE AND BLOCK BASIS
-// it adds update_int_info and update_str_info to Table/TableBlock
+// it adds update_int_info, update_float_info and update_str_info to Table/TableBlock
 
 // TODO: collapse the IntInfo and StrInfo into fields on tableColumn
 
-// StrInfo and IntInfo contains interesting tidbits about columns
+// StrInfo, IntInfo and FloatInfo contain interesting tidbits about columns
 // they also get serialized to disk in the block's info.db
 type StrInfo struct {
 	TopStringCount map[int32]int
@@ -23,7 +23,17 @@ type IntInfo struct {
 	Count int
 }
 
+// FloatInfo is the same bookkeeping as IntInfo, but for FLOAT_VAL columns
+type FloatInfo struct {
+	Min   float64
+	Max   float64
+	Avg   float64
+	M2    float64
+	Count int
+}
+
 type IntInfoTable map[int16]*IntInfo
+type FloatInfoTable map[int16]*FloatInfo
 type StrInfoTable map[int16]*StrInfo
 
 var TOP_STRING_COUNT = 20
@@ -130,10 +140,63 @@ func update_int_info(int_info_table map[int16]*IntInfo, name int16, val int64) {
 	info.Count++
 }
 
+func update_float_info(float_info_table map[int16]*FloatInfo, name int16, val float64) {
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		return
+	}
+
+	info, ok := float_info_table[name]
+	if !ok {
+		info = &FloatInfo{}
+		float_info_table[name] = info
+		info.Max = val
+		info.Min = val
+		info.Avg = val
+		info.Count = 1
+	}
+
+	delta := val - info.Avg
+	stddev := info.M2 / float64(info.Count-1)
+	if stddev <= 1 {
+		stddev = math.Max(math.Abs(info.Avg), 1.0) // assume large standard deviation early on
+	}
+
+	// same outlier rules as update_int_info
+	ignored := false
+	if info.Max < val || info.Min > val {
+		delta_in_stddev := math.Abs(delta) / stddev
+
+		if (delta_in_stddev < STD_CUTOFF && info.Count > MIN_CUTOFF) || FLAGS.SKIP_OUTLIERS == false {
+			info.Max = math.Max(info.Max, val)
+			info.Min = math.Min(info.Min, val)
+		} else {
+			ignored = true
+
+			if info.Count > MIN_CUTOFF {
+				Debug("IGNORING FLOAT VALUE", val, "AVG IS", info.Avg, "DELTA / STD", delta_in_stddev)
+			}
+		}
+	}
+
+	if ignored == false || info.Count < MIN_CUTOFF {
+		info.Avg = info.Avg + delta/float64(info.Count)
+		info.M2 = info.M2 + delta*(val-info.Avg)
+	}
+	info.Count++
+}
+
 func (t *Table) update_int_info(name int16, val int64) {
 	update_int_info(t.IntInfo, name, val)
 }
 
+func (t *Table) update_float_info(name int16, val float64) {
+	if t.FloatInfo == nil {
+		t.FloatInfo = make(FloatInfoTable)
+	}
+
+	update_float_info(t.FloatInfo, name, val)
+}
+
 func (tb *TableBlock) update_str_info(name int16, val int, increment int) {
 	if tb.StrInfo == nil {
 		tb.StrInfo = make(map[int16]*StrInfo)
@@ -150,6 +213,14 @@ func (tb *TableBlock) update_int_info(name int16, val int64) {
 	update_int_info(tb.IntInfo, name, val)
 }
 
+func (tb *TableBlock) update_float_info(name int16, val float64) {
+	if tb.FloatInfo == nil {
+		tb.FloatInfo = make(FloatInfoTable)
+	}
+
+	update_float_info(tb.FloatInfo, name, val)
+}
+
 func (t *Table) get_int_info(name int16) *IntInfo {
 	return t.IntInfo[name]
 
@@ -162,3 +233,7 @@ func (tb *TableBlock) get_int_info(name int16) *IntInfo {
 func (tb *TableBlock) get_str_info(name int16) *StrInfo {
 	return tb.StrInfo[name]
 }
+
+func (t *Table) get_float_info(name int16) *FloatInfo {
+	return t.FloatInfo[name]
+}
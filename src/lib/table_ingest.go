@@ -161,6 +161,13 @@ func (t *Table) LoadRowStoreRecords(digest string, after_block_load_cb AfterRowB
 		t.RowBlock.Name = ROW_STORE_BLOCK
 	}
 
+	// digestion reads a renamed copy of the ingest dir and has to see every
+	// file, only queries get to skip old log files
+	start, has_start := rowStoreStartBound()
+	if digest != INGEST_DIR {
+		has_start = false
+	}
+
 	for _, file := range files {
 		filename := file.Name()
 
@@ -173,6 +180,11 @@ func (t *Table) LoadRowStoreRecords(digest string, after_block_load_cb AfterRowB
 			continue
 		}
 
+		if has_start && file.ModTime().Unix() < start {
+			Debug("SKIPPING ROW STORE FILE", filename, "WRITTEN BEFORE", start)
+			continue
+		}
+
 		filename = path.Join(dirname, file.Name())
 
 		records := t.LoadRecordsFromLog(filename)
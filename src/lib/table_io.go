@@ -71,10 +71,11 @@ func (t *Table) SaveTableInfo(fname string) {
 
 func getSaveTable(t *Table) *Table {
 	return &Table{Name: t.Name,
-		KeyTable: t.KeyTable,
-		KeyTypes: t.KeyTypes,
-		IntInfo:  t.IntInfo,
-		StrInfo:  t.StrInfo}
+		KeyTable:  t.KeyTable,
+		KeyTypes:  t.KeyTypes,
+		IntInfo:   t.IntInfo,
+		StrInfo:   t.StrInfo,
+		FloatInfo: t.FloatInfo}
 }
 
 func (t *Table) saveRecordList(records RecordList) bool {
@@ -177,6 +178,12 @@ func (t *Table) LoadTableInfoFrom(filename string) bool {
 		if saved_table.StrInfo != nil {
 			t.StrInfo = saved_table.StrInfo
 		}
+		if saved_table.FloatInfo != nil {
+			t.FloatInfo = saved_table.FloatInfo
+		}
+
+		t.addJoinedColumns()
+		t.addComputedColumns()
 	}
 
 	// If we are recovering the INFO lock, we won't necessarily have
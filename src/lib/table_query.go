@@ -29,6 +29,9 @@ func (t *Table) LoadAndQueryRecords(loadSpec *LoadSpec, querySpec *QuerySpec) in
 	if querySpec != nil {
 
 		querySpec.Table = t
+		// computed columns change the results of the same blocks, so their
+		// expressions go into the query cache key
+		querySpec.Computed = t.computedSpecs()
 	}
 
 	// Load and setup our OPTS.STR_REPLACEMENTS
@@ -60,6 +63,8 @@ func (t *Table) LoadAndQueryRecords(loadSpec *LoadSpec, querySpec *QuerySpec) in
 			return 0
 		}
 	}
+	t.prepareJoin()
+	t.prepareComputed()
 
 	if FLAGS.UPDATE_TABLE_INFO {
 		Debug("RESETTING TABLE INFO FOR OVERWRITING")
@@ -411,8 +416,13 @@ func (t *Table) LoadAndQueryRecords(loadSpec *LoadSpec, querySpec *QuerySpec) in
 		querySpec.Results = resultSpec.Results
 		querySpec.TimeResults = resultSpec.TimeResults
 		querySpec.MatchedCount = count + cached_count
+		querySpec.Dropped = resultSpec.Dropped
+		querySpec.DroppedGroups = resultSpec.DroppedGroups
+		querySpec.HeavyHitterFloor = resultSpec.HeavyHitterFloor
+		querySpec.LuaResult = resultSpec.LuaResult
+		querySpec.SessionEvents = resultSpec.SessionEvents
 
-		querySpec.SortResults(querySpec.OrderBy)
+		querySpec.FinishResults()
 	}
 
 	t.WriteBlockCache()
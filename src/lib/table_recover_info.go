@@ -56,6 +56,23 @@ func (t *Table) ReadBlockInfoFromDir(dirname string) *SavedColumnInfo {
 		case strings.HasPrefix(col_name, "set"):
 			col_name = strings.Replace(col_name, "set_", "", 1)
 			col_type = SET_VAL
+		case strings.HasPrefix(col_name, "float"):
+			col_name = strings.Replace(col_name, "float_", "", 1)
+			col_type = FLOAT_VAL
+
+			col_info := info.FloatInfoMap[col_name]
+			col_id := t.get_key_id(col_name)
+			float_info, ok := t.FloatInfo[col_id]
+			if !ok {
+				t.FloatInfo[col_id] = col_info
+			} else if col_info != nil {
+				if col_info.Min < float_info.Min {
+					float_info.Min = col_info.Min
+				}
+				if col_info.Max > float_info.Max {
+					float_info.Max = col_info.Max
+				}
+			}
 		case strings.HasPrefix(col_name, "int"):
 			col_name = strings.Replace(col_name, "int_", "", 1)
 			col_type = INT_VAL
@@ -132,6 +149,13 @@ func (t *Table) DeduceTableInfoFromBlocks() {
 					}
 					type_counts[col][INT_VAL]++
 				}
+				for col := range info.FloatInfoMap {
+					_, ok := type_counts[col]
+					if !ok {
+						type_counts[col] = make(map[int]int)
+					}
+					type_counts[col][FLOAT_VAL]++
+				}
 				for col := range info.StrInfoMap {
 					_, ok := type_counts[col]
 					if !ok {
@@ -0,0 +1,159 @@
+package sybil
+
+import "fmt"
+import "strconv"
+import "strings"
+import "sync"
+import "time"
+
+// {{{ TIME BUCKETS
+// -time-bucket takes either a fixed width (3600 or 1h), which is aligned to
+// the epoch, or a calendar unit (day, week or month), which is aligned to
+// midnight in the -tz time zone. Weeks start on Monday. Calendar buckets
+// keep track of DST changes and months of different lengths.
+
+const (
+	CALENDAR_DAY   = "day"
+	CALENDAR_WEEK  = "week"
+	CALENDAR_MONTH = "month"
+)
+
+// the nominal width of each calendar bucket, for anything that only needs
+// an idea of how big the buckets are
+var CALENDAR_BUCKETS = map[string]int{
+	CALENDAR_DAY:   60 * 60 * 24,
+	CALENDAR_WEEK:  60 * 60 * 24 * 7,
+	CALENDAR_MONTH: 60 * 60 * 24 * 30,
+}
+
+// ParseTimeBucket parses a -time-bucket value into its width in seconds and
+// its calendar unit (which is empty for fixed width buckets)
+func ParseTimeBucket(val string) (int, string, error) {
+	val = strings.ToLower(strings.TrimSpace(val))
+	if width, ok := CALENDAR_BUCKETS[val]; ok {
+		return width, val, nil
+	}
+
+	width, err := strconv.Atoi(val)
+	if err != nil {
+		var secs int64
+		secs, err = parseTimeOffset(val)
+		width = int(secs)
+	}
+
+	if err != nil || width <= 0 {
+		return 0, "", fmt.Errorf("invalid time bucket %q, expected seconds, a duration like 6h or one of day, week or month", val)
+	}
+
+	return width, "", nil
+}
+
+var time_zones = make(map[string]*time.Location)
+var time_zones_m = &sync.Mutex{}
+
+// LoadTimeZone looks up (and caches) a -tz name. An empty name is the local
+// time zone, which is what time results have always been printed in
+func LoadTimeZone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+
+	time_zones_m.Lock()
+	defer time_zones_m.Unlock()
+
+	if loc, ok := time_zones[name]; ok {
+		return loc, nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+
+	time_zones[name] = loc
+	return loc, nil
+}
+
+func mustLoadTimeZone(name string) *time.Location {
+	loc, err := LoadTimeZone(name)
+	if err != nil {
+		Warn("UNKNOWN TIME ZONE", name, "USING LOCAL TIME")
+		return time.Local
+	}
+
+	return loc
+}
+
+// returns the start of the calendar bucket holding val and the start of the
+// bucket after it
+func calendarBucket(val int64, calendar string, loc *time.Location) (int64, int64) {
+	t := time.Unix(val, 0).In(loc)
+	year, month, day := t.Date()
+
+	var start time.Time
+	var next time.Time
+	switch calendar {
+	case CALENDAR_WEEK:
+		// Go's weeks start on Sunday, ours start on Monday
+		offset := (int(t.Weekday()) + 6) % 7
+		start = time.Date(year, month, day-offset, 0, 0, 0, 0, loc)
+		next = time.Date(year, month, day-offset+7, 0, 0, 0, 0, loc)
+	case CALENDAR_MONTH:
+		start = time.Date(year, month, 1, 0, 0, 0, 0, loc)
+		next = time.Date(year, month+1, 1, 0, 0, 0, 0, loc)
+	default:
+		start = time.Date(year, month, day, 0, 0, 0, 0, loc)
+		next = time.Date(year, month, day+1, 0, 0, 0, 0, loc)
+	}
+
+	return start.Unix(), next.Unix()
+}
+
+// timeBucketer finds the time bucket for a timestamp. Records tend to be
+// sorted by time inside a block, so the last calendar bucket is remembered to
+// avoid a time zone conversion for every record.
+type timeBucketer struct {
+	width    int64
+	calendar string
+	loc      *time.Location
+
+	start int64
+	next  int64
+}
+
+func newTimeBucketer(width int, calendar string, tz string) *timeBucketer {
+	b := &timeBucketer{width: int64(width), calendar: calendar}
+	if calendar != "" {
+		b.loc = mustLoadTimeZone(tz)
+	}
+
+	return b
+}
+
+func (b *timeBucketer) bucket(val int64) int64 {
+	if b.calendar == "" {
+		return val / b.width * b.width
+	}
+
+	if val < b.start || val >= b.next {
+		b.start, b.next = calendarBucket(val, b.calendar, b.loc)
+	}
+
+	return b.start
+}
+
+// returns the start of the bucket after the one starting at bucket
+func (b *timeBucketer) next_bucket(bucket int64) int64 {
+	if b.calendar == "" {
+		return bucket + b.width
+	}
+
+	_, next := calendarBucket(bucket, b.calendar, b.loc)
+	return next
+}
+
+func (qp *QueryParams) newTimeBucketer() *timeBucketer {
+	return newTimeBucketer(qp.TimeBucket, qp.TimeCalendar, qp.TimeZone)
+}
+
+// }}} TIME BUCKETS
@@ -0,0 +1,122 @@
+package sybil
+
+import "testing"
+import "time"
+
+func TestParseTimeBucket(t *testing.T) {
+	good := map[string]int{"3600": 3600, "6h": 6 * 3600, "day": 86400, "Week": 7 * 86400}
+	for val, expected := range good {
+		width, _, err := ParseTimeBucket(val)
+		if err != nil || width != expected {
+			t.Error("PARSED TIME BUCKET WRONG", val, width, err)
+		}
+	}
+
+	if _, calendar, _ := ParseTimeBucket("month"); calendar != CALENDAR_MONTH {
+		t.Error("MONTH SHOULD BE A CALENDAR BUCKET", calendar)
+	}
+
+	for _, val := range []string{"", "0", "-60", "fortnight"} {
+		if _, _, err := ParseTimeBucket(val); err == nil {
+			t.Error("EXPECTED AN ERROR FOR TIME BUCKET", val)
+		}
+	}
+}
+
+func TestCalendarBuckets(t *testing.T) {
+	loc, err := LoadTimeZone("America/New_York")
+	if err != nil {
+		t.Skip("NO TIME ZONE DATA", err)
+		return
+	}
+
+	at := func(value string) int64 {
+		ts, err := time.ParseInLocation("2006-01-02 15:04", value, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts.Unix()
+	}
+
+	// 2017-03-12 is 23 hours long in New York, and 2017-03-15 is a Wednesday
+	tests := []struct {
+		calendar string
+		val      string
+		start    string
+		next     string
+	}{
+		{CALENDAR_DAY, "2017-03-12 22:30", "2017-03-12 00:00", "2017-03-13 00:00"},
+		{CALENDAR_DAY, "2017-03-13 00:00", "2017-03-13 00:00", "2017-03-14 00:00"},
+		{CALENDAR_WEEK, "2017-03-15 12:00", "2017-03-13 00:00", "2017-03-20 00:00"},
+		{CALENDAR_WEEK, "2017-03-19 23:59", "2017-03-13 00:00", "2017-03-20 00:00"},
+		{CALENDAR_MONTH, "2017-02-28 23:00", "2017-02-01 00:00", "2017-03-01 00:00"},
+		{CALENDAR_MONTH, "2017-12-31 23:00", "2017-12-01 00:00", "2018-01-01 00:00"},
+	}
+
+	for _, test := range tests {
+		start, next := calendarBucket(at(test.val), test.calendar, loc)
+		if start != at(test.start) || next != at(test.next) {
+			t.Error("WRONG", test.calendar, "BUCKET FOR", test.val, time.Unix(start, 0).In(loc), time.Unix(next, 0).In(loc))
+		}
+	}
+
+	// the bucketer remembers the last bucket, so make sure it moves on
+	bucketer := newTimeBucketer(86400, CALENDAR_DAY, "America/New_York")
+	for _, val := range []string{"2017-03-12 01:00", "2017-03-12 23:00", "2017-03-13 01:00", "2017-03-11 23:00"} {
+		expected, _ := calendarBucket(at(val), CALENDAR_DAY, loc)
+		if bucketer.bucket(at(val)) != expected {
+			t.Error("BUCKETER RETURNED WRONG DAY FOR", val)
+		}
+	}
+}
+
+func TestCalendarTimeSeries(t *testing.T) {
+	if _, err := LoadTimeZone("America/Los_Angeles"); err != nil {
+		t.Skip("NO TIME ZONE DATA", err)
+		return
+	}
+
+	tableName := getTestTableName(t)
+	deleteTestDb(tableName)
+	defer deleteTestDb(tableName)
+
+	// one record every hour, starting at midnight UTC
+	start := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC).Unix()
+	blockCount := 3
+	addRecords(tableName, func(r *Record, index int) {
+		r.AddIntField("time", start+int64(index)*3600)
+	}, blockCount)
+
+	nt := saveAndReloadTable(t, tableName, blockCount)
+
+	old_time_col := OPTS.TIME_COL_ID
+	OPTS.TIME_COL_ID = nt.KeyTable["time"]
+	defer func() { OPTS.TIME_COL_ID = old_time_col }()
+
+	querySpec := newQuerySpec()
+	querySpec.TimeBucket = CALENDAR_BUCKETS[CALENDAR_DAY]
+	querySpec.TimeCalendar = CALENDAR_DAY
+	querySpec.TimeZone = "America/Los_Angeles"
+	nt.MatchAndAggregate(querySpec)
+
+	// midnight UTC is 5pm the day before in LA, so the first bucket only
+	// gets 7 records and the rest get 24
+	loc, _ := LoadTimeZone("America/Los_Angeles")
+	for bucket, results := range querySpec.TimeResults {
+		bucket_time := time.Unix(int64(bucket), 0).In(loc)
+		if bucket_time.Hour() != 0 || bucket_time.Minute() != 0 {
+			t.Error("DAY BUCKET DOESNT START AT MIDNIGHT", bucket_time)
+		}
+
+		count := int64(0)
+		for _, r := range results {
+			count += r.Count
+		}
+
+		first_day := bucket_time.Day() == 31
+		last_day := int64(bucket)+86400 > start+int64(CHUNK_SIZE*blockCount)*3600
+		if first_day && count != 7 || !first_day && !last_day && count != 24 {
+			t.Error("DAY BUCKET HAS WRONG COUNT", bucket_time, count)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package sybil
+
+import "fmt"
+import "math"
+import "sort"
+import "strconv"
+import "strings"
+import "text/tabwriter"
+import "time"
+
+// {{{ TIME SERIES FILL
+// time results only hold the buckets that had records in them, so graphing
+// them draws a straight line across any outage. -fill prints every bucket
+// between -start and -end (or between the first and last bucket with results)
+// for every top group:
+//
+//   zero: missing buckets have a count and values of 0
+//   null: missing buckets have null values (- in the table output)
+//   previous: missing buckets repeat the group's last bucket (null until
+//   the group has one)
+//
+// -transform series are only computed over the buckets with results, so
+// filled buckets have null transforms (except -fill previous, which repeats
+// them too)
+
+const (
+	FILL_ZERO     = "zero"
+	FILL_NULL     = "null"
+	FILL_PREVIOUS = "previous"
+)
+
+// a typo'd -time-bucket with a wide -start could otherwise print forever
+var MAX_FILL_BUCKETS = 100000
+
+// ParseFill validates a -fill value
+func ParseFill(val string) (string, error) {
+	val = strings.ToLower(strings.TrimSpace(val))
+	switch val {
+	case "", FILL_ZERO, FILL_NULL, FILL_PREVIOUS:
+		return val, nil
+	}
+
+	return "", fmt.Errorf("invalid fill %q, expected one of zero, null or previous", val)
+}
+
+// returns every time bucket a filled time series should print, in order
+func (qs *QuerySpec) fillTimeBuckets() []int64 {
+	buckets := make([]int64, 0)
+	if len(qs.TimeResults) == 0 && (qs.FillStart == 0 || qs.FillEnd == 0) {
+		return buckets
+	}
+
+	first := int64(math.MaxInt64)
+	last := int64(math.MinInt64)
+	for k := range qs.TimeResults {
+		first = Min(first, int64(k))
+		last = Max(last, int64(k))
+	}
+
+	bucketer := qs.newTimeBucketer()
+	start := first
+	if qs.FillStart != 0 {
+		start = bucketer.bucket(qs.FillStart)
+	}
+
+	end := last + 1
+	if qs.FillEnd != 0 {
+		end = qs.FillEnd
+	}
+
+	for bucket := start; bucket < end; bucket = bucketer.next_bucket(bucket) {
+		if len(buckets) >= MAX_FILL_BUCKETS {
+			Warn("ONLY FILLING THE FIRST", MAX_FILL_BUCKETS, "TIME BUCKETS")
+			break
+		}
+
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets
+}
+
+// returns the result to print for a group in a time bucket, or nil if the
+// bucket should be printed as empty. buckets must be visited in order, prev
+// remembers each group's last result for FILL_PREVIOUS
+func (qs *QuerySpec) fillResult(bucket int64, group string, prev map[string]*Result) *Result {
+	if r, ok := qs.TimeResults[int(bucket)][group]; ok {
+		prev[group] = r
+		return r
+	}
+
+	if qs.Fill == FILL_PREVIOUS {
+		return prev[group]
+	}
+
+	return nil
+}
+
+// the value printed for empty buckets: 0 for FILL_ZERO and null otherwise
+func (qs *QuerySpec) fillValue() interface{} {
+	if qs.Fill == FILL_ZERO {
+		return 0
+	}
+
+	return nil
+}
+
+func (qs *QuerySpec) emptyResultJSON(group string, val interface{}) ResultJSON {
+	var res = make(ResultJSON)
+	for _, agg := range qs.Aggregations {
+		res[agg.Label()] = val
+	}
+	for _, t := range qs.Transforms {
+		res[t.Label()] = nil
+	}
+
+	var group_key = strings.Split(group, GROUP_DELIMITER)
+	for i, g := range qs.Groups {
+		res[g.Name] = group_key[i]
+	}
+
+	if len(qs.Distincts) > 0 {
+		res["Distinct"] = val
+	} else {
+		res["Samples"] = val
+	}
+	res["Count"] = val
+
+	return res
+}
+
+func printEmptyTimeResultRows(w *tabwriter.Writer, time_str string, group string, querySpec *QuerySpec, val_str string) {
+	if len(querySpec.Distincts) > 0 || len(querySpec.Aggregations) == 0 {
+		fmt.Fprintln(w, time_str, "\t", val_str, "\t", group, "\t")
+		return
+	}
+
+	for _, agg := range querySpec.Aggregations {
+		fmt.Fprintln(w, time_str, "\t", val_str, "\t", group, "\t", agg.Label(), "\t", val_str, "\t")
+	}
+	for _, t := range querySpec.Transforms {
+		fmt.Fprintln(w, time_str, "\t", val_str, "\t", group, "\t", t.Label(), "\t", "-", "\t")
+	}
+}
+
+// returns the groups a filled time series prints in every bucket: the top
+// results, or with LimitPerBucket, any group that made a bucket's top results
+func (qs *QuerySpec) timeGroups(sorted []*Result) []string {
+	groups := make([]string, 0)
+	if !qs.LimitPerBucket {
+		for _, r := range sorted {
+			groups = append(groups, r.GroupByKey)
+		}
+
+		return groups
+	}
+
+	in_buckets := make(map[string]bool)
+	for _, results := range qs.TimeResults {
+		for k := range results {
+			in_buckets[k] = true
+		}
+	}
+
+	// groups keep their overall order, the ones that were pruned from the
+	// overall results (and the other group) go last
+	for _, r := range qs.Sorted {
+		if in_buckets[r.GroupByKey] {
+			groups = append(groups, r.GroupByKey)
+			delete(in_buckets, r.GroupByKey)
+		}
+	}
+
+	rest := make([]string, 0)
+	for k := range in_buckets {
+		rest = append(rest, k)
+	}
+	sort.Strings(rest)
+
+	return append(groups, rest...)
+}
+
+// prints the time results for the given groups in every bucket of the filled
+// range
+func printFilledTimeResults(querySpec *QuerySpec, groups []string) {
+	buckets := querySpec.fillTimeBuckets()
+	Debug("FILLING", len(buckets), "TIME BUCKETS WITH", querySpec.Fill)
+
+	prev := make(map[string]*Result)
+	fill_value := querySpec.fillValue()
+
+	if FLAGS.JSON {
+		marshalled_results := make(map[string][]ResultJSON)
+		for _, bucket := range buckets {
+			key := strconv.FormatInt(bucket, 10)
+			marshalled_results[key] = make([]ResultJSON, 0)
+
+			for _, group := range groups {
+				res := querySpec.emptyResultJSON(group, fill_value)
+				if r := querySpec.fillResult(bucket, group, prev); r != nil {
+					res = r.toResultJSON(querySpec)
+				}
+
+				marshalled_results[key] = append(marshalled_results[key], res)
+			}
+		}
+
+		printJson(marshalled_results)
+		return
+	}
+
+	fill_str := "-"
+	if fill_value != nil {
+		fill_str = fmt.Sprint(fill_value)
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(OUTPUT, 0, 1, 0, ' ', tabwriter.AlignRight)
+
+	loc := mustLoadTimeZone(querySpec.TimeZone)
+	for _, bucket := range buckets {
+		time_str := time.Unix(bucket, 0).In(loc).Format(OPTS.TIME_FORMAT)
+		for _, group := range groups {
+			if r := querySpec.fillResult(bucket, group, prev); r != nil {
+				printTimeResultRows(w, time_str, r, querySpec)
+			} else {
+				printEmptyTimeResultRows(w, time_str, group, querySpec, fill_str)
+			}
+		}
+	}
+
+	w.Flush()
+}
+
+// }}} TIME SERIES FILL
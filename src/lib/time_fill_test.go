@@ -0,0 +1,120 @@
+package sybil
+
+import "bytes"
+import "encoding/json"
+import "os"
+import "strconv"
+import "testing"
+
+func TestParseFill(t *testing.T) {
+	for _, val := range []string{"", "zero", "NULL", " previous"} {
+		if _, err := ParseFill(val); err != nil {
+			t.Error("COULDNT PARSE FILL", val, err)
+		}
+	}
+
+	if _, err := ParseFill("linear"); err == nil {
+		t.Error("EXPECTED AN ERROR FOR FILL linear")
+	}
+}
+
+func TestTimeSeriesFill(t *testing.T) {
+	tableName := getTestTableName(t)
+	deleteTestDb(tableName)
+	defer deleteTestDb(tableName)
+
+	// records cover hours 0 through 5 except for hour 2, host b only shows
+	// up in hour 0
+	start := int64(1496275200)
+	blockCount := 3
+	addRecords(tableName, func(r *Record, index int) {
+		hour := int64(index % 6)
+		if hour == 2 {
+			hour = 3
+		}
+
+		host := "a"
+		if hour == 0 && index%12 == 0 {
+			host = "b"
+		}
+
+		r.AddIntField("time", start+hour*3600)
+		r.AddStrField("host", host)
+	}, blockCount)
+
+	nt := saveAndReloadTable(t, tableName, blockCount)
+
+	old_time_col := OPTS.TIME_COL_ID
+	OPTS.TIME_COL_ID = nt.KeyTable["time"]
+	defer func() { OPTS.TIME_COL_ID = old_time_col }()
+
+	querySpec := newQuerySpec()
+	querySpec.Groups = append(querySpec.Groups, nt.Grouping("host"))
+	querySpec.TimeBucket = 3600
+	querySpec.Limit = 10
+	querySpec.OrderBy = SORT_COUNT
+	querySpec.PruneBy = SORT_COUNT
+	nt.MatchAndAggregate(querySpec)
+
+	// fill an hour on either side of the records
+	querySpec.FillStart = start - 3600
+	querySpec.FillEnd = start + 7*3600
+
+	var buf bytes.Buffer
+	OUTPUT = &buf
+	FLAGS.JSON = true
+	defer func() { OUTPUT = os.Stdout; FLAGS.JSON = false }()
+
+	printFill := func(fill string) map[int64]map[string]interface{} {
+		buf.Reset()
+		querySpec.Fill = fill
+		printTimeResults(querySpec)
+
+		var marshalled map[string][]map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &marshalled); err != nil {
+			t.Fatal("COULDNT READ JSON RESULTS", err, buf.String())
+		}
+
+		if len(marshalled) != 8 {
+			t.Error(fill, "FILL PRINTED WRONG NUMBER OF BUCKETS", len(marshalled))
+		}
+
+		counts := make(map[int64]map[string]interface{})
+		for key, results := range marshalled {
+			if len(results) != 2 {
+				t.Error(fill, "FILL SHOULD PRINT BOTH HOSTS IN EVERY BUCKET", key, results)
+			}
+
+			bucket, _ := strconv.ParseInt(key, 10, 64)
+			counts[bucket] = make(map[string]interface{})
+			for _, res := range results {
+				counts[bucket][res["host"].(string)] = res["Count"]
+			}
+		}
+
+		return counts
+	}
+
+	hour := func(h int64) int64 { return start + h*3600 }
+
+	zero := printFill(FILL_ZERO)
+	if zero[hour(2)]["a"] != float64(0) || zero[hour(-1)]["a"] != float64(0) || zero[hour(1)]["b"] != float64(0) {
+		t.Error("ZERO FILL SHOULD PRINT 0 FOR EMPTY BUCKETS", zero)
+	}
+	if zero[hour(1)]["a"] == float64(0) {
+		t.Error("ZERO FILL CHANGED A FULL BUCKET", zero[hour(1)])
+	}
+
+	null := printFill(FILL_NULL)
+	if null[hour(2)]["a"] != nil || null[hour(6)]["b"] != nil || null[hour(3)]["a"] == nil {
+		t.Error("NULL FILL SHOULD PRINT null FOR EMPTY BUCKETS", null)
+	}
+
+	previous := printFill(FILL_PREVIOUS)
+	if previous[hour(2)]["a"] != previous[hour(1)]["a"] || previous[hour(5)]["b"] != previous[hour(0)]["b"] {
+		t.Error("PREVIOUS FILL SHOULD REPEAT THE LAST BUCKET", previous)
+	}
+	if previous[hour(-1)]["a"] != nil {
+		t.Error("PREVIOUS FILL SHOULD BE null BEFORE THE FIRST BUCKET", previous[hour(-1)])
+	}
+}
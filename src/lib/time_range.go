@@ -0,0 +1,150 @@
+package sybil
+
+import "fmt"
+import "strconv"
+import "strings"
+import "time"
+
+// {{{ TIME RANGES
+// -start and -end bound a query to a range of FLAGS.TIME_COL. They become
+// gte / lt IntFilters, so they skip blocks the same way int filters do.
+// Bounds can be given as:
+//
+//   RFC3339 timestamps: 2017-06-01T12:00:00Z or just 2017-06-01
+//   epoch seconds: 1496275200
+//   relative times: now, -6h, now-7d, now+1h
+
+var TIME_BOUND_FORMATS = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"}
+
+// ParseTimeBound turns a -start or -end value into epoch seconds, relative
+// times are relative to now. Timestamps without an offset are read in loc.
+func ParseTimeBound(val string, now time.Time, loc *time.Location) (int64, error) {
+	val = strings.TrimSpace(val)
+	if val == "" {
+		return 0, fmt.Errorf("empty time")
+	}
+
+	if epoch, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return epoch, nil
+	}
+
+	for _, format := range TIME_BOUND_FORMATS {
+		if t, err := time.ParseInLocation(format, val, loc); err == nil {
+			return t.Unix(), nil
+		}
+	}
+
+	offset := strings.TrimPrefix(strings.ToLower(val), "now")
+	if offset == "" {
+		return now.Unix(), nil
+	}
+
+	sign := int64(1)
+	switch offset[0] {
+	case '-':
+		sign = -1
+	case '+':
+	default:
+		return 0, fmt.Errorf("invalid time %q, expected RFC3339, epoch seconds or a relative time like -6h", val)
+	}
+
+	secs, err := parseTimeOffset(offset[1:])
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q: %s", val, err)
+	}
+
+	return now.Unix() + sign*secs, nil
+}
+
+// parses durations like 90s, 6h or 1.5d into seconds
+func parseTimeOffset(offset string) (int64, error) {
+	i := len(offset)
+	for i > 0 && !(offset[i-1] >= '0' && offset[i-1] <= '9') {
+		i--
+	}
+
+	unit, ok := SQL_DURATIONS[offset[i:]]
+	if !ok {
+		return 0, fmt.Errorf("unknown duration unit %q, expected one of s, m, h, d or w", offset[i:])
+	}
+
+	num, err := strconv.ParseFloat(offset[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad duration %q", offset)
+	}
+
+	return int64(num * float64(unit)), nil
+}
+
+// ParseTimeRange parses a -start and -end pair into epoch seconds, a missing
+// bound comes back as 0
+func ParseTimeRange(start_val, end_val string, now time.Time) (int64, int64, error) {
+	loc := timeBoundZone()
+	start := int64(0)
+	end := int64(0)
+	var err error
+
+	if start_val != "" {
+		start, err = ParseTimeBound(start_val, now, loc)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if end_val != "" {
+		end, err = ParseTimeBound(end_val, now, loc)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return start, end, nil
+}
+
+// returns the time filters for a FilterSpec's start and end
+func (t *Table) timeRangeFilters(filterSpec FilterSpec, now time.Time) ([]Filter, error) {
+	filters := []Filter{}
+
+	start, end, err := ParseTimeRange(filterSpec.Start, filterSpec.End, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if filterSpec.Start != "" {
+		start = align_time_filter(FLAGS.TIME_COL, start)
+		filters = append(filters, t.IntFilter(FLAGS.TIME_COL, "gte", int(start)))
+	}
+
+	if filterSpec.End != "" {
+		filters = append(filters, t.IntFilter(FLAGS.TIME_COL, "lt", int(end)))
+	}
+
+	return filters, nil
+}
+
+// dates in -start and -end are in the -tz time zone, or local time without
+// one, like the time buckets and printed times
+func timeBoundZone() *time.Location {
+	return mustLoadTimeZone(FLAGS.TIME_ZONE)
+}
+
+// row store log files can only hold records that were ingested before the
+// file was written, so when the time column holds ingestion times, any log
+// file last modified before -start can be skipped without reading it. Records
+// with later times (from clock skew, future timestamps or a time column that
+// isn't the ingestion time) would be dropped with it, so the skipping is only
+// done with -skip-old-logs
+func rowStoreStartBound() (int64, bool) {
+	if FLAGS.START == "" || !FLAGS.SKIP_OLD_LOGS {
+		return 0, false
+	}
+
+	start, err := ParseTimeBound(FLAGS.START, time.Now(), timeBoundZone())
+	if err != nil {
+		return 0, false
+	}
+
+	return start, true
+}
+
+// }}} TIME RANGES
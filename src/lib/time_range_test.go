@@ -0,0 +1,127 @@
+package sybil
+
+import "testing"
+import "time"
+
+func TestParseTimeBound(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	good := map[string]int64{
+		"1496275200":           1496275200,
+		"2017-06-01T00:00:00Z": 1496275200,
+		"2017-06-01":           1496275200,
+		"now":                  1000000,
+		"-6h":                  1000000 - 6*3600,
+		"now-7d":               1000000 - 7*86400,
+		"NOW+1.5m":             1000000 + 90,
+	}
+
+	for val, expected := range good {
+		parsed, err := ParseTimeBound(val, now, time.UTC)
+		if err != nil || parsed != expected {
+			t.Error("PARSED TIME BOUND WRONG", val, parsed, "EXPECTED", expected, err)
+		}
+	}
+
+	for _, val := range []string{"", "yesterday", "now-7", "-6y", "now*2h"} {
+		if _, err := ParseTimeBound(val, now, time.UTC); err == nil {
+			t.Error("EXPECTED AN ERROR FOR TIME BOUND", val)
+		}
+	}
+
+	// dates without an offset are read in the given time zone
+	loc := time.FixedZone("UTC-7", -7*3600)
+	if parsed, _ := ParseTimeBound("2017-06-01", now, loc); parsed != 1496275200+7*3600 {
+		t.Error("DATE WASNT PARSED IN THE TIME ZONE", parsed)
+	}
+	if parsed, _ := ParseTimeBound("2017-06-01T00:00:00Z", now, loc); parsed != 1496275200 {
+		t.Error("RFC3339 OFFSET SHOULD WIN OVER THE TIME ZONE", parsed)
+	}
+}
+
+func TestTimeRangeFilters(t *testing.T) {
+	tableName := getTestTableName(t)
+	deleteTestDb(tableName)
+	defer deleteTestDb(tableName)
+
+	// each block covers its own hour
+	blockCount := 3
+	addRecords(tableName, func(r *Record, index int) {
+		r.AddIntField("time", int64(index/CHUNK_SIZE)*3600+int64(index%CHUNK_SIZE))
+	}, blockCount)
+
+	nt := saveAndReloadTable(t, tableName, blockCount)
+
+	old_time_col := FLAGS.TIME_COL
+	FLAGS.TIME_COL = "time"
+	defer func() { FLAGS.TIME_COL = old_time_col }()
+
+	loadSpec := nt.NewLoadSpec()
+	filterSpec := FilterSpec{Start: "3600", End: "1970-01-01T02:00:00Z"}
+	filters := BuildFilters(nt, &loadSpec, filterSpec)
+	if len(filters) != 2 {
+		t.Fatal("START AND END SHOULD BUILD TWO FILTERS", filters)
+	}
+
+	querySpec := newQuerySpec()
+	querySpec.Filters = filters
+	nt.MatchAndAggregate(querySpec)
+
+	for _, r := range querySpec.Results {
+		if r.Count != int64(CHUNK_SIZE) {
+			t.Error("TIME RANGE MATCHED THE WRONG RECORDS", r.Count, "EXPECTED", CHUNK_SIZE)
+		}
+	}
+
+	// only the middle block overlaps the range
+	loaded := 0
+	for _, b := range nt.BlockList {
+		if nt.ShouldLoadBlockFromDir(b.Name, querySpec) {
+			loaded++
+		}
+	}
+
+	if loaded != 1 {
+		t.Error("TIME RANGE SHOULD SKIP ALL BUT ONE BLOCK, LOADED", loaded)
+	}
+}
+
+func TestRowStoreStartBound(t *testing.T) {
+	old_start, old_skip := FLAGS.START, FLAGS.SKIP_OLD_LOGS
+	defer func() { FLAGS.START, FLAGS.SKIP_OLD_LOGS = old_start, old_skip }()
+
+	// log files are only skipped by their mtime when asked to
+	FLAGS.START, FLAGS.SKIP_OLD_LOGS = "1496275200", false
+	if _, ok := rowStoreStartBound(); ok {
+		t.Error("ROW STORE LOGS SHOULDNT BE SKIPPED WITHOUT -skip-old-logs")
+	}
+
+	FLAGS.SKIP_OLD_LOGS = true
+	if start, ok := rowStoreStartBound(); !ok || start != 1496275200 {
+		t.Error("EXPECTED -skip-old-logs TO SKIP LOGS WRITTEN BEFORE -start", start, ok)
+	}
+}
+
+func TestTimeBoundZone(t *testing.T) {
+	old_local, old_tz := time.Local, FLAGS.TIME_ZONE
+	defer func() { time.Local, FLAGS.TIME_ZONE = old_local, old_tz }()
+
+	// without -tz, dates in -start and -end are read in the same (local)
+	// time zone that time buckets are made and printed in
+	time.Local = time.FixedZone("UTC-7", -7*3600)
+	FLAGS.TIME_ZONE = ""
+
+	bucket_loc, err := LoadTimeZone(FLAGS.TIME_ZONE)
+	if err != nil || timeBoundZone() != bucket_loc {
+		t.Error("TIME BOUNDS AND BUCKETS ARE IN DIFFERENT TIME ZONES", timeBoundZone(), bucket_loc)
+	}
+
+	start, err := ParseTimeBound("2017-06-01", time.Now(), timeBoundZone())
+	if err != nil || start != 1496275200+7*3600 {
+		t.Error("DATE WASNT PARSED IN LOCAL TIME", start, err)
+	}
+
+	FLAGS.TIME_ZONE = "UTC"
+	if start, _ = ParseTimeBound("2017-06-01", time.Now(), timeBoundZone()); start != 1496275200 {
+		t.Error("DATE WASNT PARSED IN THE -tz TIME ZONE", start)
+	}
+}
@@ -0,0 +1,254 @@
+package sybil
+
+import "fmt"
+import "sort"
+import "strings"
+
+// {{{ TIME SERIES TRANSFORMS
+// -transform derives new series from the results of a time query, ex:
+//
+//   -time -agg "max(requests)" -int latency -transform "rate(max(requests)),mavg(latency)"
+//
+//   rate: how fast the value grew per second since the group's previous
+//   bucket, for counters that only go up. A drop is a counter reset, so the
+//   new value is all growth
+//   delta: the change in the value since the group's previous bucket
+//   cumsum: the running total of the value
+//   mavg: the mean of the value over the group's last -window buckets
+//   mpNN: the NNth percentile of the value over the group's last -window
+//   buckets, ex: mp50(latency) is a moving median
+//
+// the value is anything -sort takes: count, a column (its average) or
+// op(col). Transforms are computed when the results are printed, after
+// every node's results are merged. Buckets a group has no results in are
+// skipped.
+
+const (
+	TRANSFORM_RATE   = "rate"
+	TRANSFORM_DELTA  = "delta"
+	TRANSFORM_CUMSUM = "cumsum"
+	TRANSFORM_MAVG   = "mavg"
+)
+
+var DEFAULT_TRANSFORM_WINDOW = 5
+
+type Transform struct {
+	Op    string
+	Field string // the result field it transforms, see having.go
+}
+
+func (t Transform) Label() string {
+	return t.Op + "(" + t.Field + ")"
+}
+
+func isValidTransformOp(op string) bool {
+	switch op {
+	case TRANSFORM_RATE, TRANSFORM_DELTA, TRANSFORM_CUMSUM, TRANSFORM_MAVG:
+		return true
+	}
+
+	_, ok := movingPercentileOp(op)
+	return ok
+}
+
+// mpNN transforms take the NNth percentile of a window
+func movingPercentileOp(op string) (float64, bool) {
+	if !strings.HasPrefix(op, "m") {
+		return 0, false
+	}
+
+	return percentileOp(op[1:])
+}
+
+// ParseTransforms parses a list of transforms like rate(max(requests)).
+// Separators inside a transform's parens don't split it
+func ParseTransforms(spec string, sep string) ([]Transform, error) {
+	transforms := make([]Transform, 0)
+	if strings.TrimSpace(spec) == "" {
+		return transforms, nil
+	}
+
+	tokens := make([]string, 0)
+	depth, start := 0, 0
+	for i := 0; i < len(spec); i++ {
+		switch {
+		case spec[i] == '(':
+			depth++
+		case spec[i] == ')':
+			depth--
+		case depth == 0 && strings.HasPrefix(spec[i:], sep):
+			tokens = append(tokens, spec[start:i])
+			start = i + len(sep)
+		}
+	}
+	tokens = append(tokens, spec[start:])
+
+	for _, token := range tokens {
+		token = strings.TrimSpace(token)
+		open := strings.Index(token, "(")
+		if open <= 0 || !strings.HasSuffix(token, ")") {
+			return nil, fmt.Errorf("invalid transform %q, expected op(value), ex: rate(max(requests))", token)
+		}
+
+		t := Transform{
+			Op:    strings.ToLower(strings.TrimSpace(token[:open])),
+			Field: strings.TrimSpace(token[open+1 : len(token)-1]),
+		}
+
+		if !isValidTransformOp(t.Op) {
+			return nil, fmt.Errorf("unknown transform %q, expected one of rate, delta, cumsum, mavg or mpNN", t.Op)
+		}
+
+		agg, on_result := havingField(t.Field)
+		if !on_result && (agg.Name == "" || (agg.Op != OP_STDDEV && !isValidAggOp(agg.Op))) {
+			return nil, fmt.Errorf("cant transform %q", t.Field)
+		}
+
+		transforms = append(transforms, t)
+	}
+
+	return transforms, nil
+}
+
+// TransformAggregations returns the column aggregations the transforms
+// read, which the query has to aggregate for them to have values
+func TransformAggregations(transforms []Transform) []Aggregation {
+	aggs := make([]Aggregation, 0)
+	for _, t := range transforms {
+		if agg, on_result := havingField(t.Field); !on_result {
+			aggs = append(aggs, agg)
+		}
+	}
+
+	return aggs
+}
+
+// computes every transform for each time result, keyed by the transform's
+// label
+func (qs *QuerySpec) transformTimeResults() map[*Result]map[string]float64 {
+	transformed := make(map[*Result]map[string]float64)
+	if len(qs.Transforms) == 0 {
+		return transformed
+	}
+
+	buckets := make([]int, 0)
+	for k := range qs.TimeResults {
+		buckets = append(buckets, k)
+	}
+	sort.Ints(buckets)
+
+	// each group's results and their buckets, in time order
+	series := make(map[string][]*Result)
+	times := make(map[string][]int)
+	for _, bucket := range buckets {
+		for group, r := range qs.TimeResults[bucket] {
+			series[group] = append(series[group], r)
+			times[group] = append(times[group], bucket)
+			transformed[r] = make(map[string]float64)
+		}
+	}
+
+	window := qs.TransformWindow
+	if window <= 0 {
+		window = DEFAULT_TRANSFORM_WINDOW
+	}
+
+	for group, results := range series {
+		for _, t := range qs.Transforms {
+			label := t.Label()
+			values := make([]float64, 0, len(results))
+			bucket_times := make([]int, 0, len(results))
+			from := make([]*Result, 0, len(results))
+			for i, r := range results {
+				if val, ok := r.HavingValue(t.Field); ok {
+					values = append(values, val)
+					bucket_times = append(bucket_times, times[group][i])
+					from = append(from, r)
+				}
+			}
+
+			// cumsum keeps a running sum instead of adding up every bucket
+			// before each one
+			cumsum := 0.0
+			for i, r := range from {
+				if t.Op == TRANSFORM_CUMSUM {
+					cumsum += values[i]
+					transformed[r][label] = cumsum
+					continue
+				}
+
+				if val, ok := transformValue(t.Op, values, bucket_times, i, window); ok {
+					transformed[r][label] = val
+				}
+			}
+		}
+	}
+
+	return transformed
+}
+
+// the transform of the ith value of a series, cumsum is summed up as the
+// series is walked in transformTimeResults
+func transformValue(op string, values []float64, times []int, i int, window int) (float64, bool) {
+	switch op {
+	case TRANSFORM_DELTA:
+		if i == 0 {
+			return 0, false
+		}
+		return values[i] - values[i-1], true
+
+	case TRANSFORM_RATE:
+		if i == 0 || times[i] <= times[i-1] {
+			return 0, false
+		}
+
+		growth := values[i] - values[i-1]
+		if growth < 0 {
+			growth = values[i]
+		}
+		return growth / float64(times[i]-times[i-1]), true
+	}
+
+	start := i - window + 1
+	if start < 0 {
+		start = 0
+	}
+	in_window := values[start : i+1]
+
+	if op == TRANSFORM_MAVG {
+		sum := 0.0
+		for _, val := range in_window {
+			sum += val
+		}
+		return sum / float64(len(in_window)), true
+	}
+
+	p, _ := movingPercentileOp(op)
+	return windowPercentile(in_window, p), true
+}
+
+// the pth percentile of a few values, interpolated between the two closest
+func windowPercentile(values []float64, p float64) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	rank := p / 100 * float64(len(sorted)-1)
+	low := int(rank)
+	if low+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+
+	return sorted[low] + (sorted[low+1]-sorted[low])*(rank-float64(low))
+}
+
+// the transform of a time result, or nil if it has none
+func (qs *QuerySpec) transformedValue(r *Result, t Transform) interface{} {
+	if val, ok := qs.transformed[r][t.Label()]; ok {
+		return val
+	}
+
+	return nil
+}
+
+// }}} TIME SERIES TRANSFORMS
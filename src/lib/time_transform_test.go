@@ -0,0 +1,145 @@
+package sybil
+
+import "bytes"
+import "encoding/json"
+import "math"
+import "os"
+import "strconv"
+import "testing"
+
+func TestParseTransforms(t *testing.T) {
+	transforms, err := ParseTransforms("rate(max(requests)), delta(count),cumsum(bytes),mp99.9(latency)", ",")
+	if err != nil || len(transforms) != 4 {
+		t.Fatal("COULDNT PARSE TRANSFORMS", transforms, err)
+	}
+
+	if transforms[0].Label() != "rate(max(requests))" || transforms[3].Op != "mp99.9" {
+		t.Error("TRANSFORMS PARSED WRONG", transforms)
+	}
+
+	aggs := TransformAggregations(transforms)
+	if len(aggs) != 3 || aggs[0].Op != OP_MAX || aggs[1].Op != OP_AVG {
+		t.Error("TRANSFORMS READ THE WRONG AGGREGATIONS", aggs)
+	}
+
+	for _, bad := range []string{"rate", "speed(count)", "mp100(latency)", "rate(bogus(latency))", "rate(count"} {
+		if _, err := ParseTransforms(bad, ","); err == nil {
+			t.Error("EXPECTED AN ERROR FOR TRANSFORM", bad)
+		}
+	}
+
+	if windowPercentile([]float64{4, 1, 3, 2}, 50) != 2.5 {
+		t.Error("MOVING MEDIAN IS WRONG", windowPercentile([]float64{4, 1, 3, 2}, 50))
+	}
+}
+
+func TestTimeTransforms(t *testing.T) {
+	tableName := getTestTableName(t)
+	deleteTestDb(tableName)
+	defer deleteTestDb(tableName)
+
+	// a counter that grows by 10 a second, with no records in hour 3 and a
+	// reset in hour 5
+	start := int64(1496275200)
+	blockCount := 3
+	addRecords(tableName, func(r *Record, index int) {
+		hour := int64(index % 6)
+		if hour == 3 {
+			hour = 4
+		}
+
+		requests := hour * 3600 * 10
+		if hour == 5 {
+			requests = 360
+		}
+
+		r.AddIntField("time", start+hour*3600)
+		r.AddIntField("requests", requests)
+	}, blockCount)
+
+	nt := saveAndReloadTable(t, tableName, blockCount)
+
+	old_time_col := OPTS.TIME_COL_ID
+	OPTS.TIME_COL_ID = nt.KeyTable["time"]
+	defer func() { OPTS.TIME_COL_ID = old_time_col }()
+
+	transforms, err := ParseTransforms("rate(max(requests)),delta(max(requests)),cumsum(count),mavg(count),mp50(count)", ",")
+	if err != nil {
+		t.Fatal("COULDNT PARSE TRANSFORMS", err)
+	}
+
+	querySpec := newQuerySpec()
+	querySpec.Aggregations = append(querySpec.Aggregations, nt.Aggregation("requests", OP_MAX))
+	querySpec.TimeBucket = 3600
+	querySpec.Limit = 10
+	querySpec.OrderBy = SORT_COUNT
+	querySpec.PruneBy = SORT_COUNT
+	querySpec.Transforms = transforms
+	querySpec.TransformWindow = 2
+	nt.MatchAndAggregate(querySpec)
+
+	var buf bytes.Buffer
+	OUTPUT = &buf
+	FLAGS.JSON = true
+	defer func() { OUTPUT = os.Stdout; FLAGS.JSON = false }()
+
+	printTimeResults(querySpec)
+
+	var marshalled map[string][]map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &marshalled); err != nil {
+		t.Fatal("COULDNT READ JSON RESULTS", err, buf.String())
+	}
+
+	hour := func(h int64) map[string]interface{} {
+		results := marshalled[strconv.FormatInt(start+h*3600, 10)]
+		if len(results) != 1 {
+			t.Fatal("EXPECTED ONE RESULT IN HOUR", h, results)
+		}
+		return results[0]
+	}
+
+	if len(marshalled) != 5 {
+		t.Fatal("TRANSFORMS CHANGED THE BUCKETS", len(marshalled))
+	}
+
+	// the first bucket has nothing to compare to
+	if hour(0)["rate(max(requests))"] != nil || hour(0)["delta(max(requests))"] != nil {
+		t.Error("FIRST BUCKET SHOULDNT HAVE A RATE", hour(0))
+	}
+
+	// hour 4 is compared to hour 2, across the missing bucket
+	for _, h := range []int64{1, 2, 4} {
+		if hour(h)["rate(max(requests))"] != float64(10) {
+			t.Error("RATE IN HOUR", h, "IS", hour(h)["rate(max(requests))"], "EXPECTED 10")
+		}
+	}
+
+	if hour(4)["delta(max(requests))"] != float64(72000) {
+		t.Error("DELTA IS", hour(4)["delta(max(requests))"], "EXPECTED 72000")
+	}
+
+	if hour(5)["rate(max(requests))"] != float64(0.1) {
+		t.Error("RATE AFTER A RESET IS", hour(5)["rate(max(requests))"], "EXPECTED 0.1")
+	}
+
+	count := func(h int64) float64 { return hour(h)["Count"].(float64) }
+
+	total := 0.0
+	for _, h := range []int64{0, 1, 2, 4, 5} {
+		total += count(h)
+	}
+
+	if hour(5)["cumsum(count)"] != total {
+		t.Error("CUMSUM IS", hour(5)["cumsum(count)"], "EXPECTED", total)
+	}
+
+	// the window covers the group's last 2 buckets with results
+	expected := (count(2) + count(4)) / 2
+	if math.Abs(hour(4)["mavg(count)"].(float64)-expected) > 0.001 || hour(4)["mp50(count)"] != hour(4)["mavg(count)"] {
+		t.Error("MOVING AVERAGE IS", hour(4)["mavg(count)"], hour(4)["mp50(count)"], "EXPECTED", expected)
+	}
+
+	if hour(0)["mavg(count)"] != count(0) {
+		t.Error("MOVING AVERAGE OF THE FIRST BUCKET SHOULD BE ITS VALUE", hour(0))
+	}
+}